@@ -9,8 +9,8 @@ import (
 
 	"decred.org/dcrwallet/errors"
 	w "decred.org/dcrwallet/wallet"
-	"github.com/decred/dcrd/chaincfg/v3"
 	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/wire"
 	"github.com/planetdecred/dcrlibwallet/addresshelper"
 )
 
@@ -233,13 +233,23 @@ func (wallet *Wallet) HDPathForAccount(accountNumber int32) (string, error) {
 
 	var hdPath string
 	isLegacyCoinType := cointype == wallet.chainParams.LegacyCoinType
-	if wallet.chainParams.Name == chaincfg.MainNetParams().Name {
+	switch wallet.chainParams.Net {
+	case wire.MainNet:
 		if isLegacyCoinType {
 			hdPath = LegacyMainnetHDPath
 		} else {
 			hdPath = MainnetHDPath
 		}
-	} else {
+	case wire.SimNet:
+		if isLegacyCoinType {
+			hdPath = LegacySimnetHDPath
+		} else {
+			hdPath = TestnetHDPath
+		}
+	case wire.RegNet:
+		// The legacy and SLIP0044 coin types of regnet are the same.
+		hdPath = TestnetHDPath
+	default:
 		if isLegacyCoinType {
 			hdPath = LegacyTestnetHDPath
 		} else {
@@ -0,0 +1,282 @@
+package dcrlibwallet
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"decred.org/dcrwallet/errors"
+	w "decred.org/dcrwallet/wallet"
+	"decred.org/dcrwallet/wallet/udb"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/planetdecred/dcrlibwallet/txindex"
+)
+
+const (
+	AddressBranchExternal = udb.ExternalBranch
+	AddressBranchInternal = udb.InternalBranch
+
+	AddressFilterAll    int32 = 0
+	AddressFilterUsed   int32 = 1
+	AddressFilterUnused int32 = 2
+)
+
+// noAddressIndex is the value dcrwallet uses for the last used or last
+// returned child index of a branch on which no address has been used or
+// returned.
+const noAddressIndex = ^uint32(0)
+
+func (wallet *Wallet) ListAddresses(account int32, branch uint32, usedFilter, offset, limit int32) (string, error) {
+	addresses, err := wallet.ListAddressesRaw(account, branch, usedFilter, offset, limit)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedAddresses, err := json.Marshal(&addresses)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedAddresses), nil
+}
+
+// ListAddressesRaw returns the addresses that have been used or returned on
+// the specified account branch, ordered by child index. The usedFilter is
+// one of AddressFilterAll, AddressFilterUsed or AddressFilterUnused and is
+// applied before the offset and limit. dcrwallet only records the last used
+// child index of a branch, so the addresses up to it are the used addresses
+// and the addresses after it up to the last returned index are unused.
+func (wallet *Wallet) ListAddressesRaw(account int32, branch uint32, usedFilter, offset, limit int32) ([]*AddressUsage, error) {
+	if wallet.IsRestored && !wallet.HasDiscoveredAccounts {
+		return nil, errors.E(ErrAddressDiscoveryNotDone)
+	}
+
+	if branch != AddressBranchExternal && branch != AddressBranchInternal {
+		return nil, errors.New(ErrInvalid)
+	}
+	if usedFilter < AddressFilterAll || usedFilter > AddressFilterUnused || offset < 0 || limit < 0 {
+		return nil, errors.New(ErrInvalid)
+	}
+
+	props, err := wallet.accountProperties(uint32(account))
+	if err != nil {
+		return nil, err
+	}
+
+	lastUsed, lastReturned := props.LastUsedExternalIndex, props.LastReturnedExternalIndex
+	if branch == AddressBranchInternal {
+		lastUsed, lastReturned = props.LastUsedInternalIndex, props.LastReturnedInternalIndex
+	}
+
+	// The child indexes of the addresses that pass the filter are
+	// firstIndex to lastIndex, both included.
+	addresses := make([]*AddressUsage, 0)
+	firstIndex, lastIndex := int64(0), addressIndexOrNone(maxAddressIndex(lastUsed, lastReturned))
+	switch usedFilter {
+	case AddressFilterUsed:
+		lastIndex = addressIndexOrNone(lastUsed)
+	case AddressFilterUnused:
+		firstIndex = addressIndexOrNone(lastUsed) + 1
+	}
+	firstIndex += int64(offset)
+	if firstIndex > lastIndex {
+		return addresses, nil
+	}
+
+	ctx := wallet.shutdownContext()
+	xpub, err := wallet.internal.AccountXpub(ctx, uint32(account))
+	if err != nil {
+		return nil, translateError(err)
+	}
+	branchKey, err := xpub.Child(branch)
+	if err != nil {
+		return nil, err
+	}
+
+	accountPath, err := wallet.HDPathForAccount(account)
+	if err != nil {
+		return nil, err
+	}
+
+	balances, err := wallet.balanceByAddress(uint32(account))
+	if err != nil {
+		return nil, err
+	}
+
+	for index := uint32(firstIndex); int64(index) <= lastIndex; index++ {
+		childKey, err := branchKey.Child(index)
+		if err != nil {
+			// Some child indexes are invalid and are skipped by
+			// dcrwallet when deriving addresses.
+			continue
+		}
+
+		addr, err := dcrutil.NewAddressPubKeyHash(dcrutil.Hash160(childKey.SerializedPubKey()),
+			wallet.chainParams, dcrec.STEcdsaSecp256k1)
+		if err != nil {
+			return nil, err
+		}
+
+		usage := &AddressUsage{
+			Address:        addr.Address(),
+			Account:        account,
+			Branch:         branch,
+			Index:          index,
+			DerivationPath: fmt.Sprintf("%s / %d / %d", accountPath, branch, index),
+			Used:           int64(index) <= addressIndexOrNone(lastUsed),
+			Balance:        balances[addr.Address()],
+		}
+
+		usage.ReceiveCount, usage.TotalReceived, err = wallet.receivedByAddress(usage.Address, account)
+		if err != nil {
+			return nil, err
+		}
+
+		addresses = append(addresses, usage)
+		if limit > 0 && int32(len(addresses)) >= limit {
+			break
+		}
+	}
+
+	return addresses, nil
+}
+
+// AddressGapReport returns how many unused addresses have been returned on
+// each branch of the account and how many more can be returned before the
+// gap limit is reached.
+func (wallet *Wallet) AddressGapReport(account int32) (*AddressGapReport, error) {
+	props, err := wallet.accountProperties(uint32(account))
+	if err != nil {
+		return nil, err
+	}
+
+	gapLimit := wallet.internal.GapLimit()
+	return &AddressGapReport{
+		Account:  account,
+		GapLimit: gapLimit,
+		External: branchGapReport(AddressBranchExternal, props.LastUsedExternalIndex, props.LastReturnedExternalIndex, gapLimit),
+		Internal: branchGapReport(AddressBranchInternal, props.LastUsedInternalIndex, props.LastReturnedInternalIndex, gapLimit),
+	}, nil
+}
+
+func branchGapReport(branch, lastUsed, lastReturned, gapLimit uint32) *BranchGapReport {
+	report := &BranchGapReport{
+		Branch:            branch,
+		LastUsedIndex:     addressIndexOrNone(lastUsed),
+		LastReturnedIndex: addressIndexOrNone(lastReturned),
+	}
+
+	// Child indexes after the last used index up to the last returned
+	// index have been handed out but not used yet.
+	if report.LastReturnedIndex > report.LastUsedIndex {
+		report.UnusedReturnedCount = uint32(report.LastReturnedIndex - report.LastUsedIndex)
+	}
+	if report.UnusedReturnedCount < gapLimit {
+		report.RemainingBeforeGap = gapLimit - report.UnusedReturnedCount
+	}
+
+	return report
+}
+
+func (wallet *Wallet) accountProperties(account uint32) (*w.AccountProperties, error) {
+	accounts, err := wallet.internal.Accounts(wallet.shutdownContext())
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	for i := range accounts.Accounts {
+		if accounts.Accounts[i].AccountNumber == account {
+			return &accounts.Accounts[i].AccountProperties, nil
+		}
+	}
+
+	return nil, errors.New(ErrNotExist)
+}
+
+// receivedByAddress returns the number of indexed transactions that pay to
+// the address of the account and the total amount that they pay to it.
+func (wallet *Wallet) receivedByAddress(address string, account int32) (count int32, amount int64, err error) {
+	outputs, err := wallet.txDB.ReadAddressOutputs(address)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Only count a tx once even if it has multiple outputs paying to the
+	// address.
+	counted := make(map[string]bool)
+	for _, output := range outputs {
+		if output.Account != account {
+			continue
+		}
+
+		amount += output.Amount
+		if !counted[output.TxHash] {
+			count++
+			counted[output.TxHash] = true
+		}
+	}
+
+	return count, amount, nil
+}
+
+// AddressOutputs returns the outputs of the transaction that pay to the
+// addresses of the wallet, which are indexed by address in the tx index.
+func (tx *Transaction) AddressOutputs() []*txindex.AddressOutput {
+	var outputs []*txindex.AddressOutput
+	for _, output := range tx.Outputs {
+		if output.AccountNumber < 0 || output.Address == "" {
+			continue
+		}
+
+		outputs = append(outputs, &txindex.AddressOutput{
+			ID:      fmt.Sprintf("%s:%d", tx.Hash, output.Index),
+			Address: output.Address,
+			TxHash:  tx.Hash,
+			Account: output.AccountNumber,
+			Amount:  output.Amount,
+		})
+	}
+	return outputs
+}
+
+// balanceByAddress sums the unspent outputs of the account by address,
+// including unconfirmed outputs.
+func (wallet *Wallet) balanceByAddress(account uint32) (map[string]int64, error) {
+	policy := w.OutputSelectionPolicy{
+		Account:               account,
+		RequiredConfirmations: 0,
+	}
+	unspentOutputs, err := wallet.internal.UnspentOutputs(wallet.shutdownContext(), policy)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	balances := make(map[string]int64)
+	for _, output := range unspentOutputs {
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(output.Output.Version, output.Output.PkScript, wallet.chainParams, true)
+		if err != nil || len(addrs) == 0 {
+			continue
+		}
+		balances[addrs[0].Address()] += output.Output.Value
+	}
+
+	return balances, nil
+}
+
+func maxAddressIndex(lastUsed, lastReturned uint32) uint32 {
+	if lastUsed == noAddressIndex {
+		return lastReturned
+	}
+	if lastReturned == noAddressIndex || lastUsed > lastReturned {
+		return lastUsed
+	}
+	return lastReturned
+}
+
+func addressIndexOrNone(index uint32) int64 {
+	if index == noAddressIndex {
+		return -1
+	}
+	return int64(index)
+}
@@ -0,0 +1,136 @@
+package dcrlibwallet
+
+import (
+	"context"
+
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/txhelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AddressUsage", func() {
+	var wallet *Wallet
+	var cleanup func()
+	var addresses []string
+
+	// payAddress indexes a transaction with an output that pays amount to
+	// the address of account 0 for each of amounts.
+	payAddress := func(hash, address string, amounts ...int64) {
+		tx := &Transaction{
+			Hash:      hash,
+			Type:      txhelper.TxTypeRegular,
+			Direction: txhelper.TxDirectionReceived,
+			Timestamp: 1600000000,
+		}
+		for i, amount := range amounts {
+			tx.Outputs = append(tx.Outputs, &TxOutput{Index: int32(i), Address: address, Amount: amount})
+		}
+		_, err := wallet.txDB.SaveOrUpdate(&Transaction{}, tx)
+		Expect(err).To(BeNil())
+	}
+
+	// markUsed adds an unmined transaction that pays to the address to the
+	// wallet, which marks the address used.
+	markUsed := func(address string) {
+		addr, err := dcrutil.DecodeAddress(address, wallet.chainParams)
+		Expect(err).To(BeNil())
+		pkScript, err := txscript.PayToAddrScript(addr)
+		Expect(err).To(BeNil())
+
+		tx := wire.NewMsgTx()
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, 1e8, nil))
+		tx.AddTxOut(wire.NewTxOut(1e8, pkScript))
+		Expect(wallet.internal.AddTransaction(context.Background(), tx, nil)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		_, wallet, cleanup = createTestMultiWallet("simnet")
+
+		addresses = nil
+		for i := 0; i < 3; i++ {
+			address, err := wallet.NextAddress(0)
+			Expect(err).To(BeNil())
+			addresses = append(addresses, address)
+		}
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("reports the addresses up to the last used index as used", func() {
+		markUsed(addresses[1])
+		payAddress("a", addresses[1], 100, 200)
+		payAddress("b", addresses[1], 300)
+
+		all, err := wallet.ListAddressesRaw(0, AddressBranchExternal, AddressFilterAll, 0, 0)
+		Expect(err).To(BeNil())
+		var listed []string
+		for _, usage := range all {
+			listed = append(listed, usage.Address)
+		}
+		Expect(listed).To(ContainElements(addresses))
+
+		// Addresses before the last used one are used even if their
+		// receipts are not indexed.
+		used, err := wallet.ListAddressesRaw(0, AddressBranchExternal, AddressFilterUsed, 0, 0)
+		Expect(err).To(BeNil())
+		lastUsed := used[len(used)-1]
+		Expect(lastUsed.Address).To(Equal(addresses[1]))
+		Expect(lastUsed.ReceiveCount).To(Equal(int32(2)))
+		Expect(lastUsed.TotalReceived).To(Equal(int64(600)))
+		listed = nil
+		for _, usage := range used {
+			Expect(usage.Used).To(BeTrue())
+			listed = append(listed, usage.Address)
+		}
+		Expect(listed).To(ContainElement(addresses[0]))
+
+		unused, err := wallet.ListAddressesRaw(0, AddressBranchExternal, AddressFilterUnused, 0, 0)
+		Expect(err).To(BeNil())
+		Expect(unused).To(HaveLen(len(all) - len(used)))
+		Expect(unused[0].Address).To(Equal(addresses[2]))
+		for _, usage := range unused {
+			Expect(usage.Used).To(BeFalse())
+		}
+
+		page, err := wallet.ListAddressesRaw(0, AddressBranchExternal, AddressFilterAll, 1, 1)
+		Expect(err).To(BeNil())
+		Expect(page).To(HaveLen(1))
+		Expect(page[0].Index).To(Equal(all[1].Index))
+
+		page, err = wallet.ListAddressesRaw(0, AddressBranchExternal, AddressFilterUsed, int32(len(used)-1), 5)
+		Expect(err).To(BeNil())
+		Expect(page).To(HaveLen(1))
+		Expect(page[0].Address).To(Equal(addresses[1]))
+
+		page, err = wallet.ListAddressesRaw(0, AddressBranchExternal, AddressFilterUnused, int32(len(unused)), 0)
+		Expect(err).To(BeNil())
+		Expect(page).To(BeEmpty())
+	})
+
+	It("forgets the outputs of transactions that are removed from the index", func() {
+		payAddress("a", addresses[0], 100)
+
+		var tx Transaction
+		Expect(wallet.txDB.FindOne("Hash", "a", &tx)).To(Succeed())
+		Expect(wallet.txDB.Delete(&tx)).To(Succeed())
+
+		all, err := wallet.ListAddressesRaw(0, AddressBranchExternal, AddressFilterAll, 0, 0)
+		Expect(err).To(BeNil())
+		for _, usage := range all {
+			Expect(usage.ReceiveCount).To(BeZero())
+		}
+	})
+
+	It("rejects invalid branches and filters", func() {
+		_, err := wallet.ListAddressesRaw(0, 2, AddressFilterAll, 0, 0)
+		Expect(err).To(MatchError(ErrInvalid))
+		_, err = wallet.ListAddressesRaw(0, AddressBranchExternal, 3, 0, 0)
+		Expect(err).To(MatchError(ErrInvalid))
+	})
+})
@@ -0,0 +1,79 @@
+package dcrlibwallet
+
+import (
+	"encoding/json"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+)
+
+const (
+	// StrictCFilterValidationConfigKey enables the strict validation of
+	// the cfilters that the wallets fetch during SPV sync. In strict mode,
+	// cfilters are validated against the header commitments of the blocks,
+	// filters from peers that serve invalid inclusion proofs are refetched
+	// from another peer and those peers are banned.
+	StrictCFilterValidationConfigKey = "strict_cfilter_validation"
+
+	// invalidCFiltersBanDuration is the time that peers which served
+	// invalid cfilters are banned for in strict mode.
+	invalidCFiltersBanDuration = 24 * time.Hour
+)
+
+func (mw *MultiWallet) CFilterValidationStats() (string, error) {
+	stats := mw.CFilterValidationStatsRaw()
+
+	jsonEncodedStats, err := json.Marshal(stats)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedStats), nil
+}
+
+// CFilterValidationStatsRaw returns the number of cfilters validated and the
+// number of times that peers served invalid cfilters during the SPV syncs
+// since the multiwallet was loaded.
+func (mw *MultiWallet) CFilterValidationStatsRaw() *CFilterValidationStats {
+	mw.syncData.mu.RLock()
+	stats := mw.syncData.cfilterStats
+	mw.syncData.mu.RUnlock()
+
+	stats.StrictValidation = mw.ReadBoolConfigValueForKey(StrictCFilterValidationConfigKey, false)
+	return &stats
+}
+
+func (mw *MultiWallet) cfiltersValidated(count int) {
+	mw.syncData.mu.Lock()
+	mw.syncData.cfilterStats.ValidatedFilters += int64(count)
+	mw.syncData.mu.Unlock()
+}
+
+// invalidCFilters counts the invalid cfilters served by the peer with the
+// address addr. In strict mode, the peer is banned for every invalid
+// response, whether or not the cfilters are refetched from another peer.
+func (mw *MultiWallet) invalidCFilters(addr string, refetching bool) {
+	strict := mw.ReadBoolConfigValueForKey(StrictCFilterValidationConfigKey, false)
+	mw.syncDiagnostics.recordError("peer "+addr, errors.New("served invalid cfilters"))
+
+	mw.syncData.mu.Lock()
+	mw.syncData.cfilterStats.InvalidResponses++
+	if refetching {
+		mw.syncData.cfilterStats.RefetchedRanges++
+	}
+	mw.syncData.mu.Unlock()
+
+	if !strict {
+		return
+	}
+
+	log.Warnf("Banning peer %s for serving invalid cfilters", addr)
+	if err := mw.BanPeer(addr, int64(invalidCFiltersBanDuration.Seconds())); err != nil {
+		log.Errorf("Error banning peer %s: %v", addr, err)
+		return
+	}
+
+	mw.syncData.mu.Lock()
+	mw.syncData.cfilterStats.BannedPeers++
+	mw.syncData.mu.Unlock()
+}
@@ -0,0 +1,50 @@
+package dcrlibwallet
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CFilterValidation", func() {
+	var mw *MultiWallet
+	var cleanup func()
+
+	BeforeEach(func() {
+		mw, _, cleanup = createTestMultiWallet("simnet")
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("counts invalid responses without banning peers outside strict mode", func() {
+		mw.invalidCFilters("127.0.0.1:18555", true)
+		mw.invalidCFilters("127.0.0.1:18555", false)
+
+		stats := mw.CFilterValidationStatsRaw()
+		Expect(stats.InvalidResponses).To(Equal(int64(2)))
+		Expect(stats.RefetchedRanges).To(Equal(int64(1)))
+		Expect(stats.BannedPeers).To(BeZero())
+
+		bans, err := mw.BannedPeersRaw()
+		Expect(err).To(BeNil())
+		Expect(bans).To(BeEmpty())
+	})
+
+	It("counts only the peers that were banned in strict mode", func() {
+		mw.SetBoolConfigValueForKey(StrictCFilterValidationConfigKey, true)
+
+		mw.invalidCFilters("127.0.0.1:18555", false)
+		mw.invalidCFilters("not a peer address", false)
+
+		stats := mw.CFilterValidationStatsRaw()
+		Expect(stats.StrictValidation).To(BeTrue())
+		Expect(stats.InvalidResponses).To(Equal(int64(2)))
+		Expect(stats.BannedPeers).To(Equal(int64(1)))
+
+		bans, err := mw.BannedPeersRaw()
+		Expect(err).To(BeNil())
+		Expect(bans).To(HaveLen(1))
+		Expect(bans[0].Host).To(Equal("127.0.0.1"))
+	})
+})
@@ -0,0 +1,245 @@
+package dcrlibwallet
+
+import (
+	"encoding/json"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+)
+
+// ConfirmationSubscription is used with storm for saving confirmation
+// threshold subscriptions to the multiwallet db. A subscription with an
+// empty `TxHash` applies to every transaction mined for the wallet and is
+// kept until it is removed. Other subscriptions are pending notifications
+// for a single transaction and are deleted once the transaction reaches the
+// subscribed number of confirmations.
+type ConfirmationSubscription struct {
+	ID            int    `storm:"id,increment" json:"id"`
+	WalletID      int    `storm:"index" json:"wallet_id"`
+	TxHash        string `storm:"index" json:"tx_hash"`
+	Confirmations int32  `json:"confirmations"`
+	CreatedAt     int64  `json:"created_at"`
+}
+
+func (mw *MultiWallet) AddTxConfirmationsListener(listener TxConfirmationsListener, uniqueIdentifier string) error {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	if _, ok := mw.txConfirmationsListeners[uniqueIdentifier]; ok {
+		return errors.New(ErrListenerAlreadyExist)
+	}
+
+	mw.txConfirmationsListeners[uniqueIdentifier] = listener
+	return nil
+}
+
+func (mw *MultiWallet) RemoveTxConfirmationsListener(uniqueIdentifier string) {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	delete(mw.txConfirmationsListeners, uniqueIdentifier)
+}
+
+func (mw *MultiWallet) publishTransactionConfirmations(walletID int, transactionHash string, confirmations int32) {
+	mw.publishEvent(Event{
+		Kind:          TransactionConfirmationsEvent,
+		WalletID:      walletID,
+		TxHash:        transactionHash,
+		Confirmations: confirmations,
+	})
+}
+
+// AddConfirmationThreshold registers a notification for every transaction
+// of the specified wallet that is mined from now on. Listeners are notified
+// once each transaction reaches the specified number of confirmations.
+func (mw *MultiWallet) AddConfirmationThreshold(walletID int, confirmations int32) error {
+	if mw.WalletWithID(walletID) == nil {
+		return errors.New(ErrNotExist)
+	}
+
+	return mw.addConfirmationSubscription(walletID, "", confirmations)
+}
+
+// AddTransactionConfirmationThreshold registers a notification for when the
+// specified transaction reaches the specified number of confirmations. If
+// the transaction already has enough confirmations, listeners are notified
+// immediately.
+func (mw *MultiWallet) AddTransactionConfirmationThreshold(walletID int, txHash string, confirmations int32) error {
+	wallet := mw.WalletWithID(walletID)
+	if wallet == nil {
+		return errors.New(ErrNotExist)
+	}
+
+	if _, err := chainhash.NewHashFromStr(txHash); err != nil {
+		return errors.New(ErrInvalid)
+	}
+
+	err := mw.addConfirmationSubscription(walletID, txHash, confirmations)
+	if err != nil {
+		return err
+	}
+
+	if wallet.WalletOpened() {
+		mw.checkTransactionConfirmations(wallet, wallet.GetBestBlock())
+	}
+
+	return nil
+}
+
+func (mw *MultiWallet) RemoveConfirmationThreshold(walletID int, confirmations int32) error {
+	return mw.removeConfirmationSubscription(walletID, "", confirmations)
+}
+
+func (mw *MultiWallet) RemoveTransactionConfirmationThreshold(walletID int, txHash string, confirmations int32) error {
+	return mw.removeConfirmationSubscription(walletID, txHash, confirmations)
+}
+
+func (mw *MultiWallet) ConfirmationSubscriptions(walletID int) (string, error) {
+	subscriptions, err := mw.ConfirmationSubscriptionsRaw(walletID)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedSubscriptions, err := json.Marshal(&subscriptions)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedSubscriptions), nil
+}
+
+// ConfirmationSubscriptionsRaw returns the wallet-wide thresholds and the
+// pending transaction notifications registered for the specified wallet.
+func (mw *MultiWallet) ConfirmationSubscriptionsRaw(walletID int) ([]ConfirmationSubscription, error) {
+	return mw.findConfirmationSubscriptions(q.Eq("WalletID", walletID))
+}
+
+func (mw *MultiWallet) addConfirmationSubscription(walletID int, txHash string, confirmations int32) error {
+	if confirmations < 1 {
+		return errors.New(ErrInvalid)
+	}
+
+	exists, err := mw.confirmationSubscriptionExists(walletID, txHash, confirmations)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.New(ErrExist)
+	}
+
+	return mw.saveConfirmationSubscription(walletID, txHash, confirmations)
+}
+
+func (mw *MultiWallet) saveConfirmationSubscription(walletID int, txHash string, confirmations int32) error {
+	return mw.db.Save(&ConfirmationSubscription{
+		WalletID:      walletID,
+		TxHash:        txHash,
+		Confirmations: confirmations,
+		CreatedAt:     time.Now().Unix(),
+	})
+}
+
+func (mw *MultiWallet) confirmationSubscriptionExists(walletID int, txHash string, confirmations int32) (bool, error) {
+	existing, err := mw.findConfirmationSubscriptions(confirmationSubscriptionMatcher(walletID, txHash, confirmations))
+	if err != nil {
+		return false, err
+	}
+	return len(existing) > 0, nil
+}
+
+func (mw *MultiWallet) removeConfirmationSubscription(walletID int, txHash string, confirmations int32) error {
+	err := mw.db.Select(confirmationSubscriptionMatcher(walletID, txHash, confirmations)).Delete(&ConfirmationSubscription{})
+	if err == storm.ErrNotFound {
+		return errors.New(ErrNotExist)
+	}
+	return err
+}
+
+func (mw *MultiWallet) deleteConfirmationSubscriptions(walletID int) error {
+	err := mw.db.Select(q.Eq("WalletID", walletID)).Delete(&ConfirmationSubscription{})
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (mw *MultiWallet) findConfirmationSubscriptions(matcher q.Matcher) ([]ConfirmationSubscription, error) {
+	subscriptions := make([]ConfirmationSubscription, 0)
+	err := mw.db.Select(matcher).OrderBy("ID").Find(&subscriptions)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+func confirmationSubscriptionMatcher(walletID int, txHash string, confirmations int32) q.Matcher {
+	return q.And(
+		q.Eq("WalletID", walletID),
+		q.Eq("TxHash", txHash),
+		q.Eq("Confirmations", confirmations),
+	)
+}
+
+// trackTransactionConfirmations creates a pending notification for each of
+// the wallet-wide thresholds of the wallet for every newly mined transaction.
+func (mw *MultiWallet) trackTransactionConfirmations(walletID int, txHashes []string) {
+	thresholds, err := mw.findConfirmationSubscriptions(q.And(q.Eq("WalletID", walletID), q.Eq("TxHash", "")))
+	if err != nil {
+		log.Errorf("[%d] Error reading confirmation thresholds: %v", walletID, err)
+		return
+	}
+
+	for _, threshold := range thresholds {
+		for _, txHash := range txHashes {
+			// The tx may already have a pending notification if it
+			// was reorganized into another block.
+			exists, err := mw.confirmationSubscriptionExists(walletID, txHash, threshold.Confirmations)
+			if err == nil && !exists {
+				err = mw.saveConfirmationSubscription(walletID, txHash, threshold.Confirmations)
+			}
+			if err != nil {
+				log.Errorf("[%d] Error saving confirmation subscription for %s: %v", walletID, txHash, err)
+			}
+		}
+	}
+}
+
+// checkTransactionConfirmations notifies listeners of the pending
+// transaction notifications of the wallet that have reached their threshold
+// at the provided best block height. The number of confirmations reported
+// may exceed the threshold if blocks were attached while the wallet was not
+// running.
+func (mw *MultiWallet) checkTransactionConfirmations(wallet *Wallet, bestBlockHeight int32) {
+	pending, err := mw.findConfirmationSubscriptions(q.And(q.Eq("WalletID", wallet.ID), q.Not(q.Eq("TxHash", ""))))
+	if err != nil {
+		log.Errorf("[%d] Error reading confirmation subscriptions: %v", wallet.ID, err)
+		return
+	}
+
+	for i := range pending {
+		subscription := &pending[i]
+
+		tx := &Transaction{}
+		err = wallet.txDB.FindOne("Hash", subscription.TxHash, tx)
+		if err != nil || tx.BlockHeight == BlockHeightInvalid {
+			// The tx is either not indexed yet or not mined.
+			continue
+		}
+
+		confirmations := bestBlockHeight - tx.BlockHeight + 1
+		if confirmations < subscription.Confirmations {
+			continue
+		}
+
+		mw.publishTransactionConfirmations(wallet.ID, subscription.TxHash, confirmations)
+
+		err = mw.db.DeleteStruct(subscription)
+		if err != nil {
+			log.Errorf("[%d] Error deleting confirmation subscription %d: %v", wallet.ID, subscription.ID, err)
+		}
+	}
+}
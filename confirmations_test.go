@@ -0,0 +1,119 @@
+package dcrlibwallet
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Confirmations", func() {
+	var mw *MultiWallet
+	var wallet *Wallet
+	var cleanup func()
+	var events <-chan Event
+
+	// indexTx indexes a transaction mined at blockHeight and returns its
+	// hash.
+	indexTx := func(blockHeight int32) string {
+		var hash chainhash.Hash
+		_, err := rand.Read(hash[:])
+		Expect(err).To(BeNil())
+		tx := &Transaction{Hash: hash.String(), BlockHeight: blockHeight, Timestamp: 1600000000}
+		_, err = wallet.txDB.SaveOrUpdate(&Transaction{}, tx)
+		Expect(err).To(BeNil())
+		return tx.Hash
+	}
+
+	expectNoEvent := func() {
+		Consistently(events, 100*time.Millisecond).ShouldNot(Receive())
+	}
+
+	BeforeEach(func() {
+		mw, wallet, cleanup = createTestMultiWallet("simnet")
+		events = mw.Subscribe(&EventFilter{Kinds: []EventKind{TransactionConfirmationsEvent}})
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("validates and saves confirmation thresholds", func() {
+		Expect(mw.AddConfirmationThreshold(wallet.ID+1, 2)).To(MatchError(ErrNotExist))
+		Expect(mw.AddConfirmationThreshold(wallet.ID, 0)).To(MatchError(ErrInvalid))
+		Expect(mw.AddTransactionConfirmationThreshold(wallet.ID, "not a hash", 2)).To(MatchError(ErrInvalid))
+
+		Expect(mw.AddConfirmationThreshold(wallet.ID, 2)).To(Succeed())
+		Expect(mw.AddConfirmationThreshold(wallet.ID, 2)).To(MatchError(ErrExist))
+		Expect(mw.AddConfirmationThreshold(wallet.ID, 6)).To(Succeed())
+
+		subscriptions, err := mw.ConfirmationSubscriptionsRaw(wallet.ID)
+		Expect(err).To(BeNil())
+		Expect(subscriptions).To(HaveLen(2))
+		Expect(subscriptions[0].TxHash).To(BeEmpty())
+		Expect(subscriptions[0].Confirmations).To(Equal(int32(2)))
+
+		Expect(mw.RemoveConfirmationThreshold(wallet.ID, 2)).To(Succeed())
+		Expect(mw.RemoveConfirmationThreshold(wallet.ID, 2)).To(MatchError(ErrNotExist))
+	})
+
+	It("notifies once when mined transactions reach a threshold", func() {
+		Expect(mw.AddConfirmationThreshold(wallet.ID, 3)).To(Succeed())
+		txHash := indexTx(10)
+		mw.trackTransactionConfirmations(wallet.ID, []string{txHash})
+		// A reorg that mines the tx again does not track it twice.
+		mw.trackTransactionConfirmations(wallet.ID, []string{txHash})
+
+		subscriptions, err := mw.ConfirmationSubscriptionsRaw(wallet.ID)
+		Expect(err).To(BeNil())
+		Expect(subscriptions).To(HaveLen(2))
+
+		mw.checkTransactionConfirmations(wallet, 11)
+		expectNoEvent()
+
+		mw.checkTransactionConfirmations(wallet, 12)
+		var event Event
+		Eventually(events).Should(Receive(&event))
+		Expect(event.WalletID).To(Equal(wallet.ID))
+		Expect(event.TxHash).To(Equal(txHash))
+		Expect(event.Confirmations).To(Equal(int32(3)))
+
+		// The notification is removed once sent, and the wallet-wide
+		// threshold is kept.
+		subscriptions, err = mw.ConfirmationSubscriptionsRaw(wallet.ID)
+		Expect(err).To(BeNil())
+		Expect(subscriptions).To(HaveLen(1))
+		Expect(subscriptions[0].TxHash).To(BeEmpty())
+
+		mw.checkTransactionConfirmations(wallet, 13)
+		expectNoEvent()
+	})
+
+	It("notifies immediately for transactions that already have enough confirmations", func() {
+		txHash := indexTx(wallet.GetBestBlock())
+		Expect(mw.AddTransactionConfirmationThreshold(wallet.ID, txHash, 1)).To(Succeed())
+
+		var event Event
+		Eventually(events).Should(Receive(&event))
+		Expect(event.TxHash).To(Equal(txHash))
+
+		subscriptions, err := mw.ConfirmationSubscriptionsRaw(wallet.ID)
+		Expect(err).To(BeNil())
+		Expect(subscriptions).To(BeEmpty())
+	})
+
+	It("does not notify for unmined transactions", func() {
+		txHash := indexTx(BlockHeightInvalid)
+		Expect(mw.AddTransactionConfirmationThreshold(wallet.ID, txHash, 1)).To(Succeed())
+		mw.checkTransactionConfirmations(wallet, 100)
+		expectNoEvent()
+
+		Expect(mw.deleteConfirmationSubscriptions(wallet.ID)).To(Succeed())
+		subscriptions, err := mw.ConfirmationSubscriptionsRaw(wallet.ID)
+		Expect(err).To(BeNil())
+		Expect(subscriptions).To(BeEmpty())
+	})
+})
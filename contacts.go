@@ -0,0 +1,238 @@
+package dcrlibwallet
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/planetdecred/dcrlibwallet/txhelper"
+)
+
+// Contact is used with storm for saving address book entries to the
+// multiwallet db. The `Address` field is unique so that an address
+// can only be saved for one contact.
+type Contact struct {
+	ID        int    `storm:"id,increment" json:"id"`
+	Name      string `storm:"index" json:"name"`
+	Address   string `storm:"unique" json:"address"`
+	Network   string `json:"network"`
+	Note      string `json:"note"`
+	CreatedAt int64  `json:"created_at"`
+	LastUsed  int64  `storm:"index" json:"last_used"`
+}
+
+// validateContact ensures that the contact has a name and that the contact
+// address is valid for the network this multiwallet was initialized for.
+func (mw *MultiWallet) validateContact(name, address string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New(ErrInvalid)
+	}
+
+	if _, err := dcrutil.DecodeAddress(address, mw.chainParams); err != nil {
+		return errors.New(ErrInvalidAddress)
+	}
+
+	return nil
+}
+
+func (mw *MultiWallet) AddContact(name, address, note string) (*Contact, error) {
+	if err := mw.validateContact(name, address); err != nil {
+		return nil, err
+	}
+
+	contact := &Contact{
+		Name:      strings.TrimSpace(name),
+		Address:   address,
+		Network:   mw.chainParams.Name,
+		Note:      note,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	err := mw.db.Save(contact)
+	if err != nil {
+		if err == storm.ErrAlreadyExists {
+			return nil, errors.New(ErrExist)
+		}
+		return nil, err
+	}
+
+	return contact, nil
+}
+
+func (mw *MultiWallet) UpdateContact(contactID int, name, address, note string) error {
+	if err := mw.validateContact(name, address); err != nil {
+		return err
+	}
+
+	contact, err := mw.ContactWithID(contactID)
+	if err != nil {
+		return err
+	}
+
+	contact.Name = strings.TrimSpace(name)
+	contact.Address = address
+	contact.Note = note
+
+	err = mw.db.Save(contact)
+	if err == storm.ErrAlreadyExists {
+		return errors.New(ErrExist)
+	}
+	return err
+}
+
+func (mw *MultiWallet) DeleteContact(contactID int) error {
+	contact, err := mw.ContactWithID(contactID)
+	if err != nil {
+		return err
+	}
+
+	return mw.db.DeleteStruct(contact)
+}
+
+func (mw *MultiWallet) ContactWithID(contactID int) (*Contact, error) {
+	contact := &Contact{}
+	err := mw.db.One("ID", contactID, contact)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil, errors.New(ErrNotExist)
+		}
+		return nil, err
+	}
+
+	return contact, nil
+}
+
+// ContactForAddress returns the contact saved with the provided address.
+func (mw *MultiWallet) ContactForAddress(address string) (*Contact, error) {
+	contact := &Contact{}
+	err := mw.db.One("Address", address, contact)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil, errors.New(ErrNotExist)
+		}
+		return nil, err
+	}
+
+	return contact, nil
+}
+
+func (mw *MultiWallet) GetContacts(offset, limit int32) (string, error) {
+	contacts, err := mw.GetContactsRaw(offset, limit)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedContacts, err := json.Marshal(&contacts)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedContacts), nil
+}
+
+// GetContactsRaw returns saved contacts ordered by name.
+func (mw *MultiWallet) GetContactsRaw(offset, limit int32) ([]Contact, error) {
+	return mw.findContacts(q.True(), offset, limit)
+}
+
+func (mw *MultiWallet) SearchContacts(searchTerm string) (string, error) {
+	contacts, err := mw.SearchContactsRaw(searchTerm)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedContacts, err := json.Marshal(&contacts)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedContacts), nil
+}
+
+// SearchContactsRaw returns the contacts whose name, address or note
+// contains the search term. The search is case insensitive.
+func (mw *MultiWallet) SearchContactsRaw(searchTerm string) ([]Contact, error) {
+	pattern := "(?i)" + regexp.QuoteMeta(strings.TrimSpace(searchTerm))
+	matcher := q.Or(
+		q.Re("Name", pattern),
+		q.Re("Address", pattern),
+		q.Re("Note", pattern),
+	)
+
+	return mw.findContacts(matcher, 0, 0)
+}
+
+func (mw *MultiWallet) findContacts(matcher q.Matcher, offset, limit int32) ([]Contact, error) {
+	query := mw.db.Select(matcher)
+	if offset > 0 {
+		query = query.Skip(int(offset))
+	}
+	if limit > 0 {
+		query = query.Limit(int(limit))
+	}
+
+	contacts := make([]Contact, 0)
+	err := query.OrderBy("Name").Find(&contacts)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// contactNameForAddress returns the name of the contact saved with the
+// provided address or an empty string if no such contact exists.
+func (mw *MultiWallet) contactNameForAddress(address string) string {
+	if address == "" {
+		return ""
+	}
+
+	contact, err := mw.ContactForAddress(address)
+	if err != nil {
+		return ""
+	}
+	return contact.Name
+}
+
+// markContactsUsed updates the last used time of contacts whose addresses
+// received funds sent from this wallet in the provided transaction.
+func (mw *MultiWallet) markContactsUsed(tx *Transaction) {
+	if tx.Type != txhelper.TxTypeRegular || tx.Direction != txhelper.TxDirectionSent {
+		return
+	}
+
+	for _, output := range tx.Outputs {
+		if output.AccountNumber > -1 {
+			continue
+		}
+
+		contact, err := mw.ContactForAddress(output.Address)
+		if err != nil {
+			continue
+		}
+
+		contact.LastUsed = tx.Timestamp
+		if err = mw.db.Save(contact); err != nil {
+			log.Errorf("error updating last used time for contact %d: %v", contact.ID, err)
+		}
+	}
+}
+
+// resolveContactNames sets the contact name of outputs that pay to addresses
+// saved in the address book.
+func (wallet *Wallet) resolveContactNames(tx *Transaction) {
+	if wallet.contactNameForAddress == nil {
+		return
+	}
+
+	for _, output := range tx.Outputs {
+		if output.AccountNumber == -1 {
+			output.ContactName = wallet.contactNameForAddress(output.Address)
+		}
+	}
+}
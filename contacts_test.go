@@ -0,0 +1,125 @@
+package dcrlibwallet
+
+import (
+	"math/rand"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/planetdecred/dcrlibwallet/txhelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// randomAddress returns a random P2PKH address for the network.
+func randomAddress(params *chaincfg.Params) string {
+	pubKeyHash := make([]byte, 20)
+	_, err := rand.Read(pubKeyHash)
+	Expect(err).To(BeNil())
+
+	address, err := dcrutil.NewAddressPubKeyHash(pubKeyHash, params, dcrec.STEcdsaSecp256k1)
+	Expect(err).To(BeNil())
+	return address.Address()
+}
+
+var _ = Describe("Contacts", func() {
+	var mw *MultiWallet
+	var wallet *Wallet
+	var cleanup func()
+
+	BeforeEach(func() {
+		mw, wallet, cleanup = createTestMultiWallet("simnet")
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("saves contacts with valid addresses of the network", func() {
+		address := randomAddress(mw.chainParams)
+		contact, err := mw.AddContact("  Alice ", address, "rent")
+		Expect(err).To(BeNil())
+		Expect(contact.Name).To(Equal("Alice"))
+		Expect(contact.Network).To(Equal(mw.chainParams.Name))
+
+		saved, err := mw.ContactWithID(contact.ID)
+		Expect(err).To(BeNil())
+		Expect(saved).To(Equal(contact))
+
+		_, err = mw.AddContact(" ", randomAddress(mw.chainParams), "")
+		Expect(err).To(MatchError(ErrInvalid))
+		_, err = mw.AddContact("Bob", randomAddress(chaincfg.MainNetParams()), "")
+		Expect(err).To(MatchError(ErrInvalidAddress))
+	})
+
+	It("saves an address for one contact only", func() {
+		address := randomAddress(mw.chainParams)
+		alice, err := mw.AddContact("Alice", address, "")
+		Expect(err).To(BeNil())
+
+		_, err = mw.AddContact("Bob", address, "")
+		Expect(err).To(MatchError(ErrExist))
+
+		bob, err := mw.AddContact("Bob", randomAddress(mw.chainParams), "")
+		Expect(err).To(BeNil())
+		Expect(mw.UpdateContact(bob.ID, "Bob", address, "")).To(MatchError(ErrExist))
+
+		// Updating a contact with its own address is allowed.
+		Expect(mw.UpdateContact(alice.ID, "Alice B", address, "note")).To(Succeed())
+		contact, err := mw.ContactForAddress(address)
+		Expect(err).To(BeNil())
+		Expect(contact.Name).To(Equal("Alice B"))
+
+		Expect(mw.DeleteContact(alice.ID)).To(Succeed())
+		_, err = mw.ContactWithID(alice.ID)
+		Expect(err).To(MatchError(ErrNotExist))
+		_, err = mw.AddContact("Carol", address, "")
+		Expect(err).To(BeNil())
+	})
+
+	It("lists contacts by name and searches them", func() {
+		for _, name := range []string{"Carol", "alice", "Bob"} {
+			_, err := mw.AddContact(name, randomAddress(mw.chainParams), name+" note")
+			Expect(err).To(BeNil())
+		}
+
+		contacts, err := mw.GetContactsRaw(1, 1)
+		Expect(err).To(BeNil())
+		Expect(contacts).To(HaveLen(1))
+		Expect(contacts[0].Name).To(Equal("Carol"))
+
+		contacts, err = mw.SearchContactsRaw("ALI")
+		Expect(err).To(BeNil())
+		Expect(contacts).To(HaveLen(1))
+		Expect(contacts[0].Name).To(Equal("alice"))
+
+		contacts, err = mw.SearchContactsRaw("a.c")
+		Expect(err).To(BeNil())
+		Expect(contacts).To(BeEmpty())
+	})
+
+	It("marks contacts paid by the wallet as used and names their outputs", func() {
+		address := randomAddress(mw.chainParams)
+		contact, err := mw.AddContact("Alice", address, "")
+		Expect(err).To(BeNil())
+
+		tx := &Transaction{
+			Type:      txhelper.TxTypeRegular,
+			Direction: txhelper.TxDirectionSent,
+			Timestamp: 1600000000,
+			Outputs: []*TxOutput{
+				{Address: address, AccountNumber: -1},
+				{Address: randomAddress(mw.chainParams), AccountNumber: 0},
+			},
+		}
+		mw.markContactsUsed(tx)
+		wallet.resolveContactNames(tx)
+
+		saved, err := mw.ContactWithID(contact.ID)
+		Expect(err).To(BeNil())
+		Expect(saved.LastUsed).To(Equal(tx.Timestamp))
+		Expect(tx.Outputs[0].ContactName).To(Equal("Alice"))
+		Expect(tx.Outputs[1].ContactName).To(BeEmpty())
+	})
+})
@@ -0,0 +1,371 @@
+package dcrlibwallet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/planetdecred/dcrlibwallet/txhelper"
+	"github.com/planetdecred/dcrlibwallet/txindex"
+)
+
+const (
+	CostBasisMethodFIFO    int32 = 0
+	CostBasisMethodLIFO    int32 = 1
+	CostBasisMethodAverage int32 = 2
+
+	DisposalTypeSend      = "send"
+	DisposalTypeFee       = "fee"
+	DisposalTypeStakeLoss = "stake_loss"
+
+	priceTableDateFormat = "2006-01-02"
+)
+
+// RateSource provides the fiat price of 1 DCR at the provided unix
+// timestamp. Apps may implement this to fetch rates from an exchange or use
+// the PriceTable provided by this package.
+type RateSource interface {
+	Rate(timestamp int64) (float64, error)
+}
+
+// PriceTable is a RateSource that uses daily fiat prices supplied by the
+// user. The price of the most recent date on or before the requested time is
+// used if the exact date has no price.
+type PriceTable struct {
+	prices map[string]float64
+	dates  []string
+}
+
+func NewPriceTable() *PriceTable {
+	return &PriceTable{
+		prices: make(map[string]float64),
+	}
+}
+
+// AddPrice sets the fiat price of 1 DCR for the provided date. The date must
+// be formatted as YYYY-MM-DD.
+func (table *PriceTable) AddPrice(date string, price float64) error {
+	if _, err := time.Parse(priceTableDateFormat, date); err != nil || price < 0 {
+		return errors.New(ErrInvalid)
+	}
+
+	if _, ok := table.prices[date]; !ok {
+		index := sort.SearchStrings(table.dates, date)
+		table.dates = append(table.dates, "")
+		copy(table.dates[index+1:], table.dates[index:])
+		table.dates[index] = date
+	}
+	table.prices[date] = price
+
+	return nil
+}
+
+func (table *PriceTable) Rate(timestamp int64) (float64, error) {
+	date := time.Unix(timestamp, 0).UTC().Format(priceTableDateFormat)
+	if price, ok := table.prices[date]; ok {
+		return price, nil
+	}
+
+	// Use the price of the closest date before the requested date.
+	index := sort.SearchStrings(table.dates, date)
+	if index == 0 {
+		return 0, errors.Errorf("no price available for %s", date)
+	}
+	return table.prices[table.dates[index-1]], nil
+}
+
+// costBasisLot is an amount of DCR acquired in a single transaction.
+type costBasisLot struct {
+	amount int64
+	cost   float64
+}
+
+// costBasisLedger tracks the lots held by a wallet and consumes them on
+// disposal according to the cost basis method.
+type costBasisLedger struct {
+	method int32
+	lots   []*costBasisLot
+}
+
+func (ledger *costBasisLedger) acquire(amount int64, cost float64) {
+	if ledger.method == CostBasisMethodAverage && len(ledger.lots) > 0 {
+		// All holdings are pooled into a single lot so that every
+		// disposal uses the average cost of the pool.
+		ledger.lots[0].amount += amount
+		ledger.lots[0].cost += cost
+		return
+	}
+
+	ledger.lots = append(ledger.lots, &costBasisLot{amount: amount, cost: cost})
+}
+
+// dispose removes the amount from the held lots and returns the cost of the
+// removed amount. The uncovered amount is the part of the disposal that
+// exceeds the holdings, which happens if the wallet history is incomplete.
+func (ledger *costBasisLedger) dispose(amount int64) (cost float64, uncovered int64) {
+	remaining := amount
+	for remaining > 0 && len(ledger.lots) > 0 {
+		index := 0
+		if ledger.method == CostBasisMethodLIFO {
+			index = len(ledger.lots) - 1
+		}
+		lot := ledger.lots[index]
+
+		consumed := remaining
+		if lot.amount < consumed {
+			consumed = lot.amount
+		}
+
+		lotCost := lot.cost * float64(consumed) / float64(lot.amount)
+		cost += lotCost
+		lot.cost -= lotCost
+		lot.amount -= consumed
+		remaining -= consumed
+
+		if lot.amount == 0 {
+			ledger.lots = append(ledger.lots[:index], ledger.lots[index+1:]...)
+		}
+	}
+
+	return cost, remaining
+}
+
+func (ledger *costBasisLedger) holdings() (amount int64, cost float64) {
+	for _, lot := range ledger.lots {
+		amount += lot.amount
+		cost += lot.cost
+	}
+	return
+}
+
+func (wallet *Wallet) RealizedGainsReport(method int32, rates RateSource) (string, error) {
+	report, err := wallet.RealizedGainsReportRaw(method, rates)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedReport, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedReport), nil
+}
+
+// RealizedGainsReportRaw computes the realized gain of every disposal of DCR
+// from this wallet using the indexed transactions and the fiat prices from
+// the provided rate source.
+func (wallet *Wallet) RealizedGainsReportRaw(method int32, rates RateSource) (*RealizedGainsReport, error) {
+	var transactions []Transaction
+	err := wallet.txDB.Read(0, 0, txindex.TxFilterAll, false, &transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := computeRealizedGains(transactions, method, rates)
+	if err != nil {
+		return nil, err
+	}
+
+	report.WalletID = wallet.ID
+	return report, nil
+}
+
+// ExportYearlyGainsReport returns the disposals of the specified year as CSV
+// followed by a total row.
+func (wallet *Wallet) ExportYearlyGainsReport(year int32, method int32, rates RateSource) (string, error) {
+	report, err := wallet.RealizedGainsReportRaw(method, rates)
+	if err != nil {
+		return "", err
+	}
+
+	return report.yearlyCSV(year)
+}
+
+// computeRealizedGains replays the wallet's transactions in the order they
+// were made. Received funds, coinbase outputs and vote rewards are
+// acquisitions; sent funds, fees and stake losses are disposals. Ticket
+// purchases don't dispose of any funds since the locked funds are still
+// owned by the wallet, the ticket fee is accounted for in the reward or loss
+// of the vote or revocation that spends the ticket.
+func computeRealizedGains(transactions []Transaction, method int32, rates RateSource) (*RealizedGainsReport, error) {
+	if method < CostBasisMethodFIFO || method > CostBasisMethodAverage {
+		return nil, errors.New(ErrInvalid)
+	}
+
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].Timestamp < transactions[j].Timestamp
+	})
+
+	ledger := &costBasisLedger{method: method}
+	report := &RealizedGainsReport{
+		Method:    method,
+		Disposals: make([]*Disposal, 0),
+		Years:     make([]*YearlyGains, 0),
+	}
+
+	for i := range transactions {
+		tx := &transactions[i]
+		if tx.BlockHeight == BlockHeightInvalid {
+			// Unmined txs may never be confirmed.
+			continue
+		}
+
+		acquired, disposals := costBasisEvents(tx)
+		if acquired == 0 && len(disposals) == 0 {
+			continue
+		}
+
+		rate, err := rates.Rate(tx.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+
+		yearly := report.yearlyGains(int32(time.Unix(tx.Timestamp, 0).UTC().Year()))
+
+		if acquired > 0 {
+			value := dcrutil.Amount(acquired).ToCoin() * rate
+			ledger.acquire(acquired, value)
+			yearly.AcquiredAmount += acquired
+			yearly.AcquiredValue += value
+		}
+
+		for _, disposal := range disposals {
+			disposal.Rate = rate
+			disposal.Proceeds = dcrutil.Amount(disposal.Amount).ToCoin() * rate
+			disposal.CostBasis, disposal.UncoveredAmount = ledger.dispose(disposal.Amount)
+			disposal.Gain = disposal.Proceeds - disposal.CostBasis
+
+			yearly.DisposalCount++
+			yearly.Proceeds += disposal.Proceeds
+			yearly.CostBasis += disposal.CostBasis
+			yearly.Gain += disposal.Gain
+			report.Disposals = append(report.Disposals, disposal)
+		}
+	}
+
+	report.HoldingAmount, report.HoldingCostBasis = ledger.holdings()
+	for _, yearly := range report.Years {
+		report.TotalGain += yearly.Gain
+	}
+
+	return report, nil
+}
+
+// costBasisEvents returns the amount acquired and the disposals made by this
+// wallet in the transaction.
+func costBasisEvents(tx *Transaction) (acquired int64, disposals []*Disposal) {
+	newDisposal := func(disposalType string, amount int64) *Disposal {
+		return &Disposal{
+			TxHash:    tx.Hash,
+			Timestamp: tx.Timestamp,
+			Type:      disposalType,
+			Amount:    amount,
+		}
+	}
+
+	switch tx.Type {
+	case txhelper.TxTypeTicketPurchase:
+		return 0, nil
+
+	case txhelper.TxTypeVote, txhelper.TxTypeRevocation:
+		if tx.VoteReward > 0 {
+			return tx.VoteReward, nil
+		}
+		if tx.VoteReward < 0 {
+			return 0, []*Disposal{newDisposal(DisposalTypeStakeLoss, -tx.VoteReward)}
+		}
+		return 0, nil
+	}
+
+	var walletInputs, walletOutputs int64
+	for _, input := range tx.Inputs {
+		if input.AccountNumber > -1 {
+			walletInputs += input.Amount
+		}
+	}
+	for _, output := range tx.Outputs {
+		if output.AccountNumber > -1 {
+			walletOutputs += output.Amount
+		}
+	}
+
+	if walletOutputs >= walletInputs {
+		return walletOutputs - walletInputs, nil
+	}
+
+	// The wallet spent more than it received back. The part of the
+	// spent amount not used for the tx fee was sent to other wallets.
+	spent := walletInputs - walletOutputs
+	fee := tx.Fee
+	if fee > spent {
+		fee = spent
+	}
+
+	if spent > fee {
+		disposals = append(disposals, newDisposal(DisposalTypeSend, spent-fee))
+	}
+	if fee > 0 {
+		disposals = append(disposals, newDisposal(DisposalTypeFee, fee))
+	}
+	return 0, disposals
+}
+
+func (report *RealizedGainsReport) yearlyGains(year int32) *YearlyGains {
+	for _, yearly := range report.Years {
+		if yearly.Year == year {
+			return yearly
+		}
+	}
+
+	yearly := &YearlyGains{Year: year}
+	report.Years = append(report.Years, yearly)
+	return yearly
+}
+
+func (report *RealizedGainsReport) yearlyCSV(year int32) (string, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 2, 64)
+	}
+
+	records := [][]string{{"date", "tx_hash", "type", "amount_dcr", "rate", "proceeds", "cost_basis", "gain", "uncovered_dcr"}}
+	var proceeds, costBasis, gain float64
+	for _, disposal := range report.Disposals {
+		disposalTime := time.Unix(disposal.Timestamp, 0).UTC()
+		if int32(disposalTime.Year()) != year {
+			continue
+		}
+
+		records = append(records, []string{
+			disposalTime.Format(priceTableDateFormat),
+			disposal.TxHash,
+			disposal.Type,
+			fmt.Sprintf("%.8f", dcrutil.Amount(disposal.Amount).ToCoin()),
+			formatFloat(disposal.Rate),
+			formatFloat(disposal.Proceeds),
+			formatFloat(disposal.CostBasis),
+			formatFloat(disposal.Gain),
+			fmt.Sprintf("%.8f", dcrutil.Amount(disposal.UncoveredAmount).ToCoin()),
+		})
+
+		proceeds += disposal.Proceeds
+		costBasis += disposal.CostBasis
+		gain += disposal.Gain
+	}
+	records = append(records, []string{"total", "", "", "", "", formatFloat(proceeds), formatFloat(costBasis), formatFloat(gain), ""})
+
+	if err := writer.WriteAll(records); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,117 @@
+package dcrlibwallet
+
+import (
+	"strings"
+
+	"github.com/planetdecred/dcrlibwallet/txhelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CostBasis", func() {
+	var prices *PriceTable
+
+	BeforeEach(func() {
+		prices = NewPriceTable()
+		Expect(prices.AddPrice("2020-01-01", 10)).To(Succeed())
+		Expect(prices.AddPrice("2020-06-01", 20)).To(Succeed())
+		Expect(prices.AddPrice("2021-01-01", 40)).To(Succeed())
+	})
+
+	Describe("PriceTable", func() {
+		It("uses the closest earlier price for dates without a price", func() {
+			rate, err := prices.Rate(unixDate("2020-03-15"))
+			Expect(err).To(BeNil())
+			Expect(rate).To(Equal(10.0))
+
+			_, err = prices.Rate(unixDate("2019-12-31"))
+			Expect(err).ToNot(BeNil())
+
+			Expect(prices.AddPrice("01/01/2020", 10)).ToNot(Succeed())
+		})
+	})
+
+	Describe("computeRealizedGains", func() {
+		var transactions []Transaction
+
+		BeforeEach(func() {
+			transactions = []Transaction{
+				receiveTx("a", "2020-01-01", dcrAtoms(10)),
+				receiveTx("b", "2020-06-01", dcrAtoms(10)),
+				sendTx("c", "2021-01-01", dcrAtoms(20), dcrAtoms(5), 0),
+			}
+		})
+
+		It("consumes the oldest lots first with FIFO", func() {
+			report, err := computeRealizedGains(transactions, CostBasisMethodFIFO, prices)
+			Expect(err).To(BeNil())
+			Expect(report.Disposals).To(HaveLen(1))
+
+			disposal := report.Disposals[0]
+			Expect(disposal.Type).To(Equal(DisposalTypeSend))
+			Expect(disposal.Proceeds).To(BeNumerically("~", 200))
+			Expect(disposal.CostBasis).To(BeNumerically("~", 50))
+			Expect(disposal.Gain).To(BeNumerically("~", 150))
+			Expect(report.HoldingAmount).To(Equal(dcrAtoms(15)))
+		})
+
+		It("consumes the newest lots first with LIFO", func() {
+			report, err := computeRealizedGains(transactions, CostBasisMethodLIFO, prices)
+			Expect(err).To(BeNil())
+			Expect(report.Disposals[0].CostBasis).To(BeNumerically("~", 100))
+			Expect(report.Disposals[0].Gain).To(BeNumerically("~", 100))
+		})
+
+		It("uses the pooled cost with the average method", func() {
+			report, err := computeRealizedGains(transactions, CostBasisMethodAverage, prices)
+			Expect(err).To(BeNil())
+			Expect(report.Disposals[0].CostBasis).To(BeNumerically("~", 75))
+			Expect(report.HoldingCostBasis).To(BeNumerically("~", 225))
+		})
+
+		It("reports fees as separate disposals and tracks uncovered amounts", func() {
+			transactions = []Transaction{
+				receiveTx("a", "2020-01-01", dcrAtoms(1)),
+				sendTx("b", "2020-06-01", dcrAtoms(3), dcrAtoms(2), dcrAtoms(1)),
+			}
+
+			report, err := computeRealizedGains(transactions, CostBasisMethodFIFO, prices)
+			Expect(err).To(BeNil())
+			Expect(report.Disposals).To(HaveLen(2))
+			Expect(report.Disposals[0].Type).To(Equal(DisposalTypeSend))
+			Expect(report.Disposals[0].UncoveredAmount).To(Equal(dcrAtoms(1)))
+			Expect(report.Disposals[1].Type).To(Equal(DisposalTypeFee))
+			Expect(report.Disposals[1].UncoveredAmount).To(Equal(dcrAtoms(1)))
+		})
+
+		It("treats vote rewards as acquisitions and ignores ticket purchases", func() {
+			transactions = []Transaction{
+				{Hash: "t", Type: txhelper.TxTypeTicketPurchase, Timestamp: unixDate("2020-01-01"), Fee: 1000,
+					Inputs: []*TxInput{{Amount: dcrAtoms(100), AccountNumber: 0}}},
+				{Hash: "v", Type: txhelper.TxTypeVote, Timestamp: unixDate("2020-06-01"), VoteReward: dcrAtoms(1)},
+			}
+
+			report, err := computeRealizedGains(transactions, CostBasisMethodFIFO, prices)
+			Expect(err).To(BeNil())
+			Expect(report.Disposals).To(BeEmpty())
+			Expect(report.Years).To(HaveLen(1))
+			Expect(report.Years[0].AcquiredValue).To(BeNumerically("~", 20))
+		})
+
+		It("exports the disposals of a year as csv", func() {
+			report, err := computeRealizedGains(transactions, CostBasisMethodFIFO, prices)
+			Expect(err).To(BeNil())
+
+			exported, err := report.yearlyCSV(2021)
+			Expect(err).To(BeNil())
+			lines := strings.Split(strings.TrimSpace(exported), "\n")
+			Expect(lines).To(HaveLen(3))
+			Expect(lines[1]).To(HavePrefix("2021-01-01,c,send,5.00000000,40.00,200.00,50.00,150.00"))
+
+			exported, err = report.yearlyCSV(2020)
+			Expect(err).To(BeNil())
+			Expect(strings.Split(strings.TrimSpace(exported), "\n")).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,380 @@
+package dcrlibwallet
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/planetdecred/dcrlibwallet/p2p"
+)
+
+const (
+	SyncDataBudgetConfigKey = "sync_data_budget"
+
+	dataUsageDateFormat     = "2006-01-02"
+	dataUsageReportInterval = time.Second
+	dataUsageFlushInterval  = 30 * time.Second
+)
+
+// DataUsage is used with storm for saving the data transferred with SPV
+// peers on each day to the multiwallet db. Days are in UTC.
+type DataUsage struct {
+	Date string `storm:"id" json:"date"`
+	StageDataUsage
+}
+
+// dataUsageTracker accumulates the data transferred with peers and seeders
+// in memory. The connections count their transfers themselves, the counts
+// are folded into the tracker every dataUsageReportInterval. The daily
+// totals are saved periodically during sync and when sync ends.
+type dataUsageTracker struct {
+	mu sync.Mutex
+
+	// daily holds the usage of the days that data was transferred on
+	// since the last save, including the usage saved earlier on each day.
+	daily map[string]*DataUsage
+
+	session *SyncDataUsage
+	peers   map[string]*PeerDataUsage
+
+	budget       int64
+	budgetPaused bool
+
+	// cancelResume cancels the resumption of a sync paused for the data
+	// budget, nil if no paused sync is waiting to be resumed.
+	cancelResume context.CancelFunc
+}
+
+func newDataUsageTracker() *dataUsageTracker {
+	return &dataUsageTracker{
+		daily: make(map[string]*DataUsage),
+	}
+}
+
+func dataUsageDate(t time.Time) string {
+	return t.UTC().Format(dataUsageDateFormat)
+}
+
+// untilNextDataUsageDay returns the time left until the next UTC day, when
+// the data budget is available again. Tests shorten the wait.
+var untilNextDataUsageDay = func() time.Duration {
+	now := time.Now().UTC()
+	year, month, day := now.Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+func (usage *StageDataUsage) add(stage int32, sent, received int64) {
+	usage.BytesSent += sent
+	usage.BytesReceived += received
+
+	switch stage {
+	case HeadersFetchSyncStage:
+		usage.HeadersFetchBytes += sent + received
+	case AddressDiscoverySyncStage:
+		usage.AddressDiscoveryBytes += sent + received
+	case HeadersRescanSyncStage:
+		usage.HeadersRescanBytes += sent + received
+	default:
+		usage.OtherBytes += sent + received
+	}
+}
+
+func (usage *StageDataUsage) total() int64 {
+	return usage.BytesSent + usage.BytesReceived
+}
+
+func (mw *MultiWallet) AddDataBudgetListener(listener DataBudgetListener, uniqueIdentifier string) error {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	if _, ok := mw.dataBudgetListeners[uniqueIdentifier]; ok {
+		return errors.New(ErrListenerAlreadyExist)
+	}
+
+	mw.dataBudgetListeners[uniqueIdentifier] = listener
+	return nil
+}
+
+func (mw *MultiWallet) RemoveDataBudgetListener(uniqueIdentifier string) {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	delete(mw.dataBudgetListeners, uniqueIdentifier)
+}
+
+func (mw *MultiWallet) publishSyncDataBudgetExceeded(usedBytes, budgetBytes int64) {
+	mw.publishEvent(Event{Kind: SyncDataBudgetExceededEvent, UsedBytes: usedBytes, BudgetBytes: budgetBytes})
+}
+
+// SetSyncDataBudget sets the maximum number of bytes SPV sync may transfer
+// on a day (UTC). The budget takes effect when the next sync is started. A
+// budget of 0 removes the limit.
+//
+// Once the budget is exceeded, the sync is paused and SpvSync returns
+// ErrDataBudgetExceeded for the rest of the day unless the budget is raised
+// or removed. The paused sync is resumed when the next UTC day starts,
+// calling CancelSync or starting a sync before then cancels the resumption.
+// Usage is checked against the budget every second, so a sync may transfer
+// slightly more than the budget.
+func (mw *MultiWallet) SetSyncDataBudget(budgetBytes int64) error {
+	if budgetBytes < 0 {
+		return errors.New(ErrInvalid)
+	}
+
+	mw.SetLongConfigValueForKey(SyncDataBudgetConfigKey, budgetBytes)
+	return nil
+}
+
+func (mw *MultiWallet) SyncDataBudget() int64 {
+	return mw.ReadLongConfigValueForKey(SyncDataBudgetConfigKey, 0)
+}
+
+func (mw *MultiWallet) DataUsage(since int64) (string, error) {
+	usage, err := mw.DataUsageRaw(since)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedUsage, err := json.Marshal(&usage)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedUsage), nil
+}
+
+// DataUsageRaw returns the daily data usage from the day of the since unix
+// timestamp to today, oldest first. Days without any usage are omitted.
+func (mw *MultiWallet) DataUsageRaw(since int64) ([]*DataUsage, error) {
+	if err := mw.saveDataUsage(); err != nil {
+		return nil, err
+	}
+
+	usage := make([]*DataUsage, 0)
+	query := mw.db.Select(q.Gte("Date", dataUsageDate(time.Unix(since, 0)))).OrderBy("Date")
+	err := query.Find(&usage)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+func (mw *MultiWallet) SyncDataUsage() (string, error) {
+	usage := mw.SyncDataUsageRaw()
+	if usage == nil {
+		return "", errors.New(ErrNotExist)
+	}
+
+	jsonEncodedUsage, err := json.Marshal(usage)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedUsage), nil
+}
+
+// SyncDataUsageRaw returns the data transferred by stage and by peer since
+// the current SPV sync was started, or during the last sync if sync is not
+// running. Nil is returned if SPV sync has not been started.
+func (mw *MultiWallet) SyncDataUsageRaw() *SyncDataUsage {
+	tracker := mw.dataUsage
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.session == nil {
+		return nil
+	}
+
+	usage := *tracker.session
+	usage.Peers = make([]*PeerDataUsage, 0, len(tracker.peers))
+	for _, peer := range tracker.peers {
+		peerUsage := *peer
+		usage.Peers = append(usage.Peers, &peerUsage)
+	}
+	sort.Slice(usage.Peers, func(i, j int) bool {
+		return usage.Peers[i].Address < usage.Peers[j].Address
+	})
+
+	return &usage
+}
+
+// startDataUsageSession resets the sync usage and returns an error if
+// today's usage already exceeds the data budget.
+func (mw *MultiWallet) startDataUsageSession() error {
+	budget := mw.SyncDataBudget()
+
+	tracker := mw.dataUsage
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if budget > 0 {
+		today, err := mw.dailyDataUsage(dataUsageDate(time.Now()))
+		if err != nil {
+			return err
+		}
+		if today.total() >= budget {
+			return errors.New(ErrDataBudgetExceeded)
+		}
+	}
+
+	if tracker.cancelResume != nil {
+		tracker.cancelResume()
+		tracker.cancelResume = nil
+	}
+
+	tracker.budget = budget
+	tracker.budgetPaused = false
+	tracker.session = &SyncDataUsage{StartedAt: time.Now().Unix()}
+	tracker.peers = make(map[string]*PeerDataUsage)
+	return nil
+}
+
+// dailyDataUsage returns the in-memory usage of the date, reading the usage
+// saved earlier on that date if necessary. mw.dataUsage.mu must be held.
+func (mw *MultiWallet) dailyDataUsage(date string) (*DataUsage, error) {
+	tracker := mw.dataUsage
+	if usage, ok := tracker.daily[date]; ok {
+		return usage, nil
+	}
+
+	usage := &DataUsage{Date: date}
+	err := mw.db.One("Date", date, usage)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	tracker.daily[date] = usage
+	return usage, nil
+}
+
+// recordDataUsage is called with the data transferred with a peer or seeder
+// since its usage was last reported. The data is attributed to the active
+// sync stage.
+func (mw *MultiWallet) recordDataUsage(peer string, sent, received uint64) {
+	stage := mw.CurrentSyncStage()
+
+	tracker := mw.dataUsage
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.session == nil {
+		return
+	}
+
+	today, err := mw.dailyDataUsage(dataUsageDate(time.Now()))
+	if err != nil {
+		log.Errorf("Error reading data usage: %v", err)
+		return
+	}
+	today.add(stage, int64(sent), int64(received))
+	tracker.session.add(stage, int64(sent), int64(received))
+
+	peerUsage, ok := tracker.peers[peer]
+	if !ok {
+		peerUsage = &PeerDataUsage{Address: peer}
+		tracker.peers[peer] = peerUsage
+	}
+	peerUsage.BytesSent += int64(sent)
+	peerUsage.BytesReceived += int64(received)
+
+	if tracker.budget > 0 && !tracker.budgetPaused && today.total() >= tracker.budget {
+		tracker.budgetPaused = true
+		go mw.pauseSyncForDataBudget(today.total(), tracker.budget)
+	}
+}
+
+// pauseSyncForDataBudget cancels the running sync and resumes it when the
+// next UTC day starts.
+func (mw *MultiWallet) pauseSyncForDataBudget(usedBytes, budgetBytes int64) {
+	// The sync cannot be started again today unless the budget is raised,
+	// see SetSyncDataBudget.
+	log.Warnf("Pausing sync, %d bytes transferred today exceeds the data budget of %d bytes", usedBytes, budgetBytes)
+	mw.syncDiagnostics.recordError("sync", errors.Errorf("paused, %d bytes transferred today exceeds the data budget of %d bytes", usedBytes, budgetBytes))
+	mw.CancelSync()
+
+	ctx, cancel := mw.contextWithShutdownCancel()
+	tracker := mw.dataUsage
+	tracker.mu.Lock()
+	tracker.cancelResume = cancel
+	tracker.mu.Unlock()
+
+	go mw.resumeSyncAfterDataBudget(ctx, untilNextDataUsageDay())
+	mw.publishSyncDataBudgetExceeded(usedBytes, budgetBytes)
+}
+
+// resumeSyncAfterDataBudget starts the sync paused for the data budget after
+// delay unless ctx is canceled first.
+func (mw *MultiWallet) resumeSyncAfterDataBudget(ctx context.Context, delay time.Duration) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(delay):
+	}
+
+	log.Info("Resuming sync paused for the data budget")
+	if err := mw.SpvSync(); err != nil {
+		log.Errorf("Error resuming sync paused for the data budget: %v", err)
+	}
+}
+
+// cancelDataBudgetResume cancels the resumption of a sync paused for the
+// data budget, if any.
+func (mw *MultiWallet) cancelDataBudgetResume() {
+	tracker := mw.dataUsage
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.cancelResume != nil {
+		tracker.cancelResume()
+		tracker.cancelResume = nil
+	}
+}
+
+// trackDataUsage folds the data usage of the connections of the local peer
+// into the tracker every dataUsageReportInterval and saves it every
+// dataUsageFlushInterval until ctx is canceled.
+func (mw *MultiWallet) trackDataUsage(ctx context.Context, lp *p2p.LocalPeer) {
+	reportTicker := time.NewTicker(dataUsageReportInterval)
+	defer reportTicker.Stop()
+	flushTicker := time.NewTicker(dataUsageFlushInterval)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reportTicker.C:
+			lp.ReportDataUsage(mw.recordDataUsage)
+		case <-flushTicker.C:
+			if err := mw.saveDataUsage(); err != nil {
+				log.Errorf("Error saving data usage: %v", err)
+			}
+		}
+	}
+}
+
+// saveDataUsage saves the in-memory daily usage to the db. Only today's
+// usage is kept in memory afterwards.
+func (mw *MultiWallet) saveDataUsage() error {
+	tracker := mw.dataUsage
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	today := dataUsageDate(time.Now())
+	for date, usage := range tracker.daily {
+		if err := mw.db.Save(usage); err != nil {
+			return err
+		}
+		if date != today {
+			delete(tracker.daily, date)
+		}
+	}
+
+	return nil
+}
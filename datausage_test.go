@@ -0,0 +1,120 @@
+package dcrlibwallet
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DataUsage", func() {
+	var mw *MultiWallet
+	var cleanup func()
+
+	BeforeEach(func() {
+		mw, _, cleanup = createTestMultiWallet("simnet")
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	// setSyncStage makes stage the active stage of a running sync.
+	setSyncStage := func(stage int32) {
+		mw.syncData.mu.Lock()
+		mw.syncData.syncing = true
+		mw.syncData.syncStage = stage
+		mw.syncData.mu.Unlock()
+	}
+
+	It("attributes the data transferred to the active sync stage", func() {
+		Expect(mw.startDataUsageSession()).To(Succeed())
+		mw.initActiveSyncData()
+
+		setSyncStage(HeadersFetchSyncStage)
+		mw.recordDataUsage("127.0.0.1:18555", 100, 200)
+		setSyncStage(AddressDiscoverySyncStage)
+		mw.recordDataUsage("127.0.0.2:18555", 10, 20)
+		setSyncStage(HeadersRescanSyncStage)
+		mw.recordDataUsage("127.0.0.1:18555", 1, 2)
+		setSyncStage(HeadersImportSyncStage)
+		mw.recordDataUsage("127.0.0.1:18555", 3, 4)
+
+		usage := mw.SyncDataUsageRaw()
+		Expect(usage.BytesSent).To(Equal(int64(114)))
+		Expect(usage.BytesReceived).To(Equal(int64(226)))
+		Expect(usage.HeadersFetchBytes).To(Equal(int64(300)))
+		Expect(usage.AddressDiscoveryBytes).To(Equal(int64(30)))
+		Expect(usage.HeadersRescanBytes).To(Equal(int64(3)))
+		Expect(usage.OtherBytes).To(Equal(int64(7)))
+
+		Expect(usage.Peers).To(HaveLen(2))
+		Expect(*usage.Peers[0]).To(Equal(PeerDataUsage{Address: "127.0.0.1:18555", BytesSent: 104, BytesReceived: 206}))
+		Expect(*usage.Peers[1]).To(Equal(PeerDataUsage{Address: "127.0.0.2:18555", BytesSent: 10, BytesReceived: 20}))
+
+		daily, err := mw.DataUsageRaw(time.Now().Unix())
+		Expect(err).To(BeNil())
+		Expect(daily).To(HaveLen(1))
+		Expect(daily[0].StageDataUsage).To(Equal(usage.StageDataUsage))
+	})
+
+	It("saves the usage of past days and keeps only today's usage in memory", func() {
+		Expect(mw.startDataUsageSession()).To(Succeed())
+
+		yesterday := dataUsageDate(time.Now().AddDate(0, 0, -1))
+		mw.dataUsage.daily[yesterday] = &DataUsage{
+			Date:           yesterday,
+			StageDataUsage: StageDataUsage{BytesSent: 5, BytesReceived: 10, OtherBytes: 15},
+		}
+		mw.recordDataUsage("127.0.0.1:18555", 1, 2)
+
+		Expect(mw.saveDataUsage()).To(Succeed())
+		Expect(mw.dataUsage.daily).To(HaveLen(1))
+		Expect(mw.dataUsage.daily).To(HaveKey(dataUsageDate(time.Now())))
+
+		daily, err := mw.DataUsageRaw(time.Now().AddDate(0, 0, -1).Unix())
+		Expect(err).To(BeNil())
+		Expect(daily).To(HaveLen(2))
+		Expect(daily[0].Date).To(Equal(yesterday))
+		Expect(daily[0].total()).To(Equal(int64(15)))
+		Expect(daily[1].Date).To(Equal(dataUsageDate(time.Now())))
+		Expect(daily[1].total()).To(Equal(int64(3)))
+
+		By("Adding today's later usage to the saved usage")
+		mw.recordDataUsage("127.0.0.1:18555", 4, 8)
+		Expect(mw.saveDataUsage()).To(Succeed())
+
+		daily, err = mw.DataUsageRaw(time.Now().Unix())
+		Expect(err).To(BeNil())
+		Expect(daily).To(HaveLen(1))
+		Expect(daily[0].total()).To(Equal(int64(15)))
+	})
+
+	It("pauses the sync once today's usage exceeds the budget", func() {
+		events := mw.Subscribe(&EventFilter{Kinds: []EventKind{SyncDataBudgetExceededEvent}})
+		mw.SetStringConfigValueForKey(SpvPersistentPeerAddressesConfigKey, "127.0.0.1:18555")
+		Expect(mw.SetSyncDataBudget(-1)).To(MatchError(ErrInvalid))
+		Expect(mw.SetSyncDataBudget(100)).To(Succeed())
+		Expect(mw.startDataUsageSession()).To(Succeed())
+
+		mw.recordDataUsage("127.0.0.1:18555", 30, 30)
+		Consistently(events, 200*time.Millisecond).ShouldNot(Receive())
+
+		mw.recordDataUsage("127.0.0.1:18555", 30, 30)
+		event := waitForEvent(events, SyncDataBudgetExceededEvent)
+		Expect(event.UsedBytes).To(Equal(int64(120)))
+		Expect(event.BudgetBytes).To(Equal(int64(100)))
+
+		By("Refusing to sync for the rest of the day")
+		Expect(mw.SpvSync()).To(MatchError(ErrDataBudgetExceeded))
+
+		By("Canceling the resumption of the paused sync with CancelSync")
+		mw.dataUsage.mu.Lock()
+		Expect(mw.dataUsage.cancelResume).NotTo(BeNil())
+		mw.dataUsage.mu.Unlock()
+		mw.CancelSync()
+		mw.dataUsage.mu.Lock()
+		Expect(mw.dataUsage.cancelResume).To(BeNil())
+		mw.dataUsage.mu.Unlock()
+	})
+})
@@ -6,6 +6,7 @@ import (
 	"decred.org/dcrwallet/wallet"
 	"github.com/decred/dcrd/blockchain/stake/v3"
 	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
 	"github.com/decred/dcrd/txscript/v3"
 	"github.com/decred/dcrd/wire"
 	"github.com/planetdecred/dcrlibwallet/txhelper"
@@ -102,22 +103,10 @@ func decodeTxOutputs(mtx *wire.MsgTx, netParams *chaincfg.Params, walletOutputs
 
 	for i, txOut := range mtx.TxOut {
 		// get address and script type for output
-		var address, scriptType string
-		if (txType == stake.TxTypeSStx) && (stake.IsStakeSubmissionTxOut(i)) {
-			addr, err := stake.AddrFromSStxPkScrCommitment(txOut.PkScript, netParams)
-			if err == nil {
-				address = addr.Address()
-			}
-			scriptType = txscript.StakeSubmissionTy.String()
-		} else {
-			// Ignore the error here since an error means the script
-			// couldn't parse and there is no additional information
-			// about it anyways.
-			scriptClass, addrs, _, _ := txscript.ExtractPkScriptAddrs(txOut.Version, txOut.PkScript, netParams, true)
-			if len(addrs) > 0 {
-				address = addrs[0].Address()
-			}
-			scriptType = scriptClass.String()
+		var address string
+		addr, scriptType := outputAddress(txOut, i, txType, netParams)
+		if addr != nil {
+			address = addr.Address()
 		}
 
 		output := &TxOutput{
@@ -147,6 +136,28 @@ func decodeTxOutputs(mtx *wire.MsgTx, netParams *chaincfg.Params, walletOutputs
 	return
 }
 
+// outputAddress returns the address that the output at index i of a
+// transaction of type txType pays to and the script type of the output. The
+// address is nil if the script could not be parsed.
+func outputAddress(txOut *wire.TxOut, i int, txType stake.TxType, netParams *chaincfg.Params) (dcrutil.Address, string) {
+	if (txType == stake.TxTypeSStx) && (stake.IsStakeSubmissionTxOut(i)) {
+		addr, err := stake.AddrFromSStxPkScrCommitment(txOut.PkScript, netParams)
+		if err != nil {
+			return nil, txscript.StakeSubmissionTy.String()
+		}
+		return addr, txscript.StakeSubmissionTy.String()
+	}
+
+	// Ignore the error here since an error means the script
+	// couldn't parse and there is no additional information
+	// about it anyways.
+	scriptClass, addrs, _, _ := txscript.ExtractPkScriptAddrs(txOut.Version, txOut.PkScript, netParams, true)
+	if len(addrs) > 0 {
+		return addrs[0], scriptClass.String()
+	}
+	return nil, scriptClass.String()
+}
+
 func voteInfo(msgTx *wire.MsgTx) (ssGenVersion uint32, lastBlockValid bool, voteBits string, ticketSpentHash string) {
 	if stake.IsSSGen(msgTx, true) {
 		ssGenVersion = stake.SSGenVersion(msgTx)
@@ -27,6 +27,8 @@ const (
 	ErrSyncAlreadyInProgress        = "sync_already_in_progress"
 	ErrNoPeers                      = "no_peers"
 	ErrInvalidPeers                 = "invalid_peers"
+	ErrDataBudgetExceeded           = "data_budget_exceeded"
+	ErrInvalidHeaderSnapshot        = "invalid_header_snapshot"
 	ErrListenerAlreadyExist         = "listener_already_exist"
 	ErrLoggerAlreadyRegistered      = "logger_already_registered"
 	ErrLogRotatorAlreadyInitialized = "log_rotator_already_initialized"
@@ -34,6 +36,7 @@ const (
 	ErrChangingPassphrase           = "err_changing_passphrase"
 	ErrSavingWallet                 = "err_saving_wallet"
 	ErrIndexOutOfRange              = "err_index_out_of_range"
+	ErrTicketBuyerAlreadyRunning    = "ticket_buyer_already_running"
 )
 
 // todo, should update this method to translate more error kinds.
@@ -0,0 +1,477 @@
+package dcrlibwallet
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// EventKind identifies the notification carried by an Event.
+type EventKind int32
+
+const (
+	SyncStartedEvent EventKind = iota + 1
+	PeersChangedEvent
+	HeadersFetchProgressEvent
+	AddressDiscoveryProgressEvent
+	HeadersRescanProgressEvent
+	HeadersImportProgressEvent
+	SyncCompletedEvent
+	SyncCanceledEvent
+	SyncEndedWithErrorEvent
+	SyncDebugEvent
+	TransactionEvent
+	TransactionConfirmedEvent
+	TransactionConfirmationsEvent
+	BlockAttachedEvent
+	BlocksRescanStartedEvent
+	BlocksRescanProgressEvent
+	BlocksRescanEndedEvent
+	PaymentRequestUpdatedEvent
+	SyncDataBudgetExceededEvent
+	TransactionDroppedEvent
+	TransactionConflictedEvent
+	SyncStalledEvent
+	TicketsPurchasedEvent
+	TicketPurchaseSkippedEvent
+)
+
+const (
+	// eventReplayBufferSize is the number of recent events kept for
+	// subscribers that want to replay events published before they
+	// subscribed.
+	eventReplayBufferSize = 1000
+
+	// eventSubscriptionBufferSize is the number of events that can be
+	// queued for a subscriber before the subscription is closed.
+	eventSubscriptionBufferSize = 256
+)
+
+var eventKindNames = map[EventKind]string{
+	SyncStartedEvent:              "sync_started",
+	PeersChangedEvent:             "peers_changed",
+	HeadersFetchProgressEvent:     "headers_fetch_progress",
+	AddressDiscoveryProgressEvent: "address_discovery_progress",
+	HeadersRescanProgressEvent:    "headers_rescan_progress",
+	HeadersImportProgressEvent:    "headers_import_progress",
+	SyncCompletedEvent:            "sync_completed",
+	SyncCanceledEvent:             "sync_canceled",
+	SyncEndedWithErrorEvent:       "sync_ended_with_error",
+	SyncDebugEvent:                "sync_debug",
+	TransactionEvent:              "transaction",
+	TransactionConfirmedEvent:     "transaction_confirmed",
+	TransactionConfirmationsEvent: "transaction_confirmations",
+	BlockAttachedEvent:            "block_attached",
+	BlocksRescanStartedEvent:      "blocks_rescan_started",
+	BlocksRescanProgressEvent:     "blocks_rescan_progress",
+	BlocksRescanEndedEvent:        "blocks_rescan_ended",
+	PaymentRequestUpdatedEvent:    "payment_request_updated",
+	SyncDataBudgetExceededEvent:   "sync_data_budget_exceeded",
+	TransactionDroppedEvent:       "transaction_dropped",
+	TransactionConflictedEvent:    "transaction_conflicted",
+	SyncStalledEvent:              "sync_stalled",
+	TicketsPurchasedEvent:         "tickets_purchased",
+	TicketPurchaseSkippedEvent:    "ticket_purchase_skipped",
+}
+
+func (kind EventKind) String() string {
+	if name, ok := eventKindNames[kind]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Event is a notification published by the multiwallet. Sequence numbers
+// increase by one with each event so subscribers can tell if they missed
+// any. Only the fields that relate to the kind of the event are set:
+//
+//   - SyncStartedEvent, SyncCanceledEvent: Restart
+//   - PeersChangedEvent: ConnectedPeers
+//   - HeadersFetchProgressEvent: HeadersFetchProgress
+//   - AddressDiscoveryProgressEvent: WalletID, AddressDiscoveryProgress
+//   - HeadersRescanProgressEvent, BlocksRescanProgressEvent: WalletID, HeadersRescanProgress
+//   - HeadersImportProgressEvent: HeadersImportProgress
+//   - SyncEndedWithErrorEvent: Err
+//   - SyncDebugEvent: DebugInfo
+//   - TransactionEvent: WalletID, Transaction
+//   - TransactionConfirmedEvent: WalletID, TxHash, BlockHeight
+//   - TransactionConfirmationsEvent: WalletID, TxHash, Confirmations
+//   - BlockAttachedEvent: WalletID, BlockHeight
+//   - BlocksRescanStartedEvent: WalletID
+//   - BlocksRescanEndedEvent: WalletID, Err
+//   - PaymentRequestUpdatedEvent: WalletID, PaymentRequest
+//   - SyncDataBudgetExceededEvent: UsedBytes, BudgetBytes
+//   - TransactionDroppedEvent: WalletID, TxHash
+//   - TransactionConflictedEvent: WalletID, TxHash, ConflictingTxHash
+//   - SyncStalledEvent: SyncStall
+//   - TicketsPurchasedEvent, TicketPurchaseSkippedEvent: WalletID, BlockHeight, TicketBuyer
+type Event struct {
+	Sequence  uint64
+	Kind      EventKind
+	Timestamp int64
+
+	WalletID int
+
+	Restart        bool
+	ConnectedPeers int32
+
+	HeadersFetchProgress     *HeadersFetchProgressReport
+	AddressDiscoveryProgress *AddressDiscoveryProgressReport
+	HeadersRescanProgress    *HeadersRescanProgressReport
+	HeadersImportProgress    *HeadersImportProgressReport
+	DebugInfo                *DebugInfo
+	SyncStall                *SyncStallReport
+	TicketBuyer              *TicketBuyerReport
+
+	Transaction       *Transaction
+	TxHash            string
+	ConflictingTxHash string
+	BlockHeight       int32
+	Confirmations     int32
+	PaymentRequest    *PaymentRequest
+
+	UsedBytes   int64
+	BudgetBytes int64
+
+	Err error
+}
+
+// EventFilter selects the events sent to a subscriber.
+type EventFilter struct {
+	// Kinds are the kinds of events to receive. All kinds are received if
+	// Kinds is empty.
+	Kinds []EventKind
+
+	// WalletID, if not 0, excludes the events of other wallets. Events
+	// that are not about a specific wallet, such as the sync progress, are
+	// still received.
+	WalletID int
+
+	// FromSequence, if not 0, replays the recent events with this or a
+	// later sequence number before any new event is received. Only the last
+	// 1000 events are kept for replay.
+	FromSequence uint64
+}
+
+func (filter *EventFilter) matches(event *Event) bool {
+	if filter.WalletID != 0 && event.WalletID != 0 && event.WalletID != filter.WalletID {
+		return false
+	}
+
+	if len(filter.Kinds) == 0 {
+		return true
+	}
+	for _, kind := range filter.Kinds {
+		if kind == event.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// eventStream assigns sequence numbers to published events, keeps the
+// recent events for replay and sends events to subscribers.
+type eventStream struct {
+	mu          sync.Mutex
+	sequence    uint64
+	recent      []Event
+	subscribers map[<-chan Event]*eventSubscriber
+}
+
+type eventSubscriber struct {
+	filter EventFilter
+	events chan Event
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		subscribers: make(map[<-chan Event]*eventSubscriber),
+	}
+}
+
+// Subscribe returns a channel that receives the events selected by filter,
+// or all events if filter is nil. Events are never dropped silently; if
+// the subscriber falls too far behind, the channel is closed and the
+// subscriber may subscribe again with FromSequence set to the sequence
+// after the last event received to replay the missed events. Call
+// Unsubscribe when the events are no longer needed.
+func (mw *MultiWallet) Subscribe(filter *EventFilter) <-chan Event {
+	subscriber := &eventSubscriber{}
+	if filter != nil {
+		subscriber.filter = *filter
+	}
+
+	stream := mw.events
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	var replay []Event
+	if subscriber.filter.FromSequence != 0 {
+		for i := range stream.recent {
+			event := &stream.recent[i]
+			if event.Sequence >= subscriber.filter.FromSequence && subscriber.filter.matches(event) {
+				replay = append(replay, *event)
+			}
+		}
+	}
+
+	subscriber.events = make(chan Event, len(replay)+eventSubscriptionBufferSize)
+	for _, event := range replay {
+		subscriber.events <- event
+	}
+
+	stream.subscribers[subscriber.events] = subscriber
+	return subscriber.events
+}
+
+// Unsubscribe stops sending events to the channel returned by Subscribe
+// and closes it.
+func (mw *MultiWallet) Unsubscribe(events <-chan Event) {
+	stream := mw.events
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	if subscriber, ok := stream.subscribers[events]; ok {
+		delete(stream.subscribers, events)
+		close(subscriber.events)
+	}
+}
+
+// LastEventSequence returns the sequence number of the last published
+// event, or 0 if no event was published.
+func (mw *MultiWallet) LastEventSequence() uint64 {
+	mw.events.mu.Lock()
+	defer mw.events.mu.Unlock()
+	return mw.events.sequence
+}
+
+// publishEvent sends the event to the subscribers and to the listeners
+// registered for the kind of the event.
+func (mw *MultiWallet) publishEvent(event Event) {
+	stream := mw.events
+	stream.mu.Lock()
+	stream.sequence++
+	event.Sequence = stream.sequence
+	event.Timestamp = time.Now().Unix()
+
+	stream.recent = append(stream.recent, event)
+	if len(stream.recent) > eventReplayBufferSize {
+		stream.recent = stream.recent[len(stream.recent)-eventReplayBufferSize:]
+	}
+
+	for events, subscriber := range stream.subscribers {
+		if !subscriber.filter.matches(&event) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			log.Warnf("Closing event subscription that is %d events behind", len(subscriber.events))
+			delete(stream.subscribers, events)
+			close(subscriber.events)
+		}
+	}
+	stream.mu.Unlock()
+
+	mw.notifyListeners(&event)
+}
+
+// closeSubscriptions closes the channels of all subscribers.
+func (mw *MultiWallet) closeSubscriptions() {
+	stream := mw.events
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	for events, subscriber := range stream.subscribers {
+		delete(stream.subscribers, events)
+		close(subscriber.events)
+	}
+}
+
+// notifyListeners adapts the event to the callbacks of the listener
+// interfaces registered for the kind of the event.
+func (mw *MultiWallet) notifyListeners(event *Event) {
+	switch event.Kind {
+	case SyncStartedEvent, PeersChangedEvent, HeadersFetchProgressEvent, AddressDiscoveryProgressEvent,
+		HeadersRescanProgressEvent, SyncCompletedEvent, SyncCanceledEvent, SyncEndedWithErrorEvent,
+		SyncDebugEvent:
+		for _, listener := range mw.syncProgressListeners() {
+			notifySyncProgressListener(listener, event)
+		}
+
+	case HeadersImportProgressEvent:
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.headersImportProgressListeners {
+			listener.OnHeadersImportProgress(event.HeadersImportProgress)
+		}
+
+	case BlocksRescanStartedEvent, BlocksRescanProgressEvent, BlocksRescanEndedEvent:
+		if mw.blocksRescanProgressListener != nil {
+			notifyBlocksRescanProgressListener(mw.blocksRescanProgressListener, event)
+		}
+
+	case TransactionEvent:
+		result, err := json.Marshal(event.Transaction)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.txAndBlockNotificationListeners {
+			listener.OnTransaction(string(result))
+		}
+
+	case TransactionConfirmedEvent:
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.txAndBlockNotificationListeners {
+			listener.OnTransactionConfirmed(event.WalletID, event.TxHash, event.BlockHeight)
+		}
+
+	case BlockAttachedEvent:
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.txAndBlockNotificationListeners {
+			listener.OnBlockAttached(event.WalletID, event.BlockHeight)
+		}
+
+	case TransactionConfirmationsEvent:
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.txConfirmationsListeners {
+			listener.OnTransactionConfirmations(event.WalletID, event.TxHash, event.Confirmations)
+		}
+
+	case PaymentRequestUpdatedEvent:
+		result, err := json.Marshal(event.PaymentRequest)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.paymentRequestListeners {
+			listener.OnPaymentRequestUpdated(string(result))
+		}
+
+	case SyncDataBudgetExceededEvent:
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.dataBudgetListeners {
+			listener.OnSyncDataBudgetExceeded(event.UsedBytes, event.BudgetBytes)
+		}
+
+	case SyncStalledEvent:
+		result, err := json.Marshal(event.SyncStall)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.syncStallListeners {
+			listener.OnSyncStalled(string(result))
+		}
+
+	case TicketsPurchasedEvent, TicketPurchaseSkippedEvent:
+		result, err := json.Marshal(event.TicketBuyer)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.ticketBuyerListeners {
+			if event.Kind == TicketsPurchasedEvent {
+				listener.OnTicketsPurchased(string(result))
+			} else {
+				listener.OnTicketPurchaseSkipped(string(result))
+			}
+		}
+
+	case TransactionDroppedEvent:
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.mempoolListeners {
+			listener.OnTransactionDropped(event.WalletID, event.TxHash)
+		}
+
+	case TransactionConflictedEvent:
+		mw.notificationListenersMu.RLock()
+		defer mw.notificationListenersMu.RUnlock()
+		for _, listener := range mw.mempoolListeners {
+			listener.OnTransactionConflicted(event.WalletID, event.TxHash, event.ConflictingTxHash)
+		}
+	}
+}
+
+func notifySyncProgressListener(listener SyncProgressListener, event *Event) {
+	switch event.Kind {
+	case SyncStartedEvent:
+		listener.OnSyncStarted(event.Restart)
+	case PeersChangedEvent:
+		listener.OnPeerConnectedOrDisconnected(event.ConnectedPeers)
+	case HeadersFetchProgressEvent:
+		listener.OnHeadersFetchProgress(event.HeadersFetchProgress)
+	case AddressDiscoveryProgressEvent:
+		listener.OnAddressDiscoveryProgress(event.AddressDiscoveryProgress)
+	case HeadersRescanProgressEvent:
+		listener.OnHeadersRescanProgress(event.HeadersRescanProgress)
+	case SyncCompletedEvent:
+		listener.OnSyncCompleted()
+	case SyncCanceledEvent:
+		listener.OnSyncCanceled(event.Restart)
+	case SyncEndedWithErrorEvent:
+		listener.OnSyncEndedWithError(event.Err)
+	case SyncDebugEvent:
+		listener.Debug(event.DebugInfo)
+	}
+}
+
+func notifyBlocksRescanProgressListener(listener BlocksRescanProgressListener, event *Event) {
+	switch event.Kind {
+	case BlocksRescanStartedEvent:
+		listener.OnBlocksRescanStarted(event.WalletID)
+	case BlocksRescanProgressEvent:
+		listener.OnBlocksRescanProgress(event.HeadersRescanProgress)
+	case BlocksRescanEndedEvent:
+		listener.OnBlocksRescanEnded(event.WalletID, event.Err)
+	}
+}
+
+// The copy methods return copies of the sync progress reports for events so
+// that later updates to the reports are not seen by subscribers.
+
+func copyGeneralSyncProgress(progress *GeneralSyncProgress) *GeneralSyncProgress {
+	if progress == nil {
+		return nil
+	}
+	progressCopy := *progress
+	return &progressCopy
+}
+
+func (report HeadersFetchProgressReport) copy() *HeadersFetchProgressReport {
+	report.GeneralSyncProgress = copyGeneralSyncProgress(report.GeneralSyncProgress)
+	return &report
+}
+
+func (report AddressDiscoveryProgressReport) copy() *AddressDiscoveryProgressReport {
+	report.GeneralSyncProgress = copyGeneralSyncProgress(report.GeneralSyncProgress)
+	return &report
+}
+
+func (report HeadersRescanProgressReport) copy() *HeadersRescanProgressReport {
+	report.GeneralSyncProgress = copyGeneralSyncProgress(report.GeneralSyncProgress)
+	return &report
+}
+
+func (report HeadersImportProgressReport) copy() *HeadersImportProgressReport {
+	report.GeneralSyncProgress = copyGeneralSyncProgress(report.GeneralSyncProgress)
+	return &report
+}
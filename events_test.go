@@ -0,0 +1,101 @@
+package dcrlibwallet
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type blockAttachedRecorder struct {
+	heights []int32
+}
+
+func (recorder *blockAttachedRecorder) OnTransaction(transaction string) {}
+func (recorder *blockAttachedRecorder) OnBlockAttached(walletID int, blockHeight int32) {
+	recorder.heights = append(recorder.heights, blockHeight)
+}
+func (recorder *blockAttachedRecorder) OnTransactionConfirmed(walletID int, hash string, blockHeight int32) {
+}
+
+// initTestLogRotator initializes the log rotator, which the loggers require,
+// with a log file in a temporary directory.
+func initTestLogRotator() {
+	if logRotator != nil {
+		return
+	}
+
+	logDir, err := ioutil.TempDir("", "dcrlibwallet")
+	Expect(err).To(BeNil())
+	Expect(initLogRotator(filepath.Join(logDir, "dcrlibwallet.log"))).To(BeNil())
+}
+
+var _ = Describe("Events", func() {
+	var mw *MultiWallet
+
+	BeforeEach(func() {
+		mw = &MultiWallet{
+			syncData: &syncData{
+				syncProgressListeners: make(map[string]SyncProgressListener),
+			},
+			txAndBlockNotificationListeners: make(map[string]TxAndBlockNotificationListener),
+			events:                          newEventStream(),
+		}
+	})
+
+	It("sends events in sequence to subscribers and listeners", func() {
+		recorder := &blockAttachedRecorder{}
+		Expect(mw.AddTxAndBlockNotificationListener(recorder, "recorder")).To(BeNil())
+
+		events := mw.Subscribe(nil)
+		mw.publishBlockAttached(1, 10)
+		mw.publishBlockAttached(2, 11)
+
+		first, second := <-events, <-events
+		Expect(first.Kind).To(Equal(BlockAttachedEvent))
+		Expect(first.BlockHeight).To(Equal(int32(10)))
+		Expect(second.Sequence).To(Equal(first.Sequence + 1))
+		Expect(recorder.heights).To(Equal([]int32{10, 11}))
+		Expect(mw.LastEventSequence()).To(Equal(second.Sequence))
+	})
+
+	It("filters events by kind and wallet", func() {
+		events := mw.Subscribe(&EventFilter{Kinds: []EventKind{BlockAttachedEvent}, WalletID: 2})
+		mw.publishBlockAttached(1, 10)
+		mw.publishTransactionConfirmed(2, "hash", 10)
+		mw.publishBlockAttached(2, 10)
+
+		event := <-events
+		Expect(event.WalletID).To(Equal(2))
+		Expect(event.Kind).To(Equal(BlockAttachedEvent))
+		Expect(events).ToNot(Receive())
+	})
+
+	It("replays recent events", func() {
+		for height := int32(1); height <= 5; height++ {
+			mw.publishBlockAttached(1, height)
+		}
+
+		events := mw.Subscribe(&EventFilter{FromSequence: 4})
+		Expect((<-events).BlockHeight).To(Equal(int32(4)))
+		Expect((<-events).BlockHeight).To(Equal(int32(5)))
+		Expect(events).ToNot(Receive())
+	})
+
+	It("closes subscriptions that fall behind", func() {
+		initTestLogRotator()
+
+		events := mw.Subscribe(nil)
+		for i := 0; i <= eventSubscriptionBufferSize; i++ {
+			mw.publishBlockAttached(1, int32(i))
+		}
+
+		for i := 0; i < eventSubscriptionBufferSize; i++ {
+			Expect(events).To(Receive())
+		}
+		Expect(events).To(BeClosed())
+
+		mw.Unsubscribe(events)
+	})
+})
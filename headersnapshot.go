@@ -0,0 +1,339 @@
+package dcrlibwallet
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	w "decred.org/dcrwallet/wallet"
+	"github.com/planetdecred/dcrlibwallet/utils"
+)
+
+const (
+	HeaderSnapshotPathConfigKey   = "header_snapshot_path"
+	HeaderSnapshotPubKeyConfigKey = "header_snapshot_pubkey"
+
+	// headerSnapshotImportBatchSize is the number of headers connected to
+	// the wallets at a time while importing a header snapshot.
+	headerSnapshotImportBatchSize = 2000
+)
+
+// SetHeaderSnapshot verifies the header snapshot file at path with the hex
+// encoded ed25519 public key of the snapshot signer and saves both so that
+// the headers in the snapshot are imported at the start of the next SPV
+// syncs, before any headers are fetched from peers. The snapshot must hold
+// the headers from the genesis block to a checkpoint of the network.
+func (mw *MultiWallet) SetHeaderSnapshot(path, pubKeyHex string) error {
+	pubKey, err := hex.DecodeString(pubKeyHex)
+	if err != nil || len(pubKey) != ed25519.PublicKeySize {
+		return errors.New(ErrInvalid)
+	}
+
+	if _, err = mw.verifyHeaderSnapshot(path, pubKey); err != nil {
+		log.Errorf("Header snapshot %s is invalid: %v", path, err)
+		return errors.New(ErrInvalidHeaderSnapshot)
+	}
+
+	mw.SetStringConfigValueForKey(HeaderSnapshotPathConfigKey, path)
+	mw.SetStringConfigValueForKey(HeaderSnapshotPubKeyConfigKey, pubKeyHex)
+	return nil
+}
+
+// RemoveHeaderSnapshot deletes the saved header snapshot so that all headers
+// are fetched from peers. The snapshot file is not deleted.
+func (mw *MultiWallet) RemoveHeaderSnapshot() {
+	mw.DeleteUserConfigValueForKey(HeaderSnapshotPathConfigKey)
+	mw.DeleteUserConfigValueForKey(HeaderSnapshotPubKeyConfigKey)
+}
+
+func (mw *MultiWallet) HeaderSnapshotPath() string {
+	return mw.ReadStringConfigValueForKey(HeaderSnapshotPathConfigKey)
+}
+
+// AddHeadersImportProgressListener adds a listener for the progress of
+// header snapshot imports. If headers are being imported, the listener is
+// notified of the current progress.
+func (mw *MultiWallet) AddHeadersImportProgressListener(listener HeadersImportProgressListener, uniqueIdentifier string) error {
+	mw.notificationListenersMu.Lock()
+	if _, ok := mw.headersImportProgressListeners[uniqueIdentifier]; ok {
+		mw.notificationListenersMu.Unlock()
+		return errors.New(ErrListenerAlreadyExist)
+	}
+	mw.headersImportProgressListeners[uniqueIdentifier] = listener
+	mw.notificationListenersMu.Unlock()
+
+	mw.syncData.mu.RLock()
+	var progress *HeadersImportProgressReport
+	if mw.syncData.syncing && mw.syncData.activeSyncData != nil && mw.syncData.activeSyncData.syncStage == HeadersImportSyncStage {
+		progress = mw.syncData.headersImportProgress.copy()
+	}
+	mw.syncData.mu.RUnlock()
+
+	if progress != nil {
+		listener.OnHeadersImportProgress(progress)
+	}
+	return nil
+}
+
+func (mw *MultiWallet) RemoveHeadersImportProgressListener(uniqueIdentifier string) {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	delete(mw.headersImportProgressListeners, uniqueIdentifier)
+}
+
+func (mw *MultiWallet) verifyHeaderSnapshot(path string, pubKey ed25519.PublicKey) (*utils.HeaderSnapshotInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return utils.VerifyHeaderSnapshot(file, mw.chainParams, pubKey)
+}
+
+func (mw *MultiWallet) readHeaderSnapshotTipHeight(path string) (int32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return utils.ReadHeaderSnapshotTipHeight(file, mw.chainParams)
+}
+
+// walletsBelowHeight returns the syncing wallets whose best block is below
+// height and the lowest best block of those wallets.
+func (mw *MultiWallet) walletsBelowHeight(height int32) (map[int]*Wallet, int32) {
+	wallets := make(map[int]*Wallet)
+	lowestBlockHeight := height
+	for id, wallet := range mw.wallets {
+		bestBlock := wallet.GetBestBlock()
+		if wallet.syncable() && bestBlock < height {
+			wallets[id] = wallet
+			if bestBlock < lowestBlockHeight {
+				lowestBlockHeight = bestBlock
+			}
+		}
+	}
+	return wallets, lowestBlockHeight
+}
+
+// copyVerifiedHeaderSnapshot verifies the header snapshot at path and copies
+// the bytes that were verified to a temporary file in the root dir of the
+// multiwallet, so that the headers imported are the ones that were verified
+// even if the file at path changes during the import. The copy is returned
+// open at its start, the caller must close and remove it.
+func (mw *MultiWallet) copyVerifiedHeaderSnapshot(path string, pubKey ed25519.PublicKey) (*os.File, *utils.HeaderSnapshotInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	verified, err := ioutil.TempFile(mw.rootDir, "headersnapshot")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshot, err := utils.VerifyHeaderSnapshot(io.TeeReader(file, verified), mw.chainParams, pubKey)
+	if err == nil {
+		_, err = verified.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		verified.Close()
+		os.Remove(verified.Name())
+		return nil, nil, err
+	}
+
+	return verified, snapshot, nil
+}
+
+// importHeaderSnapshot connects the headers in the saved header snapshot to
+// the main chain of each syncing wallet that is behind the snapshot tip.
+// Syncing continues from the snapshot tip when the import is done. Import
+// errors are logged and not returned, the headers that were not imported are
+// fetched from peers instead.
+func (mw *MultiWallet) importHeaderSnapshot(ctx context.Context) {
+	path := mw.HeaderSnapshotPath()
+	if path == "" {
+		return
+	}
+
+	pubKey, err := hex.DecodeString(mw.ReadStringConfigValueForKey(HeaderSnapshotPubKeyConfigKey))
+	if err != nil {
+		log.Errorf("Invalid header snapshot public key: %v", err)
+		return
+	}
+
+	// Verifying the snapshot reads all of it, which is skipped if every
+	// wallet is already past the tip height read from the start of the file.
+	tipHeight, err := mw.readHeaderSnapshotTipHeight(path)
+	if err != nil {
+		log.Errorf("Not importing invalid header snapshot %s: %v", path, err)
+		return
+	}
+	if wallets, _ := mw.walletsBelowHeight(tipHeight); len(wallets) == 0 {
+		return
+	}
+
+	// The snapshot is verified in full before any header is imported as
+	// the signature is only checked after reading all headers. The headers
+	// are imported from a copy of the verified bytes.
+	file, snapshot, err := mw.copyVerifiedHeaderSnapshot(path, pubKey)
+	if err != nil {
+		log.Errorf("Not importing invalid header snapshot %s: %v", path, err)
+		return
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	wallets, lowestBlockHeight := mw.walletsBelowHeight(snapshot.TipHeight)
+	if len(wallets) == 0 {
+		return
+	}
+
+	mw.headersImportStarted(lowestBlockHeight, snapshot.TipHeight)
+	defer mw.headersImportFinished()
+
+	batch := make([]*utils.HeaderSnapshotEntry, 0, headerSnapshotImportBatchSize)
+	_, err = utils.ReadHeaderSnapshot(file, mw.chainParams, pubKey, func(entry *utils.HeaderSnapshotEntry) error {
+		if int32(entry.Header.Height) <= lowestBlockHeight {
+			return nil
+		}
+
+		batch = append(batch, entry)
+		if len(batch) < headerSnapshotImportBatchSize {
+			return nil
+		}
+
+		err := mw.importHeaders(ctx, wallets, batch)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil && len(batch) > 0 {
+		err = mw.importHeaders(ctx, wallets, batch)
+	}
+	if err != nil {
+		log.Errorf("Error importing header snapshot: %v", err)
+	}
+}
+
+// importHeaders connects the headers to the main chain of each wallet. A
+// wallet is dropped from the import if its headers cannot be connected.
+func (mw *MultiWallet) importHeaders(ctx context.Context, wallets map[int]*Wallet, entries []*utils.HeaderSnapshotEntry) error {
+	for id, wallet := range wallets {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := importWalletHeaders(ctx, wallet.internal, entries)
+		if err != nil {
+			log.Errorf("[%d] Error importing header snapshot: %v", id, err)
+			delete(wallets, id)
+		}
+	}
+
+	if len(wallets) == 0 {
+		return errors.New("no wallet could import the header snapshot")
+	}
+
+	lastEntry := entries[len(entries)-1]
+	mw.headersImportProgress(int32(lastEntry.Header.Height))
+	return nil
+}
+
+func importWalletHeaders(ctx context.Context, wallet *w.Wallet, entries []*utils.HeaderSnapshotEntry) error {
+	_, tipHeight := wallet.MainChainTip(ctx)
+
+	var forest w.SidechainForest
+	for _, entry := range entries {
+		if int32(entry.Header.Height) <= tipHeight {
+			continue
+		}
+		hash := entry.Header.BlockHash()
+		forest.AddBlockNode(w.NewBlockNode(entry.Header, &hash, entry.Filter))
+	}
+
+	bestChain, err := wallet.EvaluateBestChain(ctx, &forest)
+	if err != nil || len(bestChain) == 0 {
+		return err
+	}
+
+	if _, err = wallet.ValidateHeaderChainDifficulties(ctx, bestChain, 0); err != nil {
+		return err
+	}
+
+	_, err = wallet.ChainSwitch(ctx, &forest, bestChain, nil)
+	return err
+}
+
+func (mw *MultiWallet) headersImportStarted(startHeight, tipHeight int32) {
+	mw.syncData.mu.Lock()
+	defer mw.syncData.mu.Unlock()
+
+	if !mw.syncData.syncing {
+		// ignore if sync is not in progress
+		return
+	}
+
+	mw.syncData.activeSyncData.syncStage = HeadersImportSyncStage
+	mw.syncDiagnostics.stageStarted(HeadersImportSyncStage)
+	mw.syncData.activeSyncData.lastProgressTime = time.Now()
+	mw.syncData.activeSyncData.headersImportStartTime = time.Now().Unix()
+	mw.syncData.activeSyncData.headersImportStartHeight = startHeight
+	mw.syncData.activeSyncData.headersImportProgress.TotalHeadersToImport = tipHeight - startHeight
+	mw.syncData.activeSyncData.headersImportProgress.CurrentHeaderHeight = startHeight
+
+	if mw.syncData.showLogs {
+		log.Infof("Importing %d block headers from the header snapshot.", tipHeight-startHeight)
+	}
+}
+
+func (mw *MultiWallet) headersImportProgress(lastImportedHeaderHeight int32) {
+	mw.syncData.mu.Lock()
+	if !mw.syncData.syncing {
+		mw.syncData.mu.Unlock()
+		return
+	}
+
+	mw.syncData.activeSyncData.lastProgressTime = time.Now()
+	report := &mw.syncData.activeSyncData.headersImportProgress
+	importedHeaders := lastImportedHeaderHeight - mw.syncData.activeSyncData.headersImportStartHeight
+	importRate := float64(importedHeaders) / float64(report.TotalHeadersToImport)
+
+	elapsedImportTime := time.Now().Unix() - mw.syncData.activeSyncData.headersImportStartTime
+	estimatedTotalImportTime := int64(math.Round(float64(elapsedImportTime) / importRate))
+
+	report.CurrentHeaderHeight = lastImportedHeaderHeight
+	report.HeadersImportProgress = roundUp(importRate * 100)
+	report.TotalTimeRemainingSeconds = estimatedTotalImportTime - elapsedImportTime
+	progress := report.copy()
+	showLogs := mw.syncData.showLogs
+	mw.syncData.mu.Unlock()
+
+	mw.publishEvent(Event{Kind: HeadersImportProgressEvent, HeadersImportProgress: progress})
+
+	if showLogs {
+		log.Infof("Imported %d of %d block headers from the header snapshot.", importedHeaders, progress.TotalHeadersToImport)
+	}
+}
+
+func (mw *MultiWallet) headersImportFinished() {
+	mw.syncData.mu.Lock()
+	defer mw.syncData.mu.Unlock()
+
+	if mw.syncData.syncing && mw.syncData.activeSyncData.syncStage == HeadersImportSyncStage {
+		mw.syncData.activeSyncData.syncStage = InvalidSyncStage
+		mw.syncDiagnostics.stageStarted(InvalidSyncStage)
+		mw.syncData.activeSyncData.lastProgressTime = time.Now()
+	}
+}
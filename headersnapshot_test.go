@@ -0,0 +1,202 @@
+package dcrlibwallet
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/gcs/v2"
+	"github.com/decred/dcrd/gcs/v2/blockcf2"
+	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// snapshotTestChain returns the entries of a chain of count headers on top
+// of the genesis block of params. Each header commits to its cfilter with a
+// single leaf stake root and solves the proof of work of the network.
+func snapshotTestChain(params *chaincfg.Params, count int) []*utils.HeaderSnapshotEntry {
+	entries := make([]*utils.HeaderSnapshotEntry, 0, count)
+	prevHash := params.GenesisHash
+	for height := 1; height <= count; height++ {
+		var key [gcs.KeySize]byte
+		filter, err := gcs.NewFilterV2(blockcf2.B, blockcf2.M, key, [][]byte{{byte(height)}})
+		Expect(err).To(BeNil())
+
+		header := &wire.BlockHeader{
+			Version:   1,
+			PrevBlock: prevHash,
+			StakeRoot: filter.Hash(),
+			Bits:      params.PowLimitBits,
+			SBits:     params.MinimumStakeDiff,
+			Height:    uint32(height),
+		}
+		for {
+			prevHash = header.BlockHash()
+			if blockchain.CheckProofOfWork(&prevHash, header.Bits, params.PowLimit) == nil {
+				break
+			}
+			header.Nonce++
+		}
+
+		entries = append(entries, &utils.HeaderSnapshotEntry{
+			Header: header,
+			Filter: filter,
+		})
+	}
+	return entries
+}
+
+var _ = Describe("HeaderSnapshot", func() {
+	var params *chaincfg.Params
+	var entries []*utils.HeaderSnapshotEntry
+	var pubKey ed25519.PublicKey
+	var privKey ed25519.PrivateKey
+
+	BeforeEach(func() {
+		var err error
+		pubKey, privKey, err = ed25519.GenerateKey(nil)
+		Expect(err).To(BeNil())
+
+		simnet := *chaincfg.SimNetParams()
+		params = &simnet
+		entries = snapshotTestChain(params, 20)
+		checkpointHash := entries[9].Header.BlockHash()
+		params.Checkpoints = []chaincfg.Checkpoint{{Height: 10, Hash: &checkpointHash}}
+	})
+
+	writeSnapshot := func(entries []*utils.HeaderSnapshotEntry) []byte {
+		var buf bytes.Buffer
+		Expect(utils.WriteHeaderSnapshot(&buf, params, entries, privKey)).To(BeNil())
+		return buf.Bytes()
+	}
+
+	It("verifies snapshots that end at a checkpoint", func() {
+		snapshot := writeSnapshot(entries[:10])
+
+		info, err := utils.VerifyHeaderSnapshot(bytes.NewReader(snapshot), params, pubKey)
+		Expect(err).To(BeNil())
+		Expect(info.TipHeight).To(Equal(int32(10)))
+		Expect(info.TipHash).To(Equal(entries[9].Header.BlockHash()))
+
+		var heights []uint32
+		_, err = utils.ReadHeaderSnapshot(bytes.NewReader(snapshot), params, pubKey, func(entry *utils.HeaderSnapshotEntry) error {
+			heights = append(heights, entry.Header.Height)
+			Expect(entry.Filter.Hash()).To(Equal(entry.Header.StakeRoot))
+			return nil
+		})
+		Expect(err).To(BeNil())
+		Expect(heights).To(HaveLen(10))
+	})
+
+	It("reads the tip height from the start of a snapshot", func() {
+		snapshot := writeSnapshot(entries[:10])
+
+		tipHeight, err := utils.ReadHeaderSnapshotTipHeight(bytes.NewReader(snapshot[:16]), params)
+		Expect(err).To(BeNil())
+		Expect(tipHeight).To(Equal(int32(10)))
+
+		_, err = utils.ReadHeaderSnapshotTipHeight(bytes.NewReader(snapshot[:16]), chaincfg.TestNet3Params())
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("rejects snapshots that do not end at a checkpoint", func() {
+		_, err := utils.VerifyHeaderSnapshot(bytes.NewReader(writeSnapshot(entries)), params, pubKey)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("rejects snapshots signed with another key", func() {
+		otherPubKey, _, err := ed25519.GenerateKey(nil)
+		Expect(err).To(BeNil())
+
+		_, err = utils.VerifyHeaderSnapshot(bytes.NewReader(writeSnapshot(entries[:10])), params, otherPubKey)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("rejects modified snapshots", func() {
+		snapshot := writeSnapshot(entries[:10])
+		snapshot[len(snapshot)-100] ^= 0xff
+
+		_, err := utils.VerifyHeaderSnapshot(bytes.NewReader(snapshot), params, pubKey)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("rejects headers that do not connect", func() {
+		unconnected := append([]*utils.HeaderSnapshotEntry{}, entries[:10]...)
+		unconnected[4] = snapshotTestChain(params, 5)[4]
+		unconnected[4].Header.Nonce++
+
+		_, err := utils.VerifyHeaderSnapshot(bytes.NewReader(writeSnapshot(unconnected)), params, pubKey)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("rejects headers that do not commit to their cfilter", func() {
+		entries[9].Filter = entries[8].Filter
+		checkpointHash := entries[9].Header.BlockHash()
+		params.Checkpoints[0].Hash = &checkpointHash
+
+		_, err := utils.VerifyHeaderSnapshot(bytes.NewReader(writeSnapshot(entries[:10])), params, pubKey)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("imports from a copy of the verified snapshot bytes", func() {
+		mw, _, cleanup := createTestMultiWallet("simnet")
+		defer cleanup()
+		mw.chainParams = params
+
+		snapshot := writeSnapshot(entries[:10])
+		path := filepath.Join(mw.rootDir, "snapshot.dat")
+		Expect(ioutil.WriteFile(path, snapshot, 0600)).To(Succeed())
+
+		file, info, err := mw.copyVerifiedHeaderSnapshot(path, pubKey)
+		Expect(err).To(BeNil())
+		defer os.Remove(file.Name())
+		defer file.Close()
+		Expect(info.TipHeight).To(Equal(int32(10)))
+
+		// Changes to the snapshot file after it was verified do not
+		// affect the copy.
+		Expect(ioutil.WriteFile(path, writeSnapshot(entries[:5]), 0600)).To(Succeed())
+		copied, err := ioutil.ReadAll(file)
+		Expect(err).To(BeNil())
+		Expect(copied).To(Equal(snapshot))
+
+		snapshot[len(snapshot)-100] ^= 0xff
+		Expect(ioutil.WriteFile(path, snapshot, 0600)).To(Succeed())
+		_, _, err = mw.copyVerifiedHeaderSnapshot(path, pubKey)
+		Expect(err).ToNot(BeNil())
+		copies, err := filepath.Glob(filepath.Join(mw.rootDir, "headersnapshot*"))
+		Expect(err).To(BeNil())
+		Expect(copies).To(HaveLen(1))
+	})
+	It("imports snapshots only into the wallets below the snapshot tip", func() {
+		mw, wallet, cleanup := createTestMultiWallet("simnet")
+		defer cleanup()
+		mw.chainParams = params
+
+		path := filepath.Join(mw.rootDir, "snapshot.dat")
+		Expect(ioutil.WriteFile(path, writeSnapshot(entries[:10]), 0600)).To(Succeed())
+		Expect(mw.SetHeaderSnapshot(path, hex.EncodeToString(pubKey))).To(Succeed())
+
+		mw.importHeaderSnapshot(context.Background())
+		Expect(wallet.GetBestBlock()).To(Equal(int32(10)))
+
+		// Only the start of the snapshot is read once every wallet is
+		// past its tip, the rest of the file is not needed.
+		snapshot := writeSnapshot(entries[:10])
+		Expect(ioutil.WriteFile(path, snapshot[:16], 0600)).To(Succeed())
+		mw.importHeaderSnapshot(context.Background())
+		Expect(wallet.GetBestBlock()).To(Equal(int32(10)))
+		copies, err := filepath.Glob(filepath.Join(mw.rootDir, "headersnapshot*"))
+		Expect(err).To(BeNil())
+		Expect(copies).To(BeEmpty())
+	})
+})
@@ -9,7 +9,6 @@ import (
 	"os"
 
 	"decred.org/dcrwallet/errors"
-	"decred.org/dcrwallet/p2p"
 	"decred.org/dcrwallet/ticketbuyer"
 	"decred.org/dcrwallet/wallet"
 	"decred.org/dcrwallet/wallet/udb"
@@ -18,6 +17,7 @@ import (
 	"github.com/decred/slog"
 	"github.com/jrick/logrotate/rotator"
 	"github.com/planetdecred/dcrlibwallet/internal/loader"
+	"github.com/planetdecred/dcrlibwallet/p2p"
 	"github.com/planetdecred/dcrlibwallet/spv"
 )
 
@@ -0,0 +1,316 @@
+package dcrlibwallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	w "decred.org/dcrwallet/wallet"
+	"github.com/decred/dcrd/blockchain/stake/v3"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+)
+
+// lookupTransactionTimeout is the time to wait for the peers to return a
+// looked up transaction or block.
+const lookupTransactionTimeout = 30 * time.Second
+
+func (mw *MultiWallet) LookupTransaction(txHash, blockHash string) (string, error) {
+	lookup, err := mw.LookupTransactionRaw(txHash, blockHash)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedLookup, err := json.Marshal(lookup)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedLookup), nil
+}
+
+// LookupTransactionRaw returns the transaction with the provided hash and the
+// inputs and outputs of the transaction that belong to each open wallet. The
+// transaction is read from the open wallets if any of them has it. Otherwise
+// it is fetched from the connected peers, from the block with blockHash if
+// blockHash is not empty or from the mempool of the peers if it is. The block
+// must be in the main chain of the open wallets. This can be used to check a
+// transaction before it is received by the wallets.
+func (mw *MultiWallet) LookupTransactionRaw(txHash, blockHash string) (*TransactionLookup, error) {
+	hash, err := chainhash.NewHashFromStr(txHash)
+	if err != nil {
+		return nil, errors.New(ErrInvalid)
+	}
+
+	var wallets []*Wallet
+	for _, wallet := range mw.wallets {
+		if wallet.WalletOpened() {
+			wallets = append(wallets, wallet)
+		}
+	}
+	if len(wallets) == 0 {
+		return nil, errors.New(ErrWalletNotLoaded)
+	}
+
+	lookup := &TransactionLookup{
+		Hash:               hash.String(),
+		BlockHeight:        BlockHeightInvalid,
+		WalletTransactions: make([]*Transaction, 0),
+	}
+
+	msgTx, timestamp, err := lookupWalletTransaction(wallets, hash, lookup)
+	if errors.Is(err, errors.NotExist) {
+		if blockHash != "" {
+			msgTx, timestamp, err = mw.lookupBlockTransaction(wallets, hash, blockHash, lookup)
+		} else {
+			msgTx, timestamp, err = mw.lookupMempoolTransaction(hash)
+		}
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	var txBuf bytes.Buffer
+	txBuf.Grow(msgTx.SerializeSize())
+	if err = msgTx.Serialize(&txBuf); err != nil {
+		return nil, err
+	}
+
+	lookup.Transaction, err = DecodeTransaction(&TxInfoFromWallet{
+		Hex:         fmt.Sprintf("%x", txBuf.Bytes()),
+		Timestamp:   timestamp,
+		BlockHeight: lookup.BlockHeight,
+	}, mw.chainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, wallet := range wallets {
+		walletTx, err := wallet.decodeLookedUpTransaction(msgTx, txBuf.Bytes(), timestamp, lookup.BlockHeight)
+		if err != nil {
+			return nil, err
+		}
+		if walletTx != nil {
+			lookup.WalletTransactions = append(lookup.WalletTransactions, walletTx)
+		}
+	}
+
+	return lookup, nil
+}
+
+// lookupWalletTransaction reads the transaction from the first wallet that
+// has it and sets the block of lookup if the transaction is mined.
+func lookupWalletTransaction(wallets []*Wallet, txHash *chainhash.Hash, lookup *TransactionLookup) (*wire.MsgTx, int64, error) {
+	for _, wallet := range wallets {
+		txSummary, _, blockHash, err := wallet.internal.TransactionSummary(wallet.shutdownContext(), txHash)
+		if errors.Is(err, errors.NotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		msgTx := new(wire.MsgTx)
+		if err = msgTx.Deserialize(bytes.NewReader(txSummary.Transaction)); err != nil {
+			return nil, 0, err
+		}
+
+		if blockHash != nil {
+			blockInfo, err := wallet.internal.BlockInfo(wallet.shutdownContext(), w.NewBlockIdentifierFromHash(blockHash))
+			if err != nil {
+				return nil, 0, err
+			}
+			lookup.BlockHash = blockHash.String()
+			lookup.BlockHeight = blockInfo.Height
+		}
+
+		return msgTx, txSummary.Timestamp, nil
+	}
+
+	return nil, 0, errors.E(errors.NotExist)
+}
+
+// lookupBlockTransaction fetches the block with the provided hash from the
+// peers and returns the transaction from it.
+func (mw *MultiWallet) lookupBlockTransaction(wallets []*Wallet, txHash *chainhash.Hash, blockHash string,
+	lookup *TransactionLookup) (*wire.MsgTx, int64, error) {
+
+	hash, err := chainhash.NewHashFromStr(blockHash)
+	if err != nil {
+		return nil, 0, errors.New(ErrInvalid)
+	}
+
+	// Peers are disconnected when they do not have a requested block,
+	// only request blocks that are known to be in the main chain.
+	var blockInfo *w.BlockInfo
+	for _, wallet := range wallets {
+		info, err := wallet.internal.BlockInfo(wallet.shutdownContext(), w.NewBlockIdentifierFromHash(hash))
+		if err == nil && info.Confirmations > 0 {
+			blockInfo = info
+			break
+		}
+	}
+	if blockInfo == nil {
+		return nil, 0, errors.New(ErrNotExist)
+	}
+
+	syncer := mw.spvSyncer()
+	if syncer == nil {
+		return nil, 0, errors.New(ErrNotConnected)
+	}
+
+	ctx, cancel := mw.lookupContext()
+	defer cancel()
+
+	block, err := syncer.Block(ctx, hash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, txs := range [][]*wire.MsgTx{block.Transactions, block.STransactions} {
+		for _, tx := range txs {
+			if tx.TxHash() == *txHash {
+				lookup.BlockHash = hash.String()
+				lookup.BlockHeight = blockInfo.Height
+				return tx, blockInfo.Timestamp, nil
+			}
+		}
+	}
+
+	return nil, 0, errors.New(ErrNotExist)
+}
+
+// lookupMempoolTransaction fetches the transaction from the mempool of the
+// peers.
+func (mw *MultiWallet) lookupMempoolTransaction(txHash *chainhash.Hash) (*wire.MsgTx, int64, error) {
+	syncer := mw.spvSyncer()
+	if syncer == nil {
+		return nil, 0, errors.New(ErrNotConnected)
+	}
+
+	ctx, cancel := mw.lookupContext()
+	defer cancel()
+
+	msgTx, err := syncer.MempoolTransaction(ctx, txHash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return msgTx, time.Now().Unix(), nil
+}
+
+func (mw *MultiWallet) lookupContext() (context.Context, context.CancelFunc) {
+	ctx, shutdownCancel := mw.contextWithShutdownCancel()
+	ctx, cancel := context.WithTimeout(ctx, lookupTransactionTimeout)
+	return ctx, func() {
+		cancel()
+		shutdownCancel()
+	}
+}
+
+// decodeLookedUpTransaction decodes the transaction with the inputs and
+// outputs that belong to the wallet, or returns nil if the wallet owns none
+// of them. Transactions that the wallet has are decoded from the wallet.
+func (wallet *Wallet) decodeLookedUpTransaction(msgTx *wire.MsgTx, serializedTx []byte, timestamp int64,
+	blockHeight int32) (*Transaction, error) {
+
+	ctx := wallet.shutdownContext()
+	txHash := msgTx.TxHash()
+
+	txSummary, _, blockHash, err := wallet.internal.TransactionSummary(ctx, &txHash)
+	if err == nil {
+		return wallet.decodeTransactionWithTxSummary(txSummary, blockHash)
+	}
+	if !errors.Is(err, errors.NotExist) {
+		return nil, err
+	}
+
+	var walletInputs []*WalletInput
+	for i, txIn := range msgTx.TxIn {
+		prevOut := &txIn.PreviousOutPoint
+		prevTxSummary, _, _, err := wallet.internal.TransactionSummary(ctx, &prevOut.Hash)
+		if errors.Is(err, errors.NotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, output := range prevTxSummary.MyOutputs {
+			if output.Index != prevOut.Index {
+				continue
+			}
+
+			accountNumber := int32(output.Account)
+			accountName, err := wallet.AccountName(accountNumber)
+			if err != nil {
+				log.Error(err)
+			}
+
+			walletInputs = append(walletInputs, &WalletInput{
+				Index:    int32(i),
+				AmountIn: int64(output.Amount),
+				WalletAccount: &WalletAccount{
+					AccountNumber: accountNumber,
+					AccountName:   accountName,
+				},
+			})
+			break
+		}
+	}
+
+	var walletOutputs []*WalletOutput
+	txType := stake.DetermineTxType(msgTx, true)
+	for i, txOut := range msgTx.TxOut {
+		addr, _ := outputAddress(txOut, i, txType, wallet.chainParams)
+		if addr == nil {
+			continue
+		}
+
+		known, err := wallet.internal.KnownAddress(ctx, addr)
+		if errors.Is(err, errors.NotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		accountNumber, err := wallet.AccountNumber(known.AccountName())
+		if err != nil {
+			log.Error(err)
+		}
+
+		var internal bool
+		if bip0044Addr, ok := known.(w.BIP0044Address); ok {
+			_, branch, _ := bip0044Addr.Path()
+			internal = branch == AddressBranchInternal
+		}
+
+		walletOutputs = append(walletOutputs, &WalletOutput{
+			Index:     int32(i),
+			AmountOut: txOut.Value,
+			Internal:  internal,
+			Address:   addr.Address(),
+			WalletAccount: &WalletAccount{
+				AccountNumber: int32(accountNumber),
+				AccountName:   known.AccountName(),
+			},
+		})
+	}
+
+	if len(walletInputs) == 0 && len(walletOutputs) == 0 {
+		return nil, nil
+	}
+
+	return DecodeTransaction(&TxInfoFromWallet{
+		WalletID:    wallet.ID,
+		Hex:         fmt.Sprintf("%x", serializedTx),
+		Timestamp:   timestamp,
+		BlockHeight: blockHeight,
+		Inputs:      walletInputs,
+		Outputs:     walletOutputs,
+	}, wallet.chainParams)
+}
@@ -0,0 +1,326 @@
+package dcrlibwallet
+
+import (
+	"context"
+	"encoding/json"
+
+	"decred.org/dcrwallet/errors"
+	w "decred.org/dcrwallet/wallet"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+)
+
+// droppedTxConflictBlocks is the maximum number of recently attached blocks
+// that a dropped transaction is checked against for conflicts.
+const droppedTxConflictBlocks = 6
+
+// MempoolTransactions returns the JSON encoded transactions of the wallet
+// that are not yet included in a block.
+func (wallet *Wallet) MempoolTransactions() (string, error) {
+	transactions, err := wallet.MempoolTransactionsRaw()
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedTransactions, err := json.Marshal(&transactions)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedTransactions), nil
+}
+
+// MempoolTransactionsRaw returns the transactions of the wallet that are not
+// yet included in a block, whether they were created by the wallet or
+// received from peers.
+func (wallet *Wallet) MempoolTransactionsRaw() ([]*Transaction, error) {
+	unminedTxs, err := wallet.internal.UnminedTransactions(wallet.shutdownContext())
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]*Transaction, 0, len(unminedTxs))
+	for _, unminedTx := range unminedTxs {
+		txHash := unminedTx.TxHash()
+		tx, err := wallet.GetTransactionRaw(txHash[:])
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, tx)
+	}
+
+	return transactions, nil
+}
+
+func (mw *MultiWallet) AddMempoolListener(listener MempoolListener, uniqueIdentifier string) error {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	if _, ok := mw.mempoolListeners[uniqueIdentifier]; ok {
+		return errors.New(ErrListenerAlreadyExist)
+	}
+
+	mw.mempoolListeners[uniqueIdentifier] = listener
+	return nil
+}
+
+func (mw *MultiWallet) RemoveMempoolListener(uniqueIdentifier string) {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	delete(mw.mempoolListeners, uniqueIdentifier)
+}
+
+// checkConflictedTransactions finds the unmined transactions that the wallet
+// removed after blocks were attached. The wallet removes unmined transactions
+// that spend the same outputs as a mined wallet transaction, and the
+// transactions that spend their outputs. spentOutpoints maps the outpoints
+// spent by the transactions of the attached blocks to the hash of the
+// spending transaction. Removed transactions that do not conflict with the
+// blocks, such as expired transactions, are reported as dropped. All removed
+// transactions are deleted from the transactions index.
+//
+// Mined transactions that do not involve the wallet are not seen by the
+// wallet, so the unmined transactions that conflict with them are found by
+// checkDroppedTransactions instead.
+func (mw *MultiWallet) checkConflictedTransactions(wallet *Wallet, spentOutpoints map[string]string) {
+	// Dropped transactions found to conflict with a block are removed from
+	// the background, which must not report them a second time.
+	wallet.conflictsMu.Lock()
+	defer wallet.conflictsMu.Unlock()
+
+	var pendingTxs []*Transaction
+	err := wallet.txDB.ReadUnmined(&pendingTxs)
+	if err != nil {
+		log.Errorf("[%d] Error reading unmined txs: %v", wallet.ID, err)
+		return
+	}
+
+	ctx := wallet.shutdownContext()
+	removedTxs := make(map[string]*Transaction)
+	for _, tx := range pendingTxs {
+		txHash, err := chainhash.NewHashFromStr(tx.Hash)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		_, _, _, err = wallet.internal.TransactionSummary(ctx, txHash)
+		if errors.Is(err, errors.NotExist) {
+			removedTxs[tx.Hash] = tx
+		} else if err != nil {
+			log.Errorf("[%d] Error checking unmined tx %s: %v", wallet.ID, tx.Hash, err)
+		}
+	}
+
+	// conflictingTxHash returns the hash of the mined transaction that tx
+	// conflicts with, directly or by spending the output of a removed
+	// transaction that conflicts with it.
+	var conflictingTxHash func(tx *Transaction) string
+	conflictingTxHash = func(tx *Transaction) string {
+		for _, input := range tx.Inputs {
+			if hash, ok := spentOutpoints[input.PreviousOutpoint]; ok {
+				return hash
+			}
+			if parent, ok := removedTxs[input.PreviousTransactionHash]; ok && parent != tx {
+				if hash := conflictingTxHash(parent); hash != "" {
+					return hash
+				}
+			}
+		}
+		return ""
+	}
+
+	for hash, tx := range removedTxs {
+		if err = wallet.txDB.Delete(tx); err != nil {
+			log.Errorf("[%d] Error deleting removed tx %s: %v", wallet.ID, hash, err)
+			continue
+		}
+
+		mw.unmatchPaymentRequests(wallet, hash)
+
+		conflictingHash := conflictingTxHash(tx)
+		if conflictingHash == "" {
+			log.Infof("[%d] Unmined transaction %s was removed", wallet.ID, hash)
+			mw.publishTransactionDropped(wallet.ID, hash)
+			continue
+		}
+
+		log.Infof("[%d] Unmined transaction %s conflicts with mined transaction %s", wallet.ID, hash, conflictingHash)
+		mw.publishEvent(Event{
+			Kind:              TransactionConflictedEvent,
+			WalletID:          wallet.ID,
+			TxHash:            hash,
+			ConflictingTxHash: conflictingHash,
+		})
+	}
+}
+
+// checkDroppedTransactions asks the connected peers for the unmined
+// transactions of the wallet that were already unmined when this was last
+// called, and reports those that no peer has in its mempool as dropped.
+// Transactions are only reported once and stay in the wallet, where they may
+// still be mined if they are broadcast again. The peers are asked in the
+// background so that slow peers do not hold up the block notifications.
+//
+// A transaction that no peer has is first checked against all transactions
+// of the blocks attached since it was last seen unmined, up to the last
+// droppedTxConflictBlocks blocks. If a mined transaction spends the same
+// outputs, the transaction is removed from the wallet and reported as
+// conflicted instead, as happens to transactions received from a sender
+// that double spent them.
+func (mw *MultiWallet) checkDroppedTransactions(wallet *Wallet) {
+	syncer := mw.spvSyncer()
+	if syncer == nil || !mw.IsSynced() {
+		return
+	}
+
+	ctx := wallet.shutdownContext()
+	unminedTxs, err := wallet.internal.UnminedTransactions(ctx)
+	if err != nil {
+		log.Errorf("[%d] Error reading unmined txs: %v", wallet.ID, err)
+		return
+	}
+	_, tipHeight := wallet.internal.MainChainTip(ctx)
+
+	wallet.mempoolMu.Lock()
+	defer wallet.mempoolMu.Unlock()
+
+	if wallet.checkingMempool {
+		return
+	}
+
+	pendingTxs := make(map[chainhash.Hash]struct{}, len(unminedTxs))
+	droppedTxs := make(map[chainhash.Hash]struct{})
+	var candidates []*wire.MsgTx
+	for _, tx := range unminedTxs {
+		txHash := tx.TxHash()
+		pendingTxs[txHash] = struct{}{}
+		if _, ok := wallet.droppedTxs[txHash]; ok {
+			droppedTxs[txHash] = struct{}{}
+			continue
+		}
+		if _, ok := wallet.pendingTxs[txHash]; ok {
+			candidates = append(candidates, tx)
+		}
+	}
+	pendingHeight := wallet.pendingTxsHeight
+	wallet.pendingTxs = pendingTxs
+	wallet.pendingTxsHeight = tipHeight
+	wallet.droppedTxs = droppedTxs
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	wallet.checkingMempool = true
+	go func() {
+		defer func() {
+			wallet.mempoolMu.Lock()
+			wallet.checkingMempool = false
+			wallet.mempoolMu.Unlock()
+		}()
+
+		// The outpoints spent by the recently attached blocks are only
+		// fetched once a transaction is found to be dropped.
+		var spentOutpoints map[string]string
+		var conflicted bool
+		defer func() {
+			if conflicted {
+				mw.checkConflictedTransactions(wallet, spentOutpoints)
+			}
+		}()
+
+		for _, tx := range candidates {
+			txHash := tx.TxHash()
+			found, err := syncer.PeersHaveTransaction(ctx, &txHash)
+			if err != nil {
+				log.Debugf("[%d] Unable to check if peers have tx %v: %v", wallet.ID, txHash, err)
+				continue
+			}
+			if found {
+				continue
+			}
+
+			if spentOutpoints == nil {
+				fromHeight := pendingHeight
+				if tipHeight-fromHeight >= droppedTxConflictBlocks {
+					fromHeight = tipHeight - droppedTxConflictBlocks + 1
+				}
+				spentOutpoints, err = blockSpentOutpoints(ctx, wallet, fromHeight, tipHeight)
+				if err != nil {
+					log.Debugf("[%d] Unable to check attached blocks for conflicts: %v", wallet.ID, err)
+					spentOutpoints = make(map[string]string)
+				}
+			}
+			if conflictsWithSpentOutpoints(tx, spentOutpoints) {
+				// The removed transaction is reported as conflicted by
+				// checkConflictedTransactions.
+				err = wallet.internal.AbandonTransaction(ctx, &txHash)
+				if err == nil {
+					conflicted = true
+					continue
+				}
+				log.Errorf("[%d] Error removing conflicted tx %v: %v", wallet.ID, txHash, err)
+			}
+
+			wallet.mempoolMu.Lock()
+			wallet.droppedTxs[txHash] = struct{}{}
+			wallet.mempoolMu.Unlock()
+
+			log.Infof("[%d] Unmined transaction %v is not in the mempool of any peer", wallet.ID, txHash)
+			mw.unmatchPaymentRequests(wallet, txHash.String())
+			mw.publishTransactionDropped(wallet.ID, txHash.String())
+		}
+	}()
+}
+
+// blockSpentOutpoints returns the outpoints spent by all transactions of the
+// main chain blocks from fromHeight to toHeight mapped to the hash of the
+// spending transaction. The blocks are fetched from the network as the wallet
+// only keeps its own transactions.
+func blockSpentOutpoints(ctx context.Context, wallet *Wallet, fromHeight, toHeight int32) (map[string]string, error) {
+	var blockHashes []*chainhash.Hash
+	for height := fromHeight; height <= toHeight; height++ {
+		block, err := wallet.internal.BlockInfo(ctx, w.NewBlockIdentifierFromHeight(height))
+		if err != nil {
+			return nil, err
+		}
+		blockHashes = append(blockHashes, &block.Hash)
+	}
+
+	n, err := wallet.internal.NetworkBackend()
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := n.Blocks(ctx, blockHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	spentOutpoints := make(map[string]string)
+	for _, block := range blocks {
+		for _, txs := range [][]*wire.MsgTx{block.Transactions, block.STransactions} {
+			for _, tx := range txs {
+				txHash := tx.TxHash().String()
+				for _, in := range tx.TxIn {
+					spentOutpoints[in.PreviousOutPoint.String()] = txHash
+				}
+			}
+		}
+	}
+	return spentOutpoints, nil
+}
+
+func conflictsWithSpentOutpoints(tx *wire.MsgTx, spentOutpoints map[string]string) bool {
+	for _, in := range tx.TxIn {
+		if _, ok := spentOutpoints[in.PreviousOutPoint.String()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (mw *MultiWallet) publishTransactionDropped(walletID int, transactionHash string) {
+	mw.publishEvent(Event{Kind: TransactionDroppedEvent, WalletID: walletID, TxHash: transactionHash})
+}
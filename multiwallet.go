@@ -3,6 +3,7 @@ package dcrlibwallet
 import (
 	"context"
 	"encoding/json"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -34,6 +35,18 @@ type MultiWallet struct {
 	notificationListenersMu         sync.RWMutex
 	txAndBlockNotificationListeners map[string]TxAndBlockNotificationListener
 	blocksRescanProgressListener    BlocksRescanProgressListener
+	txConfirmationsListeners        map[string]TxConfirmationsListener
+	paymentRequestListeners         map[string]PaymentRequestListener
+	mempoolListeners                map[string]MempoolListener
+	dataBudgetListeners             map[string]DataBudgetListener
+	syncStallListeners              map[string]SyncStallListener
+	ticketBuyerListeners            map[string]TicketBuyerListener
+	headersImportProgressListeners  map[string]HeadersImportProgressListener
+
+	events *eventStream
+
+	dataUsage       *dataUsageTracker
+	syncDiagnostics *syncDiagnosticsTracker
 
 	shuttingDown chan bool
 	cancelFuncs  []context.CancelFunc
@@ -77,6 +90,48 @@ func NewMultiWallet(rootDir, dbDriver, netType string) (*MultiWallet, error) {
 		return nil, err
 	}
 
+	// init database for saving/reading address book contacts
+	err = walletsDb.Init(&Contact{})
+	if err != nil {
+		log.Errorf("Error initializing contacts database: %s", err.Error())
+		return nil, err
+	}
+
+	// init database for saving/reading confirmation threshold subscriptions
+	err = walletsDb.Init(&ConfirmationSubscription{})
+	if err != nil {
+		log.Errorf("Error initializing confirmation subscriptions database: %s", err.Error())
+		return nil, err
+	}
+
+	// init database for saving/reading payment requests
+	err = walletsDb.Init(&PaymentRequest{})
+	if err != nil {
+		log.Errorf("Error initializing payment requests database: %s", err.Error())
+		return nil, err
+	}
+
+	// init database for saving/reading peer bans
+	err = walletsDb.Init(&BannedPeer{})
+	if err != nil {
+		log.Errorf("Error initializing banned peers database: %s", err.Error())
+		return nil, err
+	}
+
+	// init database for saving/reading queued rescans
+	err = walletsDb.Init(&RescanRequest{})
+	if err != nil {
+		log.Errorf("Error initializing rescan queue database: %s", err.Error())
+		return nil, err
+	}
+
+	// init database for saving/reading daily data usage
+	err = walletsDb.Init(&DataUsage{})
+	if err != nil {
+		log.Errorf("Error initializing data usage database: %s", err.Error())
+		return nil, err
+	}
+
 	mw := &MultiWallet{
 		dbDriver:    dbDriver,
 		rootDir:     rootDir,
@@ -87,8 +142,21 @@ func NewMultiWallet(rootDir, dbDriver, netType string) (*MultiWallet, error) {
 			syncProgressListeners: make(map[string]SyncProgressListener),
 		},
 		txAndBlockNotificationListeners: make(map[string]TxAndBlockNotificationListener),
-		Politeia:                        newPoliteia(),
-	}
+		txConfirmationsListeners:        make(map[string]TxConfirmationsListener),
+		paymentRequestListeners:         make(map[string]PaymentRequestListener),
+		mempoolListeners:                make(map[string]MempoolListener),
+		dataBudgetListeners:             make(map[string]DataBudgetListener),
+		syncStallListeners:              make(map[string]SyncStallListener),
+		ticketBuyerListeners:            make(map[string]TicketBuyerListener),
+		headersImportProgressListeners:  make(map[string]HeadersImportProgressListener),
+		events:                          newEventStream(),
+		dataUsage:                       newDataUsageTracker(),
+		syncDiagnostics:                 newSyncDiagnosticsTracker(),
+	}
+
+	mw.Politeia = newPoliteia(func() *http.Client {
+		return proxyHTTPClient(mw.socksProxy())
+	})
 
 	// read saved wallets info from db and initialize wallets
 	query := mw.db.Select(q.True()).OrderBy("ID")
@@ -104,6 +172,8 @@ func NewMultiWallet(rootDir, dbDriver, netType string) (*MultiWallet, error) {
 		if err != nil {
 			return nil, err
 		}
+		wallet.contactNameForAddress = mw.contactNameForAddress
+		wallet.trackConfirmations = mw.trackTransactionConfirmations
 		mw.wallets[wallet.ID] = wallet
 	}
 
@@ -123,8 +193,9 @@ func (mw *MultiWallet) Shutdown() {
 	// Trigger shuttingDown signal to cancel all contexts created with `shutdownContextWithCancel`.
 	mw.shuttingDown <- true
 
-	mw.CancelRescan()
+	mw.stopRescanQueue()
 	mw.CancelSync()
+	mw.closeSubscriptions()
 
 	for _, wallet := range mw.wallets {
 		wallet.Shutdown()
@@ -408,7 +479,8 @@ func (mw *MultiWallet) saveNewWallet(wallet *Wallet, setupWallet func() error) (
 		return nil, errors.New(ErrExist)
 	}
 
-	if mw.IsConnectedToDecredNetwork() {
+	// Wallets can be added to a running SPV sync but not to an RPC sync.
+	if mw.IsConnectedToDecredNetwork() && mw.spvSyncer() == nil {
 		return nil, errors.New(ErrSyncAlreadyInProgress)
 	}
 	// Perform database save operations in batch transaction
@@ -454,8 +526,14 @@ func (mw *MultiWallet) saveNewWallet(wallet *Wallet, setupWallet func() error) (
 		return nil, translateError(err)
 	}
 
+	wallet.contactNameForAddress = mw.contactNameForAddress
+	wallet.trackConfirmations = mw.trackTransactionConfirmations
 	mw.wallets[wallet.ID] = wallet
 
+	if err := mw.addWalletToSync(wallet); err != nil {
+		log.Errorf("[%d] Error adding wallet to sync: %v", wallet.ID, err)
+	}
+
 	return wallet, nil
 }
 
@@ -481,7 +559,8 @@ func (mw *MultiWallet) RenameWallet(walletID int, newName string) error {
 
 func (mw *MultiWallet) DeleteWallet(walletID int, privPass []byte) error {
 
-	if mw.IsConnectedToDecredNetwork() {
+	// Wallets can be removed from a running SPV sync but not from an RPC sync.
+	if mw.IsConnectedToDecredNetwork() && mw.spvSyncer() == nil {
 		return errors.New(ErrSyncAlreadyInProgress)
 	}
 
@@ -490,17 +569,54 @@ func (mw *MultiWallet) DeleteWallet(walletID int, privPass []byte) error {
 		return errors.New(ErrNotExist)
 	}
 
-	err := wallet.deleteWallet(privPass)
+	err := mw.removeWalletFromSync(wallet)
 	if err != nil {
 		return translateError(err)
 	}
 
+	// Stop the rescan queue if it is rescanning the wallet. The queue is
+	// started again once the wallet and its queued rescans are deleted, or
+	// resumes the rescan if the wallet is not deleted.
+	rescanning := mw.isRescanningWallet(walletID)
+	if rescanning {
+		mw.stopRescanQueue()
+		defer mw.startRescanQueue()
+	}
+
+	err = wallet.deleteWallet(privPass)
+	if err != nil {
+		// The wallet was not deleted, resume syncing it.
+		if syncErr := mw.addWalletToSync(wallet); syncErr != nil {
+			log.Errorf("[%d] Error adding wallet to sync: %v", walletID, syncErr)
+		}
+		return translateError(err)
+	}
+
 	err = mw.db.DeleteStruct(wallet)
 	if err != nil {
 		return translateError(err)
 	}
 
+	err = mw.deleteConfirmationSubscriptions(walletID)
+	if err != nil {
+		log.Errorf("[%d] Error deleting confirmation subscriptions: %v", walletID, err)
+	}
+
+	err = mw.deletePaymentRequests(walletID)
+	if err != nil {
+		log.Errorf("[%d] Error deleting payment requests: %v", walletID, err)
+	}
+
+	err = mw.deleteRescanRequests(walletID)
+	if err != nil {
+		log.Errorf("[%d] Error deleting queued rescans: %v", walletID, err)
+	}
+	if rescanning {
+		mw.publishEvent(Event{Kind: BlocksRescanEndedEvent, WalletID: walletID})
+	}
+
 	delete(mw.wallets, walletID)
+	mw.syncedWithoutRemovedWallet()
 
 	return nil
 }
@@ -567,10 +683,12 @@ func (mw *MultiWallet) OpenedWalletsCount() int32 {
 	return int32(len(mw.OpenedWalletIDsRaw()))
 }
 
+// SyncedWalletsCount returns the number of synced wallets, wallets excluded
+// from sync are not counted.
 func (mw *MultiWallet) SyncedWalletsCount() int32 {
 	var syncedWallets int32
 	for _, wallet := range mw.wallets {
-		if wallet.WalletOpened() && wallet.synced {
+		if wallet.syncable() && wallet.synced {
 			syncedWallets++
 		}
 	}
@@ -578,6 +696,19 @@ func (mw *MultiWallet) SyncedWalletsCount() int32 {
 	return syncedWallets
 }
 
+// SyncableWalletsCount returns the number of opened wallets that are not
+// excluded from sync.
+func (mw *MultiWallet) SyncableWalletsCount() int32 {
+	var syncableWallets int32
+	for _, wallet := range mw.wallets {
+		if wallet.syncable() {
+			syncableWallets++
+		}
+	}
+
+	return syncableWallets
+}
+
 func (mw *MultiWallet) WalletNameExists(walletName string) (bool, error) {
 	if strings.HasPrefix(walletName, "wallet-") {
 		return false, errors.E(ErrReservedWalletName)
@@ -2,7 +2,12 @@ package dcrlibwallet
 
 import (
 	"bytes"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"time"
+
+	"github.com/planetdecred/dcrlibwallet/txhelper"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -16,6 +21,68 @@ func genPass() []byte {
 	return pass
 }
 
+// createTestMultiWallet creates a multiwallet for netType in a temporary
+// directory with one wallet whose passphrase is "passphrase". The returned
+// func shuts down the multiwallet and removes the directory.
+func createTestMultiWallet(netType string) (*MultiWallet, *Wallet, func()) {
+	rootDir, err := ioutil.TempDir("", "dcrlibwallet")
+	Expect(err).To(BeNil())
+
+	mw, err := NewMultiWallet(rootDir, "", netType)
+	Expect(err).To(BeNil())
+
+	wallet, err := mw.CreateNewWallet("test", "passphrase", PassphraseTypePass)
+	Expect(err).To(BeNil())
+
+	return mw, wallet, func() {
+		mw.Shutdown()
+		os.RemoveAll(rootDir)
+	}
+}
+
+// dcrAtoms converts a DCR amount to atoms.
+func dcrAtoms(dcr int64) int64 {
+	return dcr * 1e8
+}
+
+// unixDate returns the unix time of a yyyy-mm-dd date.
+func unixDate(date string) int64 {
+	t, err := time.Parse("2006-01-02", date)
+	Expect(err).To(BeNil())
+	return t.Unix()
+}
+
+// receiveTx returns a regular transaction that receives amount to the
+// default account.
+func receiveTx(hash, date string, amount int64) Transaction {
+	return Transaction{
+		Hash:      hash,
+		Type:      txhelper.TxTypeRegular,
+		Direction: txhelper.TxDirectionReceived,
+		Timestamp: unixDate(date),
+		Amount:    amount,
+		Outputs:   []*TxOutput{{Amount: amount, AccountNumber: 0}},
+	}
+}
+
+// sendTx returns a regular transaction that spends input from the default
+// account, sends sent to another wallet and returns the change.
+func sendTx(hash, date string, input, sent, fee int64) Transaction {
+	return Transaction{
+		Hash:      hash,
+		Type:      txhelper.TxTypeRegular,
+		Direction: txhelper.TxDirectionSent,
+		Timestamp: unixDate(date),
+		Amount:    sent,
+		Fee:       fee,
+		Inputs:    []*TxInput{{Amount: input, AccountNumber: 0}},
+		Outputs: []*TxOutput{
+			{Amount: sent, AccountNumber: -1},
+			{Amount: input - sent - fee, AccountNumber: 0},
+		},
+	}
+}
+
 var _ = Describe("MultiwalletUtils", func() {
 	Describe("Wallet Seed Encryption", func() {
 		Context("encryptWalletSeed and decryptWalletSeed", func() {
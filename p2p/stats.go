@@ -0,0 +1,116 @@
+// Copyright (c) 2021 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package p2p
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DataUsageFunc is called with the number of bytes sent to and received from
+// a remote peer or seeder since the data usage of its connection was last
+// reported.  Peers are identified by their net address key and seeders by
+// their host:port address.
+type DataUsageFunc func(peer string, sent, received uint64)
+
+// countingConn is a net.Conn that counts the bytes read from and written to
+// the underlying connection.  The unreported counts are reset each time they
+// are reported by ReportDataUsage.
+type countingConn struct {
+	// atomics
+	atomicBytesSent          uint64
+	atomicBytesReceived      uint64
+	atomicUnreportedSent     uint64
+	atomicUnreportedReceived uint64
+	atomicClosed             uint32
+
+	net.Conn
+	peer string
+}
+
+// countConn wraps c in a countingConn that is reported by ReportDataUsage
+// until it is closed.
+func (lp *LocalPeer) countConn(c net.Conn, peer string) *countingConn {
+	counter := &countingConn{Conn: c, peer: peer}
+	lp.countersMu.Lock()
+	lp.counters[counter] = struct{}{}
+	lp.countersMu.Unlock()
+	return counter
+}
+
+// countedDial dials addr with the dial function of the local peer and counts
+// the data transferred over the connection.  It is used for connections to
+// seeders.
+func (lp *LocalPeer) countedDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, err := lp.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return lp.countConn(c, addr), nil
+}
+
+// ReportDataUsage calls report with the bytes transferred over each
+// connection to remote peers and seeders since the last call, skipping the
+// connections without any transfers.  Closed connections are reported one
+// last time and then forgotten.
+func (lp *LocalPeer) ReportDataUsage(report DataUsageFunc) {
+	lp.countersMu.Lock()
+	defer lp.countersMu.Unlock()
+
+	for c := range lp.counters {
+		// Check for closing before taking the counts so that no
+		// transfers are missed after the connection is forgotten.
+		closed := atomic.LoadUint32(&c.atomicClosed) == 1
+		sent := atomic.SwapUint64(&c.atomicUnreportedSent, 0)
+		received := atomic.SwapUint64(&c.atomicUnreportedReceived, 0)
+		if sent > 0 || received > 0 {
+			report(c.peer, sent, received)
+		}
+		if closed {
+			delete(lp.counters, c)
+		}
+	}
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		atomic.AddUint64(&c.atomicBytesReceived, uint64(n))
+		atomic.AddUint64(&c.atomicUnreportedReceived, uint64(n))
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		atomic.AddUint64(&c.atomicBytesSent, uint64(n))
+		atomic.AddUint64(&c.atomicUnreportedSent, uint64(n))
+	}
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	atomic.StoreUint32(&c.atomicClosed, 1)
+	return c.Conn.Close()
+}
+
+// BytesSent returns the number of bytes written to the peer's connection.
+func (rp *RemotePeer) BytesSent() uint64 {
+	return atomic.LoadUint64(&rp.counter.atomicBytesSent)
+}
+
+// BytesReceived returns the number of bytes read from the peer's connection.
+func (rp *RemotePeer) BytesReceived() uint64 {
+	return atomic.LoadUint64(&rp.counter.atomicBytesReceived)
+}
+
+// PingTime returns the round trip time of the last answered ping, or zero if
+// no ping has been answered yet.
+func (rp *RemotePeer) PingTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&rp.atomicPingTime))
+}
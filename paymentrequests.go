@@ -0,0 +1,413 @@
+package dcrlibwallet
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+const (
+	PaymentRequestStatusAll           int32 = -1
+	PaymentRequestStatusPending       int32 = 0
+	PaymentRequestStatusPartiallyPaid int32 = 1
+	PaymentRequestStatusPaid          int32 = 2
+	PaymentRequestStatusOverpaid      int32 = 3
+	PaymentRequestStatusExpired       int32 = 4
+)
+
+// PaymentRequest is used with storm for saving payment requests to the
+// multiwallet db. Each request reserves a fresh address from the wallet
+// account and tracks the transactions paying to that address. ExpiresAt is
+// a unix timestamp, a value of 0 means the request does not expire.
+//
+// AmountReceived is the total of the payments that are still in the wallet,
+// mined or not, and AmountConfirmed the total of the payments with the
+// confirmations that the wallet requires to spend them. Only confirmed
+// payments count towards the status of the request. Unsettled is set while
+// the status of the request can change as blocks are attached: while it is
+// pending or partially paid, or some of its payments are not confirmed.
+type PaymentRequest struct {
+	ID              int                      `storm:"id,increment" json:"id"`
+	WalletID        int                      `storm:"index" json:"wallet_id"`
+	Account         int32                    `json:"account"`
+	Address         string                   `storm:"index" json:"address"`
+	Amount          int64                    `json:"amount"`
+	Memo            string                   `json:"memo"`
+	Status          int32                    `storm:"index" json:"status"`
+	AmountReceived  int64                    `json:"amount_received"`
+	AmountConfirmed int64                    `json:"amount_confirmed"`
+	Unsettled       bool                     `storm:"index" json:"unsettled"`
+	Payments        []*PaymentRequestPayment `json:"payments"`
+	CreatedAt       int64                    `json:"created_at"`
+	ExpiresAt       int64                    `json:"expires_at"`
+	UpdatedAt       int64                    `json:"updated_at"`
+}
+
+// PaymentRequestPayment is a transaction that pays to the address of a
+// payment request. BlockHeight is -1 while the transaction is not mined.
+type PaymentRequestPayment struct {
+	TxHash      string `json:"tx_hash"`
+	Amount      int64  `json:"amount"`
+	BlockHeight int32  `json:"block_height"`
+}
+
+func (mw *MultiWallet) AddPaymentRequestListener(listener PaymentRequestListener, uniqueIdentifier string) error {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	if _, ok := mw.paymentRequestListeners[uniqueIdentifier]; ok {
+		return errors.New(ErrListenerAlreadyExist)
+	}
+
+	mw.paymentRequestListeners[uniqueIdentifier] = listener
+	return nil
+}
+
+func (mw *MultiWallet) RemovePaymentRequestListener(uniqueIdentifier string) {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	delete(mw.paymentRequestListeners, uniqueIdentifier)
+}
+
+func (mw *MultiWallet) publishPaymentRequestUpdated(paymentRequest *PaymentRequest) {
+	mw.publishEvent(Event{
+		Kind:           PaymentRequestUpdatedEvent,
+		WalletID:       paymentRequest.WalletID,
+		PaymentRequest: paymentRequest,
+	})
+}
+
+// CreatePaymentRequest reserves a fresh address from the specified wallet
+// account and saves a pending request for the specified amount to it.
+// Addresses of requests that are still open are never handed out for a new
+// request, an error is returned if the wallet cannot provide an unreserved
+// address without violating the address gap limit.
+func (mw *MultiWallet) CreatePaymentRequest(walletID int, account int32, amount int64, memo string, expiresAt int64) (*PaymentRequest, error) {
+	wallet := mw.WalletWithID(walletID)
+	if wallet == nil {
+		return nil, errors.New(ErrNotExist)
+	}
+
+	now := time.Now().Unix()
+	if amount <= 0 || (expiresAt != 0 && expiresAt <= now) {
+		return nil, errors.New(ErrInvalid)
+	}
+
+	address, err := mw.unreservedPaymentAddress(wallet, account)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentRequest := &PaymentRequest{
+		WalletID:  walletID,
+		Account:   account,
+		Address:   address,
+		Amount:    amount,
+		Memo:      memo,
+		Status:    PaymentRequestStatusPending,
+		Unsettled: true,
+		Payments:  make([]*PaymentRequestPayment, 0),
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+		UpdatedAt: now,
+	}
+
+	err = mw.db.Save(paymentRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return paymentRequest, nil
+}
+
+// unreservedPaymentAddress returns the next address of the account that is
+// not used by an open payment request. NextAddress wraps around to unused
+// addresses once the gap limit is reached so at most gap limit addresses
+// are checked.
+func (mw *MultiWallet) unreservedPaymentAddress(wallet *Wallet, account int32) (string, error) {
+	for i := uint32(0); i < wallet.internal.GapLimit(); i++ {
+		address, err := wallet.NextAddress(account)
+		if err != nil {
+			return "", err
+		}
+
+		openRequests, err := mw.findPaymentRequests(q.And(
+			q.Eq("Address", address),
+			q.In("Status", []int32{PaymentRequestStatusPending, PaymentRequestStatusPartiallyPaid}),
+		), 0, 0)
+		if err != nil {
+			return "", err
+		}
+
+		if len(openRequests) == 0 {
+			return address, nil
+		}
+	}
+
+	return "", errors.New(ErrUnavailable)
+}
+
+func (mw *MultiWallet) PaymentRequestWithID(paymentRequestID int) (*PaymentRequest, error) {
+	paymentRequest := &PaymentRequest{}
+	err := mw.db.One("ID", paymentRequestID, paymentRequest)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil, errors.New(ErrNotExist)
+		}
+		return nil, err
+	}
+
+	return paymentRequest, nil
+}
+
+func (mw *MultiWallet) DeletePaymentRequest(paymentRequestID int) error {
+	paymentRequest, err := mw.PaymentRequestWithID(paymentRequestID)
+	if err != nil {
+		return err
+	}
+
+	return mw.db.DeleteStruct(paymentRequest)
+}
+
+func (mw *MultiWallet) GetPaymentRequests(walletID int, status, offset, limit int32) (string, error) {
+	paymentRequests, err := mw.GetPaymentRequestsRaw(walletID, status, offset, limit)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedPaymentRequests, err := json.Marshal(&paymentRequests)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedPaymentRequests), nil
+}
+
+// GetPaymentRequestsRaw returns the payment requests of the specified wallet
+// with the specified status, newest first. Use PaymentRequestStatusAll to
+// return requests of any status. Statuses are updated as blocks are
+// attached, so a request is only reported as expired once a block is
+// attached after it expires.
+func (mw *MultiWallet) GetPaymentRequestsRaw(walletID int, status, offset, limit int32) ([]PaymentRequest, error) {
+	matcher := q.Eq("WalletID", walletID)
+	if status != PaymentRequestStatusAll {
+		matcher = q.And(matcher, q.Eq("Status", status))
+	}
+
+	return mw.findPaymentRequests(matcher, offset, limit)
+}
+
+func (mw *MultiWallet) findPaymentRequests(matcher q.Matcher, offset, limit int32) ([]PaymentRequest, error) {
+	query := mw.db.Select(matcher)
+	if offset > 0 {
+		query = query.Skip(int(offset))
+	}
+	if limit > 0 {
+		query = query.Limit(int(limit))
+	}
+
+	paymentRequests := make([]PaymentRequest, 0)
+	err := query.OrderBy("ID").Reverse().Find(&paymentRequests)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return paymentRequests, nil
+}
+
+func (mw *MultiWallet) deletePaymentRequests(walletID int) error {
+	err := mw.db.Select(q.Eq("WalletID", walletID)).Delete(&PaymentRequest{})
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// matchPaymentRequests credits the outputs of the transaction to the payment
+// requests of the wallet whose addresses they pay to. If an address was
+// reserved by more than one request, the most recent request is credited.
+// Payments that were credited before they were mined are updated with the
+// height of their block.
+func (mw *MultiWallet) matchPaymentRequests(wallet *Wallet, tx *Transaction) {
+	received := make(map[string]int64)
+	for _, output := range tx.Outputs {
+		if output.AccountNumber > -1 {
+			received[output.Address] += output.Amount
+		}
+	}
+
+	for address, amount := range received {
+		paymentRequests, err := mw.findPaymentRequests(q.And(q.Eq("WalletID", wallet.ID), q.Eq("Address", address)), 0, 1)
+		if err != nil {
+			log.Errorf("[%d] Error reading payment requests: %v", wallet.ID, err)
+			continue
+		}
+		if len(paymentRequests) == 0 {
+			continue
+		}
+
+		paymentRequest := &paymentRequests[0]
+		payment := paymentRequest.payment(tx.Hash)
+		switch {
+		case payment == nil:
+			paymentRequest.Payments = append(paymentRequest.Payments, &PaymentRequestPayment{
+				TxHash:      tx.Hash,
+				Amount:      amount,
+				BlockHeight: tx.BlockHeight,
+			})
+		case payment.BlockHeight != tx.BlockHeight:
+			payment.BlockHeight = tx.BlockHeight
+		default:
+			// The tx was credited when it was first seen.
+			continue
+		}
+
+		mw.updatePaymentRequest(wallet, paymentRequest, true)
+	}
+}
+
+// unmatchPaymentRequests reverses the payment of the transaction to the
+// payment requests of the wallet, if any, after the transaction was removed
+// from the wallet or dropped from the mempool of the peers. A dropped
+// transaction that is mined later is credited again.
+func (mw *MultiWallet) unmatchPaymentRequests(wallet *Wallet, txHash string) {
+	paymentRequests, err := mw.findPaymentRequests(q.Eq("WalletID", wallet.ID), 0, 0)
+	if err != nil {
+		log.Errorf("[%d] Error reading payment requests: %v", wallet.ID, err)
+		return
+	}
+
+	for i := range paymentRequests {
+		paymentRequest := &paymentRequests[i]
+		for j, payment := range paymentRequest.Payments {
+			if payment.TxHash != txHash {
+				continue
+			}
+
+			paymentRequest.Payments = append(paymentRequest.Payments[:j], paymentRequest.Payments[j+1:]...)
+			mw.updatePaymentRequest(wallet, paymentRequest, true)
+			break
+		}
+	}
+}
+
+// updatePaymentRequests updates the statuses of the payment requests of the
+// wallet after a block is attached, as payments gain confirmations and open
+// requests expire. Only unsettled requests are read, settled requests cannot
+// change status unless a payment is matched or reversed.
+func (mw *MultiWallet) updatePaymentRequests(wallet *Wallet) {
+	paymentRequests, err := mw.unsettledPaymentRequests(wallet.ID)
+	if err != nil {
+		log.Errorf("[%d] Error reading payment requests: %v", wallet.ID, err)
+		return
+	}
+
+	for _, paymentRequest := range paymentRequests {
+		mw.updatePaymentRequest(wallet, paymentRequest, false)
+	}
+}
+
+// unsettledPaymentRequests returns the unsettled payment requests of the
+// wallet, oldest first. The requests are looked up through the storm index
+// of Unsettled so that settled requests are not read.
+func (mw *MultiWallet) unsettledPaymentRequests(walletID int) ([]*PaymentRequest, error) {
+	var unsettled []PaymentRequest
+	err := mw.db.Find("Unsettled", true, &unsettled)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	paymentRequests := make([]*PaymentRequest, 0, len(unsettled))
+	for i := range unsettled {
+		if unsettled[i].WalletID == walletID {
+			paymentRequests = append(paymentRequests, &unsettled[i])
+		}
+	}
+	sort.Slice(paymentRequests, func(i, j int) bool {
+		return paymentRequests[i].ID < paymentRequests[j].ID
+	})
+	return paymentRequests, nil
+}
+
+// updatePaymentRequest recomputes the amounts and the status of the payment
+// request at the best block of the wallet. The request is saved and
+// listeners are notified if it changed or if paymentsChanged is set.
+func (mw *MultiWallet) updatePaymentRequest(wallet *Wallet, paymentRequest *PaymentRequest, paymentsChanged bool) {
+	now := time.Now().Unix()
+	changed := paymentRequest.refresh(wallet.GetBestBlock(), wallet.RequiredConfirmations(), now)
+	if !changed && !paymentsChanged {
+		return
+	}
+
+	paymentRequest.UpdatedAt = now
+	err := mw.db.Save(paymentRequest)
+	if err != nil {
+		log.Errorf("[%d] Error saving payment request %d: %v", paymentRequest.WalletID, paymentRequest.ID, err)
+		return
+	}
+
+	mw.publishPaymentRequestUpdated(paymentRequest)
+}
+
+// refresh recomputes the amounts and the status of the payment request at
+// bestBlockHeight, counting the payments with requiredConfirmations as
+// confirmed. It returns true if any of them changed.
+func (paymentRequest *PaymentRequest) refresh(bestBlockHeight, requiredConfirmations int32, now int64) bool {
+	var received, confirmed int64
+	for _, payment := range paymentRequest.Payments {
+		received += payment.Amount
+		if payment.confirmations(bestBlockHeight) >= requiredConfirmations {
+			confirmed += payment.Amount
+		}
+	}
+
+	changed := received != paymentRequest.AmountReceived || confirmed != paymentRequest.AmountConfirmed
+	paymentRequest.AmountReceived = received
+	paymentRequest.AmountConfirmed = confirmed
+
+	status := paymentRequest.currentStatus(now)
+	changed = changed || status != paymentRequest.Status
+	paymentRequest.Status = status
+	paymentRequest.Unsettled = confirmed < received || status == PaymentRequestStatusPending ||
+		status == PaymentRequestStatusPartiallyPaid
+
+	return changed
+}
+
+// currentStatus computes the status of the payment request from the amount
+// confirmed. Funds confirmed after a request expired still complete it.
+func (paymentRequest *PaymentRequest) currentStatus(now int64) int32 {
+	switch {
+	case paymentRequest.AmountConfirmed > paymentRequest.Amount:
+		return PaymentRequestStatusOverpaid
+	case paymentRequest.AmountConfirmed == paymentRequest.Amount:
+		return PaymentRequestStatusPaid
+	case paymentRequest.ExpiresAt != 0 && paymentRequest.ExpiresAt <= now:
+		return PaymentRequestStatusExpired
+	case paymentRequest.AmountConfirmed > 0:
+		return PaymentRequestStatusPartiallyPaid
+	default:
+		return PaymentRequestStatusPending
+	}
+}
+
+func (paymentRequest *PaymentRequest) payment(txHash string) *PaymentRequestPayment {
+	for _, payment := range paymentRequest.Payments {
+		if payment.TxHash == txHash {
+			return payment
+		}
+	}
+	return nil
+}
+
+func (payment *PaymentRequestPayment) confirmations(bestBlockHeight int32) int32 {
+	if payment.BlockHeight == BlockHeightInvalid {
+		return 0
+	}
+	return bestBlockHeight - payment.BlockHeight + 1
+}
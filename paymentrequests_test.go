@@ -0,0 +1,225 @@
+package dcrlibwallet
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PaymentRequests", func() {
+	var mw *MultiWallet
+	var wallet *Wallet
+	var cleanup func()
+
+	// payTx returns a transaction that pays amount to the address, mined at
+	// blockHeight.
+	payTx := func(address string, amount int64, blockHeight int32) *Transaction {
+		var hash chainhash.Hash
+		_, err := rand.Read(hash[:])
+		Expect(err).To(BeNil())
+		return &Transaction{
+			Hash:        hash.String(),
+			BlockHeight: blockHeight,
+			Outputs: []*TxOutput{
+				{Address: address, Amount: amount, AccountNumber: 0},
+				{Address: "change", Amount: 1e8, AccountNumber: -1},
+			},
+		}
+	}
+
+	readPaymentRequest := func(id int) *PaymentRequest {
+		var paymentRequest PaymentRequest
+		Expect(mw.db.One("ID", id, &paymentRequest)).To(Succeed())
+		return &paymentRequest
+	}
+
+	BeforeEach(func() {
+		mw, wallet, cleanup = createTestMultiWallet("simnet")
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("validates and creates payment requests on unreserved addresses", func() {
+		_, err := mw.CreatePaymentRequest(wallet.ID+1, 0, 1e8, "", 0)
+		Expect(err).To(MatchError(ErrNotExist))
+		_, err = mw.CreatePaymentRequest(wallet.ID, 0, 0, "", 0)
+		Expect(err).To(MatchError(ErrInvalid))
+		_, err = mw.CreatePaymentRequest(wallet.ID, 0, 1e8, "", time.Now().Unix()-1)
+		Expect(err).To(MatchError(ErrInvalid))
+
+		first, err := mw.CreatePaymentRequest(wallet.ID, 0, 1e8, "first", 0)
+		Expect(err).To(BeNil())
+		second, err := mw.CreatePaymentRequest(wallet.ID, 0, 1e8, "second", 0)
+		Expect(err).To(BeNil())
+		Expect(second.Address).NotTo(Equal(first.Address))
+		Expect(first.Status).To(Equal(PaymentRequestStatusPending))
+
+		paymentRequests, err := mw.GetPaymentRequestsRaw(wallet.ID, PaymentRequestStatusAll, 0, 0)
+		Expect(err).To(BeNil())
+		Expect(paymentRequests).To(HaveLen(2))
+		Expect(paymentRequests[0].Memo).To(Equal("second"))
+	})
+
+	It("counts only payments with the required confirmations", func() {
+		paymentRequest, err := mw.CreatePaymentRequest(wallet.ID, 0, 3e8, "", 0)
+		Expect(err).To(BeNil())
+
+		tx := payTx(paymentRequest.Address, 1e8, BlockHeightInvalid)
+		mw.matchPaymentRequests(wallet, tx)
+
+		paymentRequest = readPaymentRequest(paymentRequest.ID)
+		Expect(paymentRequest.AmountReceived).To(Equal(int64(1e8)))
+		Expect(paymentRequest.AmountConfirmed).To(BeZero())
+		Expect(paymentRequest.Status).To(Equal(PaymentRequestStatusPending))
+
+		// Matching the tx again does not credit it twice.
+		mw.matchPaymentRequests(wallet, tx)
+		Expect(readPaymentRequest(paymentRequest.ID).AmountReceived).To(Equal(int64(1e8)))
+
+		now := time.Now().Unix()
+		paymentRequest.Payments[0].BlockHeight = 10
+		Expect(paymentRequest.refresh(10, 2, now)).To(BeFalse())
+		Expect(paymentRequest.refresh(11, 2, now)).To(BeTrue())
+		Expect(paymentRequest.AmountConfirmed).To(Equal(int64(1e8)))
+		Expect(paymentRequest.Status).To(Equal(PaymentRequestStatusPartiallyPaid))
+
+		paymentRequest.Payments = append(paymentRequest.Payments, &PaymentRequestPayment{Amount: 2e8, BlockHeight: 11})
+		Expect(paymentRequest.refresh(11, 2, now)).To(BeTrue())
+		Expect(paymentRequest.Status).To(Equal(PaymentRequestStatusPartiallyPaid))
+		Expect(paymentRequest.refresh(12, 2, now)).To(BeTrue())
+		Expect(paymentRequest.Status).To(Equal(PaymentRequestStatusPaid))
+
+		paymentRequest.Payments = append(paymentRequest.Payments, &PaymentRequestPayment{Amount: 1, BlockHeight: BlockHeightInvalid})
+		Expect(paymentRequest.refresh(12, 0, now)).To(BeTrue())
+		Expect(paymentRequest.Status).To(Equal(PaymentRequestStatusOverpaid))
+	})
+
+	It("updates partially paid, paid and overpaid requests as they are matched", func() {
+		mw.SaveUserConfigValue(SpendUnconfirmedConfigKey, true)
+		events := mw.Subscribe(&EventFilter{Kinds: []EventKind{PaymentRequestUpdatedEvent}})
+
+		paymentRequest, err := mw.CreatePaymentRequest(wallet.ID, 0, 3e8, "", 0)
+		Expect(err).To(BeNil())
+
+		mw.matchPaymentRequests(wallet, payTx(paymentRequest.Address, 1e8, BlockHeightInvalid))
+		Expect(readPaymentRequest(paymentRequest.ID).Status).To(Equal(PaymentRequestStatusPartiallyPaid))
+
+		var event Event
+		Eventually(events).Should(Receive(&event))
+		Expect(event.PaymentRequest.ID).To(Equal(paymentRequest.ID))
+		Expect(event.PaymentRequest.AmountReceived).To(Equal(int64(1e8)))
+
+		mw.matchPaymentRequests(wallet, payTx(paymentRequest.Address, 2e8, BlockHeightInvalid))
+		Expect(readPaymentRequest(paymentRequest.ID).Status).To(Equal(PaymentRequestStatusPaid))
+
+		mw.matchPaymentRequests(wallet, payTx(paymentRequest.Address, 1, BlockHeightInvalid))
+		paymentRequest = readPaymentRequest(paymentRequest.ID)
+		Expect(paymentRequest.Status).To(Equal(PaymentRequestStatusOverpaid))
+		Expect(paymentRequest.AmountReceived).To(Equal(int64(3e8 + 1)))
+		Expect(paymentRequest.Payments).To(HaveLen(3))
+	})
+
+	It("reverses payments that are removed from the wallet", func() {
+		mw.SaveUserConfigValue(SpendUnconfirmedConfigKey, true)
+
+		paymentRequest, err := mw.CreatePaymentRequest(wallet.ID, 0, 1e8, "", 0)
+		Expect(err).To(BeNil())
+
+		tx := payTx(paymentRequest.Address, 1e8, BlockHeightInvalid)
+		mw.matchPaymentRequests(wallet, tx)
+		Expect(readPaymentRequest(paymentRequest.ID).Status).To(Equal(PaymentRequestStatusPaid))
+
+		mw.unmatchPaymentRequests(wallet, tx.Hash)
+		paymentRequest = readPaymentRequest(paymentRequest.ID)
+		Expect(paymentRequest.Status).To(Equal(PaymentRequestStatusPending))
+		Expect(paymentRequest.AmountReceived).To(BeZero())
+		Expect(paymentRequest.Payments).To(BeEmpty())
+
+		// A dropped tx that is mined later is credited again.
+		tx.BlockHeight = 0
+		mw.matchPaymentRequests(wallet, tx)
+		Expect(readPaymentRequest(paymentRequest.ID).AmountReceived).To(Equal(int64(1e8)))
+	})
+
+	It("only updates unsettled requests when blocks are attached", func() {
+		mw.SaveUserConfigValue(SpendUnconfirmedConfigKey, true)
+		paid, err := mw.CreatePaymentRequest(wallet.ID, 0, 1e8, "paid", 0)
+		Expect(err).To(BeNil())
+		mw.matchPaymentRequests(wallet, payTx(paid.Address, 1e8, BlockHeightInvalid))
+
+		_, err = mw.CreatePaymentRequest(wallet.ID, 0, 1e8, "pending", 0)
+		Expect(err).To(BeNil())
+		expired, err := mw.CreatePaymentRequest(wallet.ID, 0, 1e8, "expired", time.Now().Unix()+60)
+		Expect(err).To(BeNil())
+		expired.ExpiresAt = time.Now().Unix() - 1
+		Expect(mw.db.Save(expired)).To(Succeed())
+
+		memos := func() []string {
+			unsettled, err := mw.unsettledPaymentRequests(wallet.ID)
+			Expect(err).To(BeNil())
+			var memos []string
+			for _, paymentRequest := range unsettled {
+				memos = append(memos, paymentRequest.Memo)
+			}
+			return memos
+		}
+		Expect(readPaymentRequest(paid.ID).Status).To(Equal(PaymentRequestStatusPaid))
+		Expect(memos()).To(Equal([]string{"pending", "expired"}))
+
+		mw.updatePaymentRequests(wallet)
+		Expect(readPaymentRequest(expired.ID).Status).To(Equal(PaymentRequestStatusExpired))
+		Expect(memos()).To(Equal([]string{"pending"}))
+	})
+
+	It("keeps paid requests with unconfirmed payments unsettled", func() {
+		paymentRequest, err := mw.CreatePaymentRequest(wallet.ID, 0, 1e8, "", 0)
+		Expect(err).To(BeNil())
+
+		now := time.Now().Unix()
+		paymentRequest.Payments = []*PaymentRequestPayment{
+			{Amount: 1e8, BlockHeight: 10},
+			{Amount: 1, BlockHeight: BlockHeightInvalid},
+		}
+		Expect(paymentRequest.refresh(11, 2, now)).To(BeTrue())
+		Expect(paymentRequest.Status).To(Equal(PaymentRequestStatusPaid))
+		Expect(paymentRequest.Unsettled).To(BeTrue())
+
+		paymentRequest.Payments[1].BlockHeight = 11
+		Expect(paymentRequest.refresh(12, 2, now)).To(BeTrue())
+		Expect(paymentRequest.Status).To(Equal(PaymentRequestStatusOverpaid))
+		Expect(paymentRequest.Unsettled).To(BeFalse())
+	})
+
+	It("expires open requests when blocks are attached, not when they are read", func() {
+		paymentRequest, err := mw.CreatePaymentRequest(wallet.ID, 0, 1e8, "", time.Now().Unix()+60)
+		Expect(err).To(BeNil())
+		paymentRequest.ExpiresAt = time.Now().Unix() - 1
+		Expect(mw.db.Save(paymentRequest)).To(Succeed())
+
+		events := mw.Subscribe(&EventFilter{Kinds: []EventKind{PaymentRequestUpdatedEvent}})
+
+		paymentRequests, err := mw.GetPaymentRequestsRaw(wallet.ID, PaymentRequestStatusAll, 0, 0)
+		Expect(err).To(BeNil())
+		Expect(paymentRequests[0].Status).To(Equal(PaymentRequestStatusPending))
+		Consistently(events, 100*time.Millisecond).ShouldNot(Receive())
+
+		mw.updatePaymentRequests(wallet)
+		Expect(readPaymentRequest(paymentRequest.ID).Status).To(Equal(PaymentRequestStatusExpired))
+		Eventually(events).Should(Receive())
+
+		// Requests that did not change are not saved again.
+		mw.updatePaymentRequests(wallet)
+		Consistently(events, 100*time.Millisecond).ShouldNot(Receive())
+
+		// Funds confirmed after a request expired still complete it.
+		mw.SaveUserConfigValue(SpendUnconfirmedConfigKey, true)
+		mw.matchPaymentRequests(wallet, payTx(paymentRequest.Address, 1e8, BlockHeightInvalid))
+		Expect(readPaymentRequest(paymentRequest.ID).Status).To(Equal(PaymentRequestStatusPaid))
+	})
+})
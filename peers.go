@@ -0,0 +1,197 @@
+package dcrlibwallet
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/planetdecred/dcrlibwallet/spv"
+)
+
+// BannedPeer is used with storm for saving peer bans to the multiwallet db.
+// Bans apply to all peers on the banned host regardless of the port.
+type BannedPeer struct {
+	Host        string `storm:"id" json:"host"`
+	BannedAt    int64  `json:"banned_at"`
+	BannedUntil int64  `storm:"index" json:"banned_until"`
+}
+
+func (mw *MultiWallet) spvSyncer() *spv.Syncer {
+	mw.syncData.mu.RLock()
+	defer mw.syncData.mu.RUnlock()
+	return mw.syncData.syncer
+}
+
+func (mw *MultiWallet) PeerInfo() (string, error) {
+	peers, err := mw.PeerInfoRaw()
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedPeers, err := json.Marshal(&peers)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedPeers), nil
+}
+
+// PeerInfoRaw returns the peers connected during SPV sync, ordered by the
+// time they were connected. An empty list is returned if SPV sync is not
+// running. The ping time is only known after the first ping which is sent
+// 2 minutes after connecting.
+func (mw *MultiWallet) PeerInfoRaw() ([]*PeerInfo, error) {
+	peers := make([]*PeerInfo, 0)
+
+	syncer := mw.spvSyncer()
+	if syncer == nil {
+		return peers, nil
+	}
+
+	for addr, rp := range syncer.GetRemotePeers() {
+		peers = append(peers, &PeerInfo{
+			ID:              int32(rp.ID()),
+			Address:         addr,
+			UserAgent:       rp.UA(),
+			ProtocolVersion: int32(rp.Pver()),
+			Services:        rp.Services().String(),
+			StartingHeight:  rp.InitialHeight(),
+			BanScore:        int32(rp.BanScore()),
+			PingMillis:      rp.PingTime().Milliseconds(),
+			BytesSent:       int64(rp.BytesSent()),
+			BytesReceived:   int64(rp.BytesReceived()),
+		})
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID < peers[j].ID
+	})
+
+	return peers, nil
+}
+
+// DisconnectPeer disconnects the connected peer with the address returned
+// by PeerInfo. The syncer may connect to the peer again later, use BanPeer
+// to prevent that.
+func (mw *MultiWallet) DisconnectPeer(address string) error {
+	syncer := mw.spvSyncer()
+	if syncer == nil {
+		return errors.New(ErrNotConnected)
+	}
+
+	if err := syncer.DisconnectPeer(address); err != nil {
+		return errors.New(ErrNotExist)
+	}
+
+	return nil
+}
+
+// BanPeer prevents SPV sync from connecting to the host of the address for
+// durationSeconds and disconnects any connected peer on that host. The
+// address may be a host or a host:port pair. Bans are persisted and apply
+// to later syncs until they expire. Persistent peers are not affected.
+func (mw *MultiWallet) BanPeer(address string, durationSeconds int64) error {
+	host := peerHost(address)
+	if net.ParseIP(host) == nil || durationSeconds <= 0 {
+		return errors.New(ErrInvalid)
+	}
+
+	now := time.Now()
+	bannedUntil := now.Add(time.Duration(durationSeconds) * time.Second)
+	err := mw.db.Save(&BannedPeer{
+		Host:        host,
+		BannedAt:    now.Unix(),
+		BannedUntil: bannedUntil.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if syncer := mw.spvSyncer(); syncer != nil {
+		syncer.BanPeer(host, bannedUntil)
+	}
+
+	return nil
+}
+
+func (mw *MultiWallet) UnbanPeer(address string) error {
+	host := peerHost(address)
+	err := mw.db.DeleteStruct(&BannedPeer{Host: host})
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return errors.New(ErrNotExist)
+		}
+		return err
+	}
+
+	if syncer := mw.spvSyncer(); syncer != nil {
+		syncer.UnbanPeer(host)
+	}
+
+	return nil
+}
+
+func (mw *MultiWallet) BannedPeers() (string, error) {
+	bans, err := mw.BannedPeersRaw()
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedBans, err := json.Marshal(&bans)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedBans), nil
+}
+
+// BannedPeersRaw returns the bans that have not expired.
+func (mw *MultiWallet) BannedPeersRaw() ([]BannedPeer, error) {
+	bans := make([]BannedPeer, 0)
+	err := mw.db.Select(q.Gt("BannedUntil", time.Now().Unix())).OrderBy("BannedUntil").Find(&bans)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return bans, nil
+}
+
+// activePeerBans deletes expired bans and returns the expiry time of each
+// remaining ban by host.
+func (mw *MultiWallet) activePeerBans() (map[string]time.Time, error) {
+	err := mw.db.Select(q.Lte("BannedUntil", time.Now().Unix())).Delete(&BannedPeer{})
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	bans, err := mw.BannedPeersRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	bannedHosts := make(map[string]time.Time, len(bans))
+	for _, ban := range bans {
+		bannedHosts[peerHost(ban.Host)] = time.Unix(ban.BannedUntil, 0)
+	}
+
+	return bannedHosts, nil
+}
+
+// peerHost returns the host of address, or address itself if it does not
+// include a port. IP hosts are returned in the form of net.IP.String so
+// that bans match the addresses of the peers however the IP is written.
+func peerHost(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
@@ -0,0 +1,49 @@
+package dcrlibwallet
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Peers", func() {
+	var mw *MultiWallet
+	var cleanup func()
+
+	BeforeEach(func() {
+		mw, _, cleanup = createTestMultiWallet("simnet")
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("normalizes the hosts of peer addresses", func() {
+		Expect(peerHost("127.0.0.1:19560")).To(Equal("127.0.0.1"))
+		Expect(peerHost("127.0.0.1")).To(Equal("127.0.0.1"))
+		Expect(peerHost("[::ffff:127.0.0.1]:19560")).To(Equal("127.0.0.1"))
+		Expect(peerHost("[2001:DB8:0:0::1]:19560")).To(Equal("2001:db8::1"))
+		Expect(peerHost("[2001:db8::1]")).To(Equal("2001:db8::1"))
+		Expect(peerHost("2001:0db8::1")).To(Equal("2001:db8::1"))
+	})
+
+	It("bans and unbans hosts however their IPs are written", func() {
+		Expect(mw.BanPeer("not an ip", 60)).To(MatchError(ErrInvalid))
+		Expect(mw.BanPeer("127.0.0.1", 0)).To(MatchError(ErrInvalid))
+
+		Expect(mw.BanPeer("[2001:DB8:0:0::1]:19560", 60)).To(Succeed())
+		Expect(mw.BanPeer("::ffff:127.0.0.1", 60)).To(Succeed())
+
+		bans, err := mw.activePeerBans()
+		Expect(err).To(BeNil())
+		Expect(bans).To(HaveKey("2001:db8::1"))
+		Expect(bans).To(HaveKey("127.0.0.1"))
+
+		Expect(mw.UnbanPeer("2001:db8::1")).To(Succeed())
+		Expect(mw.UnbanPeer("127.0.0.1:19560")).To(Succeed())
+		Expect(mw.UnbanPeer("127.0.0.1")).To(MatchError(ErrNotExist))
+
+		banned, err := mw.BannedPeersRaw()
+		Expect(err).To(BeNil())
+		Expect(banned).To(BeEmpty())
+	})
+})
@@ -20,10 +20,13 @@ const (
 type Politeia struct {
 	csrfToken    string
 	serverPolicy *ServerPolicy
+	httpClient   func() *http.Client
 }
 
-func newPoliteia() Politeia {
-	return Politeia{}
+func newPoliteia(httpClient func() *http.Client) Politeia {
+	return Politeia{
+		httpClient: httpClient,
+	}
 }
 
 func (p *Politeia) prepareRequest(path, method string, queryStrings map[string]string, body []byte) (*http.Request, error) {
@@ -48,7 +51,7 @@ func (p *Politeia) prepareRequest(path, method string, queryStrings map[string]s
 		originalURL := req.URL
 		if p.csrfToken == "" {
 			req.URL.Path = apiVersionPath
-			res, err := http.DefaultClient.Do(req)
+			res, err := p.httpClient().Do(req)
 			if err != nil {
 				return nil, fmt.Errorf("error fetching csrf token")
 			}
@@ -71,7 +74,7 @@ func (p *Politeia) makeRequest(path, method string, queryStrings map[string]stri
 		return err
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := p.httpClient().Do(req)
 	if err != nil {
 		return err
 	}
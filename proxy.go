@@ -0,0 +1,128 @@
+package dcrlibwallet
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/decred/dcrd/connmgr/v3"
+	"github.com/decred/go-socks/socks"
+	"github.com/planetdecred/dcrlibwallet/p2p"
+)
+
+const (
+	ProxyAddressConfigKey         = "socks_proxy_address"
+	ProxyUsernameConfigKey        = "socks_proxy_username"
+	ProxyPasswordConfigKey        = "socks_proxy_password"
+	ProxyStreamIsolationConfigKey = "socks_proxy_stream_isolation"
+
+	// proxyLookupTimeout bounds the time taken by the proxy to resolve a
+	// host, so that a proxy that does not answer cannot hold up the
+	// connections to peers.
+	proxyLookupTimeout = 30 * time.Second
+)
+
+// SetSocksProxy saves the SOCKS5 proxy used for connecting to SPV peers and
+// seeders, and for the Politeia and VSP http requests. If streamIsolation is
+// true, random credentials are used for each connection so that Tor uses a
+// different circuit for each connection, the username and password are
+// ignored in that case. The proxy is used by syncs started after it is set.
+func (mw *MultiWallet) SetSocksProxy(address, username, password string, streamIsolation bool) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return errors.New(ErrInvalid)
+	}
+
+	mw.SetStringConfigValueForKey(ProxyAddressConfigKey, address)
+	mw.SetStringConfigValueForKey(ProxyUsernameConfigKey, username)
+	mw.SetStringConfigValueForKey(ProxyPasswordConfigKey, password)
+	mw.SetBoolConfigValueForKey(ProxyStreamIsolationConfigKey, streamIsolation)
+	return nil
+}
+
+// RemoveSocksProxy deletes the saved SOCKS5 proxy so that connections are
+// made directly.
+func (mw *MultiWallet) RemoveSocksProxy() {
+	mw.DeleteUserConfigValueForKey(ProxyAddressConfigKey)
+	mw.DeleteUserConfigValueForKey(ProxyUsernameConfigKey)
+	mw.DeleteUserConfigValueForKey(ProxyPasswordConfigKey)
+	mw.DeleteUserConfigValueForKey(ProxyStreamIsolationConfigKey)
+}
+
+func (mw *MultiWallet) SocksProxyAddress() string {
+	return mw.ReadStringConfigValueForKey(ProxyAddressConfigKey)
+}
+
+// socksProxy returns the saved SOCKS5 proxy or nil if no proxy is set.
+func (mw *MultiWallet) socksProxy() *socks.Proxy {
+	return newSocksProxy(func(key string, valueOut interface{}) error {
+		return mw.ReadUserConfigValue(key, valueOut)
+	})
+}
+
+// socksProxy returns the SOCKS5 proxy saved for the multiwallet this wallet
+// belongs to or nil if no proxy is set.
+func (wallet *Wallet) socksProxy() *socks.Proxy {
+	if wallet.readUserConfigValue == nil {
+		return nil
+	}
+
+	return newSocksProxy(func(key string, valueOut interface{}) error {
+		return wallet.readUserConfigValue(true, key, valueOut)
+	})
+}
+
+func newSocksProxy(readConfigValue func(key string, valueOut interface{}) error) *socks.Proxy {
+	var address string
+	if err := readConfigValue(ProxyAddressConfigKey, &address); err != nil || address == "" {
+		return nil
+	}
+
+	proxy := &socks.Proxy{Addr: address}
+	readConfigValue(ProxyUsernameConfigKey, &proxy.Username)
+	readConfigValue(ProxyPasswordConfigKey, &proxy.Password)
+	readConfigValue(ProxyStreamIsolationConfigKey, &proxy.TorIsolation)
+	return proxy
+}
+
+// proxyDialFunc returns a dial function that connects through the proxy or
+// directly if the proxy is nil.
+func proxyDialFunc(proxy *socks.Proxy) p2p.DialFunc {
+	if proxy == nil {
+		var dialer net.Dialer
+		return dialer.DialContext
+	}
+	return proxy.DialContext
+}
+
+// proxyLookupFunc returns the function used to resolve host names to IPs.
+// When a proxy is set, hosts are resolved with the Tor RESOLVE extension so
+// that lookups never leave the proxy. Lookups fail if the proxy does not
+// support the extension or does not answer within proxyLookupTimeout.
+func proxyLookupFunc(proxy *socks.Proxy) func(host string) ([]net.IP, error) {
+	if proxy == nil {
+		return net.LookupIP
+	}
+
+	return func(host string) ([]net.IP, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), proxyLookupTimeout)
+		defer cancel()
+		return connmgr.TorLookupIP(ctx, host, proxy.Addr)
+	}
+}
+
+// proxyHTTPClient returns an http client that connects through the proxy or
+// the default http client if the proxy is nil. Host names are sent to the
+// proxy to be resolved.
+func proxyHTTPClient(proxy *socks.Proxy) *http.Client {
+	if proxy == nil {
+		return http.DefaultClient
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: proxy.DialContext,
+		},
+	}
+}
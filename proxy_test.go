@@ -0,0 +1,201 @@
+package dcrlibwallet
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/decred/go-socks/socks"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// socksStandIn is a minimal SOCKS5 server that supports the CONNECT command
+// with or without username/password authentication.
+type socksStandIn struct {
+	listener    net.Listener
+	connections int32
+}
+
+func newSocksStandIn() *socksStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+
+	server := &socksStandIn{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *socksStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// greeting: version, number of methods, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	method := byte(0x00)
+	for _, m := range methods {
+		if m == 0x02 {
+			method = m
+		}
+	}
+	conn.Write([]byte{0x05, method})
+
+	if method == 0x02 {
+		// username/password sub-negotiation, any credentials are accepted
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		username := make([]byte, buf[1])
+		io.ReadFull(conn, username)
+		passLen := make([]byte, 1)
+		io.ReadFull(conn, passLen)
+		password := make([]byte, passLen[0])
+		io.ReadFull(conn, password)
+		conn.Write([]byte{0x01, 0x00})
+	}
+
+	// request: version, command, reserved, address type
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	if request[1] != 0x01 {
+		// only CONNECT is supported
+		conn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	var host string
+	switch request[3] {
+	case 0x01:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 0x03:
+		hostLen := make([]byte, 1)
+		io.ReadFull(conn, hostLen)
+		hostBytes := make([]byte, hostLen[0])
+		io.ReadFull(conn, hostBytes)
+		host = string(hostBytes)
+	default:
+		return
+	}
+	portBytes := make([]byte, 2)
+	io.ReadFull(conn, portBytes)
+	port := binary.BigEndian.Uint16(portBytes)
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+
+	atomic.AddInt32(&server.connections, 1)
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+var _ = Describe("Proxy", func() {
+	var server *socksStandIn
+	var proxy *socks.Proxy
+
+	BeforeEach(func() {
+		server = newSocksStandIn()
+		proxy = &socks.Proxy{Addr: server.listener.Addr().String()}
+	})
+
+	AfterEach(func() {
+		server.listener.Close()
+	})
+
+	It("sends http requests through the proxy", func() {
+		httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "proxied")
+		}))
+		defer httpServer.Close()
+
+		for _, isolation := range []bool{false, true} {
+			proxy.TorIsolation = isolation
+			res, err := proxyHTTPClient(proxy).Get(httpServer.URL)
+			Expect(err).To(BeNil())
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			Expect(err).To(BeNil())
+			Expect(string(body)).To(Equal("proxied"))
+		}
+
+		Expect(atomic.LoadInt32(&server.connections)).To(Equal(int32(2)))
+	})
+
+	It("sends legacy VSP requests through the proxy of the multiwallet", func() {
+		httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status":"success","data":{"PoolFees":7.5,"Script":"00","TicketAddress":"address"}}`)
+		}))
+		defer httpServer.Close()
+
+		mw, _, cleanup := createTestMultiWallet("simnet")
+		defer cleanup()
+		Expect(mw.SetSocksProxy(proxy.Addr, "", "", false)).To(Succeed())
+
+		info, err := mw.CallVSPTicketInfoAPI(httpServer.URL, "pubkeyaddr")
+		Expect(err).To(BeNil())
+		Expect(info.PoolFees).To(Equal(7.5))
+		Expect(atomic.LoadInt32(&server.connections)).To(Equal(int32(1)))
+	})
+
+	It("dials peers through the proxy", func() {
+		peer, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer peer.Close()
+
+		go func() {
+			conn, err := peer.Accept()
+			if err == nil {
+				conn.Write([]byte("version"))
+				conn.Close()
+			}
+		}()
+
+		conn, err := proxyDialFunc(proxy)(context.Background(), "tcp", peer.Addr().String())
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		msg, err := ioutil.ReadAll(conn)
+		Expect(err).To(BeNil())
+		Expect(string(msg)).To(Equal("version"))
+		Expect(atomic.LoadInt32(&server.connections)).To(Equal(int32(1)))
+	})
+
+	It("does not resolve hosts outside the proxy", func() {
+		_, err := proxyLookupFunc(proxy)("localhost")
+		Expect(err).ToNot(BeNil())
+		Expect(atomic.LoadInt32(&server.connections)).To(Equal(int32(0)))
+	})
+})
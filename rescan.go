@@ -2,117 +2,405 @@ package dcrlibwallet
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"math"
+	"sort"
 	"time"
 
 	"decred.org/dcrwallet/errors"
 	w "decred.org/dcrwallet/wallet"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
 )
 
 func (mw *MultiWallet) RescanBlocks(walletID int) error {
+	return mw.RescanBlocksFromHeight(walletID, 0)
+}
 
+// RescanBlocksFromDate rescans the blocks of the main chain starting from the
+// first block mined at or after the provided unix timestamp. The height of
+// that block is looked up in the headers saved by the wallet.
+func (mw *MultiWallet) RescanBlocksFromDate(walletID int, timestamp int64) error {
 	wallet := mw.WalletWithID(walletID)
 	if wallet == nil {
 		return errors.E(ErrNotExist)
 	}
 
-	netBackend, err := wallet.internal.NetworkBackend()
+	startHeight, err := wallet.blockHeightAtTime(timestamp)
 	if err != nil {
-		return errors.E(ErrNotConnected)
+		return err
 	}
 
-	if mw.IsRescanning() || !mw.IsSynced() {
+	return mw.RescanBlocksFromHeight(walletID, startHeight)
+}
+
+// RescanRequest is used with storm for saving the queued rescans to the
+// multiwallet db so that they resume after a restart. ScannedThrough is the
+// height that the rescan has completed through, a resumed rescan continues
+// from the next block. Running is only set in the rescan queue returned by
+// RescanQueueRaw.
+type RescanRequest struct {
+	ID             int   `storm:"id,increment" json:"id"`
+	WalletID       int   `storm:"index" json:"wallet_id"`
+	StartHeight    int32 `json:"start_height"`
+	ScannedThrough int32 `json:"scanned_through"`
+	Running        bool  `json:"running"`
+	CreatedAt      int64 `json:"created_at"`
+}
+
+// RescanBlocksFromHeight queues a rescan of the blocks of the main chain
+// starting from startHeight for transactions relevant to the wallet. The
+// transactions of the rescanned blocks are reindexed after the rescan.
+// Rescanning from a recent height is much faster than a full rescan when the
+// wallet is only missing recent transactions, e.g. after importing a key.
+//
+// Queued rescans run one after another while the wallets are synced. If a
+// rescan is already queued for the wallet and is not running, its start
+// height is lowered instead of queueing another rescan. A lowered rescan that
+// was interrupted starts over from the new start height.
+func (mw *MultiWallet) RescanBlocksFromHeight(walletID int, startHeight int32) error {
+	wallet := mw.WalletWithID(walletID)
+	if wallet == nil {
+		return errors.E(ErrNotExist)
+	}
+
+	bestBlock := wallet.GetBestBlock()
+	if startHeight < 0 || startHeight > bestBlock {
 		return errors.E(ErrInvalid)
 	}
 
-	go func() {
-		defer func() {
-			mw.syncData.mu.Lock()
-			mw.syncData.rescanning = false
-			mw.syncData.cancelRescan = nil
-			mw.syncData.mu.Unlock()
-		}()
+	mw.syncData.mu.Lock()
+	runningRescanID := mw.syncData.runningRescanID
+	mw.syncData.mu.Unlock()
+
+	var queued []*RescanRequest
+	err := mw.db.Find("WalletID", walletID, &queued)
+	if err != nil && err != storm.ErrNotFound {
+		return translateError(err)
+	}
+
+	for _, request := range queued {
+		if request.ID == runningRescanID {
+			continue
+		}
+		if startHeight < request.StartHeight {
+			// An interrupted rescan resumes after the blocks it scanned,
+			// which would skip the blocks below its old start height.
+			request.StartHeight = startHeight
+			request.ScannedThrough = -1
+			if err = mw.db.Save(request); err != nil {
+				return translateError(err)
+			}
+		}
+		mw.startRescanQueue()
+		return nil
+	}
+
+	err = mw.db.Save(&RescanRequest{
+		WalletID:       walletID,
+		StartHeight:    startHeight,
+		ScannedThrough: -1,
+		CreatedAt:      time.Now().Unix(),
+	})
+	if err != nil {
+		return translateError(err)
+	}
+
+	mw.startRescanQueue()
+	return nil
+}
+
+func (mw *MultiWallet) RescanQueue() (string, error) {
+	queue, err := mw.RescanQueueRaw()
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedQueue, err := json.Marshal(&queue)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedQueue), nil
+}
+
+// RescanQueueRaw returns the queued rescans in the order they run, including
+// the running rescan. The progress of the running rescan is published with
+// BlocksRescanProgressEvent.
+func (mw *MultiWallet) RescanQueueRaw() ([]*RescanRequest, error) {
+	queue := make([]*RescanRequest, 0)
+	err := mw.db.Select(q.True()).OrderBy("ID").Find(&queue)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, translateError(err)
+	}
+
+	mw.syncData.mu.RLock()
+	defer mw.syncData.mu.RUnlock()
+	for _, request := range queue {
+		request.Running = request.ID == mw.syncData.runningRescanID
+	}
+
+	return queue, nil
+}
+
+// CancelQueuedRescan removes the rescan with the provided ID from the queue,
+// stopping it if it is running.
+func (mw *MultiWallet) CancelQueuedRescan(requestID int) error {
+	mw.syncData.mu.Lock()
+	if mw.syncData.cancelRescan != nil && requestID == mw.syncData.runningRescanID {
+		mw.syncData.rescanCanceled = true
+		mw.syncData.cancelRescan()
+		mw.syncData.mu.Unlock()
+
+		log.Infof("Rescan %d canceled.", requestID)
+		return nil
+	}
+	mw.syncData.mu.Unlock()
+
+	err := mw.db.DeleteStruct(&RescanRequest{ID: requestID})
+	if err == storm.ErrNotFound {
+		return errors.New(ErrNotExist)
+	}
+	return translateError(err)
+}
+
+// runRescanQueue runs the queued rescans in order until the queue is empty,
+// the wallets are no longer synced or the multiwallet is shut down. Rescans
+// that are interrupted stay queued and resume from the height they were
+// scanned through.
+func (mw *MultiWallet) runRescanQueue(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for {
+		request := mw.nextRescanRequest(ctx)
+		if request == nil {
+			return
+		}
+
+		wallet := mw.WalletWithID(request.WalletID)
+		if wallet == nil {
+			mw.deleteRescanRequest(request)
+			continue
+		}
 
-		ctx, cancel := wallet.shutdownContextWithCancel()
+		rescanCtx, cancel := context.WithCancel(ctx)
 
 		mw.syncData.mu.Lock()
-		mw.syncData.rescanning = true
 		mw.syncData.cancelRescan = cancel
+		mw.syncData.runningRescanID = request.ID
+		mw.syncData.rescanCanceled = false
 		mw.syncData.mu.Unlock()
 
-		if mw.blocksRescanProgressListener != nil {
-			mw.blocksRescanProgressListener.OnBlocksRescanStarted(walletID)
+		err := mw.rescanBlocks(rescanCtx, wallet, request)
+		cancel()
+		mw.syncDiagnostics.rescanEnded(wallet.ID, err)
+
+		mw.syncData.mu.Lock()
+		canceled := mw.syncData.rescanCanceled
+		mw.syncData.cancelRescan = nil
+		mw.syncData.runningRescanID = 0
+		mw.syncData.mu.Unlock()
+
+		if !canceled && (ctx.Err() != nil || !mw.IsSynced()) {
+			// The queue or the sync was stopped, resume later.
+			log.Infof("[%d] Rescan interrupted at height %d", wallet.ID, request.ScannedThrough)
+			continue
 		}
 
-		progress := make(chan w.RescanProgress, 1)
-		go wallet.internal.RescanProgressFromHeight(ctx, netBackend, 0, progress)
+		mw.deleteRescanRequest(request)
+		if canceled {
+			log.Info("Rescan canceled through context")
+			err = nil
+		} else if err != nil {
+			log.Error(err)
+			mw.syncDiagnostics.recordError(fmt.Sprintf("wallet %d", wallet.ID), err)
+		}
+		mw.publishEvent(Event{Kind: BlocksRescanEndedEvent, WalletID: wallet.ID, Err: err})
+	}
+}
 
-		rescanStartTime := time.Now().Unix()
+// nextRescanRequest returns the next queued rescan to run. Nil is returned
+// and the queue is marked as stopped if the queue is empty or ctx is canceled
+// or the wallets are no longer synced.
+func (mw *MultiWallet) nextRescanRequest(ctx context.Context) *RescanRequest {
+	mw.syncData.mu.Lock()
+	defer mw.syncData.mu.Unlock()
 
-		for p := range progress {
-			if p.Err != nil {
-				log.Error(p.Err)
-				if mw.blocksRescanProgressListener != nil {
-					mw.blocksRescanProgressListener.OnBlocksRescanEnded(walletID, p.Err)
-				}
-				return
-			}
+	// The queue is read while holding the lock so that rescans queued
+	// after it is found empty start the queue again.
+	if ctx.Err() == nil && mw.syncData.synced {
+		request := new(RescanRequest)
+		err := mw.db.Select(q.True()).OrderBy("ID").First(request)
+		if err == nil {
+			return request
+		}
+		if err != storm.ErrNotFound {
+			log.Errorf("Error reading rescan queue: %v", err)
+		}
+	}
 
-			rescanProgressReport := &HeadersRescanProgressReport{
-				CurrentRescanHeight: p.ScannedThrough,
-				TotalHeadersToScan:  wallet.GetBestBlock(),
-				WalletID:            walletID,
-			}
+	mw.syncData.rescanning = false
+	mw.syncData.stopRescans = nil
+	return nil
+}
 
-			elapsedRescanTime := time.Now().Unix() - rescanStartTime
-			rescanRate := float64(p.ScannedThrough) / float64(rescanProgressReport.TotalHeadersToScan)
+// rescanBlocks runs the rescan of request and reindexes the rescanned
+// transactions, saving the progress of the rescan to the multiwallet db.
+func (mw *MultiWallet) rescanBlocks(ctx context.Context, wallet *Wallet, request *RescanRequest) error {
+	netBackend, err := wallet.internal.NetworkBackend()
+	if err != nil {
+		return errors.E(ErrNotConnected)
+	}
 
-			rescanProgressReport.RescanProgress = int32(math.Round(rescanRate * 100))
-			estimatedTotalRescanTime := int64(math.Round(float64(elapsedRescanTime) / rescanRate))
-			rescanProgressReport.RescanTimeRemaining = estimatedTotalRescanTime - elapsedRescanTime
+	mw.publishEvent(Event{Kind: BlocksRescanStartedEvent, WalletID: wallet.ID})
 
-			rescanProgressReport.GeneralSyncProgress = &GeneralSyncProgress{
-				TotalSyncProgress:         rescanProgressReport.RescanProgress,
-				TotalTimeRemainingSeconds: rescanProgressReport.RescanTimeRemaining,
-			}
+	bestBlock := wallet.GetBestBlock()
+	resumeHeight := request.StartHeight
+	if request.ScannedThrough >= resumeHeight {
+		resumeHeight = request.ScannedThrough + 1
+	}
+	if resumeHeight > bestBlock {
+		resumeHeight = bestBlock
+	}
 
-			if mw.blocksRescanProgressListener != nil {
-				mw.blocksRescanProgressListener.OnBlocksRescanProgress(rescanProgressReport)
-			}
+	mw.syncDiagnostics.rescanStarted(wallet.ID, resumeHeight, true)
 
-			select {
-			case <-ctx.Done():
-				log.Info("Rescan canceled through context")
-
-				if mw.blocksRescanProgressListener != nil {
-					if ctx.Err() != nil && ctx.Err() != context.Canceled {
-						mw.blocksRescanProgressListener.OnBlocksRescanEnded(walletID, ctx.Err())
-					} else {
-						mw.blocksRescanProgressListener.OnBlocksRescanEnded(walletID, nil)
-					}
-				}
-
-				return
-			default:
-				continue
-			}
+	progress := make(chan w.RescanProgress, 1)
+	go wallet.internal.RescanProgressFromHeight(ctx, netBackend, resumeHeight, progress)
+
+	rescanStartTime := time.Now().Unix()
+
+	for p := range progress {
+		if p.Err != nil {
+			return p.Err
 		}
 
-		err := wallet.reindexTransactions()
-		if mw.blocksRescanProgressListener != nil {
-			mw.blocksRescanProgressListener.OnBlocksRescanEnded(walletID, err)
+		request.ScannedThrough = p.ScannedThrough
+		mw.syncDiagnostics.rescanProgress(wallet.ID, p.ScannedThrough)
+		if err := mw.db.UpdateField(request, "ScannedThrough", p.ScannedThrough); err != nil {
+			log.Errorf("[%d] Error saving rescan progress: %v", wallet.ID, err)
 		}
-	}()
 
-	return nil
+		rescanProgressReport := &HeadersRescanProgressReport{
+			CurrentRescanHeight: p.ScannedThrough,
+			TotalHeadersToScan:  wallet.GetBestBlock(),
+			WalletID:            wallet.ID,
+		}
+
+		elapsedRescanTime := time.Now().Unix() - rescanStartTime
+		rescanRate := float64(1)
+		if totalHeadersToScan := rescanProgressReport.TotalHeadersToScan - resumeHeight; totalHeadersToScan > 0 {
+			rescanRate = float64(p.ScannedThrough-resumeHeight) / float64(totalHeadersToScan)
+		}
+
+		rescanProgressReport.RescanProgress = int32(math.Round(rescanRate * 100))
+		estimatedTotalRescanTime := int64(math.Round(float64(elapsedRescanTime) / rescanRate))
+		rescanProgressReport.RescanTimeRemaining = estimatedTotalRescanTime - elapsedRescanTime
+
+		rescanProgressReport.GeneralSyncProgress = &GeneralSyncProgress{
+			TotalSyncProgress:         rescanProgressReport.RescanProgress,
+			TotalTimeRemainingSeconds: rescanProgressReport.RescanTimeRemaining,
+		}
+
+		mw.publishEvent(Event{
+			Kind:                  BlocksRescanProgressEvent,
+			WalletID:              wallet.ID,
+			HeadersRescanProgress: rescanProgressReport,
+		})
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Transactions are only added by a rescan, so a partial rescan only
+	// requires the rescanned blocks to be reindexed.
+	if request.StartHeight == 0 {
+		return wallet.reindexTransactions()
+	}
+	return wallet.indexTransactionsFrom(request.StartHeight)
 }
 
+// startRescanQueue starts running the queued rescans if the wallets are
+// synced and the queue is not already running.
+func (mw *MultiWallet) startRescanQueue() {
+	mw.syncData.mu.Lock()
+	defer mw.syncData.mu.Unlock()
+
+	if mw.syncData.rescanning || !mw.syncData.synced {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	mw.syncData.rescanning = true
+	mw.syncData.stopRescans = func() {
+		cancel()
+		<-done
+	}
+	go mw.runRescanQueue(ctx, done)
+}
+
+// isRescanningWallet returns true if the running rescan is a rescan of the
+// wallet.
+func (mw *MultiWallet) isRescanningWallet(walletID int) bool {
+	mw.syncData.mu.RLock()
+	runningRescanID := mw.syncData.runningRescanID
+	mw.syncData.mu.RUnlock()
+
+	if runningRescanID == 0 {
+		return false
+	}
+
+	var request RescanRequest
+	err := mw.db.One("ID", runningRescanID, &request)
+	return err == nil && request.WalletID == walletID
+}
+
+// stopRescanQueue stops the running rescan without removing it from the queue
+// and waits for the queue to stop.
+func (mw *MultiWallet) stopRescanQueue() {
+	mw.syncData.mu.RLock()
+	stopRescans := mw.syncData.stopRescans
+	mw.syncData.mu.RUnlock()
+
+	if stopRescans != nil {
+		stopRescans()
+	}
+}
+
+func (mw *MultiWallet) deleteRescanRequest(request *RescanRequest) {
+	err := mw.db.DeleteStruct(request)
+	if err != nil && err != storm.ErrNotFound {
+		log.Errorf("[%d] Error deleting rescan request: %v", request.WalletID, err)
+	}
+}
+
+func (mw *MultiWallet) deleteRescanRequests(walletID int) error {
+	err := mw.db.Select(q.Eq("WalletID", walletID)).Delete(&RescanRequest{})
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// CancelRescan stops the running rescan and removes all queued rescans.
 func (mw *MultiWallet) CancelRescan() {
 	mw.syncData.mu.Lock()
 	defer mw.syncData.mu.Unlock()
+
+	err := mw.db.Select(q.True()).Delete(&RescanRequest{})
+	if err != nil && err != storm.ErrNotFound {
+		log.Errorf("Error clearing rescan queue: %v", err)
+	}
+
 	if mw.syncData.cancelRescan != nil {
+		mw.syncData.rescanCanceled = true
 		mw.syncData.cancelRescan()
-		mw.syncData.cancelRescan = nil
 
 		log.Info("Rescan canceled.")
 	}
@@ -127,3 +415,34 @@ func (mw *MultiWallet) IsRescanning() bool {
 func (mw *MultiWallet) SetBlocksRescanProgressListener(blocksRescanProgressListener BlocksRescanProgressListener) {
 	mw.blocksRescanProgressListener = blocksRescanProgressListener
 }
+
+// blockHeightAtTime returns the height of the first main chain block with a
+// timestamp at or after the provided unix timestamp. Block timestamps only
+// increase approximately, so the block is found by a binary search over the
+// saved headers.
+func (wallet *Wallet) blockHeightAtTime(timestamp int64) (int32, error) {
+	ctx := wallet.shutdownContext()
+	_, bestBlock := wallet.internal.MainChainTip(ctx)
+
+	var searchErr error
+	height := sort.Search(int(bestBlock)+1, func(height int) bool {
+		if searchErr != nil {
+			return true
+		}
+		info, err := wallet.internal.BlockInfo(ctx, w.NewBlockIdentifierFromHeight(int32(height)))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return info.Timestamp >= timestamp
+	})
+	if searchErr != nil {
+		return 0, searchErr
+	}
+	if height > int(bestBlock) {
+		// All blocks were mined before the timestamp.
+		return 0, errors.E(ErrInvalid)
+	}
+
+	return int32(height), nil
+}
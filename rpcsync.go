@@ -0,0 +1,316 @@
+package dcrlibwallet
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"decred.org/dcrwallet/chain"
+	"decred.org/dcrwallet/errors"
+	"github.com/planetdecred/dcrlibwallet/utils"
+)
+
+// rpcSyncRetryDelay is the time to wait before reconnecting the syncer of a
+// wallet that failed.
+const rpcSyncRetryDelay = 5 * time.Second
+
+// RpcSync connects all opened wallets to the dcrd JSON-RPC server at host
+// and syncs them using the server as the wallets' network backend instead
+// of the SPV peer network. The cert is the PEM encoded TLS certificate of
+// the server, the system CAs are used to verify the server if it is empty.
+// Sync progress is reported to the sync progress listeners using the same
+// stages as SpvSync. The server is reported as the only peer of the sync in
+// the sync diagnostics.
+//
+// Each wallet is synced by a separate syncer. A syncer that fails is
+// reconnected after a delay without stopping the syncers of the other
+// wallets. The sync only ends with an error if a syncer fails before any
+// syncer connected to the server, as that is caused by an invalid host,
+// certificate or credentials.
+func (mw *MultiWallet) RpcSync(host, user, pass string, cert []byte) error {
+	if host == "" {
+		return errors.New(ErrInvalid)
+	}
+
+	return mw.rpcSync(&chain.RPCOptions{
+		Address:     host,
+		DefaultPort: utils.DefaultRPCPort(mw.chainParams),
+		User:        user,
+		Pass:        pass,
+		CA:          cert,
+	})
+}
+
+// RestartRpcSync cancels the current sync and reconnects to the dcrd
+// JSON-RPC server used by the last call to RpcSync.
+func (mw *MultiWallet) RestartRpcSync() error {
+	mw.syncData.mu.Lock()
+	rpcOptions := mw.syncData.rpcOptions
+	if rpcOptions != nil {
+		mw.syncData.restartSyncRequested = true
+	}
+	mw.syncData.mu.Unlock()
+
+	if rpcOptions == nil {
+		return errors.New(ErrInvalid)
+	}
+
+	mw.CancelSync() // a new sync cannot start until the current syncers return.
+	return mw.rpcSync(rpcOptions)
+}
+
+func (mw *MultiWallet) rpcSync(rpcOptions *chain.RPCOptions) error {
+	// prevent an attempt to sync when the previous syncing has not been canceled
+	if mw.IsSyncing() || mw.IsSynced() {
+		return errors.New(ErrSyncAlreadyInProgress)
+	}
+
+	if mw.SyncableWalletsCount() == 0 {
+		return errors.New(ErrWalletNotLoaded)
+	}
+
+	// init activeSyncData to be used to hold data used
+	// to calculate sync estimates only during sync
+	mw.initActiveSyncData()
+
+	ctx, cancel := mw.contextWithShutdownCancel()
+
+	var restartSyncRequested bool
+
+	mw.syncData.mu.Lock()
+	restartSyncRequested = mw.syncData.restartSyncRequested
+	mw.syncData.restartSyncRequested = false
+	mw.syncData.syncing = true
+	mw.syncData.cancelSync = cancel
+	mw.syncData.syncCanceled = make(chan struct{})
+	mw.syncData.rpcOptions = rpcOptions
+	mw.syncData.mu.Unlock()
+
+	mw.syncDiagnostics.syncStarted(restartSyncRequested)
+	mw.publishEvent(Event{Kind: SyncStartedEvent, Restart: restartSyncRequested})
+
+	var syncers sync.WaitGroup
+	var syncErrOnce sync.Once
+	var syncError error
+	progress := newRPCSyncProgress()
+	for _, wallet := range mw.wallets {
+		if !wallet.syncable() {
+			continue
+		}
+
+		wallet.waiting = true
+		wallet.syncing = true
+		progress.addWallet(wallet.ID)
+
+		syncers.Add(1)
+		go func(wallet *Wallet) {
+			defer syncers.Done()
+			err := mw.runRPCSyncer(ctx, wallet, rpcOptions, progress)
+			if err != nil {
+				syncErrOnce.Do(func() {
+					syncError = err
+					cancel()
+				})
+			}
+		}(wallet)
+	}
+
+	go func() {
+		syncers.Wait()
+		mw.handlePeerCountUpdate(0)
+		mw.syncDiagnostics.syncEnded(syncError)
+
+		//sync has ended or errored
+		if syncError != nil {
+			mw.notifySyncError(syncError)
+		} else {
+			mw.notifySyncCanceled()
+		}
+		close(mw.syncData.syncCanceled)
+
+		//reset sync variables
+		mw.resetSyncData()
+	}()
+	return nil
+}
+
+// runRPCSyncer syncs the wallet until ctx is canceled, reconnecting the
+// syncer after rpcSyncRetryDelay if it fails. An error is returned if the
+// syncer fails before any syncer connected to the server.
+func (mw *MultiWallet) runRPCSyncer(ctx context.Context, wallet *Wallet, rpcOptions *chain.RPCOptions, progress *rpcSyncProgress) error {
+	for {
+		syncer := chain.NewSyncer(wallet.internal, rpcOptions)
+		syncer.SetCallbacks(mw.rpcSyncCallbacks(wallet, rpcOptions, progress))
+		err := syncer.Run(ctx)
+		if progress.disconnect(wallet.ID) {
+			mw.syncDiagnostics.peerDisconnected(rpcOptions.Address)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !progress.everConnected() {
+			return err
+		}
+
+		mw.syncDiagnostics.peerError(rpcOptions.Address, err)
+		log.Errorf("[%d] RPC sync failed, reconnecting in %v: %v", wallet.ID, rpcSyncRetryDelay, err)
+		mw.handlePeerCountUpdate(progress.connectedCount())
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(rpcSyncRetryDelay):
+		}
+	}
+}
+
+// rpcSyncProgress combines the progress of the syncers of the wallets. The
+// RPC syncer does not report peers, a syncer is counted as a connected peer
+// once it starts fetching data from the server. The headers fetch progress
+// is reported at the lowest header height of the wallets, and the stage
+// ends once the syncers of all wallets fetched their headers.
+type rpcSyncProgress struct {
+	mu              sync.Mutex
+	connected       map[int]bool
+	ranConnected    bool
+	headerHeights   map[int]int32
+	headerTimes     map[int]int64
+	headersFinished map[int]bool
+}
+
+func newRPCSyncProgress() *rpcSyncProgress {
+	return &rpcSyncProgress{
+		connected:       make(map[int]bool),
+		headerHeights:   make(map[int]int32),
+		headerTimes:     make(map[int]int64),
+		headersFinished: make(map[int]bool),
+	}
+}
+
+func (progress *rpcSyncProgress) addWallet(walletID int) {
+	progress.mu.Lock()
+	progress.headersFinished[walletID] = false
+	progress.mu.Unlock()
+}
+
+// connect marks the syncer of the wallet as connected and returns the number
+// of connected syncers.
+func (progress *rpcSyncProgress) connect(walletID int) int32 {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	progress.connected[walletID] = true
+	progress.ranConnected = true
+	return int32(len(progress.connected))
+}
+
+// disconnect marks the syncer of the wallet as disconnected and returns true
+// if it was the last connected syncer.
+func (progress *rpcSyncProgress) disconnect(walletID int) bool {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if !progress.connected[walletID] {
+		return false
+	}
+	delete(progress.connected, walletID)
+	return len(progress.connected) == 0
+}
+
+func (progress *rpcSyncProgress) connectedCount() int32 {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	return int32(len(progress.connected))
+}
+
+func (progress *rpcSyncProgress) everConnected() bool {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	return progress.ranConnected
+}
+
+// headersFetched records the header height and time of the wallet and
+// returns the lowest header height of the wallets with its time.
+func (progress *rpcSyncProgress) headersFetched(walletID int, height int32, headerTime int64) (int32, int64) {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+
+	progress.headerHeights[walletID] = height
+	progress.headerTimes[walletID] = headerTime
+
+	lowestWalletID := walletID
+	for id, height := range progress.headerHeights {
+		if height < progress.headerHeights[lowestWalletID] {
+			lowestWalletID = id
+		}
+	}
+	return progress.headerHeights[lowestWalletID], progress.headerTimes[lowestWalletID]
+}
+
+// finishHeaders marks the headers of the wallet as fetched and returns true
+// if the headers of all wallets are fetched.
+func (progress *rpcSyncProgress) finishHeaders(walletID int) bool {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+
+	progress.headersFinished[walletID] = true
+	for _, finished := range progress.headersFinished {
+		if !finished {
+			return false
+		}
+	}
+	return true
+}
+
+// rpcSyncCallbacks adapts the callbacks of the wallet's RPC syncer to the
+// sync progress callbacks used for SPV sync.
+func (mw *MultiWallet) rpcSyncCallbacks(wallet *Wallet, rpcOptions *chain.RPCOptions, progress *rpcSyncProgress) *chain.Callbacks {
+	// The RPC syncer reports the number of headers fetched in each batch
+	// rather than the height of the last header.
+	var lastHeaderHeight int32
+
+	return &chain.Callbacks{
+		Synced: func(synced bool) {
+			mw.synced(wallet.ID, synced)
+		},
+		FetchMissingCFiltersStarted: func() {
+			connectedSyncers := progress.connect(wallet.ID)
+			if connectedSyncers == 1 {
+				mw.syncDiagnostics.peerConnected(rpcOptions.Address)
+			}
+			mw.handlePeerCountUpdate(connectedSyncers)
+		},
+		FetchMissingCFiltersProgress: func(missingCFitlersStart, missingCFitlersEnd int32) {
+			mw.syncDiagnostics.cfiltersReceived(rpcOptions.Address, int(missingCFitlersEnd-missingCFitlersStart+1))
+		},
+		FetchMissingCFiltersFinished: func() {},
+		FetchHeadersStarted: func() {
+			lastHeaderHeight = wallet.GetBestBlock()
+			progress.headersFetched(wallet.ID, lastHeaderHeight, wallet.GetBestBlockTimeStamp())
+			mw.fetchHeadersStarted(lastHeaderHeight + mw.estimateBlockHeadersCountAfter(wallet.GetBestBlockTimeStamp()))
+		},
+		FetchHeadersProgress: func(fetchedHeadersCount int32, lastHeaderTime int64) {
+			lastHeaderHeight += fetchedHeadersCount
+			mw.syncDiagnostics.headersReceived(rpcOptions.Address, int(fetchedHeadersCount))
+			mw.fetchHeadersProgress(progress.headersFetched(wallet.ID, lastHeaderHeight, lastHeaderTime))
+		},
+		FetchHeadersFinished: func() {
+			if progress.finishHeaders(wallet.ID) {
+				mw.fetchHeadersFinished()
+			}
+		},
+		DiscoverAddressesStarted: func() {
+			mw.discoverAddressesStarted(wallet.ID)
+		},
+		DiscoverAddressesFinished: func() {
+			mw.discoverAddressesFinished(wallet.ID)
+		},
+		RescanStarted: func() {
+			mw.rescanStarted(wallet.ID)
+		},
+		RescanProgress: func(rescannedThrough int32) {
+			mw.rescanProgress(wallet.ID, rescannedThrough)
+		},
+		RescanFinished: func() {
+			mw.rescanFinished(wallet.ID)
+		},
+	}
+}
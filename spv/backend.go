@@ -10,7 +10,6 @@ import (
 	"sync"
 
 	"decred.org/dcrwallet/errors"
-	"decred.org/dcrwallet/p2p"
 	"decred.org/dcrwallet/validate"
 	"decred.org/dcrwallet/wallet"
 	"github.com/decred/dcrd/chaincfg/chainhash"
@@ -19,6 +18,7 @@ import (
 	"github.com/decred/dcrd/gcs/v2/blockcf2"
 	"github.com/decred/dcrd/txscript/v3"
 	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/p2p"
 )
 
 var _ wallet.NetworkBackend = (*WalletBackend)(nil)
@@ -63,12 +63,26 @@ type filterProof = struct {
 }
 
 // CFiltersV2 implements the CFiltersV2 method of the wallet.Peer interface.
+//
+// In strict mode, the filters are validated against the header commitments of
+// the blocks.  Peers that serve invalid filters are reported and not asked
+// again, and the filters are refetched from another peer.
 func (wb *WalletBackend) CFiltersV2(ctx context.Context, blockHashes []*chainhash.Hash) ([]filterProof, error) {
+	pick := pickAny
+	var invalidPeers map[*p2p.RemotePeer]struct{}
+	if wb.strictCFilters {
+		invalidPeers = make(map[*p2p.RemotePeer]struct{})
+		pick = func(rp *p2p.RemotePeer) bool {
+			_, invalid := invalidPeers[rp]
+			return !invalid
+		}
+	}
+
 	for {
 		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
-		rp, err := wb.pickRemote(pickAny)
+		rp, err := wb.pickRemote(pick)
 		if err != nil {
 			return nil, err
 		}
@@ -76,6 +90,18 @@ func (wb *WalletBackend) CFiltersV2(ctx context.Context, blockHashes []*chainhas
 		if err != nil {
 			continue
 		}
+		wb.cfiltersReceived(rp, len(fs))
+		if wb.strictCFilters {
+			err = wb.validateCFilters(ctx, blockHashes, fs)
+			if errors.Is(err, errors.Consensus) {
+				invalidPeers[rp] = struct{}{}
+				wb.invalidCFilters(rp, true, err)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
 		return fs, nil
 	}
 }
@@ -94,6 +120,7 @@ func (wb *WalletBackend) Headers(ctx context.Context, blockLocators []*chainhash
 		if err != nil {
 			continue
 		}
+		wb.headersReceived(rp, len(hs))
 		return hs, nil
 	}
 }
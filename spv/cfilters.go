@@ -0,0 +1,73 @@
+// Copyright (c) 2021 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"context"
+
+	"decred.org/dcrwallet/errors"
+	"decred.org/dcrwallet/validate"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/planetdecred/dcrlibwallet/p2p"
+)
+
+// SetStrictCFilterValidation sets whether the cfilters returned to the
+// wallets by the CFiltersV2 method of the wallet backends are validated
+// against the header commitments of the blocks.  In strict mode, filters from
+// peers that serve invalid inclusion proofs are dropped and refetched from
+// another peer.  This must be called before Run.
+func (s *Syncer) SetStrictCFilterValidation(strict bool) {
+	s.strictCFilters = strict
+}
+
+func (s *Syncer) cfiltersValidated(count int) {
+	if s.notifications != nil && s.notifications.CFiltersValidated != nil {
+		s.notifications.CFiltersValidated(count)
+	}
+}
+
+// invalidCFilters reports that rp served cfilters with invalid inclusion
+// proofs.  refetching is set if the filters are requested again from
+// another peer.
+func (s *Syncer) invalidCFilters(rp *p2p.RemotePeer, refetching bool, err error) {
+	log.Warnf("Peer %v served invalid cfilters: %v", rp, err)
+	if s.notifications != nil && s.notifications.InvalidCFilters != nil {
+		s.notifications.InvalidCFilters(rp.String(), refetching)
+	}
+}
+
+// validateCFilters validates the cfilters fetched for the wallet against
+// the header commitments of the blocks.  Filters of blocks whose header is
+// not saved by the wallet yet are skipped, those are validated by the caller
+// against the headers that it fetched.
+func (wb *WalletBackend) validateCFilters(ctx context.Context, blockHashes []*chainhash.Hash, filters []filterProof) error {
+	wb.walletsMu.RLock()
+	w := wb.wallets[wb.WalletID]
+	wb.walletsMu.RUnlock()
+	if w == nil {
+		return errors.E(errors.NotExist, "wallet is not synced")
+	}
+
+	cnet := w.ChainParams().Net
+	var validated int
+	for i, cf := range filters {
+		header, err := w.BlockHeader(ctx, blockHashes[i])
+		if errors.Is(err, errors.NotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = validate.CFilterV2HeaderCommitment(cnet, header, cf.Filter, cf.ProofIndex, cf.Proof)
+		if err != nil {
+			return err
+		}
+		validated++
+	}
+
+	wb.cfiltersValidated(validated)
+	return nil
+}
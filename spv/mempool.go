@@ -0,0 +1,113 @@
+// Copyright (c) 2021 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"context"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/p2p"
+)
+
+// PeersHaveTransaction asks every connected remote peer for the transaction
+// with the provided hash and reports whether any of them has it in its
+// mempool.  False is only returned if all peers replied that they do not have
+// the transaction; an error is returned if some peer did not reply and no
+// other peer has the transaction.
+func (s *Syncer) PeersHaveTransaction(ctx context.Context, txHash *chainhash.Hash) (bool, error) {
+	_, err := s.MempoolTransaction(ctx, txHash)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, errors.NotExist):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// MempoolTransaction asks every connected remote peer for the transaction
+// with the provided hash and returns the first reply.  An error with the
+// NotExist kind is returned if all peers replied that they do not have the
+// transaction in their mempool.
+func (s *Syncer) MempoolTransaction(ctx context.Context, txHash *chainhash.Hash) (*wire.MsgTx, error) {
+	remotes := s.remotePeers()
+	if len(remotes) == 0 {
+		return nil, errors.E(errors.NoPeers)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type reply struct {
+		tx  *wire.MsgTx
+		err error
+	}
+	replies := make(chan reply, len(remotes))
+	for _, rp := range remotes {
+		rp := rp
+		go func() {
+			txs, err := rp.Transactions(ctx, []*chainhash.Hash{txHash})
+			switch {
+			case err != nil:
+				replies <- reply{err: err}
+			case len(txs) != 1 || txs[0] == nil:
+				replies <- reply{err: p2p.ErrNotFound}
+			default:
+				replies <- reply{tx: txs[0]}
+			}
+		}()
+	}
+
+	var err error
+	for range remotes {
+		r := <-replies
+		if r.tx != nil {
+			return r.tx, nil
+		}
+		if r.err != nil && (err == nil || errors.Is(err, errors.NotExist)) {
+			err = r.err
+		}
+	}
+	return nil, err
+}
+
+// Block requests the block with the provided hash from the connected remote
+// peers, one peer at a time, until a peer returns it.  The block must be in
+// the main chain of the peers as peers are disconnected when they do not
+// have a requested block.
+func (s *Syncer) Block(ctx context.Context, blockHash *chainhash.Hash) (*wire.MsgBlock, error) {
+	remotes := s.remotePeers()
+	if len(remotes) == 0 {
+		return nil, errors.E(errors.NoPeers)
+	}
+
+	var err error
+	for _, rp := range remotes {
+		var block *wire.MsgBlock
+		block, err = rp.Block(ctx, blockHash)
+		if err == nil {
+			return block, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+// remotePeers returns a snapshot of the connected remote peers.
+func (s *Syncer) remotePeers() []*p2p.RemotePeer {
+	s.remotesMu.Lock()
+	defer s.remotesMu.Unlock()
+
+	remotes := make([]*p2p.RemotePeer, 0, len(s.remotes))
+	for _, rp := range s.remotes {
+		remotes = append(remotes, rp)
+	}
+	return remotes
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2021 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/planetdecred/dcrlibwallet/p2p"
+)
+
+var errBannedPeer = errors.E("peer is banned")
+
+// SetBannedPeers sets the hosts that must not be connected to until the
+// corresponding expiry time.  Persistent peers are connected to even if they
+// are banned.
+func (s *Syncer) SetBannedPeers(bans map[string]time.Time) {
+	s.remotesMu.Lock()
+	defer s.remotesMu.Unlock()
+
+	s.bannedHosts = make(map[string]time.Time, len(bans))
+	for host, until := range bans {
+		s.bannedHosts[host] = until
+	}
+}
+
+// BanPeer bans host until the provided time and disconnects from all
+// connected remote peers on that host.  Persistent peers ignore bans and
+// remain connected.
+func (s *Syncer) BanPeer(host string, until time.Time) {
+	s.remotesMu.Lock()
+	s.bannedHosts[host] = until
+	var banned []*p2p.RemotePeer
+	for k, rp := range s.remotes {
+		if _, ok := s.persistentRemotes[k]; ok {
+			continue
+		}
+		if rp.NA().IP.String() == host {
+			banned = append(banned, rp)
+		}
+	}
+	s.remotesMu.Unlock()
+
+	for _, rp := range banned {
+		log.Infof("Disconnecting banned peer %v", rp)
+		rp.Disconnect(errBannedPeer)
+	}
+}
+
+// UnbanPeer removes the ban of host.
+func (s *Syncer) UnbanPeer(host string) {
+	s.remotesMu.Lock()
+	delete(s.bannedHosts, host)
+	s.remotesMu.Unlock()
+}
+
+// DisconnectPeer disconnects the connected remote peer with the address
+// addr.  The peer may be reconnected to later unless it is also banned.
+func (s *Syncer) DisconnectPeer(addr string) error {
+	s.remotesMu.Lock()
+	rp, ok := s.remotes[addr]
+	s.remotesMu.Unlock()
+	if !ok {
+		return errors.E(errors.NotExist, "peer is not connected")
+	}
+
+	rp.Disconnect(errors.E("peer disconnected by user"))
+	return nil
+}
+
+// isBanned returns whether host is currently banned.  Expired bans are
+// removed.  s.remotesMu must be held.
+func (s *Syncer) isBanned(host string) bool {
+	until, ok := s.bannedHosts[host]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(s.bannedHosts, host)
+		return false
+	}
+	return true
+}
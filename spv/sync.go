@@ -15,7 +15,6 @@ import (
 
 	"decred.org/dcrwallet/errors"
 	"decred.org/dcrwallet/lru"
-	"decred.org/dcrwallet/p2p"
 	"decred.org/dcrwallet/validate"
 	"decred.org/dcrwallet/wallet"
 	"github.com/decred/dcrd/addrmgr"
@@ -23,6 +22,7 @@ import (
 	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrd/gcs/v2/blockcf2"
 	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/p2p"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -38,18 +38,28 @@ type Syncer struct {
 	atomicCatchUpTryLock uint32          // CAS (entered=1) to perform discovery/rescan
 	atomicWalletsSynced  map[int]*uint32 // CAS (synced=1) when wallet syncing complete
 
-	wallets map[int]*wallet.Wallet
-	lp      *p2p.LocalPeer
-
-	// Protected by atomicCatchUpTryLock
+	// wallets, atomicWalletsSynced and loadedFilters may be modified while
+	// the syncer is running and are protected by walletsMu.  runCtx is the
+	// context of the running syncer, nil if the syncer is not running.
+	wallets       map[int]*wallet.Wallet
 	loadedFilters map[int]bool
+	runCtx        context.Context
+	walletsMu     sync.RWMutex
+
+	lp *p2p.LocalPeer
 
 	persistentPeers []string
 
 	connectingRemotes map[string]struct{}
 	remotes           map[string]*p2p.RemotePeer
+	persistentRemotes map[string]struct{}
+	bannedHosts       map[string]time.Time // k=host v=ban expiry
 	remotesMu         sync.Mutex
 
+	// strictCFilters is set when the cfilters fetched by the wallet
+	// backends are validated against the block headers.
+	strictCFilters bool
+
 	// Data filters
 	//
 	// TODO: Replace precise rescan filter with wallet db accesses to avoid
@@ -98,6 +108,24 @@ type Notifications struct {
 	RescanProgress               func(walletID int, rescannedThrough int32)
 	RescanFinished               func(walletID int)
 
+	// PeerError is called with the error that the peer with address addr
+	// was disconnected for, or that the connection attempt failed with.
+	PeerError func(addr string, err error)
+
+	// HeadersReceived and CFiltersReceived are called with the number of
+	// block headers and cfilters received from the peer with address addr.
+	HeadersReceived  func(addr string, count int)
+	CFiltersReceived func(addr string, count int)
+
+	// CFiltersValidated is called with the number of cfilters whose
+	// inclusion proofs were validated against the block headers.
+	CFiltersValidated func(count int)
+
+	// InvalidCFilters is called when the peer with address addr served
+	// cfilters with invalid inclusion proofs.  refetching is set if the
+	// filters are requested again from another peer.
+	InvalidCFilters func(addr string, refetching bool)
+
 	// MempoolTxs is called whenever new relevant unmined transactions are
 	// observed and saved.
 	MempoolTxs func(walletID int, txs []*wire.MsgTx)
@@ -130,6 +158,8 @@ func NewSyncer(wallets map[int]*wallet.Wallet, lp *p2p.LocalPeer) *Syncer {
 		loadedFilters:       make(map[int]bool, len(wallets)),
 		connectingRemotes:   make(map[string]struct{}),
 		remotes:             make(map[string]*p2p.RemotePeer),
+		persistentRemotes:   make(map[string]struct{}),
+		bannedHosts:         make(map[string]time.Time),
 		rescanFilter:        rescanFilter,
 		filterData:          filterData,
 		seenTxs:             lru.NewCache(2000),
@@ -153,7 +183,8 @@ func (s *Syncer) SetNotifications(ntfns *Notifications) {
 // synced checks the atomic that controls wallet syncness and if previously
 // unsynced, updates to synced and notifies the callback, if set.
 func (s *Syncer) synced(walletID int) {
-	if atomic.CompareAndSwapUint32(s.atomicWalletsSynced[walletID], 0, 1) &&
+	walletSynced := s.walletSyncedFlag(walletID)
+	if walletSynced != nil && atomic.CompareAndSwapUint32(walletSynced, 0, 1) &&
 		s.notifications != nil &&
 		s.notifications.Synced != nil {
 		s.notifications.Synced(walletID, true)
@@ -175,7 +206,8 @@ func (s *Syncer) GetRemotePeers() map[string]*p2p.RemotePeer {
 // unsynced checks the atomic that controls wallet syncness and if previously
 // synced, updates to unsynced and notifies the callback, if set.
 func (s *Syncer) unsynced(walletID int) {
-	if atomic.CompareAndSwapUint32(s.atomicWalletsSynced[walletID], 1, 0) &&
+	walletSynced := s.walletSyncedFlag(walletID)
+	if walletSynced != nil && atomic.CompareAndSwapUint32(walletSynced, 1, 0) &&
 		s.notifications != nil &&
 		s.notifications.Synced != nil {
 		s.notifications.Synced(walletID, false)
@@ -196,6 +228,25 @@ func (s *Syncer) peerDisconnected(remotesCount int, addr string) {
 	}
 }
 
+// peerError notifies the error of a peer, if set.
+func (s *Syncer) peerError(addr string, err error) {
+	if s.notifications != nil && s.notifications.PeerError != nil {
+		s.notifications.PeerError(addr, err)
+	}
+}
+
+func (s *Syncer) headersReceived(rp *p2p.RemotePeer, count int) {
+	if s.notifications != nil && s.notifications.HeadersReceived != nil {
+		s.notifications.HeadersReceived(rp.String(), count)
+	}
+}
+
+func (s *Syncer) cfiltersReceived(rp *p2p.RemotePeer, count int) {
+	if s.notifications != nil && s.notifications.CFiltersReceived != nil {
+		s.notifications.CFiltersReceived(rp.String(), count)
+	}
+}
+
 func (s *Syncer) fetchMissingCfiltersStart(walletID int) {
 	if s.notifications != nil && s.notifications.FetchMissingCFiltersStarted != nil {
 		s.notifications.FetchMissingCFiltersStarted(walletID)
@@ -292,7 +343,7 @@ func (s *Syncer) lowestChainTip(ctx context.Context) (chainhash.Hash, int32, *wa
 	var lowestTip int32 = -1
 	var lowestTipHash chainhash.Hash
 	var lowestTipWallet *wallet.Wallet
-	for _, w := range s.wallets {
+	for _, w := range s.currentWallets() {
 		if hash, height := w.MainChainTip(ctx); height < lowestTip || lowestTip == -1 {
 			lowestTip = height
 			lowestTipHash = hash
@@ -307,7 +358,7 @@ func (s *Syncer) highestChainTip(ctx context.Context) (chainhash.Hash, int32, *w
 	var highestTip int32 = -1
 	var highestTipHash chainhash.Hash
 	var highestTipWallet *wallet.Wallet
-	for _, w := range s.wallets {
+	for _, w := range s.currentWallets() {
 		if hash, height := w.MainChainTip(ctx); height > highestTip || highestTip == -1 {
 			highestTip = height
 			highestTipHash = hash
@@ -321,10 +372,11 @@ func (s *Syncer) highestChainTip(ctx context.Context) (chainhash.Hash, int32, *w
 // Run synchronizes the wallet, returning when synchronization fails or the
 // context is cancelled.
 func (s *Syncer) Run(ctx context.Context) error {
-	log.Infof("Syncing %d wallets", len(s.wallets))
+	wallets := s.currentWallets()
+	log.Infof("Syncing %d wallets", len(wallets))
 
 	var highestTipHeight int32
-	for id, w := range s.wallets {
+	for id, w := range wallets {
 		tipHash, tipHeight := w.MainChainTip(ctx)
 		log.Infof("[%d] Headers synced through block %v height %d", id, &tipHash, tipHeight)
 
@@ -388,6 +440,9 @@ func (s *Syncer) Run(ctx context.Context) error {
 
 	g.Go(func() error { return s.handleMempool(ctx) })
 
+	// Wallets added from now on are set up and caught up by AddWallet.
+	s.walletsMu.Lock()
+	s.runCtx = ctx
 	for walletID, w := range s.wallets {
 		walletBackend := &WalletBackend{
 			Syncer:   s,
@@ -395,8 +450,17 @@ func (s *Syncer) Run(ctx context.Context) error {
 		}
 
 		w.SetNetworkBackend(walletBackend)
-		defer w.SetNetworkBackend(nil)
 	}
+	s.walletsMu.Unlock()
+
+	defer func() {
+		s.walletsMu.Lock()
+		s.runCtx = nil
+		for _, w := range s.wallets {
+			w.SetNetworkBackend(nil)
+		}
+		s.walletsMu.Unlock()
+	}()
 
 	// Wait until cancellation or a handler errors.
 	return g.Wait()
@@ -419,12 +483,16 @@ func (s *Syncer) peerCandidate(svcs wire.ServiceFlag) (*wire.NetAddress, error)
 		s.remotesMu.Lock()
 		_, isConnecting := s.connectingRemotes[k]
 		_, isRemote := s.remotes[k]
+		isBanned := s.isBanned(na.IP.String())
 
 		switch {
 		// Skip peer if already connected, or in process of connecting
 		// TODO: this should work with network blocks, not exact addresses.
 		case isConnecting || isRemote:
 			fallthrough
+		// Never connect to banned peers.
+		case isBanned:
+			fallthrough
 		// Only allow recent nodes (10mins) after we failed 30 times
 		case tries < 30 && time.Since(kaddr.LastAttempt()) < 10*time.Minute:
 			fallthrough
@@ -452,6 +520,7 @@ func (s *Syncer) connectToPersistent(ctx context.Context, raddr string) error {
 			if err != nil {
 				if ctx.Err() == nil {
 					log.Errorf("Peering attempt failed: %v", err)
+					s.peerError(raddr, err)
 				}
 				return
 			}
@@ -460,6 +529,7 @@ func (s *Syncer) connectToPersistent(ctx context.Context, raddr string) error {
 			k := addrmgr.NetAddressKey(rp.NA())
 			s.remotesMu.Lock()
 			s.remotes[k] = rp
+			s.persistentRemotes[k] = struct{}{}
 			n := len(s.remotes)
 			s.remotesMu.Unlock()
 			s.peerConnected(n, k)
@@ -476,6 +546,7 @@ func (s *Syncer) connectToPersistent(ctx context.Context, raddr string) error {
 			err = rp.Err()
 			s.remotesMu.Lock()
 			delete(s.remotes, k)
+			delete(s.persistentRemotes, k)
 			n = len(s.remotes)
 			s.remotesMu.Unlock()
 			s.peerDisconnected(n, k)
@@ -484,6 +555,7 @@ func (s *Syncer) connectToPersistent(ctx context.Context, raddr string) error {
 				return
 			}
 			log.Warnf("Lost peer %v: %v", raddr, err)
+			s.peerError(k, err)
 		}()
 
 		if err := ctx.Err(); err != nil {
@@ -540,6 +612,7 @@ func (s *Syncer) connectToCandidates(ctx context.Context) error {
 				s.remotesMu.Unlock()
 				if ctx.Err() == nil {
 					log.Warnf("Peering attempt failed: %v", err)
+					s.peerError(k, err)
 				}
 				return
 			}
@@ -547,6 +620,12 @@ func (s *Syncer) connectToCandidates(ctx context.Context) error {
 
 			s.remotesMu.Lock()
 			delete(s.connectingRemotes, k)
+			if s.isBanned(na.IP.String()) {
+				// The peer was banned while connecting.
+				s.remotesMu.Unlock()
+				rp.Disconnect(errBannedPeer)
+				return
+			}
 			s.remotes[k] = rp
 			n := len(s.remotes)
 			s.remotesMu.Unlock()
@@ -564,6 +643,7 @@ func (s *Syncer) connectToCandidates(ctx context.Context) error {
 			err = rp.Err()
 			if ctx.Err() != context.Canceled {
 				log.Warnf("Lost peer %v: %v", raddr, err)
+				s.peerError(k, err)
 			}
 
 			<-wait
@@ -611,7 +691,7 @@ func (s *Syncer) getTransactionsByHashes(ctx context.Context, txHashes []*chainh
 	var notFound []*wire.InvVect
 	var foundTxs []*wire.MsgTx
 
-	for walletID, w := range s.wallets {
+	for walletID, w := range s.currentWallets() {
 		walletFoundTxs, _, err := w.GetTransactionsByHashes(ctx, txHashes)
 		if err != nil && !errors.Is(err, errors.NotExist) {
 			return nil, nil, errors.Errorf("[%d] Failed to look up transactions for getdata reply to peer: %v", walletID, err)
@@ -809,7 +889,7 @@ func (s *Syncer) handleBlockInvs(ctx context.Context, rp *p2p.RemotePeer, hashes
 func (s *Syncer) handleTxInvs(ctx context.Context, rp *p2p.RemotePeer, hashes []*chainhash.Hash) {
 	const opf = "spv.handleTxInvs(%v)"
 
-	for _, wallet := range s.wallets {
+	for _, wallet := range s.currentWallets() {
 		rpt, err := wallet.RescanPoint(ctx)
 		if err != nil {
 			op := errors.Opf(opf, rp.RemoteAddr())
@@ -866,7 +946,7 @@ ProcessTx:
 	}
 
 	// Save any relevant transaction.
-	for walletID, w := range s.wallets {
+	for walletID, w := range s.currentWallets() {
 		relevant := s.filterRelevant(txs, walletID)
 		for _, tx := range relevant {
 
@@ -1053,8 +1133,10 @@ func (s *Syncer) handleBlockAnnouncements(ctx context.Context, rp *p2p.RemotePee
 		}
 		return err
 	}
+	s.headersReceived(rp, len(headers))
+	s.cfiltersReceived(rp, len(filters))
 
-	for walletID, w := range s.wallets {
+	for walletID, w := range s.currentWallets() {
 		newBlocks := make([]*wallet.BlockNode, 0, len(headers))
 		var bestChain []*wallet.BlockNode
 		var matchingTxs map[chainhash.Hash][]*wire.MsgTx
@@ -1078,8 +1160,10 @@ func (s *Syncer) handleBlockAnnouncements(ctx context.Context, rp *p2p.RemotePee
 				err = validate.CFilterV2HeaderCommitment(cnet, headers[i],
 					filter, proofIndex, proof)
 				if err != nil {
+					s.invalidCFilters(rp, false, err)
 					return err
 				}
+				s.cfiltersValidated(1)
 
 				n := wallet.NewBlockNode(headers[i], blockHashes[i], filter)
 				if s.sidechains.AddBlockNode(n) {
@@ -1239,11 +1323,17 @@ func (s *Syncer) getHeaders(ctx context.Context, rp *p2p.RemotePeer) error {
 			})
 		}
 		err = g.Wait()
+		if errors.Is(err, errors.Consensus) {
+			s.invalidCFilters(rp, false, err)
+		}
 		if err != nil {
 			return err
 		}
+		s.headersReceived(rp, len(headers))
+		s.cfiltersReceived(rp, len(nodes))
+		s.cfiltersValidated(len(nodes))
 
-		for walletID, w := range s.wallets {
+		for walletID, w := range s.currentWallets() {
 			var added int
 			s.sidechainMu.Lock()
 			for _, n := range nodes {
@@ -1314,19 +1404,39 @@ func (s *Syncer) getHeaders(ctx context.Context, rp *p2p.RemotePeer) error {
 }
 
 func (s *Syncer) fetchMissingCFilters(ctx context.Context, rp *p2p.RemotePeer) error {
-	for walletID, w := range s.wallets {
-		s.fetchMissingCfiltersStart(walletID)
-		progress := make(chan wallet.MissingCFilterProgress, 1)
-		go w.FetchMissingCFiltersWithProgress(ctx, rp, progress)
+	for walletID, w := range s.currentWallets() {
+		if err := s.fetchMissingWalletCFilters(ctx, rp, walletID, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Syncer) fetchMissingWalletCFilters(ctx context.Context, rp *p2p.RemotePeer, walletID int, w *wallet.Wallet) error {
+	s.fetchMissingCfiltersStart(walletID)
+	progress := make(chan wallet.MissingCFilterProgress, 1)
+
+	// In strict mode the filters are fetched through the wallet backend,
+	// which refetches invalid filters from other peers.
+	var peer wallet.Peer = rp
+	if s.strictCFilters {
+		peer = &WalletBackend{Syncer: s, WalletID: walletID}
+	}
+	go w.FetchMissingCFiltersWithProgress(ctx, peer, progress)
 
-		for p := range progress {
-			if p.Err != nil {
-				return p.Err
+	for p := range progress {
+		if p.Err != nil {
+			if !s.strictCFilters && errors.Is(p.Err, errors.Consensus) {
+				s.invalidCFilters(rp, false, p.Err)
 			}
-			s.fetchMissingCfiltersProgress(walletID, p.BlockHeightStart, p.BlockHeightEnd)
+			return p.Err
+		}
+		if !s.strictCFilters {
+			s.cfiltersReceived(rp, int(p.BlockHeightEnd-p.BlockHeightStart+1))
 		}
-		s.fetchMissingCfiltersFinished(walletID)
+		s.fetchMissingCfiltersProgress(walletID, p.BlockHeightStart, p.BlockHeightEnd)
 	}
+	s.fetchMissingCfiltersFinished(walletID)
 	return nil
 }
 
@@ -1354,68 +1464,8 @@ func (s *Syncer) startupSync(ctx context.Context, rp *p2p.RemotePeer) error {
 	log.Debugf("Finished fetching headers from %v", rp.RemoteAddr())
 
 	if atomic.CompareAndSwapUint32(&s.atomicCatchUpTryLock, 0, 1) {
-		for walletID, w := range s.wallets {
-			err = func() error {
-				rescanPoint, err := w.RescanPoint(ctx)
-				if err != nil {
-					return err
-				}
-				walletBackend := &WalletBackend{
-					Syncer:   s,
-					WalletID: walletID,
-				}
-				if rescanPoint == nil {
-					if !s.loadedFilters[walletID] {
-						err = w.LoadActiveDataFilters(ctx, walletBackend, true)
-						if err != nil {
-							return err
-						}
-						s.loadedFilters[walletID] = true
-					}
-
-					s.synced(walletID)
-
-					return nil
-				}
-				// RescanPoint is != nil so we are not synced to the peer and
-				// check to see if it was previously synced
-				s.unsynced(walletID)
-
-				s.discoverAddressesStart(walletID)
-				err = w.DiscoverActiveAddresses(ctx, rp, rescanPoint, !w.Locked(), w.GapLimit())
-				if err != nil {
-					return err
-				}
-
-				s.discoverAddressesFinished(walletID)
-
-				err = w.LoadActiveDataFilters(ctx, walletBackend, true)
-				if err != nil {
-					return err
-				}
-				s.loadedFilters[walletID] = true
-
-				s.rescanStart(walletID)
-
-				rescanBlock, err := w.BlockHeader(ctx, rescanPoint)
-				if err != nil {
-					return err
-				}
-				progress := make(chan wallet.RescanProgress, 1)
-				go w.RescanProgressFromHeight(ctx, walletBackend, int32(rescanBlock.Height), progress)
-
-				for p := range progress {
-					if p.Err != nil {
-						return p.Err
-					}
-					s.rescanProgress(walletID, p.ScannedThrough)
-				}
-				s.rescanFinished(walletID)
-
-				s.synced(walletID)
-
-				return nil
-			}()
+		for walletID, w := range s.currentWallets() {
+			err = s.catchUpWallet(ctx, rp, walletID, w)
 		}
 
 		atomic.StoreUint32(&s.atomicCatchUpTryLock, 0)
@@ -1424,7 +1474,7 @@ func (s *Syncer) startupSync(ctx context.Context, rp *p2p.RemotePeer) error {
 		}
 	}
 
-	for _, w := range s.wallets {
+	for _, w := range s.currentWallets() {
 		unminedTxs, err := w.UnminedTransactions(ctx)
 		if err != nil {
 			log.Errorf("Cannot load unmined transactions for resending: %v", err)
@@ -1443,6 +1493,71 @@ func (s *Syncer) startupSync(ctx context.Context, rp *p2p.RemotePeer) error {
 	return nil
 }
 
+// catchUpWallet discovers the addresses of the wallet and rescans the blocks
+// after the wallet's rescan point if the wallet is not synced to the peer.
+// The caller must hold atomicCatchUpTryLock.
+func (s *Syncer) catchUpWallet(ctx context.Context, rp *p2p.RemotePeer, walletID int, w *wallet.Wallet) error {
+	rescanPoint, err := w.RescanPoint(ctx)
+	if err != nil {
+		return err
+	}
+	walletBackend := &WalletBackend{
+		Syncer:   s,
+		WalletID: walletID,
+	}
+	if rescanPoint == nil {
+		if !s.filtersLoaded(walletID) {
+			err = w.LoadActiveDataFilters(ctx, walletBackend, true)
+			if err != nil {
+				return err
+			}
+			s.setFiltersLoaded(walletID)
+		}
+
+		s.synced(walletID)
+
+		return nil
+	}
+	// RescanPoint is != nil so we are not synced to the peer and
+	// check to see if it was previously synced
+	s.unsynced(walletID)
+
+	s.discoverAddressesStart(walletID)
+	err = w.DiscoverActiveAddresses(ctx, rp, rescanPoint, !w.Locked(), w.GapLimit())
+	if err != nil {
+		return err
+	}
+
+	s.discoverAddressesFinished(walletID)
+
+	err = w.LoadActiveDataFilters(ctx, walletBackend, true)
+	if err != nil {
+		return err
+	}
+	s.setFiltersLoaded(walletID)
+
+	s.rescanStart(walletID)
+
+	rescanBlock, err := w.BlockHeader(ctx, rescanPoint)
+	if err != nil {
+		return err
+	}
+	progress := make(chan wallet.RescanProgress, 1)
+	go w.RescanProgressFromHeight(ctx, walletBackend, int32(rescanBlock.Height), progress)
+
+	for p := range progress {
+		if p.Err != nil {
+			return p.Err
+		}
+		s.rescanProgress(walletID, p.ScannedThrough)
+	}
+	s.rescanFinished(walletID)
+
+	s.synced(walletID)
+
+	return nil
+}
+
 // handleMempool handles eviction from the local mempool of non-wallet-backed
 // transactions. It MUST be run as a goroutine.
 func (s *Syncer) handleMempool(ctx context.Context) error {
@@ -0,0 +1,256 @@
+// Copyright (c) 2021 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"decred.org/dcrwallet/wallet"
+	"github.com/decred/dcrd/gcs/v2/blockcf2"
+	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/p2p"
+)
+
+// copyHeadersBatchSize is the number of headers copied to an added wallet at
+// a time.
+const copyHeadersBatchSize = 2000
+
+// currentWallets returns a copy of the wallets synced by the syncer.
+func (s *Syncer) currentWallets() map[int]*wallet.Wallet {
+	s.walletsMu.RLock()
+	defer s.walletsMu.RUnlock()
+
+	wallets := make(map[int]*wallet.Wallet, len(s.wallets))
+	for walletID, w := range s.wallets {
+		wallets[walletID] = w
+	}
+	return wallets
+}
+
+func (s *Syncer) hasWallet(walletID int) bool {
+	s.walletsMu.RLock()
+	defer s.walletsMu.RUnlock()
+
+	_, ok := s.wallets[walletID]
+	return ok
+}
+
+// walletSyncedFlag returns the atomic synced flag of the wallet or nil if the
+// wallet has been removed.
+func (s *Syncer) walletSyncedFlag(walletID int) *uint32 {
+	s.walletsMu.RLock()
+	defer s.walletsMu.RUnlock()
+	return s.atomicWalletsSynced[walletID]
+}
+
+func (s *Syncer) filtersLoaded(walletID int) bool {
+	s.walletsMu.RLock()
+	defer s.walletsMu.RUnlock()
+	return s.loadedFilters[walletID]
+}
+
+func (s *Syncer) setFiltersLoaded(walletID int) {
+	s.walletsMu.Lock()
+	if _, ok := s.wallets[walletID]; ok {
+		s.loadedFilters[walletID] = true
+	}
+	s.walletsMu.Unlock()
+}
+
+// AddWallet adds a wallet to the syncer.  If the syncer is running, the
+// wallet's network backend is set and the wallet is caught up in the
+// background with the headers of the synced wallets and the connected peers.  Wallets added before
+// the syncer is run are synced like the wallets passed to NewSyncer.
+func (s *Syncer) AddWallet(walletID int, w *wallet.Wallet) error {
+	s.walletsMu.Lock()
+	if _, ok := s.wallets[walletID]; ok {
+		s.walletsMu.Unlock()
+		return errors.E(errors.Exist, "wallet is already synced")
+	}
+
+	// The filters must exist before the wallet is seen by any handler.
+	s.filterMu.Lock()
+	s.rescanFilter[walletID] = wallet.NewRescanFilter(nil, nil)
+	s.filterData[walletID] = &blockcf2.Entries{}
+	s.filterMu.Unlock()
+
+	s.wallets[walletID] = w
+	s.atomicWalletsSynced[walletID] = new(uint32)
+	s.loadedFilters[walletID] = false
+	ctx := s.runCtx
+	if ctx != nil {
+		w.SetNetworkBackend(&WalletBackend{
+			Syncer:   s,
+			WalletID: walletID,
+		})
+	}
+	s.walletsMu.Unlock()
+
+	if ctx != nil {
+		go s.catchUpAddedWallet(ctx, walletID, w)
+	}
+	return nil
+}
+
+// RemoveWallet stops syncing the wallet and unsets its network backend.
+// The remaining wallets continue to sync.
+func (s *Syncer) RemoveWallet(walletID int) error {
+	s.walletsMu.Lock()
+	w, ok := s.wallets[walletID]
+	if !ok {
+		s.walletsMu.Unlock()
+		return errors.E(errors.NotExist, "wallet is not synced")
+	}
+	delete(s.wallets, walletID)
+	delete(s.atomicWalletsSynced, walletID)
+	delete(s.loadedFilters, walletID)
+	running := s.runCtx != nil
+	s.walletsMu.Unlock()
+
+	// Handlers that are processing the wallet may still look up its
+	// filters, so they are emptied rather than deleted.
+	s.filterMu.Lock()
+	s.rescanFilter[walletID] = wallet.NewRescanFilter(nil, nil)
+	s.filterData[walletID] = &blockcf2.Entries{}
+	s.filterMu.Unlock()
+
+	if running {
+		w.SetNetworkBackend(nil)
+	}
+	return nil
+}
+
+// catchUpAddedWallet syncs a wallet added to the running syncer using one of
+// the connected peers, retrying with another peer on failure until the
+// wallet is synced, removed or the context is canceled.
+func (s *Syncer) catchUpAddedWallet(ctx context.Context, walletID int, w *wallet.Wallet) {
+	for s.hasWallet(walletID) {
+		rp, err := s.pickRemote(func(*p2p.RemotePeer) bool { return true })
+		if err == nil {
+			err = s.catchUpAddedWalletWith(ctx, rp, walletID, w)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			log.Warnf("[%d] Failed to sync added wallet with %v: %v", walletID, rp, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (s *Syncer) catchUpAddedWalletWith(ctx context.Context, rp *p2p.RemotePeer, walletID int, w *wallet.Wallet) error {
+	if err := s.fetchMissingWalletCFilters(ctx, rp, walletID, w); err != nil {
+		return err
+	}
+
+	// Peers only announce new headers after the startup sync, so the
+	// headers are copied from the wallets that were synced before.
+	s.fetchHeadersStart(rp.InitialHeight())
+	if err := s.copyHeaders(ctx, walletID, w); err != nil {
+		return err
+	}
+	s.fetchHeadersFinished()
+
+	// Wait for any other wallet catch up to finish.
+	for !atomic.CompareAndSwapUint32(&s.atomicCatchUpTryLock, 0, 1) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	defer atomic.StoreUint32(&s.atomicCatchUpTryLock, 0)
+
+	if !s.hasWallet(walletID) {
+		return nil
+	}
+	return s.catchUpWallet(ctx, rp, walletID, w)
+}
+
+// copyHeaders connects the main chain headers and cfilters of the wallet
+// with the highest tip to w in batches of copyHeadersBatchSize, starting at
+// the last block that both wallets have in their main chains.  Blocks that
+// are announced during the copy are connected to w by the announcement
+// handlers once w has their parent.
+func (s *Syncer) copyHeaders(ctx context.Context, walletID int, w *wallet.Wallet) error {
+	for {
+		done, err := s.copyHeadersBatch(ctx, walletID, w)
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Syncer) copyHeadersBatch(ctx context.Context, walletID int, w *wallet.Wallet) (done bool, err error) {
+	// Headers fetched from peers are not connected to any wallet during the
+	// batch.
+	s.sidechainMu.Lock()
+	defer s.sidechainMu.Unlock()
+
+	_, sourceTip, source := s.highestChainTip(ctx)
+	_, tip := w.MainChainTip(ctx)
+	if source == nil || source == w || sourceTip <= tip {
+		return true, nil
+	}
+
+	// Find the last block that both wallets agree on.
+	height := tip
+	for height > 0 {
+		sourceBlock, err := source.BlockInfo(ctx, wallet.NewBlockIdentifierFromHeight(height))
+		if err != nil {
+			return false, err
+		}
+		if haveBlock, _, _ := w.BlockInMainChain(ctx, &sourceBlock.Hash); haveBlock {
+			break
+		}
+		height--
+	}
+
+	lastHeight := height + copyHeadersBatchSize
+	if lastHeight > sourceTip {
+		lastHeight = sourceTip
+	}
+
+	var forest wallet.SidechainForest
+	for height++; height <= lastHeight; height++ {
+		block, err := source.BlockInfo(ctx, wallet.NewBlockIdentifierFromHeight(height))
+		if err != nil {
+			return false, err
+		}
+		header := new(wire.BlockHeader)
+		if err := header.FromBytes(block.Header); err != nil {
+			return false, err
+		}
+		_, filter, err := source.CFilterV2(ctx, &block.Hash)
+		if err != nil {
+			return false, err
+		}
+		forest.AddBlockNode(wallet.NewBlockNode(header, &block.Hash, filter))
+	}
+
+	bestChain, err := w.EvaluateBestChain(ctx, &forest)
+	if err != nil || len(bestChain) == 0 {
+		return true, err
+	}
+	if _, err = w.ValidateHeaderChainDifficulties(ctx, bestChain, 0); err != nil {
+		return false, err
+	}
+	if _, err = w.ChainSwitch(ctx, &forest, bestChain, nil); err != nil {
+		return false, err
+	}
+
+	tipHeader := bestChain[len(bestChain)-1].Header
+	s.fetchHeadersProgress(tipHeader)
+	log.Infof("[%d] Copied %d header(s) from synced wallets, new tip height %d",
+		walletID, len(bestChain), tipHeader.Height)
+	return false, nil
+}
@@ -0,0 +1,932 @@
+package dcrlibwallet
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	w "decred.org/dcrwallet/wallet"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/spvtest"
+	"github.com/planetdecred/dcrlibwallet/vsp"
+	"github.com/planetdecred/dcrlibwallet/vsptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// spvTestTimeout is the time allowed to each step of the SPV sync tests.
+const spvTestTimeout = 30 * time.Second
+
+// waitForEvent returns the next event of kind received on events.
+func waitForEvent(events <-chan Event, kind EventKind) Event {
+	timeout := time.After(spvTestTimeout)
+	for {
+		select {
+		case event, ok := <-events:
+			Expect(ok).To(BeTrue(), "event subscription closed")
+			if event.Kind == kind {
+				return event
+			}
+		case <-timeout:
+			Fail("timed out waiting for " + kind.String())
+		}
+	}
+}
+
+var _ = Describe("SpvSync", func() {
+	var chain *spvtest.Chain
+	var peers []*spvtest.Peer
+	var rootDir string
+	var mw *MultiWallet
+	var wallet *Wallet
+	var events <-chan Event
+
+	// startPeer starts a peer serving the test chain that is closed after
+	// the test.
+	startPeer := func(misbehavior spvtest.Misbehavior) *spvtest.Peer {
+		peer, err := spvtest.NewPeer(chain, misbehavior)
+		Expect(err).To(BeNil())
+		peers = append(peers, peer)
+		return peer
+	}
+
+	// createMultiWallet creates a multiwallet with one wallet that syncs
+	// from the given peers only.
+	createMultiWallet := func(syncPeers ...*spvtest.Peer) {
+		var err error
+		rootDir, err = ioutil.TempDir("", "dcrlibwallet")
+		Expect(err).To(BeNil())
+
+		mw, err = NewMultiWallet(rootDir, "", chain.Params().Name)
+		Expect(err).To(BeNil())
+
+		wallet, err = mw.CreateNewWallet("spvtest", "passphrase", PassphraseTypePass)
+		Expect(err).To(BeNil())
+
+		addresses := make([]string, len(syncPeers))
+		for i, peer := range syncPeers {
+			addresses[i] = peer.Addr()
+		}
+		mw.SetStringConfigValueForKey(SpvPersistentPeerAddressesConfigKey, strings.Join(addresses, ";"))
+	}
+
+	startSync := func(syncPeers ...*spvtest.Peer) {
+		createMultiWallet(syncPeers...)
+		events = mw.Subscribe(nil)
+		Expect(mw.SpvSync()).To(BeNil())
+	}
+
+	walletTipHash := func() chainhash.Hash {
+		hash, _ := wallet.internal.MainChainTip(context.Background())
+		return hash
+	}
+
+	chainTipHash := func() chainhash.Hash {
+		hash, _ := chain.Tip()
+		return hash
+	}
+
+	// mempoolTxHashes returns the hashes of the unmined transactions of the
+	// wallet.
+	mempoolTxHashes := func() []string {
+		mempoolTxs, err := wallet.MempoolTransactionsRaw()
+		Expect(err).To(BeNil())
+		hashes := make([]string, len(mempoolTxs))
+		for i, tx := range mempoolTxs {
+			hashes[i] = tx.Hash
+		}
+		return hashes
+	}
+
+	BeforeEach(func() {
+		chain = spvtest.NewChain(chaincfg.SimNetParams())
+		_, err := chain.GenerateBlocks(20)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		if mw != nil {
+			mw.Shutdown()
+			mw = nil
+		}
+		for _, peer := range peers {
+			peer.Close()
+		}
+		peers = nil
+		os.RemoveAll(rootDir)
+	})
+
+	It("syncs the chain of a peer", func() {
+		startSync(startPeer(spvtest.Honest))
+
+		waitForEvent(events, SyncCompletedEvent)
+		Expect(mw.IsSynced()).To(BeTrue())
+		Expect(walletTipHash()).To(Equal(chainTipHash()))
+	})
+
+	It("catches up wallets created or restored during the sync", func() {
+		startSync(startPeer(spvtest.Honest))
+
+		created, err := mw.CreateNewWallet("created", "passphrase", PassphraseTypePass)
+		Expect(err).To(BeNil())
+		seed, err := GenerateSeed()
+		Expect(err).To(BeNil())
+		restored, err := mw.RestoreWallet("restored", seed, "passphrase", PassphraseTypePass)
+		Expect(err).To(BeNil())
+		// Accounts are discovered while the restored wallet is unlocked.
+		Expect(restored.UnlockWallet([]byte("passphrase"))).To(Succeed())
+
+		allSynced := func() bool {
+			return mw.IsSynced() && mw.SyncedWalletsCount() == 3
+		}
+		Eventually(allSynced, spvTestTimeout).Should(BeTrue())
+		for _, w := range []*Wallet{wallet, created, restored} {
+			Expect(w.IsSynced()).To(BeTrue())
+			Expect(w.GetBestBlock()).To(Equal(int32(20)))
+		}
+
+		By("Receiving the transactions of a restored wallet mined after it was added")
+		address, err := restored.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		tx, err := chain.PayToAddress(address, 100000000)
+		Expect(err).To(BeNil())
+		_, err = chain.GenerateBlocks(1, tx)
+		Expect(err).To(BeNil())
+
+		txHash := tx.TxHash()
+		minedHeight := func() int32 {
+			minedTx, err := restored.GetTransactionRaw(txHash[:])
+			if err != nil {
+				return -1
+			}
+			return minedTx.BlockHeight
+		}
+		Eventually(minedHeight, spvTestTimeout).Should(Equal(int32(21)))
+	})
+
+	It("completes the sync when a wallet is deleted during the sync", func() {
+		peer := startPeer(spvtest.Honest)
+		createMultiWallet(peer)
+		deleted, err := mw.CreateNewWallet("deleted", "passphrase", PassphraseTypePass)
+		Expect(err).To(BeNil())
+		events = mw.Subscribe(nil)
+		Expect(mw.SpvSync()).To(BeNil())
+
+		Expect(mw.DeleteWallet(deleted.ID, []byte("passphrase"))).To(Succeed())
+		Expect(mw.WalletWithID(deleted.ID)).To(BeNil())
+
+		Eventually(mw.IsSynced, spvTestTimeout).Should(BeTrue())
+		Expect(mw.SyncableWalletsCount()).To(Equal(int32(1)))
+		Expect(mw.SyncedWalletsCount()).To(Equal(int32(1)))
+		Expect(walletTipHash()).To(Equal(chainTipHash()))
+
+		By("Receiving new blocks with the remaining wallet")
+		_, err = chain.GenerateBlocks(1)
+		Expect(err).To(BeNil())
+		event := waitForEvent(events, BlockAttachedEvent)
+		Expect(event.WalletID).To(Equal(wallet.ID))
+		Expect(event.BlockHeight).To(Equal(int32(21)))
+	})
+
+	It("skips wallets excluded from sync", func() {
+		peer := startPeer(spvtest.Honest)
+		createMultiWallet(peer)
+		excluded, err := mw.CreateNewWallet("excluded", "passphrase", PassphraseTypePass)
+		Expect(err).To(BeNil())
+		Expect(mw.SetWalletExcludedFromSync(excluded.ID, true)).To(Succeed())
+		events = mw.Subscribe(nil)
+
+		By("Syncing the other wallets with SpvSync")
+		Expect(mw.SpvSync()).To(BeNil())
+		Expect(excluded.IsSyncing()).To(BeFalse())
+		waitForEvent(events, SyncCompletedEvent)
+
+		Expect(mw.IsSynced()).To(BeTrue())
+		Expect(mw.SyncableWalletsCount()).To(Equal(int32(1)))
+		Expect(mw.SyncedWalletsCount()).To(Equal(int32(1)))
+		Expect(excluded.IsSynced()).To(BeFalse())
+		Expect(excluded.GetBestBlock()).To(Equal(int32(0)))
+		Expect(mw.GetLowestBlock().Height).To(Equal(int32(20)))
+
+		By("Syncing the other wallets with RpcSync")
+		mw.CancelSync()
+		Expect(mw.RpcSync("127.0.0.1:1", "user", "pass", nil)).To(Succeed())
+		Expect(wallet.IsSyncing()).To(BeTrue())
+		Expect(excluded.IsSyncing()).To(BeFalse())
+		waitForEvent(events, SyncEndedWithErrorEvent)
+
+		By("Catching up the wallet once it is included in the running sync")
+		Expect(mw.SpvSync()).To(BeNil())
+		waitForEvent(events, SyncCompletedEvent)
+		Expect(mw.SetWalletExcludedFromSync(excluded.ID, false)).To(Succeed())
+		Eventually(excluded.IsSynced, spvTestTimeout).Should(BeTrue())
+		Expect(mw.IsSynced()).To(BeTrue())
+		Expect(excluded.GetBestBlock()).To(Equal(int32(20)))
+		Expect(mw.GetLowestBlock().Height).To(Equal(int32(20)))
+	})
+
+	It("reports sync diagnostics", func() {
+		peer := startPeer(spvtest.Honest)
+		startSync(peer)
+		waitForEvent(events, SyncCompletedEvent)
+
+		diagnostics, err := mw.SyncDiagnosticsRaw()
+		Expect(err).To(BeNil())
+		Expect(diagnostics.Synced).To(BeTrue())
+		Expect(diagnostics.SyncCompletedAt).To(BeNumerically(">=", diagnostics.SyncStartedAt))
+		Expect(diagnostics.HeadersFetched).To(BeNumerically("==", 20))
+		Expect(diagnostics.CFiltersFetched).To(BeNumerically("==", 20))
+		Expect(diagnostics.Errors).To(BeEmpty())
+
+		var stages []int32
+		for _, stage := range diagnostics.Stages {
+			Expect(stage.EndedAt).NotTo(BeZero())
+			stages = append(stages, stage.Stage)
+		}
+		Expect(stages).To(ContainElements(int32(HeadersFetchSyncStage), int32(AddressDiscoverySyncStage), int32(HeadersRescanSyncStage)))
+
+		Expect(diagnostics.Peers).To(HaveLen(1))
+		Expect(diagnostics.Peers[0].Address).To(Equal(peer.Addr()))
+		Expect(diagnostics.Peers[0].Connections).To(BeNumerically("==", 1))
+		Expect(diagnostics.Peers[0].HeadersFetched).To(BeNumerically("==", 20))
+
+		Expect(diagnostics.Wallets).To(HaveLen(1))
+		Expect(diagnostics.Wallets[0].BestBlock).To(BeNumerically("==", 20))
+		Expect(diagnostics.Wallets[0].RescanRanges).To(HaveLen(1))
+		Expect(diagnostics.Wallets[0].RescanRanges[0].ScannedThrough).To(BeNumerically("==", 20))
+
+		Expect(diagnostics.AddressManager.PersistentPeers).To(ConsistOf(peer.Addr()))
+
+		jsonDiagnostics, err := mw.SyncDiagnostics()
+		Expect(err).To(BeNil())
+		var decoded map[string]interface{}
+		Expect(json.Unmarshal([]byte(jsonDiagnostics), &decoded)).To(Succeed())
+		Expect(decoded).To(HaveKey("addressManager"))
+	})
+
+	It("purchases tickets through a vspd VSP", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		By("Funding the tickets and the VSP fees")
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		ticketFunds, err := chain.PayToAddress(address, 1000000000)
+		Expect(err).To(BeNil())
+		feeFunds, err := chain.PayToAddress(address, 100000000)
+		Expect(err).To(BeNil())
+		_, err = chain.GenerateBlocks(1, ticketFunds, feeFunds)
+		Expect(err).To(BeNil())
+		waitForEvent(events, BlockAttachedEvent)
+
+		server, err := vsptest.NewServer(chain, vsptest.Honest)
+		Expect(err).To(BeNil())
+		defer server.Close()
+
+		info, err := mw.VSPInfoRaw(server.URL())
+		Expect(err).To(BeNil())
+		Expect(info.PubKey).To(Equal(base64.StdEncoding.EncodeToString(server.PubKey())))
+		Expect(info.FeePercentage).To(Equal(vsptest.FeePercentage))
+		Expect(info.Network).To(Equal(chain.Params().Name))
+
+		By("Purchasing a ticket")
+		hashes, err := wallet.PurchaseTickets(context.Background(), &PurchaseTicketsRequest{
+			NumTickets:            1,
+			RequiredConfirmations: 1,
+			Passphrase:            []byte("passphrase"),
+			VSPPubKey:             info.PubKey,
+		}, server.URL())
+		Expect(err).To(BeNil())
+		Expect(hashes).To(HaveLen(1))
+
+		ticketHash, err := chainhash.NewHashFromStr(hashes[0])
+		Expect(err).To(BeNil())
+		vspTicket, ok := server.Ticket(ticketHash)
+		Expect(ok).To(BeTrue())
+		Expect(vspTicket.FeeTx).NotTo(BeNil())
+		Expect(vspTicket.VotingKey).NotTo(BeEmpty())
+		feeTxHash := vspTicket.FeeTx.TxHash()
+		Expect(chain.MempoolTx(&feeTxHash)).NotTo(BeNil())
+
+		status, err := wallet.VSPTicketStatusRaw(hashes[0], []byte("passphrase"))
+		Expect(err).To(BeNil())
+		Expect(status.VSPHost).To(Equal(server.URL()))
+		Expect(status.FeeAmount).To(Equal(int64(vspTicket.FeeAmount)))
+		Expect(status.FeeTxHash).To(Equal(feeTxHash.String()))
+		Expect(status.FeeTxStatus).To(Equal(vsp.FeeTxStatusBroadcast))
+
+		By("Mining the ticket and the fee transaction")
+		ticketTx := chain.MempoolTx(ticketHash)
+		Expect(ticketTx).NotTo(BeNil())
+		splitTx := chain.MempoolTx(&ticketTx.TxIn[0].PreviousOutPoint.Hash)
+		Expect(splitTx).NotTo(BeNil())
+		_, err = chain.GenerateBlocks(1, splitTx, ticketTx, vspTicket.FeeTx)
+		Expect(err).To(BeNil())
+		waitForEvent(events, BlockAttachedEvent)
+
+		status, err = wallet.VSPTicketStatusRaw(hashes[0], []byte("passphrase"))
+		Expect(err).To(BeNil())
+		Expect(status.FeeTxStatus).To(Equal(vsp.FeeTxStatusConfirmed))
+
+		By("Updating the vote choices of the ticket")
+		// Simnet has no agendas, only the request to the VSP is checked.
+		Expect(wallet.SetVoteChoice("unknown", "yes", []byte("passphrase"))).NotTo(Succeed())
+		record, err := wallet.vspTicket(ticketHash)
+		Expect(err).To(BeNil())
+		Expect(wallet.UnlockWallet([]byte("passphrase"))).To(Succeed())
+		Expect(wallet.updateVSPVoteChoices(hashes[0], record)).To(Succeed())
+		wallet.LockWallet()
+
+		voteChoices, err := wallet.ticketVoteChoices(context.Background(), ticketHash)
+		Expect(err).To(BeNil())
+		vspTicket, ok = server.Ticket(ticketHash)
+		Expect(ok).To(BeTrue())
+		Expect(vspTicket.VoteChoices).To(Equal(voteChoices))
+	})
+
+	It("buys tickets automatically as blocks are attached", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		config := &TicketBuyerConfig{
+			BalanceToMaintain:  100000000,
+			MaxTicketPrice:     chain.Params().MinimumStakeDiff - 1,
+			MaxTicketsPerBlock: 1,
+		}
+		Expect(wallet.StartAutoTicketBuyer(config, []byte("wrong"))).To(MatchError(ErrInvalidPassphrase))
+		Expect(wallet.StartAutoTicketBuyer(config, []byte("passphrase"))).To(Succeed())
+		Expect(wallet.StartAutoTicketBuyer(config, []byte("passphrase"))).To(MatchError(ErrTicketBuyerAlreadyRunning))
+		Expect(wallet.IsAutoTicketBuyerRunning()).To(BeTrue())
+
+		By("Skipping blocks while the ticket price is above the maximum")
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		funds, err := chain.PayToAddress(address, 1000000000)
+		Expect(err).To(BeNil())
+		_, err = chain.GenerateBlocks(1, funds)
+		Expect(err).To(BeNil())
+		event := waitForEvent(events, TicketPurchaseSkippedEvent)
+		Expect(event.BlockHeight).To(Equal(int32(21)))
+		Expect(event.TicketBuyer.SkipReason).To(Equal(TicketBuyerSkipTicketPriceAboveMax))
+		Expect(event.TicketBuyer.TicketPrice).To(Equal(chain.Params().MinimumStakeDiff))
+
+		wallet.StopAutoTicketBuyer()
+		status := wallet.AutoTicketBuyerStatusRaw()
+		Expect(status.Running).To(BeFalse())
+		Expect(status.LastBlockHeight).To(Equal(int32(21)))
+		Expect(status.LastReport.SkipReason).To(Equal(TicketBuyerSkipTicketPriceAboveMax))
+
+		By("Skipping the last block at which tickets can be mined at the ticket price")
+		config.MaxTicketPrice = 0
+		Expect(wallet.StartAutoTicketBuyer(config, []byte("passphrase"))).To(Succeed())
+		_, err = chain.GenerateBlocks(1)
+		Expect(err).To(BeNil())
+		event = waitForEvent(events, TicketPurchaseSkippedEvent)
+		Expect(event.BlockHeight).To(Equal(int32(22)))
+		Expect(event.TicketBuyer.SkipReason).To(Equal(TicketBuyerSkipPriceChangePending))
+
+		By("Skipping blocks when the balance covers the ticket price but not the fees")
+		report := &TicketBuyerReport{WalletID: wallet.ID, BlockHeight: 23}
+		wallet.buyTickets(context.Background(), &ticketBuyer{}, &TicketBuyerConfig{
+			BalanceToMaintain: 1000000000 - chain.Params().MinimumStakeDiff,
+		}, []byte("passphrase"), report)
+		Expect(report.SpendableBalance).To(Equal(int64(1000000000)))
+		Expect(report.SkipReason).To(Equal(TicketBuyerSkipInsufficientBalance))
+
+		By("Buying a ticket that expires before the next ticket price change")
+		_, err = chain.GenerateBlocks(1)
+		Expect(err).To(BeNil())
+		event = waitForEvent(events, TicketsPurchasedEvent)
+		Expect(event.BlockHeight).To(Equal(int32(23)))
+		Expect(event.TicketBuyer.TicketHashes).To(HaveLen(1))
+		Expect(event.TicketBuyer.SpendableBalance).To(Equal(int64(1000000000)))
+
+		ticketHash, err := chainhash.NewHashFromStr(event.TicketBuyer.TicketHashes[0])
+		Expect(err).To(BeNil())
+		Eventually(func() *wire.MsgTx { return chain.MempoolTx(ticketHash) }, spvTestTimeout).ShouldNot(BeNil())
+		Expect(chain.MempoolTx(ticketHash).Expiry).To(Equal(uint32(32)))
+
+		status = wallet.AutoTicketBuyerStatusRaw()
+		Expect(status.Running).To(BeTrue())
+		Expect(status.TicketsPurchased).To(Equal(int32(1)))
+		Expect(status.LastPurchaseAt).NotTo(BeZero())
+
+		statusJSON, err := wallet.AutoTicketBuyerStatus()
+		Expect(err).To(BeNil())
+		Expect(statusJSON).To(ContainSubstring(`"maxTicketsPerBlock":1`))
+
+		wallet.StopAutoTicketBuyer()
+		Expect(wallet.IsAutoTicketBuyerRunning()).To(BeFalse())
+	})
+
+	It("fetches the VSP info once while buying tickets automatically through a VSP", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		server, err := vsptest.NewServer(chain, vsptest.Honest)
+		Expect(err).To(BeNil())
+		defer server.Close()
+
+		config := &TicketBuyerConfig{
+			MaxTicketsPerBlock: 1,
+			VSPHost:            server.URL(),
+			VSPPubKey:          base64.StdEncoding.EncodeToString(server.PubKey()),
+		}
+		Expect(wallet.StartAutoTicketBuyer(config, []byte("passphrase"))).To(Succeed())
+		defer wallet.StopAutoTicketBuyer()
+
+		By("Funding a purchase at each of the blocks 23 and 24")
+		// The VSP fees are paid with other outputs than the tickets.
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		var funds []*wire.MsgTx
+		for i := 0; i < 4; i++ {
+			tx, err := chain.PayToAddress(address, 1000000000)
+			Expect(err).To(BeNil())
+			funds = append(funds, tx)
+		}
+		_, err = chain.GenerateBlocks(1, funds...)
+		Expect(err).To(BeNil())
+		event := waitForEvent(events, TicketPurchaseSkippedEvent)
+		Expect(event.TicketBuyer.SkipReason).To(Equal(TicketBuyerSkipInsufficientBalance))
+		_, err = chain.GenerateBlocks(1)
+		Expect(err).To(BeNil())
+		event = waitForEvent(events, TicketPurchaseSkippedEvent)
+		Expect(event.TicketBuyer.SkipReason).To(Equal(TicketBuyerSkipPriceChangePending))
+		Expect(server.InfoRequests()).To(BeZero())
+
+		By("Fetching the VSP info for the first purchase only")
+
+		for height := int32(23); height <= 24; height++ {
+			_, err = chain.GenerateBlocks(1)
+			Expect(err).To(BeNil())
+			event = waitForEvent(events, TicketsPurchasedEvent)
+			Expect(event.BlockHeight).To(Equal(height))
+			Expect(event.TicketBuyer.TicketHashes).To(HaveLen(1))
+			Expect(server.InfoRequests()).To(Equal(1))
+		}
+	})
+
+	It("rejects VSPs whose responses are not signed by their key", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		server, err := vsptest.NewServer(chain, vsptest.InvalidSignatures)
+		Expect(err).To(BeNil())
+		defer server.Close()
+
+		_, err = mw.VSPInfoRaw(server.URL())
+		Expect(err).To(MatchError(ContainSubstring("invalid signature")))
+
+		_, err = wallet.PurchaseTickets(context.Background(), &PurchaseTicketsRequest{
+			NumTickets:            1,
+			RequiredConfirmations: 1,
+			Passphrase:            []byte("passphrase"),
+			VSPPubKey:             base64.StdEncoding.EncodeToString(server.PubKey()),
+		}, server.URL())
+		Expect(err).To(MatchError(ContainSubstring("invalid signature")))
+	})
+
+	It("receives mempool transactions and new blocks after syncing", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		tx, err := chain.PayToAddress(address, 100000000)
+		Expect(err).To(BeNil())
+
+		By("Announcing a transaction that pays the wallet")
+		chain.AddMempoolTx(tx)
+		event := waitForEvent(events, TransactionEvent)
+		Expect(event.Transaction.Hash).To(Equal(tx.TxHash().String()))
+		Expect(event.Transaction.BlockHeight).To(Equal(int32(-1)))
+
+		By("Mining the transaction")
+		_, err = chain.GenerateBlocks(1, tx)
+		Expect(err).To(BeNil())
+		event = waitForEvent(events, BlockAttachedEvent)
+		Expect(event.BlockHeight).To(Equal(int32(21)))
+		Expect(walletTipHash()).To(Equal(chainTipHash()))
+
+		txHash := tx.TxHash()
+		minedTx, err := wallet.GetTransactionRaw(txHash[:])
+		Expect(err).To(BeNil())
+		Expect(minedTx.BlockHeight).To(Equal(int32(21)))
+	})
+
+	It("reports mempool transactions that conflict with a mined transaction", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		tx, err := chain.PayToAddress(address, 100000000)
+		Expect(err).To(BeNil())
+		chain.AddMempoolTx(tx)
+		Eventually(mempoolTxHashes, spvTestTimeout).Should(ConsistOf(tx.TxHash().String()))
+
+		By("Mining a wallet transaction that spends the same output")
+		mempoolEvents := mw.Subscribe(&EventFilter{Kinds: []EventKind{TransactionConflictedEvent, TransactionDroppedEvent}})
+		doubleSpend, err := chain.DoubleSpend(tx, address, 50000000)
+		Expect(err).To(BeNil())
+		_, err = chain.GenerateBlocks(1, doubleSpend)
+		Expect(err).To(BeNil())
+
+		var event Event
+		Eventually(mempoolEvents, spvTestTimeout).Should(Receive(&event))
+		Expect(event.Kind).To(Equal(TransactionConflictedEvent))
+		Expect(event.TxHash).To(Equal(tx.TxHash().String()))
+		Expect(event.ConflictingTxHash).To(Equal(doubleSpend.TxHash().String()))
+		Expect(mempoolTxHashes()).To(BeEmpty())
+
+		transactions, err := wallet.GetTransactionsRaw(0, 0, TxFilterAll, true)
+		Expect(err).To(BeNil())
+		Expect(transactions).To(HaveLen(1))
+		Expect(transactions[0].Hash).To(Equal(doubleSpend.TxHash().String()))
+	})
+
+	It("reports mempool transactions that conflict with a mined transaction of another wallet", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		tx, err := chain.PayToAddress(address, 100000000)
+		Expect(err).To(BeNil())
+		chain.AddMempoolTx(tx)
+		Eventually(mempoolTxHashes, spvTestTimeout).Should(ConsistOf(tx.TxHash().String()))
+
+		By("Mining a transaction of the sender that spends the same output")
+		mempoolEvents := mw.Subscribe(&EventFilter{Kinds: []EventKind{TransactionConflictedEvent, TransactionDroppedEvent}})
+		externalAddr, err := dcrutil.NewAddressPubKeyHash(make([]byte, 20), chain.Params(), dcrec.STEcdsaSecp256k1)
+		Expect(err).To(BeNil())
+		doubleSpend, err := chain.DoubleSpend(tx, externalAddr.Address(), 50000000)
+		Expect(err).To(BeNil())
+		_, err = chain.GenerateBlocks(1, doubleSpend)
+		Expect(err).To(BeNil())
+
+		// The wallet does not see the mined transaction, the conflict is
+		// found once the transaction was unmined for a whole block.
+		_, err = chain.GenerateBlocks(1)
+		Expect(err).To(BeNil())
+
+		var event Event
+		Eventually(mempoolEvents, spvTestTimeout).Should(Receive(&event))
+		Expect(event.Kind).To(Equal(TransactionConflictedEvent))
+		Expect(event.TxHash).To(Equal(tx.TxHash().String()))
+		Expect(event.ConflictingTxHash).To(Equal(doubleSpend.TxHash().String()))
+		Expect(mempoolTxHashes()).To(BeEmpty())
+
+		transactions, err := wallet.GetTransactionsRaw(0, 0, TxFilterAll, true)
+		Expect(err).To(BeNil())
+		Expect(transactions).To(BeEmpty())
+	})
+
+	It("reports mempool transactions that peers dropped", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		tx, err := chain.PayToAddress(address, 100000000)
+		Expect(err).To(BeNil())
+		chain.AddMempoolTx(tx)
+		waitForEvent(events, TransactionEvent)
+
+		By("Evicting the transaction from the mempool of the peer")
+		txHash := tx.TxHash()
+		chain.RemoveMempoolTx(&txHash)
+
+		// Transactions are only checked once they were unmined for a
+		// whole block.
+		for i := 0; i < 2; i++ {
+			_, err = chain.GenerateBlocks(1)
+			Expect(err).To(BeNil())
+			waitForEvent(events, BlockAttachedEvent)
+		}
+
+		event := waitForEvent(events, TransactionDroppedEvent)
+		Expect(event.TxHash).To(Equal(txHash.String()))
+
+		mempoolTxs, err := wallet.MempoolTransactionsRaw()
+		Expect(err).To(BeNil())
+		Expect(mempoolTxs).To(HaveLen(1))
+	})
+
+	It("looks up transactions from the mempool and blocks of peers", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		By("Looking up an unannounced transaction that pays the wallet")
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		tx, err := chain.PayToAddress(address, 100000000)
+		Expect(err).To(BeNil())
+		chain.HoldMempoolTx(tx)
+
+		lookup, err := mw.LookupTransactionRaw(tx.TxHash().String(), "")
+		Expect(err).To(BeNil())
+		Expect(lookup.BlockHeight).To(Equal(int32(-1)))
+		Expect(lookup.Transaction.Hash).To(Equal(tx.TxHash().String()))
+		Expect(lookup.Transaction.Outputs[0].AccountNumber).To(Equal(int32(-1)))
+		Expect(lookup.WalletTransactions).To(HaveLen(1))
+		walletTx := lookup.WalletTransactions[0]
+		Expect(walletTx.WalletID).To(Equal(wallet.ID))
+		Expect(walletTx.Direction).To(Equal(TxDirectionReceived))
+		Expect(walletTx.Amount).To(Equal(int64(100000000)))
+		Expect(walletTx.Outputs[0].Address).To(Equal(address))
+		Expect(walletTx.Outputs[0].AccountNumber).To(Equal(int32(0)))
+
+		By("Looking up a mined transaction that does not pay the wallet")
+		externalAddr, err := dcrutil.NewAddressPubKeyHash(make([]byte, 20), chain.Params(), dcrec.STEcdsaSecp256k1)
+		Expect(err).To(BeNil())
+		externalTx, err := chain.PayToAddress(externalAddr.Address(), 50000000)
+		Expect(err).To(BeNil())
+		blocks, err := chain.GenerateBlocks(1, externalTx)
+		Expect(err).To(BeNil())
+		waitForEvent(events, BlockAttachedEvent)
+
+		blockHash := blocks[0].BlockHash()
+		lookup, err = mw.LookupTransactionRaw(externalTx.TxHash().String(), blockHash.String())
+		Expect(err).To(BeNil())
+		Expect(lookup.BlockHash).To(Equal(blockHash.String()))
+		Expect(lookup.BlockHeight).To(Equal(int32(21)))
+		Expect(lookup.Transaction.Outputs[0].Address).To(Equal(externalAddr.Address()))
+		Expect(lookup.WalletTransactions).To(BeEmpty())
+
+		By("Looking up a transaction that no peer has")
+		txHash := externalTx.TxHash()
+		_, err = mw.LookupTransactionRaw(txHash.String(), "")
+		Expect(err).To(MatchError(ErrNotExist))
+	})
+
+	It("rescans blocks from a height or date", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		tx, err := chain.PayToAddress(address, 100000000)
+		Expect(err).To(BeNil())
+		_, err = chain.GenerateBlocks(1, tx)
+		Expect(err).To(BeNil())
+		waitForEvent(events, BlockAttachedEvent)
+		_, err = chain.GenerateBlocks(2)
+		Expect(err).To(BeNil())
+		Eventually(walletTipHash, spvTestTimeout).Should(Equal(chainTipHash()))
+
+		Expect(mw.RescanBlocksFromHeight(wallet.ID, 24)).To(MatchError(ErrInvalid))
+		Expect(mw.RescanBlocksFromDate(wallet.ID, time.Now().Add(time.Hour).Unix())).To(MatchError(ErrInvalid))
+
+		info, err := wallet.internal.BlockInfo(context.Background(), w.NewBlockIdentifierFromHeight(22))
+		Expect(err).To(BeNil())
+		Expect(wallet.blockHeightAtTime(info.Timestamp)).To(Equal(int32(22)))
+
+		Expect(mw.RescanBlocksFromDate(wallet.ID, info.Timestamp)).To(BeNil())
+		waitForEvent(events, BlocksRescanStartedEvent)
+		event := waitForEvent(events, BlocksRescanProgressEvent)
+		Expect(event.HeadersRescanProgress.CurrentRescanHeight).To(BeNumerically(">=", 22))
+		event = waitForEvent(events, BlocksRescanEndedEvent)
+		Expect(event.Err).To(BeNil())
+
+		By("Keeping the transactions indexed before the rescanned blocks")
+		transactions, err := wallet.GetTransactionsRaw(0, 0, TxFilterAll, true)
+		Expect(err).To(BeNil())
+		Expect(transactions).To(HaveLen(1))
+		Expect(transactions[0].BlockHeight).To(Equal(int32(21)))
+	})
+
+	It("notifies the confirmations of transactions found by a rescan", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+
+		address, err := wallet.CurrentAddress(0)
+		Expect(err).To(BeNil())
+		tx, err := chain.PayToAddress(address, 100000000)
+		Expect(err).To(BeNil())
+		_, err = chain.GenerateBlocks(1, tx)
+		Expect(err).To(BeNil())
+		waitForEvent(events, BlockAttachedEvent)
+
+		// The threshold is added after the tx was mined, so only the
+		// rescan finds the tx.
+		Expect(mw.AddConfirmationThreshold(wallet.ID, 2)).To(Succeed())
+		Expect(mw.ConfirmationSubscriptionsRaw(wallet.ID)).To(HaveLen(1))
+
+		Expect(mw.RescanBlocksFromHeight(wallet.ID, 0)).To(BeNil())
+		event := waitForEvent(events, BlocksRescanEndedEvent)
+		Expect(event.Err).To(BeNil())
+		Expect(mw.ConfirmationSubscriptionsRaw(wallet.ID)).To(HaveLen(2))
+
+		_, err = chain.GenerateBlocks(1)
+		Expect(err).To(BeNil())
+		event = waitForEvent(events, TransactionConfirmationsEvent)
+		Expect(event.TxHash).To(Equal(tx.TxHash().String()))
+		Expect(event.Confirmations).To(Equal(int32(2)))
+	})
+
+	It("persists queued rescans and runs them once synced", func() {
+		peer := startPeer(spvtest.Honest)
+		createMultiWallet(peer)
+
+		Expect(mw.RescanBlocksFromHeight(wallet.ID, 0)).To(BeNil())
+		Expect(mw.RescanBlocksFromHeight(wallet.ID, 0)).To(BeNil())
+		queue, err := mw.RescanQueueRaw()
+		Expect(err).To(BeNil())
+		Expect(queue).To(HaveLen(1))
+		Expect(queue[0].WalletID).To(Equal(wallet.ID))
+		Expect(queue[0].Running).To(BeFalse())
+
+		By("Canceling a queued rescan")
+		Expect(mw.CancelQueuedRescan(queue[0].ID)).To(BeNil())
+		Expect(mw.CancelQueuedRescan(queue[0].ID)).To(MatchError(ErrNotExist))
+		Expect(mw.RescanQueueRaw()).To(BeEmpty())
+
+		By("Resuming the queue after a restart")
+		Expect(mw.RescanBlocksFromHeight(wallet.ID, 0)).To(BeNil())
+		mw.Shutdown()
+		mw, err = NewMultiWallet(rootDir, "", chain.Params().Name)
+		Expect(err).To(BeNil())
+		Expect(mw.OpenWallets(nil)).To(BeNil())
+		Expect(mw.RescanQueueRaw()).To(HaveLen(1))
+
+		events = mw.Subscribe(nil)
+		Expect(mw.SpvSync()).To(BeNil())
+		waitForEvent(events, SyncCompletedEvent)
+		event := waitForEvent(events, BlocksRescanEndedEvent)
+		Expect(event.WalletID).To(Equal(wallet.ID))
+		Expect(event.Err).To(BeNil())
+		Expect(mw.RescanQueueRaw()).To(BeEmpty())
+	})
+
+	It("rescans from a lower height requested for an interrupted rescan", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+		mw.CancelSync()
+
+		// Queued rescans only run while synced, the progress of an
+		// interrupted rescan is saved as it would be by rescanBlocks.
+		Expect(mw.RescanBlocksFromHeight(wallet.ID, 10)).To(BeNil())
+		queue, err := mw.RescanQueueRaw()
+		Expect(err).To(BeNil())
+		Expect(queue).To(HaveLen(1))
+		Expect(mw.db.UpdateField(queue[0], "ScannedThrough", int32(15))).To(Succeed())
+
+		Expect(mw.RescanBlocksFromHeight(wallet.ID, 5)).To(BeNil())
+		queue, err = mw.RescanQueueRaw()
+		Expect(err).To(BeNil())
+		Expect(queue).To(HaveLen(1))
+		Expect(queue[0].StartHeight).To(Equal(int32(5)))
+		Expect(queue[0].ScannedThrough).To(Equal(int32(-1)))
+
+		Expect(mw.SpvSync()).To(BeNil())
+		event := waitForEvent(events, BlocksRescanEndedEvent)
+		Expect(event.Err).To(BeNil())
+
+		diagnostics, err := mw.SyncDiagnosticsRaw()
+		Expect(err).To(BeNil())
+		var requested []*RescanDiagnostics
+		for _, rescan := range diagnostics.Wallets[0].RescanRanges {
+			if rescan.Requested {
+				requested = append(requested, rescan)
+			}
+		}
+		Expect(requested).To(HaveLen(1))
+		Expect(requested[0].StartHeight).To(Equal(int32(5)))
+		Expect(requested[0].ScannedThrough).To(Equal(int32(20)))
+	})
+
+	It("switches to a longer chain announced after a reorg", func() {
+		startSync(startPeer(spvtest.Honest))
+		waitForEvent(events, SyncCompletedEvent)
+		replacedBlock := chain.BlockHash(18)
+
+		_, err := chain.Reorg(3, 4)
+		Expect(err).To(BeNil())
+
+		Eventually(walletTipHash, spvTestTimeout).Should(Equal(chainTipHash()))
+		Expect(chain.BlockHash(18)).ToNot(Equal(replacedBlock))
+		Expect(wallet.GetBestBlock()).To(Equal(int32(21)))
+	})
+
+	It("completes the sync with other peers when a peer stalls", func() {
+		stalled := startPeer(spvtest.Stalled)
+		startSync(stalled, startPeer(spvtest.Honest))
+
+		waitForEvent(events, SyncCompletedEvent)
+		Expect(walletTipHash()).To(Equal(chainTipHash()))
+		Expect(stalled.Connected()).To(Equal(1))
+		Expect(stalled.ReceivedMessages(wire.CmdGetHeaders)).To(BeNumerically(">", 0))
+	})
+
+	It("restarts the sync when it stalls", func() {
+		stalled := startPeer(spvtest.Stalled)
+		createMultiWallet(stalled)
+		mw.SetIntConfigValueForKey(SyncStallTimeoutConfigKey, 1)
+		events = mw.Subscribe(nil)
+		Expect(mw.SpvSync()).To(BeNil())
+
+		event := waitForEvent(events, SyncStalledEvent)
+		Expect(event.SyncStall.SyncStage).To(Equal(int32(HeadersFetchSyncStage)))
+		Expect(event.SyncStall.RotatedPeers).To(ConsistOf(stalled.Addr()))
+		Expect(event.SyncStall.Retry).To(Equal(int32(1)))
+		Expect(event.SyncStall.RetryDelaySeconds).To(Equal(int64(2)))
+
+		event = waitForEvent(events, SyncStartedEvent)
+		Expect(event.Restart).To(BeTrue())
+		Eventually(stalled.Connections, spvTestTimeout).Should(Equal(2))
+
+		By("Backing off when the restarted sync stalls again")
+		event = waitForEvent(events, SyncStalledEvent)
+		Expect(event.SyncStall.Retry).To(Equal(int32(2)))
+		Expect(event.SyncStall.RetryDelaySeconds).To(Equal(int64(4)))
+	})
+
+	It("requires persistent peers on networks without seeders", func() {
+		createMultiWallet()
+		Expect(mw.SpvSync()).To(MatchError(ErrNoPeers))
+		Expect(mw.IsSyncing()).To(BeFalse())
+	})
+
+	It("bans peers that serve invalid cfilters in strict mode", func() {
+		honest := startPeer(spvtest.Honest)
+		misbehaving, err := spvtest.NewPeerOnHost(chain, spvtest.InvalidCFilters, "127.0.0.2")
+		Expect(err).To(BeNil())
+		peers = append(peers, misbehaving)
+
+		createMultiWallet(honest, misbehaving)
+		mw.SetBoolConfigValueForKey(StrictCFilterValidationConfigKey, true)
+		events = mw.Subscribe(nil)
+		Expect(mw.SpvSync()).To(BeNil())
+
+		waitForEvent(events, SyncCompletedEvent)
+		Expect(walletTipHash()).To(Equal(chainTipHash()))
+
+		bannedHosts := func() []string {
+			bans, err := mw.BannedPeersRaw()
+			Expect(err).To(BeNil())
+			hosts := make([]string, len(bans))
+			for i, ban := range bans {
+				hosts[i] = ban.Host
+			}
+			return hosts
+		}
+		Eventually(bannedHosts, spvTestTimeout).Should(ConsistOf("127.0.0.2"))
+
+		stats := mw.CFilterValidationStatsRaw()
+		Expect(stats.StrictValidation).To(BeTrue())
+		Expect(stats.ValidatedFilters).To(BeNumerically(">=", 20))
+		Expect(stats.InvalidResponses).To(BeNumerically(">", 0))
+		Expect(stats.BannedPeers).To(BeNumerically(">", 0))
+	})
+
+	It("resumes a sync paused for the data budget on the next day", func() {
+		untilNextDay := untilNextDataUsageDay
+		untilNextDataUsageDay = func() time.Duration { return 2 * time.Second }
+		defer func() { untilNextDataUsageDay = untilNextDay }()
+
+		honest := startPeer(spvtest.Honest)
+		createMultiWallet(honest)
+		Expect(mw.SetSyncDataBudget(1)).To(Succeed())
+		events = mw.Subscribe(nil)
+		Expect(mw.SpvSync()).To(BeNil())
+
+		waitForEvent(events, SyncDataBudgetExceededEvent)
+		Expect(mw.IsSyncing() || mw.IsSynced()).To(BeFalse())
+
+		// The budget of the new day is simulated by removing the budget.
+		Expect(mw.SetSyncDataBudget(0)).To(Succeed())
+		waitForEvent(events, SyncStartedEvent)
+		waitForEvent(events, SyncCompletedEvent)
+		Expect(walletTipHash()).To(Equal(chainTipHash()))
+	})
+
+	It("keeps persistent peers connected when their host is banned", func() {
+		honest := startPeer(spvtest.Honest)
+		startSync(honest)
+		waitForEvent(events, SyncCompletedEvent)
+
+		Expect(mw.BanPeer(honest.Addr(), 60)).To(Succeed())
+		Consistently(honest.Disconnects, time.Second).Should(BeZero())
+		Expect(mw.IsSynced()).To(BeTrue())
+	})
+
+	It("disconnects peers that serve invalid cfilters", func() {
+		misbehaving := startPeer(spvtest.InvalidCFilters)
+		startSync(misbehaving)
+
+		Eventually(misbehaving.Disconnects, spvTestTimeout).Should(BeNumerically(">", 0))
+		Expect(mw.IsSynced()).To(BeFalse())
+		Expect(wallet.GetBestBlock()).To(Equal(int32(0)))
+	})
+})
@@ -0,0 +1,471 @@
+// Package spvtest provides an in-process simulated Decred network for testing
+// SPV syncing without connecting to real peers. A Chain holds a synthetic
+// chain of valid headers, cfilters, blocks and mempool transactions, and a
+// Peer serves the chain to SPV clients over loopback TCP.
+package spvtest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/blockchain/stake/v3"
+	blockchain "github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/gcs/v2"
+	"github.com/decred/dcrd/gcs/v2/blockcf2"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// opTrueScript is the output script of the coinbase outputs and the
+// previous outputs spent by the payments created by the chain.
+var opTrueScript = []byte{txscript.OP_TRUE}
+
+// block is a block of the chain with its version 2 cfilter.
+type block struct {
+	msg    *wire.MsgBlock
+	hash   chainhash.Hash
+	filter *gcs.FilterV2
+}
+
+// Chain is a synthetic block chain. Blocks are mined with the minimum
+// difficulty of the network and commit to their cfilters as described by
+// DCP0005, so they pass the header, cfilter and merkle root checks done by
+// SPV wallets. The blocks hold no votes, so only networks that allow blocks
+// without votes, such as simnet, are supported. Tickets can be mined like
+// other transactions and are placed in the stake tree.
+//
+// Blocks and mempool transactions added to the chain are announced to the
+// SPV clients connected to the peers serving the chain.
+type Chain struct {
+	params *chaincfg.Params
+
+	mu          sync.RWMutex
+	mainChain   []*block // indexed by height
+	blocks      map[chainhash.Hash]*block
+	mempool     map[chainhash.Hash]*wire.MsgTx
+	prevScripts prevScripts
+	mined       uint32
+	payments    uint32
+	peers       map[*Peer]struct{}
+}
+
+// NewChain returns a chain that holds only the genesis block of params.
+func NewChain(params *chaincfg.Params) *Chain {
+	genesis := &block{
+		msg:  params.GenesisBlock,
+		hash: params.GenesisHash,
+	}
+
+	return &Chain{
+		params:      params,
+		mainChain:   []*block{genesis},
+		blocks:      map[chainhash.Hash]*block{genesis.hash: genesis},
+		mempool:     make(map[chainhash.Hash]*wire.MsgTx),
+		prevScripts: make(prevScripts),
+		peers:       make(map[*Peer]struct{}),
+	}
+}
+
+// Params returns the network parameters of the chain.
+func (c *Chain) Params() *chaincfg.Params {
+	return c.params
+}
+
+// Tip returns the hash and height of the main chain tip.
+func (c *Chain) Tip() (chainhash.Hash, int32) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	tip := c.mainChain[len(c.mainChain)-1]
+	return tip.hash, int32(len(c.mainChain) - 1)
+}
+
+// BlockHash returns the hash of the main chain block at height or nil if
+// the main chain does not have a block at that height.
+func (c *Chain) BlockHash(height int32) *chainhash.Hash {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if height < 0 || int(height) >= len(c.mainChain) {
+		return nil
+	}
+	hash := c.mainChain[height].hash
+	return &hash
+}
+
+// TxHeight returns the height of the main chain block that mined the
+// transaction with hash, or false if no main chain block has it.
+func (c *Chain) TxHeight(hash *chainhash.Hash) (int32, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for height, b := range c.mainChain {
+		for _, txs := range [][]*wire.MsgTx{b.msg.Transactions, b.msg.STransactions} {
+			for _, tx := range txs {
+				if tx.TxHash() == *hash {
+					return int32(height), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// GenerateBlocks mines count blocks on top of the main chain tip. The txs,
+// which are removed from the mempool together with the mempool transactions
+// that double spend them, are included in the first mined block. The new blocks are announced to all connected clients.
+func (c *Chain) GenerateBlocks(count int, txs ...*wire.MsgTx) ([]*wire.MsgBlock, error) {
+	if count < 1 {
+		return nil, errors.New("at least one block must be generated")
+	}
+
+	c.mu.Lock()
+	blocks, err := c.extendChain(len(c.mainChain)-1, count, txs)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	c.announceBlocks(blocks)
+	return blocks, nil
+}
+
+// Reorg replaces the last depth blocks of the main chain with count newly
+// mined blocks, count must be greater than depth for the new blocks to
+// become the best chain. The transactions of the replaced blocks are not
+// mined again. The new blocks are announced to all connected clients.
+func (c *Chain) Reorg(depth, count int) ([]*wire.MsgBlock, error) {
+	c.mu.Lock()
+	if depth < 1 || depth >= len(c.mainChain) {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("invalid reorg depth %d", depth)
+	}
+	if count <= depth {
+		c.mu.Unlock()
+		return nil, errors.New("reorged chain must be longer than the replaced chain")
+	}
+
+	blocks, err := c.extendChain(len(c.mainChain)-1-depth, count, nil)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	c.announceBlocks(blocks)
+	return blocks, nil
+}
+
+// AddMempoolTx adds tx to the mempool and announces it to all connected
+// clients.
+func (c *Chain) AddMempoolTx(tx *wire.MsgTx) {
+	txHash := tx.TxHash()
+
+	c.mu.Lock()
+	_, exists := c.mempool[txHash]
+	c.mempool[txHash] = tx
+	c.mu.Unlock()
+
+	if !exists {
+		c.announceTx(&txHash)
+	}
+}
+
+// HoldMempoolTx adds tx to the mempool without announcing it, as when a
+// transaction was not yet relayed to the clients.
+func (c *Chain) HoldMempoolTx(tx *wire.MsgTx) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mempool[tx.TxHash()] = tx
+}
+
+// MempoolTx returns the mempool transaction with hash or nil if the mempool
+// does not have the transaction.
+func (c *Chain) MempoolTx(hash *chainhash.Hash) *wire.MsgTx {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.mempool[*hash]
+}
+
+// RemoveMempoolTx removes the transaction with hash from the mempool
+// without announcing anything, as when a full node evicts a transaction.
+func (c *Chain) RemoveMempoolTx(hash *chainhash.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.mempool, *hash)
+}
+
+// PayToAddress returns a transaction that pays amount atoms to address. The
+// transaction spends an output that only exists for the chain, it is only
+// valid for SPV clients which do not check transaction inputs.
+func (c *Chain) PayToAddress(address string, amount int64) (*wire.MsgTx, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The funding outpoints are unique as the counter is part of the hash.
+	c.payments++
+	var fundingHash chainhash.Hash
+	binary.LittleEndian.PutUint32(fundingHash[:], c.payments)
+	fundingHash[31] = 0xff
+	prevOut := wire.NewOutPoint(&fundingHash, 0, wire.TxTreeRegular)
+	c.prevScripts[*prevOut] = opTrueScript
+
+	return c.payFromOutPoint(prevOut, address, amount)
+}
+
+// DoubleSpend returns a transaction that spends the first input of tx to pay
+// amount atoms to address. Mining it invalidates tx.
+func (c *Chain) DoubleSpend(tx *wire.MsgTx, address string, amount int64) (*wire.MsgTx, error) {
+	if len(tx.TxIn) == 0 {
+		return nil, errors.New("transaction has no inputs")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prevOut := tx.TxIn[0].PreviousOutPoint
+	return c.payFromOutPoint(&prevOut, address, amount)
+}
+
+// payFromOutPoint returns a transaction that spends prevOut to pay amount
+// atoms to address.
+func (c *Chain) payFromOutPoint(prevOut *wire.OutPoint, address string, amount int64) (*wire.MsgTx, error) {
+	addr, err := dcrutil.DecodeAddress(address, c.params)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(prevOut, amount, nil))
+	tx.AddTxOut(wire.NewTxOut(amount, pkScript))
+	return tx, nil
+}
+
+// prevScripts maps the previous outputs spent by the transactions of the
+// chain to their output scripts. It implements the blockcf2.PrevScripter
+// interface.
+type prevScripts map[wire.OutPoint][]byte
+
+func (scripts prevScripts) PrevScript(prevOut *wire.OutPoint) (uint16, []byte, bool) {
+	script, ok := scripts[*prevOut]
+	return 0, script, ok
+}
+
+// addOutputs adds the outputs of tx so that the transactions spending them
+// can be mined.
+func (scripts prevScripts) addOutputs(tx *wire.MsgTx) {
+	tree := wire.TxTreeRegular
+	if stake.DetermineTxType(tx, true) != stake.TxTypeRegular {
+		tree = wire.TxTreeStake
+	}
+
+	txHash := tx.TxHash()
+	for i, txOut := range tx.TxOut {
+		scripts[*wire.NewOutPoint(&txHash, uint32(i), tree)] = txOut.PkScript
+	}
+}
+
+// extendChain mines count blocks on top of the main chain block at height
+// and makes them the main chain tip. The caller must hold the write lock.
+func (c *Chain) extendChain(height, count int, txs []*wire.MsgTx) ([]*wire.MsgBlock, error) {
+	newChain := append([]*block(nil), c.mainChain[:height+1]...)
+	blocks := make([]*wire.MsgBlock, 0, count)
+	for i := 0; i < count; i++ {
+		b, err := c.mineBlock(newChain[len(newChain)-1], txs)
+		if err != nil {
+			return nil, err
+		}
+		txs = nil
+
+		c.blocks[b.hash] = b
+		newChain = append(newChain, b)
+		blocks = append(blocks, b.msg)
+
+		for _, tx := range b.msg.Transactions[1:] {
+			c.removeMinedMempoolTx(tx)
+		}
+		for _, tx := range b.msg.STransactions {
+			c.removeMinedMempoolTx(tx)
+		}
+	}
+
+	c.mainChain = newChain
+	return blocks, nil
+}
+
+// removeMinedMempoolTx removes the mined tx and the transactions that spend
+// the same outputs from the mempool. The caller must hold the write lock.
+func (c *Chain) removeMinedMempoolTx(tx *wire.MsgTx) {
+	delete(c.mempool, tx.TxHash())
+
+	spent := make(map[wire.OutPoint]struct{}, len(tx.TxIn))
+	for _, in := range tx.TxIn {
+		spent[in.PreviousOutPoint] = struct{}{}
+	}
+	for hash, mempoolTx := range c.mempool {
+		for _, in := range mempoolTx.TxIn {
+			if _, ok := spent[in.PreviousOutPoint]; ok {
+				delete(c.mempool, hash)
+				break
+			}
+		}
+	}
+}
+
+// mineBlock returns a block with the txs on top of parent. Stake
+// transactions are mined in the stake tree. The caller must hold the write
+// lock.
+func (c *Chain) mineBlock(parent *block, txs []*wire.MsgTx) (*block, error) {
+	height := parent.msg.Header.Height + 1
+
+	// Blocks are spaced at twice the target time so that the required work
+	// difficulty never rises above the minimum difficulty of the network.
+	timestamp := parent.msg.Header.Timestamp.Add(2 * c.params.TargetTimePerBlock)
+
+	// The coinbase commits to a counter of the mined blocks so that the
+	// blocks of competing chains at the same height have different hashes.
+	c.mined++
+	coinbaseScript := make([]byte, 8)
+	binary.LittleEndian.PutUint32(coinbaseScript, height)
+	binary.LittleEndian.PutUint32(coinbaseScript[4:], c.mined)
+	coinbase := wire.NewMsgTx()
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex, wire.TxTreeRegular),
+		Sequence:         wire.MaxTxInSequenceNum,
+		BlockHeight:      wire.NullBlockHeight,
+		BlockIndex:       wire.NullBlockIndex,
+		SignatureScript:  coinbaseScript,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(0, opTrueScript))
+
+	msg := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:      parent.msg.Header.Version,
+			PrevBlock:    parent.hash,
+			VoteBits:     1,
+			Bits:         c.params.PowLimitBits,
+			SBits:        c.params.MinimumStakeDiff,
+			Height:       height,
+			Timestamp:    time.Unix(timestamp.Unix(), 0),
+			StakeVersion: parent.msg.Header.StakeVersion,
+		},
+		Transactions:  []*wire.MsgTx{coinbase},
+		STransactions: []*wire.MsgTx{},
+	}
+	for _, tx := range txs {
+		if stake.DetermineTxType(tx, true) == stake.TxTypeRegular {
+			msg.Transactions = append(msg.Transactions, tx)
+		} else {
+			msg.STransactions = append(msg.STransactions, tx)
+		}
+		c.prevScripts.addOutputs(tx)
+	}
+	msg.Header.MerkleRoot = blockchain.CalcCombinedTxTreeMerkleRoot(msg.Transactions, msg.STransactions)
+
+	// The filter is the only leaf of the header commitments, so the stake
+	// root is the filter hash and the inclusion proof is empty.
+	filter, err := blockcf2.Regular(msg, c.prevScripts)
+	if err != nil {
+		return nil, err
+	}
+	msg.Header.StakeRoot = filter.Hash()
+
+	for {
+		hash := msg.Header.BlockHash()
+		err := blockchain.CheckProofOfWork(&hash, msg.Header.Bits, c.params.PowLimit)
+		if err == nil {
+			return &block{msg: msg, hash: hash, filter: filter}, nil
+		}
+		msg.Header.Nonce++
+	}
+}
+
+// locateHeaders returns the headers of the main chain blocks after the first
+// locator hash found in the main chain, up to and including hashStop or the
+// maximum number of headers of a headers message.
+func (c *Chain) locateHeaders(locators []*chainhash.Hash, hashStop *chainhash.Hash) []*wire.BlockHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	start := 1
+	for _, locator := range locators {
+		b, ok := c.blocks[*locator]
+		if !ok {
+			continue
+		}
+		height := int(b.msg.Header.Height)
+		if height < len(c.mainChain) && c.mainChain[height] == b {
+			start = height + 1
+			break
+		}
+	}
+
+	var headers []*wire.BlockHeader
+	for height := start; height < len(c.mainChain) && len(headers) < wire.MaxBlockHeadersPerMsg; height++ {
+		b := c.mainChain[height]
+		header := b.msg.Header
+		headers = append(headers, &header)
+		if b.hash == *hashStop {
+			break
+		}
+	}
+	return headers
+}
+
+// lookupBlock returns the block with hash from the main chain or side chains.
+func (c *Chain) lookupBlock(hash *chainhash.Hash) (*block, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	b, ok := c.blocks[*hash]
+	return b, ok
+}
+
+// addPeer registers p to receive the announcements of new blocks and
+// mempool transactions.
+func (c *Chain) addPeer(p *Peer) {
+	c.mu.Lock()
+	c.peers[p] = struct{}{}
+	c.mu.Unlock()
+}
+
+func (c *Chain) removePeer(p *Peer) {
+	c.mu.Lock()
+	delete(c.peers, p)
+	c.mu.Unlock()
+}
+
+func (c *Chain) currentPeers() []*Peer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	peers := make([]*Peer, 0, len(c.peers))
+	for p := range c.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func (c *Chain) announceBlocks(blocks []*wire.MsgBlock) {
+	for _, p := range c.currentPeers() {
+		p.announceBlocks(blocks)
+	}
+}
+
+func (c *Chain) announceTx(txHash *chainhash.Hash) {
+	for _, p := range c.currentPeers() {
+		p.announceTx(txHash)
+	}
+}
@@ -0,0 +1,432 @@
+package spvtest
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/gcs/v2"
+	"github.com/decred/dcrd/gcs/v2/blockcf2"
+	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/p2p"
+)
+
+// Misbehavior describes how a Peer deviates from the behavior of an honest
+// full node.
+type Misbehavior int
+
+const (
+	// Honest peers serve the chain as a full node would.
+	Honest Misbehavior = iota
+
+	// Stalled peers complete the handshake and never respond to any
+	// message afterwards.
+	Stalled
+
+	// InvalidCFilters peers serve cfilters that are not committed to by
+	// the block headers.
+	InvalidCFilters
+)
+
+// handshakeTimeout is the time allowed to each handshake message.
+const handshakeTimeout = 5 * time.Second
+
+// peerServices are the services advertised by peers. SPV clients require
+// both.
+const peerServices = wire.SFNodeNetwork | wire.SFNodeCF
+
+// Peer is a simulated full node that serves a Chain to the SPV clients that
+// connect to it over loopback TCP.
+type Peer struct {
+	chain       *Chain
+	misbehavior Misbehavior
+	listener    net.Listener
+
+	mu            sync.Mutex
+	conns         map[*peerConn]struct{}
+	connections   int
+	disconnects   int
+	receivedMsgs  map[string]int
+	closed        bool
+	connectionsWg sync.WaitGroup
+}
+
+// peerConn is the connection of a Peer to a client.
+type peerConn struct {
+	peer *Peer
+	conn net.Conn
+
+	writeMu     sync.Mutex
+	mu          sync.Mutex
+	sendHeaders bool
+}
+
+// NewPeer starts a peer that serves chain with the given misbehavior on a
+// random loopback port. The peer must be closed with Close.
+func NewPeer(chain *Chain, misbehavior Misbehavior) (*Peer, error) {
+	return NewPeerOnHost(chain, misbehavior, "127.0.0.1")
+}
+
+// NewPeerOnHost starts a peer like NewPeer that listens on host, which must
+// be a loopback address. Peers on different hosts can be banned separately.
+func NewPeerOnHost(chain *Chain, misbehavior Misbehavior, host string) (*Peer, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Peer{
+		chain:        chain,
+		misbehavior:  misbehavior,
+		listener:     listener,
+		conns:        make(map[*peerConn]struct{}),
+		receivedMsgs: make(map[string]int),
+	}
+	chain.addPeer(p)
+
+	go p.acceptConnections()
+	return p, nil
+}
+
+// Addr returns the address to which SPV clients connect.
+func (p *Peer) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Connected returns the number of currently connected clients.
+func (p *Peer) Connected() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Connections returns the number of client connections that completed the
+// handshake since the peer was started.
+func (p *Peer) Connections() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connections
+}
+
+// Disconnects returns the number of client connections that completed the
+// handshake and were closed by the client since the peer was started.
+func (p *Peer) Disconnects() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.disconnects
+}
+
+// ReceivedMessages returns the number of messages with command received
+// from all clients after the handshake.
+func (p *Peer) ReceivedMessages(command string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.receivedMsgs[command]
+}
+
+// Close stops accepting connections and disconnects all clients.
+func (p *Peer) Close() error {
+	p.chain.removePeer(p)
+
+	p.mu.Lock()
+	p.closed = true
+	for pc := range p.conns {
+		pc.conn.Close()
+	}
+	p.mu.Unlock()
+
+	err := p.listener.Close()
+	p.connectionsWg.Wait()
+	return err
+}
+
+func (p *Peer) acceptConnections() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+		p.connectionsWg.Add(1)
+		p.mu.Unlock()
+
+		go func() {
+			defer p.connectionsWg.Done()
+			p.serve(conn)
+		}()
+	}
+}
+
+// serve performs the handshake with the client of conn and responds to its
+// messages until either side closes the connection.
+func (p *Peer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	pc := &peerConn{peer: p, conn: conn}
+	if err := pc.handshake(); err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.conns[pc] = struct{}{}
+	p.connections++
+	p.mu.Unlock()
+
+	// Messages are handled until the client disconnects unless the peer
+	// is closed first.
+	pc.handleMessages()
+
+	p.mu.Lock()
+	delete(p.conns, pc)
+	if !p.closed {
+		p.disconnects++
+	}
+	p.mu.Unlock()
+}
+
+func (pc *peerConn) handshake() error {
+	pc.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer pc.conn.SetReadDeadline(time.Time{})
+
+	msg, _, err := wire.ReadMessage(pc.conn, p2p.Pver, pc.peer.chain.params.Net)
+	if err != nil {
+		return err
+	}
+	if _, ok := msg.(*wire.MsgVersion); !ok {
+		return errors.New("first received message was not the version message")
+	}
+
+	me, err := wire.NewNetAddress(pc.conn.LocalAddr(), peerServices)
+	if err != nil {
+		return err
+	}
+	you, err := wire.NewNetAddress(pc.conn.RemoteAddr(), 0)
+	if err != nil {
+		return err
+	}
+	nonce, err := wire.RandomUint64()
+	if err != nil {
+		return err
+	}
+	_, tipHeight := pc.peer.chain.Tip()
+	version := wire.NewMsgVersion(me, you, nonce, tipHeight)
+	version.ProtocolVersion = int32(p2p.Pver)
+	version.Services = peerServices
+	version.AddUserAgent("spvtest", "1.0.0")
+
+	if err = pc.write(version); err != nil {
+		return err
+	}
+	if err = pc.write(wire.NewMsgVerAck()); err != nil {
+		return err
+	}
+
+	msg, _, err = wire.ReadMessage(pc.conn, p2p.Pver, pc.peer.chain.params.Net)
+	if err != nil {
+		return err
+	}
+	if _, ok := msg.(*wire.MsgVerAck); !ok {
+		return errors.New("did not receive verack")
+	}
+	return nil
+}
+
+// handleMessages responds to the messages received from the client until
+// reading fails.
+func (pc *peerConn) handleMessages() error {
+	for {
+		msg, _, err := wire.ReadMessage(pc.conn, p2p.Pver, pc.peer.chain.params.Net)
+		if err != nil {
+			return err
+		}
+
+		pc.peer.mu.Lock()
+		pc.peer.receivedMsgs[msg.Command()]++
+		pc.peer.mu.Unlock()
+
+		if pc.peer.misbehavior == Stalled {
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *wire.MsgGetHeaders:
+			err = pc.handleGetHeaders(msg)
+		case *wire.MsgGetCFilterV2:
+			err = pc.handleGetCFilterV2(msg)
+		case *wire.MsgGetData:
+			err = pc.handleGetData(msg)
+		case *wire.MsgInv:
+			err = pc.handleInv(msg)
+		case *wire.MsgTx:
+			pc.peer.chain.AddMempoolTx(msg)
+		case *wire.MsgSendHeaders:
+			pc.mu.Lock()
+			pc.sendHeaders = true
+			pc.mu.Unlock()
+		case *wire.MsgGetAddr:
+			err = pc.write(wire.NewMsgAddr())
+		case *wire.MsgPing:
+			err = pc.write(wire.NewMsgPong(msg.Nonce))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (pc *peerConn) handleGetHeaders(msg *wire.MsgGetHeaders) error {
+	headers := pc.peer.chain.locateHeaders(msg.BlockLocatorHashes, &msg.HashStop)
+	reply := wire.NewMsgHeaders()
+	for _, header := range headers {
+		if err := reply.AddBlockHeader(header); err != nil {
+			return err
+		}
+	}
+	return pc.write(reply)
+}
+
+// handleGetCFilterV2 sends the cfilter of the requested block. Requests for
+// unknown blocks are ignored as there is no notfound reply for cfilters.
+func (pc *peerConn) handleGetCFilterV2(msg *wire.MsgGetCFilterV2) error {
+	b, ok := pc.peer.chain.lookupBlock(&msg.BlockHash)
+	if !ok || b.filter == nil {
+		return nil
+	}
+
+	filter := b.filter
+	if pc.peer.misbehavior == InvalidCFilters {
+		var key [gcs.KeySize]byte
+		var err error
+		filter, err = gcs.NewFilterV2(blockcf2.B, blockcf2.M, key, [][]byte{b.hash[:]})
+		if err != nil {
+			return err
+		}
+	}
+
+	return pc.write(wire.NewMsgCFilterV2(&b.hash, filter.Bytes(), 0, nil))
+}
+
+// handleGetData sends the requested blocks and mempool transactions and a
+// notfound message for those that are not known.
+func (pc *peerConn) handleGetData(msg *wire.MsgGetData) error {
+	notFound := wire.NewMsgNotFound()
+	for _, inv := range msg.InvList {
+		var reply wire.Message
+		switch inv.Type {
+		case wire.InvTypeBlock:
+			if b, ok := pc.peer.chain.lookupBlock(&inv.Hash); ok {
+				reply = b.msg
+			}
+		case wire.InvTypeTx:
+			if tx := pc.peer.chain.MempoolTx(&inv.Hash); tx != nil {
+				reply = tx
+			}
+		}
+
+		if reply == nil {
+			if err := notFound.AddInvVect(inv); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := pc.write(reply); err != nil {
+			return err
+		}
+	}
+
+	if len(notFound.InvList) == 0 {
+		return nil
+	}
+	return pc.write(notFound)
+}
+
+// handleInv requests the announced transactions that are not in the
+// mempool.
+func (pc *peerConn) handleInv(msg *wire.MsgInv) error {
+	getData := wire.NewMsgGetData()
+	for _, inv := range msg.InvList {
+		if inv.Type != wire.InvTypeTx || pc.peer.chain.MempoolTx(&inv.Hash) != nil {
+			continue
+		}
+		if err := getData.AddInvVect(inv); err != nil {
+			return err
+		}
+	}
+
+	if len(getData.InvList) == 0 {
+		return nil
+	}
+	return pc.write(getData)
+}
+
+func (pc *peerConn) write(msg wire.Message) error {
+	pc.writeMu.Lock()
+	defer pc.writeMu.Unlock()
+	return wire.WriteMessage(pc.conn, msg, p2p.Pver, pc.peer.chain.params.Net)
+}
+
+// announceBlocks announces blocks to all clients, with a headers message to
+// the clients that requested header announcements and an inv message to the
+// others.
+func (p *Peer) announceBlocks(blocks []*wire.MsgBlock) {
+	if p.misbehavior == Stalled {
+		return
+	}
+
+	headers := wire.NewMsgHeaders()
+	inv := wire.NewMsgInv()
+	for _, block := range blocks {
+		header := block.Header
+		headers.AddBlockHeader(&header)
+		blockHash := header.BlockHash()
+		inv.AddInvVect(wire.NewInvVect(wire.InvTypeBlock, &blockHash))
+	}
+
+	for _, pc := range p.currentConns() {
+		pc.mu.Lock()
+		sendHeaders := pc.sendHeaders
+		pc.mu.Unlock()
+
+		if sendHeaders {
+			pc.write(headers)
+		} else {
+			pc.write(inv)
+		}
+	}
+}
+
+// announceTx announces a mempool transaction to all clients.
+func (p *Peer) announceTx(txHash *chainhash.Hash) {
+	if p.misbehavior == Stalled {
+		return
+	}
+
+	inv := wire.NewMsgInv()
+	inv.AddInvVect(wire.NewInvVect(wire.InvTypeTx, txHash))
+	for _, pc := range p.currentConns() {
+		pc.write(inv)
+	}
+}
+
+func (p *Peer) currentConns() []*peerConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := make([]*peerConn, 0, len(p.conns))
+	for pc := range p.conns {
+		conns = append(conns, pc)
+	}
+	return conns
+}
@@ -0,0 +1,180 @@
+package dcrlibwallet
+
+import (
+	"encoding/json"
+
+	"github.com/planetdecred/dcrlibwallet/txhelper"
+	"github.com/planetdecred/dcrlibwallet/txindex"
+)
+
+func (wallet *Wallet) Statistics() (string, error) {
+	stats, err := wallet.StatisticsRaw()
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedStats, err := json.Marshal(stats)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedStats), nil
+}
+
+// StatisticsRaw summarizes the activity of this wallet using the
+// transactions saved in the tx index database. Transactions must have been
+// indexed (i.e. the wallet must have been synced at least once) for the
+// summary to be accurate.
+func (wallet *Wallet) StatisticsRaw() (*WalletStatistics, error) {
+	var transactions []Transaction
+	err := wallet.txDB.Read(0, 0, txindex.TxFilterAll, false, &transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := newWalletStatistics()
+	stats.WalletID = wallet.ID
+	for i := range transactions {
+		stats.addTransaction(&transactions[i])
+	}
+	stats.computeAverages()
+
+	return stats, nil
+}
+
+func (mw *MultiWallet) Statistics() (string, error) {
+	stats, err := mw.StatisticsRaw()
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedStats, err := json.Marshal(stats)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedStats), nil
+}
+
+// StatisticsRaw returns the combined activity summary of all opened wallets.
+func (mw *MultiWallet) StatisticsRaw() (*WalletStatistics, error) {
+	rollup := newWalletStatistics()
+	rollup.WalletID = -1
+
+	for _, wallet := range mw.wallets {
+		if !wallet.WalletOpened() {
+			continue
+		}
+
+		stats, err := wallet.StatisticsRaw()
+		if err != nil {
+			return nil, err
+		}
+
+		rollup.merge(stats)
+	}
+	rollup.computeAverages()
+
+	return rollup, nil
+}
+
+func newWalletStatistics() *WalletStatistics {
+	return &WalletStatistics{
+		Regular:     &TxTypeStatistics{},
+		Coinbase:    &TxTypeStatistics{},
+		Tickets:     &TxTypeStatistics{},
+		Votes:       &TxTypeStatistics{},
+		Revocations: &TxTypeStatistics{},
+	}
+}
+
+func (stats *WalletStatistics) typeStatistics(txType string) *TxTypeStatistics {
+	switch txType {
+	case txhelper.TxTypeCoinBase:
+		return stats.Coinbase
+	case txhelper.TxTypeTicketPurchase:
+		return stats.Tickets
+	case txhelper.TxTypeVote:
+		return stats.Votes
+	case txhelper.TxTypeRevocation:
+		return stats.Revocations
+	default:
+		return stats.Regular
+	}
+}
+
+func (stats *WalletStatistics) addTransaction(tx *Transaction) {
+	stats.TransactionCount++
+
+	typeStats := stats.typeStatistics(tx.Type)
+	typeStats.Count++
+	switch {
+	case tx.Type == txhelper.TxTypeTicketPurchase:
+		typeStats.TotalSent += tx.Amount
+	case tx.Type != txhelper.TxTypeRegular, tx.Direction == txhelper.TxDirectionReceived:
+		typeStats.TotalReceived += tx.Amount
+	case tx.Direction == txhelper.TxDirectionSent:
+		typeStats.TotalSent += tx.Amount
+	}
+
+	// The fee is only paid by this wallet if at least one of the
+	// tx inputs was spent from one of the wallet's accounts.
+	for _, input := range tx.Inputs {
+		if input.AccountNumber > -1 {
+			stats.TotalFees += tx.Fee
+			break
+		}
+	}
+
+	switch tx.Type {
+	case txhelper.TxTypeVote:
+		stats.TotalVoteRewards += tx.VoteReward
+		stats.totalDaysToVote += int64(tx.DaysToVoteOrRevoke)
+	case txhelper.TxTypeRevocation:
+		stats.totalDaysToRevoke += int64(tx.DaysToVoteOrRevoke)
+	}
+
+	if stats.FirstActivity == 0 || tx.Timestamp < stats.FirstActivity {
+		stats.FirstActivity = tx.Timestamp
+	}
+	if tx.Timestamp > stats.LastActivity {
+		stats.LastActivity = tx.Timestamp
+	}
+}
+
+func (stats *WalletStatistics) merge(other *WalletStatistics) {
+	stats.TransactionCount += other.TransactionCount
+
+	for _, txType := range []string{txhelper.TxTypeRegular, txhelper.TxTypeCoinBase,
+		txhelper.TxTypeTicketPurchase, txhelper.TxTypeVote, txhelper.TxTypeRevocation} {
+
+		typeStats, otherTypeStats := stats.typeStatistics(txType), other.typeStatistics(txType)
+		typeStats.Count += otherTypeStats.Count
+		typeStats.TotalSent += otherTypeStats.TotalSent
+		typeStats.TotalReceived += otherTypeStats.TotalReceived
+	}
+
+	stats.TotalFees += other.TotalFees
+	stats.TotalVoteRewards += other.TotalVoteRewards
+	stats.totalDaysToVote += other.totalDaysToVote
+	stats.totalDaysToRevoke += other.totalDaysToRevoke
+
+	if other.FirstActivity != 0 && (stats.FirstActivity == 0 || other.FirstActivity < stats.FirstActivity) {
+		stats.FirstActivity = other.FirstActivity
+	}
+	if other.LastActivity > stats.LastActivity {
+		stats.LastActivity = other.LastActivity
+	}
+}
+
+func (stats *WalletStatistics) computeAverages() {
+	stats.VoteCount = stats.Votes.Count
+	stats.RevocationCount = stats.Revocations.Count
+
+	if stats.VoteCount > 0 {
+		stats.AverageDaysToVote = float64(stats.totalDaysToVote) / float64(stats.VoteCount)
+	}
+	if stats.RevocationCount > 0 {
+		stats.AverageDaysToRevoke = float64(stats.totalDaysToRevoke) / float64(stats.RevocationCount)
+	}
+}
@@ -0,0 +1,115 @@
+package dcrlibwallet
+
+import (
+	"github.com/planetdecred/dcrlibwallet/txhelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func voteTx(hash, date string, reward int64, daysToVote int32) Transaction {
+	return Transaction{
+		Hash:               hash,
+		Type:               txhelper.TxTypeVote,
+		Timestamp:          unixDate(date),
+		Amount:             reward,
+		VoteReward:         reward,
+		DaysToVoteOrRevoke: daysToVote,
+		TicketSpentHash:    hash + "-ticket",
+	}
+}
+
+var _ = Describe("Statistics", func() {
+	It("summarizes transactions by type", func() {
+		stats := newWalletStatistics()
+		transactions := []Transaction{
+			receiveTx("a", "2020-01-01", dcrAtoms(10)),
+			sendTx("b", "2020-02-01", dcrAtoms(10), dcrAtoms(4), 1000),
+			voteTx("c", "2020-03-01", dcrAtoms(1), 10),
+			voteTx("d", "2020-04-01", dcrAtoms(1), 20),
+		}
+		transfer := receiveTx("e", "2020-02-15", dcrAtoms(3))
+		transfer.Direction = txhelper.TxDirectionTransferred
+		transactions = append(transactions, transfer)
+		for i := range transactions {
+			stats.addTransaction(&transactions[i])
+		}
+		stats.computeAverages()
+
+		Expect(stats.TransactionCount).To(Equal(int32(5)))
+		Expect(stats.Regular.Count).To(Equal(int32(3)))
+		Expect(stats.Regular.TotalSent).To(Equal(dcrAtoms(4)))
+		Expect(stats.Regular.TotalReceived).To(Equal(dcrAtoms(10)))
+		Expect(stats.Votes.Count).To(Equal(int32(2)))
+		Expect(stats.Votes.TotalReceived).To(Equal(dcrAtoms(2)))
+		Expect(stats.VoteCount).To(Equal(int32(2)))
+		Expect(stats.TotalVoteRewards).To(Equal(dcrAtoms(2)))
+		Expect(stats.AverageDaysToVote).To(Equal(15.0))
+		Expect(stats.RevocationCount).To(BeZero())
+		Expect(stats.AverageDaysToRevoke).To(BeZero())
+		Expect(stats.FirstActivity).To(Equal(unixDate("2020-01-01")))
+		Expect(stats.LastActivity).To(Equal(unixDate("2020-04-01")))
+	})
+
+	It("only counts the fees of transactions that spend from the wallet", func() {
+		stats := newWalletStatistics()
+		received := receiveTx("a", "2020-01-01", dcrAtoms(10))
+		received.Fee = 2000
+		received.Inputs = []*TxInput{{Amount: dcrAtoms(11), AccountNumber: -1}}
+		sent := sendTx("b", "2020-02-01", dcrAtoms(10), dcrAtoms(4), 1000)
+		stats.addTransaction(&received)
+		stats.addTransaction(&sent)
+
+		Expect(stats.TotalFees).To(Equal(int64(1000)))
+	})
+
+	It("merges the summaries of several wallets", func() {
+		first, second := newWalletStatistics(), newWalletStatistics()
+		firstVote := voteTx("a", "2020-03-01", dcrAtoms(1), 10)
+		secondVote := voteTx("b", "2020-01-01", dcrAtoms(2), 30)
+		first.addTransaction(&firstVote)
+		second.addTransaction(&secondVote)
+
+		rollup := newWalletStatistics()
+		rollup.merge(first)
+		rollup.merge(second)
+		rollup.computeAverages()
+
+		Expect(rollup.TransactionCount).To(Equal(int32(2)))
+		Expect(rollup.Votes.TotalReceived).To(Equal(dcrAtoms(3)))
+		Expect(rollup.AverageDaysToVote).To(Equal(20.0))
+		Expect(rollup.FirstActivity).To(Equal(unixDate("2020-01-01")))
+		Expect(rollup.LastActivity).To(Equal(unixDate("2020-03-01")))
+	})
+
+	It("summarizes the transactions saved in the tx index", func() {
+		mw, wallet, cleanup := createTestMultiWallet("simnet")
+		defer cleanup()
+
+		stats, err := wallet.StatisticsRaw()
+		Expect(err).To(BeNil())
+		Expect(stats.WalletID).To(Equal(wallet.ID))
+		Expect(stats.TransactionCount).To(BeZero())
+
+		vote := voteTx("a", "2020-03-01", dcrAtoms(1), 10)
+		received := receiveTx("b", "2020-01-01", dcrAtoms(5))
+		for _, tx := range []*Transaction{&vote, &received} {
+			_, err = wallet.txDB.SaveOrUpdate(&Transaction{}, tx)
+			Expect(err).To(BeNil())
+		}
+
+		stats, err = wallet.StatisticsRaw()
+		Expect(err).To(BeNil())
+		Expect(stats.TransactionCount).To(Equal(int32(2)))
+		Expect(stats.VoteCount).To(Equal(int32(1)))
+
+		rollup, err := mw.StatisticsRaw()
+		Expect(err).To(BeNil())
+		Expect(rollup.WalletID).To(Equal(-1))
+		Expect(rollup.TransactionCount).To(Equal(int32(2)))
+
+		rollupJSON, err := mw.Statistics()
+		Expect(err).To(BeNil())
+		Expect(rollupJSON).To(ContainSubstring(`"totalReceived":500000000`))
+	})
+})
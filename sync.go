@@ -5,11 +5,13 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"time"
 
+	"decred.org/dcrwallet/chain"
 	"decred.org/dcrwallet/errors"
-	"decred.org/dcrwallet/p2p"
 	w "decred.org/dcrwallet/wallet"
 	"github.com/decred/dcrd/addrmgr"
+	"github.com/planetdecred/dcrlibwallet/p2p"
 	"github.com/planetdecred/dcrlibwallet/spv"
 )
 
@@ -29,9 +31,35 @@ type syncData struct {
 	// Flag to notify syncCanceled callback if the sync was canceled so as to be restarted.
 	restartSyncRequested bool
 
-	rescanning     bool
+	// Options used to connect to the dcrd JSON-RPC server if the current
+	// or last sync was started with RpcSync.
+	rpcOptions *chain.RPCOptions
+
+	// The SPV syncer of the current sync, nil if no SPV sync is running.
+	syncer *spv.Syncer
+
+	// The rescan queue is running while rescanning is set. cancelRescan
+	// stops the queued rescan with ID runningRescanID, which is removed from
+	// the queue if rescanCanceled is set. stopRescans stops the queue and
+	// waits for it to stop.
+	rescanning      bool
+	runningRescanID int
+	rescanCanceled  bool
+	stopRescans     func()
+
 	connectedPeers int32
 
+	// syncStallRetries is the number of consecutive times that the sync
+	// was restarted after stalling. rotatedPeers are the hosts of the peers
+	// rotated out after a stall mapped to the time they may be connected to
+	// again.
+	syncStallRetries int32
+	rotatedPeers     map[string]time.Time
+
+	// cfilterStats counts the cfilters validated during the SPV syncs
+	// since the multiwallet was loaded.
+	cfilterStats CFilterValidationStats
+
 	*activeSyncData
 }
 
@@ -42,6 +70,10 @@ type activeSyncData struct {
 	headersFetchProgress     HeadersFetchProgressReport
 	addressDiscoveryProgress AddressDiscoveryProgressReport
 	headersRescanProgress    HeadersRescanProgressReport
+	headersImportProgress    HeadersImportProgressReport
+
+	headersImportStartTime   int64
+	headersImportStartHeight int32
 
 	beginFetchTimeStamp   int64
 	startHeaderHeight     int32
@@ -56,6 +88,10 @@ type activeSyncData struct {
 
 	totalInactiveSeconds     int64
 	totalFetchedHeadersCount int32
+
+	// lastProgressTime is the time that the sync last entered a stage or
+	// made progress in a stage. It is used to detect stalled syncs.
+	lastProgressTime time.Time
 }
 
 const (
@@ -63,6 +99,7 @@ const (
 	HeadersFetchSyncStage     = 0
 	AddressDiscoverySyncStage = 1
 	HeadersRescanSyncStage    = 2
+	HeadersImportSyncStage    = 3
 )
 
 func (mw *MultiWallet) initActiveSyncData() {
@@ -75,6 +112,9 @@ func (mw *MultiWallet) initActiveSyncData() {
 	headersRescanProgress := HeadersRescanProgressReport{}
 	headersRescanProgress.GeneralSyncProgress = &GeneralSyncProgress{}
 
+	headersImportProgress := HeadersImportProgressReport{}
+	headersImportProgress.GeneralSyncProgress = &GeneralSyncProgress{}
+
 	mw.syncData.mu.Lock()
 	mw.syncData.activeSyncData = &activeSyncData{
 		syncStage: InvalidSyncStage,
@@ -82,12 +122,14 @@ func (mw *MultiWallet) initActiveSyncData() {
 		headersFetchProgress:     headersFetchProgress,
 		addressDiscoveryProgress: addressDiscoveryProgress,
 		headersRescanProgress:    headersRescanProgress,
+		headersImportProgress:    headersImportProgress,
 
 		beginFetchTimeStamp:       -1,
 		headersFetchTimeSpent:     -1,
 		addressDiscoveryStartTime: -1,
 		totalDiscoveryTimeSpent:   -1,
 		totalFetchedHeadersCount:  0,
+		lastProgressTime:          time.Now(),
 	}
 	mw.syncData.mu.Unlock()
 }
@@ -181,9 +223,15 @@ func (mw *MultiWallet) SpvSync() error {
 		return errors.New(ErrSyncAlreadyInProgress)
 	}
 
+	if mw.SyncableWalletsCount() == 0 {
+		return errors.New(ErrWalletNotLoaded)
+	}
+
+	proxy := mw.socksProxy()
 	addr := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 0}
-	addrManager := addrmgr.New(mw.rootDir, net.LookupIP) // TODO: be mindful of tor
+	addrManager := addrmgr.New(mw.rootDir, proxyLookupFunc(proxy))
 	lp := p2p.NewLocalPeer(mw.chainParams, addr, addrManager)
+	lp.SetDialFunc(proxyDialFunc(proxy))
 
 	var validPeerAddresses []string
 	peerAddresses := mw.ReadStringConfigValueForKey(SpvPersistentPeerAddressesConfigKey)
@@ -203,12 +251,31 @@ func (mw *MultiWallet) SpvSync() error {
 		}
 	}
 
+	// Peers are discovered using the seeders of the network. Networks without
+	// seeders, such as simnet and regnet, can only sync from persistent peers.
+	if len(validPeerAddresses) == 0 && len(mw.chainParams.Seeders()) == 0 {
+		return errors.New(ErrNoPeers)
+	}
+
+	bannedPeers, err := mw.activePeerBans()
+	if err != nil {
+		return err
+	}
+	mw.rotatedPeerBans(bannedPeers)
+
+	if err := mw.startDataUsageSession(); err != nil {
+		return err
+	}
+
 	// init activeSyncData to be used to hold data used
 	// to calculate sync estimates only during sync
 	mw.initActiveSyncData()
 
 	wallets := make(map[int]*w.Wallet)
 	for id, wallet := range mw.wallets {
+		if !wallet.syncable() {
+			continue
+		}
 		wallets[id] = wallet.internal
 		wallet.waiting = true
 		wallet.syncing = true
@@ -216,6 +283,8 @@ func (mw *MultiWallet) SpvSync() error {
 
 	syncer := spv.NewSyncer(wallets, lp)
 	syncer.SetNotifications(mw.spvSyncNotificationCallbacks())
+	syncer.SetBannedPeers(bannedPeers)
+	syncer.SetStrictCFilterValidation(mw.ReadBoolConfigValueForKey(StrictCFilterValidationConfigKey, false))
 	if len(validPeerAddresses) > 0 {
 		syncer.SetPersistentPeers(validPeerAddresses)
 	}
@@ -230,17 +299,32 @@ func (mw *MultiWallet) SpvSync() error {
 	mw.syncData.syncing = true
 	mw.syncData.cancelSync = cancel
 	mw.syncData.syncCanceled = make(chan struct{})
+	mw.syncData.syncer = syncer
 	mw.syncData.mu.Unlock()
 
-	for _, listener := range mw.syncProgressListeners() {
-		listener.OnSyncStarted(restartSyncRequested)
-	}
+	mw.syncDiagnostics.syncStarted(restartSyncRequested)
+	mw.publishEvent(Event{Kind: SyncStartedEvent, Restart: restartSyncRequested})
 
 	// syncer.Run uses a wait group to block the thread until the sync context
 	// expires or is canceled or some other error occurs such as
 	// losing connection to all persistent peers.
+	go mw.trackDataUsage(ctx, lp)
+	go mw.watchSyncProgress(ctx)
+
 	go func() {
+		mw.importHeaderSnapshot(ctx)
+
 		syncError := syncer.Run(ctx)
+		lp.ReportDataUsage(mw.recordDataUsage)
+		if err := mw.saveDataUsage(); err != nil {
+			log.Errorf("Error saving data usage: %v", err)
+		}
+		if syncError == context.Canceled {
+			mw.syncDiagnostics.syncEnded(nil)
+		} else {
+			mw.syncDiagnostics.syncEnded(syncError)
+		}
+
 		//sync has ended or errored
 		if syncError != nil {
 			if syncError == context.DeadlineExceeded {
@@ -268,9 +352,105 @@ func (mw *MultiWallet) RestartSpvSync() error {
 	return mw.SpvSync()
 }
 
+// SetWalletExcludedFromSync sets whether the wallet is synced when the
+// multiwallet syncs. The change applies immediately to a running SPV sync
+// and to the next sync if an RPC sync is running. Excluding the last wallet
+// being synced cancels the SPV sync.
+func (mw *MultiWallet) SetWalletExcludedFromSync(walletID int, excluded bool) error {
+	wallet := mw.WalletWithID(walletID)
+	if wallet == nil {
+		return errors.New(ErrNotExist)
+	}
+
+	if wallet.ExcludedFromSync == excluded {
+		return nil
+	}
+
+	if excluded {
+		if err := mw.removeWalletFromSync(wallet); err != nil {
+			return translateError(err)
+		}
+	}
+
+	wallet.ExcludedFromSync = excluded
+	if err := mw.db.Save(wallet); err != nil {
+		return translateError(err)
+	}
+
+	if excluded {
+		mw.syncedWithoutRemovedWallet()
+		return nil
+	}
+
+	return mw.addWalletToSync(wallet)
+}
+
+// addWalletToSync adds a newly created, restored or linked wallet to the
+// running SPV sync without restarting it. Nothing is done if SPV sync is
+// not running or the wallet is not opened or is excluded from sync.
+func (mw *MultiWallet) addWalletToSync(wallet *Wallet) error {
+	syncer := mw.spvSyncer()
+	if syncer == nil || !wallet.syncable() {
+		return nil
+	}
+
+	wallet.waiting = true
+	wallet.syncing = true
+
+	// The added wallet has to catch up before all wallets are synced again.
+	mw.syncData.mu.Lock()
+	wasSynced := mw.syncData.synced
+	mw.syncData.synced = false
+	mw.syncData.syncing = true
+	mw.syncData.mu.Unlock()
+
+	if wasSynced {
+		mw.publishEvent(Event{Kind: SyncStartedEvent})
+	}
+
+	return syncer.AddWallet(wallet.ID, wallet.internal)
+}
+
+// removeWalletFromSync removes a wallet that is about to be deleted or
+// excluded from sync from the running SPV sync. The sync is canceled instead
+// if no other wallet is being synced.
+func (mw *MultiWallet) removeWalletFromSync(wallet *Wallet) error {
+	syncer := mw.spvSyncer()
+	if syncer == nil || !wallet.syncable() {
+		return nil
+	}
+
+	if mw.SyncableWalletsCount() <= 1 {
+		mw.CancelSync()
+		return nil
+	}
+
+	wallet.syncing = false
+	return syncer.RemoveWallet(wallet.ID)
+}
+
+// syncedWithoutRemovedWallet completes the sync if the remaining wallets are
+// all synced after a wallet was removed from the running sync.
+func (mw *MultiWallet) syncedWithoutRemovedWallet() {
+	if !mw.IsSyncing() || mw.SyncableWalletsCount() == 0 || mw.SyncableWalletsCount() != mw.SyncedWalletsCount() {
+		return
+	}
+
+	mw.syncData.mu.Lock()
+	mw.syncData.syncing = false
+	mw.syncData.synced = true
+	mw.syncData.mu.Unlock()
+
+	mw.syncDiagnostics.syncCompleted()
+	mw.indexTransactionsAfterSync(true)
+}
+
 func (mw *MultiWallet) CancelSync() {
+	mw.cancelDataBudgetResume()
+
 	mw.syncData.mu.RLock()
 	cancelSync := mw.syncData.cancelSync
+	syncCanceled := mw.syncData.syncCanceled
 	mw.syncData.mu.RUnlock()
 
 	if cancelSync != nil {
@@ -283,7 +463,7 @@ func (mw *MultiWallet) CancelSync() {
 
 		// When sync terminates and syncer.Run returns `err == context.Canceled`,
 		// we will get notified on this channel.
-		<-mw.syncData.syncCanceled
+		<-syncCanceled
 
 		log.Info("Sync fully canceled.")
 	}
@@ -341,6 +521,8 @@ func (mw *MultiWallet) GeneralSyncProgress() *GeneralSyncProgress {
 			return mw.syncData.addressDiscoveryProgress.GeneralSyncProgress
 		case HeadersRescanSyncStage:
 			return mw.syncData.headersRescanProgress.GeneralSyncProgress
+		case HeadersImportSyncStage:
+			return mw.syncData.headersImportProgress.GeneralSyncProgress
 		}
 	}
 
@@ -375,7 +557,7 @@ func (mw *MultiWallet) GetLowestBlock() *BlockInfo {
 	var lowestBlock int32 = -1
 	var blockInfo *BlockInfo
 	for _, wallet := range mw.wallets {
-		if !wallet.WalletOpened() {
+		if !wallet.syncable() {
 			continue
 		}
 		walletBestBLock := wallet.GetBestBlock()
@@ -420,6 +602,9 @@ func (wallet *Wallet) GetBestBlockTimeStamp() int64 {
 func (mw *MultiWallet) GetLowestBlockTimestamp() int64 {
 	var timestamp int64 = -1
 	for _, wallet := range mw.wallets {
+		if !wallet.syncable() {
+			continue
+		}
 		bestBlockTimestamp := wallet.GetBestBlockTimeStamp()
 		if bestBlockTimestamp < timestamp || timestamp == -1 {
 			timestamp = bestBlockTimestamp
@@ -0,0 +1,430 @@
+package dcrlibwallet
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/addrmgr"
+)
+
+const (
+	// maxSyncDiagnosticsErrors is the number of most recent sync errors
+	// kept for the diagnostics report.
+	maxSyncDiagnosticsErrors = 50
+
+	// maxSyncDiagnosticsRescans is the number of most recent rescans kept
+	// for each wallet for the diagnostics report.
+	maxSyncDiagnosticsRescans = 10
+)
+
+// syncDiagnosticsTracker records the sync activity that is reported by
+// SyncDiagnostics. The stages are those of the current or last sync, the
+// other records cover all syncs since the multiwallet was loaded.
+type syncDiagnosticsTracker struct {
+	mu sync.Mutex
+
+	syncStartedAt   int64
+	syncCompletedAt int64
+	syncEndedAt     int64
+	syncRestarts    int32
+
+	stages  []*SyncStageDiagnostics
+	peers   map[string]*PeerSyncDiagnostics
+	rescans map[int][]*RescanDiagnostics
+	errors  []*SyncErrorDiagnostics
+}
+
+func newSyncDiagnosticsTracker() *syncDiagnosticsTracker {
+	return &syncDiagnosticsTracker{
+		peers:   make(map[string]*PeerSyncDiagnostics),
+		rescans: make(map[int][]*RescanDiagnostics),
+	}
+}
+
+func (mw *MultiWallet) SyncDiagnostics() (string, error) {
+	diagnostics, err := mw.SyncDiagnosticsRaw()
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedDiagnostics, err := json.Marshal(diagnostics)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedDiagnostics), nil
+}
+
+// SyncDiagnosticsRaw returns a report of the sync activity since the
+// multiwallet was loaded, meant to be attached to support requests instead
+// of log files. Times are unix timestamps, 0 if the event did not happen.
+func (mw *MultiWallet) SyncDiagnosticsRaw() (*SyncDiagnostics, error) {
+	diagnostics := &SyncDiagnostics{
+		GeneratedAt:       time.Now().Unix(),
+		Network:           mw.chainParams.Name,
+		Syncing:           mw.IsSyncing(),
+		Synced:            mw.IsSynced(),
+		SyncStage:         mw.CurrentSyncStage(),
+		ConnectedPeers:    mw.ConnectedPeers(),
+		CFilterValidation: mw.CFilterValidationStatsRaw(),
+		Wallets:           make([]*WalletSyncDiagnostics, 0, len(mw.wallets)),
+	}
+
+	tracker := mw.syncDiagnostics
+	tracker.mu.Lock()
+	diagnostics.SyncStartedAt = tracker.syncStartedAt
+	diagnostics.SyncCompletedAt = tracker.syncCompletedAt
+	diagnostics.SyncEndedAt = tracker.syncEndedAt
+	diagnostics.SyncRestarts = tracker.syncRestarts
+
+	diagnostics.Stages = make([]*SyncStageDiagnostics, len(tracker.stages))
+	for i, stage := range tracker.stages {
+		stageCopy := *stage
+		if stageCopy.EndedAt == 0 {
+			stageCopy.DurationSeconds = diagnostics.GeneratedAt - stageCopy.StartedAt
+		}
+		diagnostics.Stages[i] = &stageCopy
+	}
+
+	diagnostics.Peers = make([]*PeerSyncDiagnostics, 0, len(tracker.peers))
+	for _, peer := range tracker.peers {
+		peerCopy := *peer
+		diagnostics.Peers = append(diagnostics.Peers, &peerCopy)
+		diagnostics.HeadersFetched += peer.HeadersFetched
+		diagnostics.CFiltersFetched += peer.CFiltersFetched
+		if peer.Connections > 1 {
+			diagnostics.PeerReconnects += peer.Connections - 1
+		}
+	}
+
+	diagnostics.Errors = make([]*SyncErrorDiagnostics, len(tracker.errors))
+	copy(diagnostics.Errors, tracker.errors)
+
+	rescans := make(map[int][]*RescanDiagnostics, len(tracker.rescans))
+	for walletID, walletRescans := range tracker.rescans {
+		rescans[walletID] = make([]*RescanDiagnostics, len(walletRescans))
+		for i, rescan := range walletRescans {
+			rescanCopy := *rescan
+			rescans[walletID][i] = &rescanCopy
+		}
+	}
+	tracker.mu.Unlock()
+
+	sort.Slice(diagnostics.Peers, func(i, j int) bool {
+		return diagnostics.Peers[i].Address < diagnostics.Peers[j].Address
+	})
+
+	for _, wallet := range mw.AllWallets() {
+		walletDiagnostics := &WalletSyncDiagnostics{
+			WalletID:     wallet.ID,
+			Name:         wallet.Name,
+			Opened:       wallet.WalletOpened(),
+			Synced:       wallet.IsSynced(),
+			BestBlock:    -1,
+			RescanRanges: rescans[wallet.ID],
+		}
+		if walletDiagnostics.Opened {
+			walletDiagnostics.BestBlock = wallet.GetBestBlock()
+		}
+		if walletDiagnostics.RescanRanges == nil {
+			walletDiagnostics.RescanRanges = make([]*RescanDiagnostics, 0)
+		}
+		diagnostics.Wallets = append(diagnostics.Wallets, walletDiagnostics)
+	}
+	sort.Slice(diagnostics.Wallets, func(i, j int) bool {
+		return diagnostics.Wallets[i].WalletID < diagnostics.Wallets[j].WalletID
+	})
+
+	addressManager, err := mw.addressManagerDiagnostics()
+	if err != nil {
+		return nil, err
+	}
+	diagnostics.AddressManager = addressManager
+
+	return diagnostics, nil
+}
+
+// addressManagerDiagnostics reads the state of the address manager from the
+// peers file, which the address manager saves periodically during sync and
+// when the sync ends.
+func (mw *MultiWallet) addressManagerDiagnostics() (*AddressManagerDiagnostics, error) {
+	diagnostics := &AddressManagerDiagnostics{
+		PersistentPeers: make([]string, 0),
+	}
+
+	if peerAddresses := mw.ReadStringConfigValueForKey(SpvPersistentPeerAddressesConfigKey); peerAddresses != "" {
+		diagnostics.PersistentPeers = strings.Split(peerAddresses, ";")
+	}
+
+	bans, err := mw.BannedPeersRaw()
+	if err != nil {
+		return nil, err
+	}
+	diagnostics.BannedPeers = int32(len(bans))
+
+	peersFile := filepath.Join(mw.rootDir, addrmgr.PeersFilename)
+	fileInfo, err := os.Stat(peersFile)
+	if os.IsNotExist(err) {
+		return diagnostics, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	diagnostics.SavedAt = fileInfo.ModTime().Unix()
+
+	file, err := os.Open(peersFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The addresses in the tried buckets are those that were connected to
+	// successfully, the others were only learned of.
+	var peers struct {
+		Addresses []struct {
+			LastSuccess int64
+		}
+		TriedBuckets [][]string
+	}
+	if err = json.NewDecoder(file).Decode(&peers); err != nil {
+		log.Errorf("Error reading address manager peers file: %v", err)
+		return diagnostics, nil
+	}
+
+	diagnostics.KnownAddresses = int32(len(peers.Addresses))
+	for _, bucket := range peers.TriedBuckets {
+		diagnostics.TriedAddresses += int32(len(bucket))
+	}
+	for _, address := range peers.Addresses {
+		if address.LastSuccess > diagnostics.LastSuccessfulConnection {
+			diagnostics.LastSuccessfulConnection = address.LastSuccess
+		}
+	}
+
+	return diagnostics, nil
+}
+
+func (tracker *syncDiagnosticsTracker) syncStarted(restart bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	now := time.Now().Unix()
+	tracker.syncStartedAt = now
+	tracker.syncCompletedAt = 0
+	tracker.syncEndedAt = 0
+	tracker.stages = []*SyncStageDiagnostics{{
+		Stage:     InvalidSyncStage,
+		Name:      syncStageNames[InvalidSyncStage],
+		StartedAt: now,
+	}}
+	if restart {
+		tracker.syncRestarts++
+	}
+}
+
+// syncEnded records the end of the sync and of its current stage.
+func (tracker *syncDiagnosticsTracker) syncEnded(err error) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	now := time.Now().Unix()
+	tracker.endStage(now)
+	tracker.syncEndedAt = now
+	if err != nil {
+		tracker.addError(now, "sync", err)
+	}
+
+	// Rescans that are part of the sync do not report errors, they end
+	// with the sync.
+	for walletID := range tracker.rescans {
+		if rescan := tracker.currentRescan(walletID); rescan != nil && !rescan.Requested {
+			rescan.EndedAt = now
+			rescan.Error = "sync ended before the rescan completed"
+		}
+	}
+}
+
+// syncCompleted records the completion of the last sync stage.
+func (tracker *syncDiagnosticsTracker) syncCompleted() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	now := time.Now().Unix()
+	tracker.endStage(now)
+	tracker.syncCompletedAt = now
+}
+
+// stageStarted records the start of a sync stage and the end of the
+// previous stage. Restarting the current stage, as for each wallet that is
+// rescanned, continues the stage.
+func (tracker *syncDiagnosticsTracker) stageStarted(stage int32) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if n := len(tracker.stages); n > 0 && tracker.stages[n-1].EndedAt == 0 && tracker.stages[n-1].Stage == stage {
+		return
+	}
+
+	now := time.Now().Unix()
+	tracker.endStage(now)
+	tracker.stages = append(tracker.stages, &SyncStageDiagnostics{
+		Stage:     stage,
+		Name:      syncStageNames[stage],
+		StartedAt: now,
+	})
+}
+
+// endStage ends the current sync stage. tracker.mu must be held.
+func (tracker *syncDiagnosticsTracker) endStage(now int64) {
+	n := len(tracker.stages)
+	if n == 0 || tracker.stages[n-1].EndedAt != 0 {
+		return
+	}
+	stage := tracker.stages[n-1]
+	stage.EndedAt = now
+	stage.DurationSeconds = now - stage.StartedAt
+}
+
+// peer returns the diagnostics of the peer with address addr.
+// tracker.mu must be held.
+func (tracker *syncDiagnosticsTracker) peer(addr string) *PeerSyncDiagnostics {
+	peer, ok := tracker.peers[addr]
+	if !ok {
+		peer = &PeerSyncDiagnostics{Address: addr}
+		tracker.peers[addr] = peer
+	}
+	return peer
+}
+
+func (tracker *syncDiagnosticsTracker) peerConnected(addr string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	peer := tracker.peer(addr)
+	peer.Connections++
+	peer.LastConnectedAt = time.Now().Unix()
+}
+
+func (tracker *syncDiagnosticsTracker) peerDisconnected(addr string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	peer := tracker.peer(addr)
+	peer.Disconnects++
+	peer.LastDisconnectedAt = time.Now().Unix()
+}
+
+func (tracker *syncDiagnosticsTracker) peerError(addr string, err error) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	now := time.Now().Unix()
+	peer := tracker.peer(addr)
+	peer.LastError = err.Error()
+	peer.LastErrorAt = now
+	tracker.addError(now, "peer "+addr, err)
+}
+
+func (tracker *syncDiagnosticsTracker) headersReceived(addr string, count int) {
+	tracker.mu.Lock()
+	tracker.peer(addr).HeadersFetched += int64(count)
+	tracker.mu.Unlock()
+}
+
+func (tracker *syncDiagnosticsTracker) cfiltersReceived(addr string, count int) {
+	tracker.mu.Lock()
+	tracker.peer(addr).CFiltersFetched += int64(count)
+	tracker.mu.Unlock()
+}
+
+// recordError records an error of source, such as a wallet or the sync.
+func (tracker *syncDiagnosticsTracker) recordError(source string, err error) {
+	tracker.mu.Lock()
+	tracker.addError(time.Now().Unix(), source, err)
+	tracker.mu.Unlock()
+}
+
+// addError records an error, dropping the oldest error if there are too
+// many. tracker.mu must be held.
+func (tracker *syncDiagnosticsTracker) addError(now int64, source string, err error) {
+	if len(tracker.errors) == maxSyncDiagnosticsErrors {
+		tracker.errors = append(tracker.errors[:0], tracker.errors[1:]...)
+	}
+	tracker.errors = append(tracker.errors, &SyncErrorDiagnostics{
+		Time:    now,
+		Source:  source,
+		Message: err.Error(),
+	})
+}
+
+// rescanPointHeight returns the height of the block that the wallet must be
+// rescanned from, or 0 if it is unknown.
+func (wallet *Wallet) rescanPointHeight() int32 {
+	ctx := wallet.shutdownContext()
+	rescanPoint, err := wallet.internal.RescanPoint(ctx)
+	if err != nil || rescanPoint == nil {
+		return 0
+	}
+	header, err := wallet.internal.BlockHeader(ctx, rescanPoint)
+	if err != nil {
+		return 0
+	}
+	return int32(header.Height)
+}
+
+// rescanStarted records the start of a rescan of the wallet from
+// startHeight. Rescans are either part of the sync or were requested with
+// the rescan queue.
+func (tracker *syncDiagnosticsTracker) rescanStarted(walletID int, startHeight int32, requested bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	rescans := tracker.rescans[walletID]
+	if len(rescans) == maxSyncDiagnosticsRescans {
+		rescans = append(rescans[:0], rescans[1:]...)
+	}
+	tracker.rescans[walletID] = append(rescans, &RescanDiagnostics{
+		StartHeight:    startHeight,
+		ScannedThrough: startHeight - 1,
+		Requested:      requested,
+		StartedAt:      time.Now().Unix(),
+	})
+}
+
+// currentRescan returns the last rescan of the wallet if it did not end.
+// tracker.mu must be held.
+func (tracker *syncDiagnosticsTracker) currentRescan(walletID int) *RescanDiagnostics {
+	rescans := tracker.rescans[walletID]
+	if len(rescans) == 0 || rescans[len(rescans)-1].EndedAt != 0 {
+		return nil
+	}
+	return rescans[len(rescans)-1]
+}
+
+func (tracker *syncDiagnosticsTracker) rescanProgress(walletID int, scannedThrough int32) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if rescan := tracker.currentRescan(walletID); rescan != nil {
+		rescan.ScannedThrough = scannedThrough
+	}
+}
+
+func (tracker *syncDiagnosticsTracker) rescanEnded(walletID int, err error) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	rescan := tracker.currentRescan(walletID)
+	if rescan == nil {
+		return
+	}
+	rescan.EndedAt = time.Now().Unix()
+	if err != nil {
+		rescan.Error = err.Error()
+	}
+}
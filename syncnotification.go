@@ -11,9 +11,11 @@ import (
 func (mw *MultiWallet) spvSyncNotificationCallbacks() *spv.Notifications {
 	return &spv.Notifications{
 		PeerConnected: func(peerCount int32, addr string) {
+			mw.syncDiagnostics.peerConnected(addr)
 			mw.handlePeerCountUpdate(peerCount)
 		},
 		PeerDisconnected: func(peerCount int32, addr string) {
+			mw.syncDiagnostics.peerDisconnected(addr)
 			mw.handlePeerCountUpdate(peerCount)
 		},
 		Synced:                       mw.synced,
@@ -28,6 +30,11 @@ func (mw *MultiWallet) spvSyncNotificationCallbacks() *spv.Notifications {
 		RescanStarted:                mw.rescanStarted,
 		RescanProgress:               mw.rescanProgress,
 		RescanFinished:               mw.rescanFinished,
+		CFiltersValidated:            mw.cfiltersValidated,
+		InvalidCFilters:              mw.invalidCFilters,
+		PeerError:                    mw.syncDiagnostics.peerError,
+		HeadersReceived:              mw.syncDiagnostics.headersReceived,
+		CFiltersReceived:             mw.syncDiagnostics.cfiltersReceived,
 	}
 }
 
@@ -37,9 +44,7 @@ func (mw *MultiWallet) handlePeerCountUpdate(peerCount int32) {
 	shouldLog := mw.syncData.showLogs && mw.syncData.syncing
 	mw.syncData.mu.Unlock()
 
-	for _, syncProgressListener := range mw.syncProgressListeners() {
-		syncProgressListener.OnPeerConnectedOrDisconnected(peerCount)
-	}
+	mw.publishEvent(Event{Kind: PeersChangedEvent, ConnectedPeers: peerCount})
 
 	if shouldLog {
 		if peerCount == 1 {
@@ -76,6 +81,8 @@ func (mw *MultiWallet) fetchHeadersStarted(peerInitialHeight int32) {
 
 	mw.syncData.mu.Lock()
 	mw.syncData.activeSyncData.syncStage = HeadersFetchSyncStage
+	mw.syncDiagnostics.stageStarted(HeadersFetchSyncStage)
+	mw.syncData.activeSyncData.lastProgressTime = time.Now()
 	mw.syncData.activeSyncData.beginFetchTimeStamp = time.Now().Unix()
 	mw.syncData.activeSyncData.startHeaderHeight = lowestBlockHeight
 	mw.syncData.totalFetchedHeadersCount = 0
@@ -109,6 +116,7 @@ func (mw *MultiWallet) fetchHeadersProgress(lastFetchedHeaderHeight int32, lastF
 
 	// lock the mutex before reading and writing to mw.syncData.*
 	mw.syncData.mu.Lock()
+	mw.syncData.activeSyncData.lastProgressTime = time.Now()
 
 	if lastFetchedHeaderHeight > mw.syncData.activeSyncData.startHeaderHeight {
 		mw.syncData.activeSyncData.totalFetchedHeadersCount = lastFetchedHeaderHeight - mw.syncData.activeSyncData.startHeaderHeight
@@ -179,9 +187,11 @@ func (mw *MultiWallet) fetchHeadersProgress(lastFetchedHeaderHeight int32, lastF
 }
 
 func (mw *MultiWallet) publishFetchHeadersProgress() {
-	for _, syncProgressListener := range mw.syncProgressListeners() {
-		syncProgressListener.OnHeadersFetchProgress(&mw.syncData.headersFetchProgress)
-	}
+	mw.syncData.mu.RLock()
+	report := mw.syncData.headersFetchProgress.copy()
+	mw.syncData.mu.RUnlock()
+
+	mw.publishEvent(Event{Kind: HeadersFetchProgressEvent, HeadersFetchProgress: report})
 }
 
 func (mw *MultiWallet) fetchHeadersFinished() {
@@ -196,6 +206,7 @@ func (mw *MultiWallet) fetchHeadersFinished() {
 	mw.syncData.activeSyncData.startHeaderHeight = -1
 	mw.syncData.totalFetchedHeadersCount = 0
 	mw.syncData.activeSyncData.headersFetchTimeSpent = time.Now().Unix() - mw.syncData.beginFetchTimeStamp
+	mw.syncData.activeSyncData.lastProgressTime = time.Now()
 
 	// If there is some period of inactivity reported at this stage,
 	// subtract it from the total stage time.
@@ -230,6 +241,8 @@ func (mw *MultiWallet) discoverAddressesStarted(walletID int) {
 
 	mw.syncData.mu.Lock()
 	mw.syncData.activeSyncData.syncStage = AddressDiscoverySyncStage
+	mw.syncDiagnostics.stageStarted(AddressDiscoverySyncStage)
+	mw.syncData.activeSyncData.lastProgressTime = time.Now()
 	mw.syncData.activeSyncData.addressDiscoveryStartTime = time.Now().Unix()
 	mw.syncData.activeSyncData.addressDiscoveryProgress.WalletID = walletID
 	mw.syncData.addressDiscoveryCompletedOrCanceled = make(chan bool)
@@ -333,9 +346,15 @@ func (mw *MultiWallet) updateAddressDiscoveryProgress(totalHeadersFetchTime floa
 }
 
 func (mw *MultiWallet) publishAddressDiscoveryProgress() {
-	for _, syncProgressListener := range mw.syncProgressListeners() {
-		syncProgressListener.OnAddressDiscoveryProgress(&mw.syncData.activeSyncData.addressDiscoveryProgress)
-	}
+	mw.syncData.mu.RLock()
+	report := mw.syncData.activeSyncData.addressDiscoveryProgress.copy()
+	mw.syncData.mu.RUnlock()
+
+	mw.publishEvent(Event{
+		Kind:                     AddressDiscoveryProgressEvent,
+		WalletID:                 report.WalletID,
+		AddressDiscoveryProgress: report,
+	})
 }
 
 func (mw *MultiWallet) discoverAddressesFinished(walletID int) {
@@ -352,6 +371,7 @@ func (mw *MultiWallet) stopUpdatingAddressDiscoveryProgress() {
 		close(mw.syncData.activeSyncData.addressDiscoveryCompletedOrCanceled)
 		mw.syncData.activeSyncData.addressDiscoveryCompletedOrCanceled = nil
 		mw.syncData.activeSyncData.totalDiscoveryTimeSpent = time.Now().Unix() - mw.syncData.addressDiscoveryStartTime
+		mw.syncData.activeSyncData.lastProgressTime = time.Now()
 	}
 	mw.syncData.mu.Unlock()
 }
@@ -361,6 +381,10 @@ func (mw *MultiWallet) stopUpdatingAddressDiscoveryProgress() {
 func (mw *MultiWallet) rescanStarted(walletID int) {
 	mw.stopUpdatingAddressDiscoveryProgress()
 
+	if wallet := mw.WalletWithID(walletID); wallet != nil {
+		mw.syncDiagnostics.rescanStarted(walletID, wallet.rescanPointHeight(), false)
+	}
+
 	mw.syncData.mu.Lock()
 	defer mw.syncData.mu.Unlock()
 
@@ -370,6 +394,8 @@ func (mw *MultiWallet) rescanStarted(walletID int) {
 	}
 
 	mw.syncData.activeSyncData.syncStage = HeadersRescanSyncStage
+	mw.syncDiagnostics.stageStarted(HeadersRescanSyncStage)
+	mw.syncData.activeSyncData.lastProgressTime = time.Now()
 	mw.syncData.activeSyncData.rescanStartTime = time.Now().Unix()
 
 	// retain last total progress report from address discovery phase
@@ -383,6 +409,8 @@ func (mw *MultiWallet) rescanStarted(walletID int) {
 }
 
 func (mw *MultiWallet) rescanProgress(walletID int, rescannedThrough int32) {
+	mw.syncDiagnostics.rescanProgress(walletID, rescannedThrough)
+
 	if !mw.IsSyncing() {
 		// ignore if sync is not in progress
 		return
@@ -394,6 +422,7 @@ func (mw *MultiWallet) rescanProgress(walletID int, rescannedThrough int32) {
 	rescanRate := float64(rescannedThrough) / float64(totalHeadersToScan)
 
 	mw.syncData.mu.Lock()
+	mw.syncData.activeSyncData.lastProgressTime = time.Now()
 
 	// If there was some period of inactivity,
 	// assume that this process started at some point in the future,
@@ -449,18 +478,27 @@ func (mw *MultiWallet) rescanProgress(walletID int, rescannedThrough int32) {
 }
 
 func (mw *MultiWallet) publishHeadersRescanProgress() {
-	for _, syncProgressListener := range mw.syncProgressListeners() {
-		syncProgressListener.OnHeadersRescanProgress(&mw.syncData.activeSyncData.headersRescanProgress)
-	}
+	mw.syncData.mu.RLock()
+	report := mw.syncData.activeSyncData.headersRescanProgress.copy()
+	mw.syncData.mu.RUnlock()
+
+	mw.publishEvent(Event{
+		Kind:                  HeadersRescanProgressEvent,
+		WalletID:              report.WalletID,
+		HeadersRescanProgress: report,
+	})
 }
 
 func (mw *MultiWallet) rescanFinished(walletID int) {
+	mw.syncDiagnostics.rescanEnded(walletID, nil)
+
 	if !mw.IsSyncing() {
 		// ignore if sync is not in progress
 		return
 	}
 
 	mw.syncData.mu.Lock()
+	mw.syncData.activeSyncData.lastProgressTime = time.Now()
 	mw.syncData.activeSyncData.headersRescanProgress.WalletID = walletID
 	mw.syncData.activeSyncData.headersRescanProgress.TotalTimeRemainingSeconds = 0
 	mw.syncData.activeSyncData.headersRescanProgress.TotalSyncProgress = 100
@@ -475,9 +513,7 @@ func (mw *MultiWallet) rescanFinished(walletID int) {
 }
 
 func (mw *MultiWallet) publishDebugInfo(debugInfo *DebugInfo) {
-	for _, syncProgressListener := range mw.syncProgressListeners() {
-		syncProgressListener.Debug(debugInfo)
-	}
+	mw.publishEvent(Event{Kind: SyncDebugEvent, DebugInfo: debugInfo})
 }
 
 /** Helper functions start here */
@@ -494,9 +530,7 @@ func (mw *MultiWallet) estimateBlockHeadersCountAfter(lastHeaderTime int64) int3
 }
 
 func (mw *MultiWallet) notifySyncError(err error) {
-	for _, syncProgressListener := range mw.syncProgressListeners() {
-		syncProgressListener.OnSyncEndedWithError(err)
-	}
+	mw.publishEvent(Event{Kind: SyncEndedWithErrorEvent, Err: err})
 }
 
 func (mw *MultiWallet) notifySyncCanceled() {
@@ -504,9 +538,7 @@ func (mw *MultiWallet) notifySyncCanceled() {
 	restartSyncRequested := mw.syncData.restartSyncRequested
 	mw.syncData.mu.RUnlock()
 
-	for _, syncProgressListener := range mw.syncProgressListeners() {
-		syncProgressListener.OnSyncCanceled(restartSyncRequested)
-	}
+	mw.publishEvent(Event{Kind: SyncCanceledEvent, Restart: restartSyncRequested})
 }
 
 func (mw *MultiWallet) resetSyncData() {
@@ -520,6 +552,7 @@ func (mw *MultiWallet) resetSyncData() {
 	mw.syncData.synced = false
 	mw.syncData.cancelSync = nil
 	mw.syncData.syncCanceled = nil
+	mw.syncData.syncer = nil
 	mw.syncData.activeSyncData = nil
 	mw.syncData.mu.Unlock()
 
@@ -551,32 +584,41 @@ func (mw *MultiWallet) synced(walletID int, synced bool) {
 		}
 	}
 
-	if mw.OpenedWalletsCount() == mw.SyncedWalletsCount() {
+	if mw.SyncableWalletsCount() == mw.SyncedWalletsCount() {
 		mw.syncData.mu.Lock()
 		mw.syncData.syncing = false
 		mw.syncData.synced = true
+		mw.syncData.syncStallRetries = 0
 		mw.syncData.mu.Unlock()
 
-		// begin indexing transactions after sync is completed,
-		// syncProgressListeners.OnSynced() will be invoked after transactions are indexed
-		var txIndexing errgroup.Group
-		for _, wallet := range mw.wallets {
-			txIndexing.Go(wallet.IndexTransactions)
+		if synced {
+			mw.syncDiagnostics.syncCompleted()
 		}
 
-		go func() {
-			err := txIndexing.Wait()
-			if err != nil {
-				log.Errorf("Tx Index Error: %v", err)
-			}
+		mw.indexTransactionsAfterSync(synced)
+	}
+}
 
-			for _, syncProgressListener := range mw.syncProgressListeners() {
-				if synced {
-					syncProgressListener.OnSyncCompleted()
-				} else {
-					syncProgressListener.OnSyncCanceled(false)
-				}
-			}
-		}()
+// indexTransactionsAfterSync begins indexing the transactions of the wallets
+// after sync is completed. syncProgressListeners.OnSynced() will be invoked
+// after transactions are indexed.
+func (mw *MultiWallet) indexTransactionsAfterSync(synced bool) {
+	var txIndexing errgroup.Group
+	for _, wallet := range mw.wallets {
+		txIndexing.Go(wallet.IndexTransactions)
 	}
+
+	go func() {
+		err := txIndexing.Wait()
+		if err != nil {
+			log.Errorf("Tx Index Error: %v", err)
+		}
+
+		if synced {
+			mw.publishEvent(Event{Kind: SyncCompletedEvent})
+			mw.startRescanQueue()
+		} else {
+			mw.publishEvent(Event{Kind: SyncCanceledEvent})
+		}
+	}()
 }
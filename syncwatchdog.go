@@ -0,0 +1,208 @@
+package dcrlibwallet
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+)
+
+const (
+	// SyncStallTimeoutConfigKey is the number of seconds that the headers
+	// fetch, headers rescan and headers import stages of a SPV sync may go
+	// without progress before the sync is considered stalled. Values below
+	// 1 disable stall detection for these stages.
+	SyncStallTimeoutConfigKey = "sync_stall_timeout"
+
+	// AddressDiscoveryStallTimeoutConfigKey is the number of seconds that
+	// address discovery may take before the sync is considered stalled.
+	// Address discovery does not report progress so this is longer than
+	// the stall timeout of the other stages. Values below 1 disable stall
+	// detection for address discovery.
+	AddressDiscoveryStallTimeoutConfigKey = "address_discovery_stall_timeout"
+
+	defaultSyncStallTimeout             = 120
+	defaultAddressDiscoveryStallTimeout = 600
+
+	syncWatchdogInterval = time.Second
+
+	// The sync is restarted after a stall with a delay that doubles with
+	// each consecutive stall, from the min to the max delay.
+	syncStallMinRetryDelay = 2 * time.Second
+	syncStallMaxRetryDelay = 5 * time.Minute
+
+	// syncStallPeerRotationPeriod is the time that the peers connected
+	// during a stall are not connected to again, unless they are
+	// persistent peers.
+	syncStallPeerRotationPeriod = 10 * time.Minute
+)
+
+var syncStageNames = map[int32]string{
+	InvalidSyncStage:          "connecting to peers",
+	HeadersFetchSyncStage:     "headers fetch",
+	AddressDiscoverySyncStage: "address discovery",
+	HeadersRescanSyncStage:    "headers rescan",
+	HeadersImportSyncStage:    "headers import",
+}
+
+func (mw *MultiWallet) AddSyncStallListener(listener SyncStallListener, uniqueIdentifier string) error {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	if _, ok := mw.syncStallListeners[uniqueIdentifier]; ok {
+		return errors.New(ErrListenerAlreadyExist)
+	}
+
+	mw.syncStallListeners[uniqueIdentifier] = listener
+	return nil
+}
+
+func (mw *MultiWallet) RemoveSyncStallListener(uniqueIdentifier string) {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	delete(mw.syncStallListeners, uniqueIdentifier)
+}
+
+// syncStallTimeout returns the time that the sync stage may go without
+// progress, or 0 if stall detection is disabled for the stage.
+func (mw *MultiWallet) syncStallTimeout(syncStage int32) time.Duration {
+	seconds := mw.ReadIntConfigValueForKey(SyncStallTimeoutConfigKey, defaultSyncStallTimeout)
+	if syncStage == AddressDiscoverySyncStage {
+		seconds = mw.ReadIntConfigValueForKey(AddressDiscoveryStallTimeoutConfigKey, defaultAddressDiscoveryStallTimeout)
+	}
+	if seconds < 1 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// watchSyncProgress restarts the SPV sync of ctx if a sync stage makes no
+// progress for longer than the stall timeout of the stage. The peers that
+// were connected during the stall are rotated out, and the sync is restarted
+// after a delay that grows with each consecutive stall in case the network
+// is unreachable. The restart is skipped if the sync makes progress during
+// the delay.
+func (mw *MultiWallet) watchSyncProgress(ctx context.Context) {
+	ticker := time.NewTicker(syncWatchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, lastProgressTime := mw.checkSyncStall()
+		if report == nil {
+			continue
+		}
+
+		log.Warnf("SPV sync stalled: %s. Restarting sync in %d seconds.", report.Reason, report.RetryDelaySeconds)
+		mw.syncDiagnostics.recordError("sync", errors.New("stalled: "+report.Reason))
+		mw.publishEvent(Event{Kind: SyncStalledEvent, SyncStall: report})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(report.RetryDelaySeconds) * time.Second):
+		}
+
+		mw.syncData.mu.RLock()
+		progressed := mw.syncData.activeSyncData != nil && mw.syncData.activeSyncData.lastProgressTime.After(lastProgressTime)
+		mw.syncData.mu.RUnlock()
+		if progressed || ctx.Err() != nil {
+			continue
+		}
+
+		// The restart cancels ctx and waits for this sync to end, it
+		// must not block the watchdog.
+		go func() {
+			if err := mw.RestartSpvSync(); err != nil {
+				log.Errorf("Error restarting stalled sync: %v", err)
+			}
+		}()
+		return
+	}
+}
+
+// checkSyncStall returns a report of the sync stall and the time of the last
+// progress if the current sync stage made no progress for longer than its
+// stall timeout. The peers connected during the stall are rotated out for
+// the restarted sync.
+func (mw *MultiWallet) checkSyncStall() (*SyncStallReport, time.Time) {
+	mw.syncData.mu.RLock()
+	if !mw.syncData.syncing || mw.syncData.activeSyncData == nil {
+		mw.syncData.mu.RUnlock()
+		return nil, time.Time{}
+	}
+	syncStage := mw.syncData.activeSyncData.syncStage
+	lastProgressTime := mw.syncData.activeSyncData.lastProgressTime
+	connectedPeers := mw.syncData.connectedPeers
+	syncer := mw.syncData.syncer
+	mw.syncData.mu.RUnlock()
+
+	// Not having peers to sync from is not a stall.
+	stallTimeout := mw.syncStallTimeout(syncStage)
+	stalledFor := time.Since(lastProgressTime)
+	if stallTimeout == 0 || connectedPeers == 0 || stalledFor < stallTimeout {
+		return nil, time.Time{}
+	}
+
+	report := &SyncStallReport{
+		SyncStage:      syncStage,
+		StalledSeconds: int64(stalledFor.Seconds()),
+		ConnectedPeers: connectedPeers,
+		RotatedPeers:   make([]string, 0),
+	}
+
+	rotateUntil := time.Now().Add(syncStallPeerRotationPeriod)
+	if syncer != nil {
+		for addr := range syncer.GetRemotePeers() {
+			report.RotatedPeers = append(report.RotatedPeers, addr)
+		}
+	}
+
+	mw.syncData.mu.Lock()
+	retry := mw.syncData.syncStallRetries
+	mw.syncData.syncStallRetries++
+	if mw.syncData.rotatedPeers == nil {
+		mw.syncData.rotatedPeers = make(map[string]time.Time)
+	}
+	for _, addr := range report.RotatedPeers {
+		mw.syncData.rotatedPeers[peerHost(addr)] = rotateUntil
+	}
+	mw.syncData.mu.Unlock()
+
+	retryDelay := syncStallMaxRetryDelay
+	if retry < 8 && syncStallMinRetryDelay<<retry < syncStallMaxRetryDelay {
+		retryDelay = syncStallMinRetryDelay << retry
+	}
+
+	report.Retry = retry + 1
+	report.RetryDelaySeconds = int64(retryDelay.Seconds())
+	report.Reason = fmt.Sprintf("%s made no progress for %d seconds with %d connected peers",
+		syncStageNames[syncStage], report.StalledSeconds, connectedPeers)
+
+	return report, lastProgressTime
+}
+
+// rotatedPeerBans adds the hosts of the peers rotated out after a sync stall
+// to bans, keeping the later expiry time of hosts that are also banned.
+func (mw *MultiWallet) rotatedPeerBans(bans map[string]time.Time) {
+	mw.syncData.mu.Lock()
+	defer mw.syncData.mu.Unlock()
+
+	now := time.Now()
+	for host, until := range mw.syncData.rotatedPeers {
+		if until.Before(now) {
+			delete(mw.syncData.rotatedPeers, host)
+			continue
+		}
+		if until.After(bans[host]) {
+			bans[host] = until
+		}
+	}
+}
@@ -2,6 +2,7 @@ package dcrlibwallet
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -17,6 +18,7 @@ import (
 	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrd/dcrutil/v3"
 	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/vsp"
 )
 
 // StakeInfo returns information about wallet stakes, tickets and their statuses.
@@ -178,10 +180,34 @@ func (wallet *Wallet) TicketPrice(ctx context.Context) (*TicketPriceResponse, er
 
 // PurchaseTickets purchases tickets from the wallet. Returns a slice of hashes for tickets purchased
 func (wallet *Wallet) PurchaseTickets(ctx context.Context, request *PurchaseTicketsRequest, vspHost string) ([]string, error) {
+	return wallet.purchaseTickets(ctx, request, vspHost, nil)
+}
+
+// purchaseTickets purchases tickets like PurchaseTickets. vspInfo is the info
+// of the vspd VSP at vspHost if it was already fetched, nil otherwise.
+func (wallet *Wallet) purchaseTickets(ctx context.Context, request *PurchaseTicketsRequest, vspHost string,
+	vspInfo *vsp.VSPInfoResponse) ([]string, error) {
+
 	var err error
 
-	// fetch redeem script, ticket address, pool address and pool fee if vsp host isn't empty
-	if vspHost != "" {
+	// register the tickets with the vspd vsp if its pubkey is set, otherwise
+	// fetch redeem script, ticket address, pool address and pool fee from the
+	// legacy vsp if vsp host isn't empty
+	var vspClient *vsp.Client
+	if vspHost != "" && request.VSPPubKey != "" {
+		if request.TicketAddress != "" || request.PoolAddress != "" {
+			return nil, errors.New("Ticket and pool addresses are not used with vspd")
+		}
+
+		pubKey, err := base64.StdEncoding.DecodeString(request.VSPPubKey)
+		if err != nil {
+			return nil, errors.New("Invalid vsp pubkey")
+		}
+		vspClient, err = wallet.vspClient(vspHost, pubKey)
+		if err != nil {
+			return nil, errors.New("Invalid vsp host or pubkey")
+		}
+	} else if vspHost != "" {
 		if err = wallet.updateTicketPurchaseRequestWithVSPInfo(vspHost, request); err != nil {
 			return nil, err
 		}
@@ -245,6 +271,10 @@ func (wallet *Wallet) PurchaseTickets(ctx context.Context, request *PurchaseTick
 		VotingAddress: ticketAddr,
 		MinConf:       minConf,
 		Expiry:        expiry,
+		VotingAccount: request.VotingAccount,
+	}
+	if vspClient != nil {
+		wallet.vspFeePurchaseProcess(vspClient, vspInfo, request.Account, purchaseTicketsRequest)
 	}
 
 	netBackend, err := wallet.internal.NetworkBackend()
@@ -278,7 +308,7 @@ func (wallet *Wallet) updateTicketPurchaseRequestWithVSPInfo(vspHost string, req
 	}
 
 	// invoke vsp api
-	ticketPurchaseInfo, err := CallVSPTicketInfoAPI(vspHost, pubKeyAddr)
+	ticketPurchaseInfo, err := callVSPTicketInfoAPI(proxyHTTPClient(wallet.socksProxy()), vspHost, pubKeyAddr)
 	if err != nil {
 		return fmt.Errorf("vsp connection error: %s", err.Error())
 	}
@@ -307,7 +337,14 @@ func (wallet *Wallet) updateTicketPurchaseRequestWithVSPInfo(vspHost string, req
 	return nil
 }
 
-func CallVSPTicketInfoAPI(vspHost, pubKeyAddr string) (ticketPurchaseInfo *VSPTicketPurchaseInfo, err error) {
+// CallVSPTicketInfoAPI requests the ticket purchase info of pubKeyAddr from
+// the legacy VSP at vspHost through the SOCKS5 proxy of the multiwallet, if
+// one is set.
+func (mw *MultiWallet) CallVSPTicketInfoAPI(vspHost, pubKeyAddr string) (ticketPurchaseInfo *VSPTicketPurchaseInfo, err error) {
+	return callVSPTicketInfoAPI(proxyHTTPClient(mw.socksProxy()), vspHost, pubKeyAddr)
+}
+
+func callVSPTicketInfoAPI(client *http.Client, vspHost, pubKeyAddr string) (ticketPurchaseInfo *VSPTicketPurchaseInfo, err error) {
 	apiUrl := fmt.Sprintf("%s/api/v2/purchaseticket", strings.TrimSuffix(vspHost, "/"))
 	data := url.Values{}
 	data.Set("UserPubKeyAddr", pubKeyAddr)
@@ -319,23 +356,27 @@ func CallVSPTicketInfoAPI(vspHost, pubKeyAddr string) (ticketPurchaseInfo *VSPTi
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
-	var apiResponse map[string]interface{}
+	var apiResponse struct {
+		Status  string                 `json:"status"`
+		Message string                 `json:"message"`
+		Data    *VSPTicketPurchaseInfo `json:"data"`
+	}
 	err = json.NewDecoder(resp.Body).Decode(&apiResponse)
-	if err == nil {
-		data := apiResponse["data"].(map[string]interface{})
-		ticketPurchaseInfo = &VSPTicketPurchaseInfo{
-			Script:        data["Script"].(string),
-			PoolFees:      data["PoolFees"].(float64),
-			PoolAddress:   data["PoolAddress"].(string),
-			TicketAddress: data["TicketAddress"].(string),
+	if err != nil {
+		return nil, fmt.Errorf("invalid vsp response: %s", err.Error())
+	}
+	if apiResponse.Data == nil || apiResponse.Data.Script == "" || apiResponse.Data.TicketAddress == "" {
+		if apiResponse.Message != "" {
+			return nil, fmt.Errorf("vsp error: %s", apiResponse.Message)
 		}
+		return nil, fmt.Errorf("invalid vsp response: missing ticket purchase info")
 	}
-	return
+
+	return apiResponse.Data, nil
 }
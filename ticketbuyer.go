@@ -0,0 +1,422 @@
+package dcrlibwallet
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"decred.org/dcrwallet/wallet/txrules"
+	"decred.org/dcrwallet/wallet/txsizes"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/planetdecred/dcrlibwallet/vsp"
+)
+
+// Reasons that the automatic ticket buyer did not buy tickets at a block.
+const (
+	TicketBuyerSkipNotSynced              = "not_synced"
+	TicketBuyerSkipPurchaseInProgress     = "purchase_in_progress"
+	TicketBuyerSkipTicketPriceUnavailable = "ticket_price_unavailable"
+	TicketBuyerSkipTicketPriceAboveMax    = "ticket_price_above_max"
+	TicketBuyerSkipInsufficientBalance    = "insufficient_balance"
+	TicketBuyerSkipPriceChangePending     = "price_change_pending"
+	TicketBuyerSkipPurchaseFailed         = "purchase_failed"
+)
+
+// ticketBuyer is the state of the automatic ticket buyer of a wallet. The
+// passphrase is kept in memory while the ticket buyer runs and cleared when
+// it is stopped. The info of the VSP of the config is fetched once for the
+// run and fetched again after a failed purchase.
+type ticketBuyer struct {
+	config     TicketBuyerConfig
+	passphrase []byte
+	ctx        context.Context
+	cancel     context.CancelFunc
+	purchasing bool
+	vspInfo    *vsp.VSPInfoResponse
+	status     TicketBuyerStatus
+}
+
+// StartAutoTicketBuyer starts buying tickets with the funds of the source
+// account of config as blocks are attached, keeping the balance to maintain
+// in the account. The passphrase is checked before the ticket buyer starts
+// and is kept in memory until StopAutoTicketBuyer is called.
+func (wallet *Wallet) StartAutoTicketBuyer(config *TicketBuyerConfig, passphrase []byte) error {
+	if wallet.IsWatchingOnlyWallet() {
+		return errors.New(ErrWalletIsWatchOnly)
+	}
+	if config == nil || config.BalanceToMaintain < 0 || config.MaxTicketPrice < 0 || config.MaxTicketsPerBlock < 0 {
+		return errors.New(ErrInvalid)
+	}
+	if _, err := wallet.GetAccount(int32(config.Account)); err != nil {
+		return err
+	}
+	if _, err := wallet.GetAccount(int32(config.VotingAccount)); err != nil {
+		return err
+	}
+	if config.VSPPubKey != "" {
+		pubKey, err := base64.StdEncoding.DecodeString(config.VSPPubKey)
+		if err != nil {
+			return errors.New(ErrInvalid)
+		}
+		if _, err = wallet.vspClient(config.VSPHost, pubKey); err != nil {
+			return errors.New(ErrInvalid)
+		}
+	}
+
+	ctx := wallet.shutdownContext()
+	lock := make(chan time.Time, 1)
+	err := wallet.internal.Unlock(ctx, passphrase, lock)
+	lock <- time.Time{} // send matters, not the value
+	if err != nil {
+		return translateError(err)
+	}
+
+	wallet.ticketBuyerMu.Lock()
+	defer wallet.ticketBuyerMu.Unlock()
+
+	if wallet.ticketBuyer != nil && wallet.ticketBuyer.cancel != nil {
+		return errors.New(ErrTicketBuyerAlreadyRunning)
+	}
+
+	tb := &ticketBuyer{
+		config:     *config,
+		passphrase: append([]byte(nil), passphrase...),
+	}
+	tb.ctx, tb.cancel = context.WithCancel(ctx)
+	tb.status.Running = true
+	tb.status.Config = &tb.config
+	tb.status.StartedAt = time.Now().Unix()
+	wallet.ticketBuyer = tb
+
+	log.Infof("[%d] Automatic ticket buyer started", wallet.ID)
+	return nil
+}
+
+// StopAutoTicketBuyer stops the automatic ticket buyer and cancels the
+// ticket purchase in progress, if any.
+func (wallet *Wallet) StopAutoTicketBuyer() {
+	wallet.ticketBuyerMu.Lock()
+	defer wallet.ticketBuyerMu.Unlock()
+
+	tb := wallet.ticketBuyer
+	if tb == nil || tb.cancel == nil {
+		return
+	}
+
+	tb.cancel()
+	tb.cancel = nil
+	for i := range tb.passphrase {
+		tb.passphrase[i] = 0
+	}
+	tb.passphrase = nil
+	tb.status.Running = false
+
+	log.Infof("[%d] Automatic ticket buyer stopped", wallet.ID)
+}
+
+// IsAutoTicketBuyerRunning returns true if the automatic ticket buyer of the
+// wallet is running.
+func (wallet *Wallet) IsAutoTicketBuyerRunning() bool {
+	wallet.ticketBuyerMu.Lock()
+	defer wallet.ticketBuyerMu.Unlock()
+	return wallet.ticketBuyer != nil && wallet.ticketBuyer.cancel != nil
+}
+
+func (wallet *Wallet) AutoTicketBuyerStatus() (string, error) {
+	status, err := json.Marshal(wallet.AutoTicketBuyerStatusRaw())
+	if err != nil {
+		return "", err
+	}
+	return string(status), nil
+}
+
+// AutoTicketBuyerStatusRaw returns the status of the automatic ticket buyer
+// of the wallet. The status of the last run is kept after the ticket buyer
+// is stopped.
+func (wallet *Wallet) AutoTicketBuyerStatusRaw() *TicketBuyerStatus {
+	wallet.ticketBuyerMu.Lock()
+	defer wallet.ticketBuyerMu.Unlock()
+
+	if wallet.ticketBuyer == nil {
+		return &TicketBuyerStatus{}
+	}
+
+	status := wallet.ticketBuyer.status
+	config := *status.Config
+	status.Config = &config
+	if status.LastReport != nil {
+		report := *status.LastReport
+		status.LastReport = &report
+	}
+	return &status
+}
+
+func (mw *MultiWallet) AddTicketBuyerListener(listener TicketBuyerListener, uniqueIdentifier string) error {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	if _, ok := mw.ticketBuyerListeners[uniqueIdentifier]; ok {
+		return errors.New(ErrListenerAlreadyExist)
+	}
+
+	mw.ticketBuyerListeners[uniqueIdentifier] = listener
+	return nil
+}
+
+func (mw *MultiWallet) RemoveTicketBuyerListener(uniqueIdentifier string) {
+	mw.notificationListenersMu.Lock()
+	defer mw.notificationListenersMu.Unlock()
+
+	delete(mw.ticketBuyerListeners, uniqueIdentifier)
+}
+
+// runTicketBuyer buys tickets for the block at blockHeight if the automatic
+// ticket buyer of the wallet is running. Blocks attached while the wallets
+// are syncing are ignored. Tickets are bought in the background so that
+// block notifications are not held up by the purchase, and blocks attached
+// while a purchase is in progress are skipped.
+func (mw *MultiWallet) runTicketBuyer(wallet *Wallet, blockHeight int32) {
+	if !mw.IsSynced() {
+		return
+	}
+
+	wallet.ticketBuyerMu.Lock()
+	tb := wallet.ticketBuyer
+	if tb == nil || tb.cancel == nil {
+		wallet.ticketBuyerMu.Unlock()
+		return
+	}
+
+	report := &TicketBuyerReport{
+		WalletID:    wallet.ID,
+		BlockHeight: blockHeight,
+	}
+	if tb.purchasing {
+		report.SkipReason = TicketBuyerSkipPurchaseInProgress
+		event := tb.recordRun(wallet.ID, report)
+		wallet.ticketBuyerMu.Unlock()
+		mw.publishEvent(event)
+		return
+	}
+
+	tb.purchasing = true
+	config := tb.config
+	passphrase := append([]byte(nil), tb.passphrase...)
+	ctx := tb.ctx
+	wallet.ticketBuyerMu.Unlock()
+
+	go func() {
+		wallet.buyTickets(ctx, tb, &config, passphrase, report)
+
+		wallet.ticketBuyerMu.Lock()
+		tb.purchasing = false
+		if ctx.Err() != nil {
+			// The ticket buyer was stopped during the purchase.
+			wallet.ticketBuyerMu.Unlock()
+			return
+		}
+		event := tb.recordRun(wallet.ID, report)
+		wallet.ticketBuyerMu.Unlock()
+		mw.publishEvent(event)
+	}()
+}
+
+// recordRun records the report of a run of the ticket buyer in its status
+// and returns the event that reports it. wallet.ticketBuyerMu must be held.
+func (tb *ticketBuyer) recordRun(walletID int, report *TicketBuyerReport) Event {
+	tb.status.LastBlockHeight = report.BlockHeight
+	tb.status.LastReport = report
+
+	if len(report.TicketHashes) == 0 {
+		log.Debugf("[%d] Ticket buyer skipped block %d: %s", walletID, report.BlockHeight, report.SkipReason)
+		return Event{Kind: TicketPurchaseSkippedEvent, WalletID: walletID, BlockHeight: report.BlockHeight, TicketBuyer: report}
+	}
+
+	tb.status.TicketsPurchased += int32(len(report.TicketHashes))
+	tb.status.LastPurchaseAt = time.Now().Unix()
+	log.Infof("[%d] Ticket buyer purchased %d tickets at block %d", walletID, len(report.TicketHashes), report.BlockHeight)
+	return Event{Kind: TicketsPurchasedEvent, WalletID: walletID, BlockHeight: report.BlockHeight, TicketBuyer: report}
+}
+
+// buyTickets buys as many tickets as the spendable balance of the source
+// account allows after the balance to maintain and the estimated fees, up
+// to the maximum tickets per block, and records the outcome in report. Like
+// the ticket buyer of dcrwallet, tickets are not bought when the ticket
+// price changes before they can be mined, and they expire before the price
+// changes.
+func (wallet *Wallet) buyTickets(ctx context.Context, tb *ticketBuyer, config *TicketBuyerConfig, passphrase []byte,
+	report *TicketBuyerReport) {
+
+	defer func() {
+		for i := range passphrase {
+			passphrase[i] = 0
+		}
+	}()
+
+	rescanPoint, err := wallet.internal.RescanPoint(ctx)
+	if err != nil || rescanPoint != nil {
+		report.SkipReason = TicketBuyerSkipNotSynced
+		return
+	}
+
+	ticketPrice, err := wallet.TicketPrice(ctx)
+	if err != nil {
+		report.SkipReason = TicketBuyerSkipTicketPriceUnavailable
+		report.Error = err.Error()
+		return
+	}
+	report.TicketPrice = ticketPrice.TicketPrice
+	if config.MaxTicketPrice > 0 && ticketPrice.TicketPrice > config.MaxTicketPrice {
+		report.SkipReason = TicketBuyerSkipTicketPriceAboveMax
+		return
+	}
+
+	balance, err := wallet.GetAccountBalance(int32(config.Account))
+	if err != nil {
+		report.SkipReason = TicketBuyerSkipPurchaseFailed
+		report.Error = err.Error()
+		return
+	}
+	report.SpendableBalance = balance.Spendable
+	available := balance.Spendable - config.BalanceToMaintain
+	if ticketPrice.TicketPrice <= 0 || available < ticketPrice.TicketPrice {
+		report.SkipReason = TicketBuyerSkipInsufficientBalance
+		return
+	}
+
+	// The earliest that a ticket may be mined is two blocks after the
+	// block, with the split transaction that funds it in the next block.
+	intervalSize := int32(wallet.chainParams.StakeDiffWindowSize)
+	nextIntervalStart := (report.BlockHeight/intervalSize + 1) * intervalSize
+	if report.BlockHeight+2 == nextIntervalStart {
+		report.SkipReason = TicketBuyerSkipPriceChangePending
+		return
+	}
+	expiry := nextIntervalStart
+	if report.BlockHeight+1 == nextIntervalStart {
+		expiry += intervalSize
+	}
+
+	var vspInfo *vsp.VSPInfoResponse
+	if config.VSPPubKey != "" {
+		vspInfo, err = wallet.ticketBuyerVSPInfo(ctx, tb, config)
+		if err != nil {
+			report.SkipReason = TicketBuyerSkipPurchaseFailed
+			report.Error = err.Error()
+			return
+		}
+	}
+
+	// The fees of the tickets are paid from the available funds on top of
+	// the ticket prices.
+	numTickets := available / ticketPrice.TicketPrice
+	if max := int64(wallet.chainParams.MaxFreshStakePerBlock); numTickets > max {
+		numTickets = max
+	}
+	if config.MaxTicketsPerBlock > 0 && numTickets > int64(config.MaxTicketsPerBlock) {
+		numTickets = int64(config.MaxTicketsPerBlock)
+	}
+	for numTickets > 0 {
+		fees := wallet.estimateTicketFees(ticketPrice, vspInfo, numTickets)
+		if numTickets*ticketPrice.TicketPrice+fees <= available {
+			break
+		}
+		numTickets--
+	}
+	if numTickets == 0 {
+		report.SkipReason = TicketBuyerSkipInsufficientBalance
+		return
+	}
+
+	request := &PurchaseTicketsRequest{
+		Account:               config.Account,
+		RequiredConfirmations: uint32(wallet.RequiredConfirmations()),
+		NumTickets:            uint32(numTickets),
+		Passphrase:            passphrase,
+		Expiry:                uint32(expiry),
+		VotingAccount:         config.VotingAccount,
+		VSPPubKey:             config.VSPPubKey,
+	}
+	ticketHashes, err := wallet.purchaseTickets(ctx, request, config.VSPHost, vspInfo)
+	if err != nil {
+		// The VSP info is fetched again in case the purchase failed
+		// because it changed.
+		wallet.ticketBuyerMu.Lock()
+		tb.vspInfo = nil
+		wallet.ticketBuyerMu.Unlock()
+
+		report.SkipReason = TicketBuyerSkipPurchaseFailed
+		report.Error = err.Error()
+		return
+	}
+	report.TicketHashes = ticketHashes
+}
+
+// ticketBuyerVSPInfo returns the info of the VSP of config, fetching it from
+// the VSP if it was not fetched yet during the run of tb.
+func (wallet *Wallet) ticketBuyerVSPInfo(ctx context.Context, tb *ticketBuyer, config *TicketBuyerConfig) (*vsp.VSPInfoResponse, error) {
+	wallet.ticketBuyerMu.Lock()
+	info := tb.vspInfo
+	wallet.ticketBuyerMu.Unlock()
+	if info != nil {
+		return info, nil
+	}
+
+	pubKey, err := base64.StdEncoding.DecodeString(config.VSPPubKey)
+	if err != nil {
+		return nil, errors.New(ErrInvalid)
+	}
+	client, err := wallet.vspClient(config.VSPHost, pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, vspRequestTimeout)
+	defer cancel()
+	info, err = client.VSPInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("vsp connection error: %s", err.Error())
+	}
+
+	wallet.ticketBuyerMu.Lock()
+	tb.vspInfo = info
+	wallet.ticketBuyerMu.Unlock()
+	return info, nil
+}
+
+// estimateTicketFees returns the estimated fees paid for numTickets tickets
+// on top of the ticket prices: the split transaction that funds the tickets,
+// the ticket transactions and, for tickets purchased through the VSP of
+// vspInfo, the VSP fees and the transactions that pay them. Like dcrwallet,
+// the sizes of the transactions are estimated for the worst case.
+func (wallet *Wallet) estimateTicketFees(ticketPrice *TicketPriceResponse, vspInfo *vsp.VSPInfoResponse, numTickets int64) int64 {
+	relayFee := wallet.internal.RelayFee()
+
+	// The split transaction spends an input and has an output for each
+	// ticket and a change output.
+	splitOutputs := make([]int, numTickets)
+	for i := range splitOutputs {
+		splitOutputs[i] = txsizes.P2PKHPkScriptSize
+	}
+	splitSize := txsizes.EstimateSerializeSizeFromScriptSizes([]int{txsizes.RedeemP2PKHSigScriptSize},
+		splitOutputs, txsizes.P2PKHPkScriptSize)
+	splitFee := txrules.FeeForSerializeSize(relayFee, splitSize)
+
+	ticketSize := txsizes.EstimateSerializeSizeFromScriptSizes([]int{txsizes.RedeemP2PKHSigScriptSize},
+		[]int{txsizes.P2PKHPkScriptSize + 1, txsizes.TicketCommitmentScriptSize, txsizes.P2PKHPkScriptSize + 1}, 0)
+	ticketFee := txrules.FeeForSerializeSize(relayFee, ticketSize)
+
+	feePerTicket := ticketFee
+	if vspInfo != nil {
+		vspFee := txrules.StakePoolTicketFee(dcrutil.Amount(ticketPrice.TicketPrice), ticketFee, ticketPrice.Height,
+			vspInfo.FeePercentage, wallet.chainParams)
+		feeTxSize := txsizes.EstimateSerializeSizeFromScriptSizes([]int{txsizes.RedeemP2PKHSigScriptSize},
+			[]int{txsizes.P2PKHPkScriptSize}, txsizes.P2PKHPkScriptSize)
+		feePerTicket += vspFee + txrules.FeeForSerializeSize(relayFee, feeTxSize)
+	}
+
+	return int64(splitFee + feePerTicket*dcrutil.Amount(numTickets))
+}
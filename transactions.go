@@ -71,7 +71,13 @@ func (wallet *Wallet) GetTransactionRaw(txHash []byte) (*Transaction, error) {
 		return nil, err
 	}
 
-	return wallet.decodeTransactionWithTxSummary(txSummary, blockHash)
+	tx, err := wallet.decodeTransactionWithTxSummary(txSummary, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	wallet.resolveContactNames(tx)
+	return tx, nil
 }
 
 func (wallet *Wallet) GetTransactions(offset, limit, txFilter int32, newestFirst bool) (string, error) {
@@ -90,6 +96,13 @@ func (wallet *Wallet) GetTransactions(offset, limit, txFilter int32, newestFirst
 
 func (wallet *Wallet) GetTransactionsRaw(offset, limit, txFilter int32, newestFirst bool) (transactions []Transaction, err error) {
 	err = wallet.txDB.Read(offset, limit, txFilter, newestFirst, &transactions)
+	if err != nil {
+		return
+	}
+
+	for i := range transactions {
+		wallet.resolveContactNames(&transactions[i])
+	}
 	return
 }
 
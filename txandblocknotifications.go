@@ -1,8 +1,6 @@
 package dcrlibwallet
 
 import (
-	"encoding/json"
-
 	"decred.org/dcrwallet/errors"
 )
 
@@ -34,17 +32,25 @@ func (mw *MultiWallet) listenForTransactions(walletID int) {
 					if !overwritten {
 						log.Infof("[%d] New Transaction %s", wallet.ID, tempTransaction.Hash)
 
-						result, err := json.Marshal(tempTransaction)
-						if err != nil {
-							log.Error(err)
-						} else {
-							mw.mempoolTransactionNotification(string(result))
-						}
+						mw.markContactsUsed(tempTransaction)
+						mw.matchPaymentRequests(wallet, tempTransaction)
+						wallet.resolveContactNames(tempTransaction)
+
+						mw.publishEvent(Event{
+							Kind:        TransactionEvent,
+							WalletID:    wallet.ID,
+							Transaction: tempTransaction,
+						})
 					}
 				}
 
+				// The unmined transactions removed by the wallet are checked
+				// against the transactions of all attached blocks at once,
+				// as the block that removed them may not be the first one.
+				spentOutpoints := make(map[string]string)
 				for _, block := range v.AttachedBlocks {
 					blockHash := block.Header.BlockHash()
+					txHashes := make([]string, 0, len(block.Transactions))
 					for _, transaction := range block.Transactions {
 						tempTransaction, err := wallet.decodeTransactionWithTxSummary(&transaction, &blockHash)
 						if err != nil {
@@ -58,11 +64,29 @@ func (mw *MultiWallet) listenForTransactions(walletID int) {
 							return
 						}
 						mw.publishTransactionConfirmed(wallet.ID, transaction.Hash.String(), int32(block.Header.Height))
+						txHashes = append(txHashes, transaction.Hash.String())
+						for _, input := range tempTransaction.Inputs {
+							spentOutpoints[input.PreviousOutpoint] = tempTransaction.Hash
+						}
+
+						mw.matchPaymentRequests(wallet, tempTransaction)
 					}
 
+					mw.trackTransactionConfirmations(wallet.ID, txHashes)
+					mw.checkTransactionConfirmations(wallet, int32(block.Header.Height))
+					mw.updatePaymentRequests(wallet)
+
 					mw.publishBlockAttached(wallet.ID, int32(block.Header.Height))
 				}
 
+				if len(v.AttachedBlocks) > 0 {
+					mw.checkConflictedTransactions(wallet, spentOutpoints)
+					mw.checkDroppedTransactions(wallet)
+
+					tip := v.AttachedBlocks[len(v.AttachedBlocks)-1]
+					mw.runTicketBuyer(wallet, int32(tip.Header.Height))
+				}
+
 			case <-mw.syncData.syncCanceled:
 				n.Done()
 			}
@@ -91,29 +115,15 @@ func (mw *MultiWallet) RemoveTxAndBlockNotificationListener(uniqueIdentifier str
 	delete(mw.txAndBlockNotificationListeners, uniqueIdentifier)
 }
 
-func (mw *MultiWallet) mempoolTransactionNotification(transaction string) {
-	mw.notificationListenersMu.RLock()
-	defer mw.notificationListenersMu.RUnlock()
-
-	for _, txAndBlockNotifcationListener := range mw.txAndBlockNotificationListeners {
-		txAndBlockNotifcationListener.OnTransaction(transaction)
-	}
-}
-
 func (mw *MultiWallet) publishTransactionConfirmed(walletID int, transactionHash string, blockHeight int32) {
-	mw.notificationListenersMu.RLock()
-	defer mw.notificationListenersMu.RUnlock()
-
-	for _, txAndBlockNotifcationListener := range mw.txAndBlockNotificationListeners {
-		txAndBlockNotifcationListener.OnTransactionConfirmed(walletID, transactionHash, blockHeight)
-	}
+	mw.publishEvent(Event{
+		Kind:        TransactionConfirmedEvent,
+		WalletID:    walletID,
+		TxHash:      transactionHash,
+		BlockHeight: blockHeight,
+	})
 }
 
 func (mw *MultiWallet) publishBlockAttached(walletID int, blockHeight int32) {
-	mw.notificationListenersMu.RLock()
-	defer mw.notificationListenersMu.RUnlock()
-
-	for _, txAndBlockNotifcationListener := range mw.txAndBlockNotificationListeners {
-		txAndBlockNotifcationListener.OnBlockAttached(walletID, blockHeight)
-	}
+	mw.publishEvent(Event{Kind: BlockAttachedEvent, WalletID: walletID, BlockHeight: blockHeight})
 }
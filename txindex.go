@@ -7,11 +7,26 @@ import (
 )
 
 func (wallet *Wallet) IndexTransactions() error {
+	beginHeight, err := wallet.txDB.ReadIndexingStartBlock()
+	if err != nil {
+		log.Errorf("[%d] Get tx indexing start point error: %v", wallet.ID, err)
+		return err
+	}
+
+	return wallet.indexTransactionsFrom(beginHeight)
+}
+
+// indexTransactionsFrom indexes the transactions of the wallet in the blocks
+// from beginHeight to the best block, and the unmined transactions.
+// Transactions that are already indexed are updated. The confirmations of
+// transactions that were not indexed as mined before are tracked.
+func (wallet *Wallet) indexTransactionsFrom(beginHeight int32) error {
 	ctx := wallet.shutdownContext()
 
 	var totalIndex int32
 	var txEndHeight uint32
 	rangeFn := func(block *w.Block) (bool, error) {
+		var minedTxHashes []string
 		for _, transaction := range block.Transactions {
 
 			var blockHash *chainhash.Hash
@@ -27,15 +42,23 @@ func (wallet *Wallet) IndexTransactions() error {
 				return false, err
 			}
 
-			_, err = wallet.txDB.SaveOrUpdate(&Transaction{}, tx)
+			indexedTx := &Transaction{}
+			overwritten, err := wallet.txDB.SaveOrUpdate(indexedTx, tx)
 			if err != nil {
 				log.Errorf("[%d] Index tx replace tx err : %v", wallet.ID, err)
 				return false, err
 			}
+			if block.Header != nil && (!overwritten || indexedTx.BlockHeight == BlockHeightInvalid) {
+				minedTxHashes = append(minedTxHashes, tx.Hash)
+			}
 
 			totalIndex++
 		}
 
+		if len(minedTxHashes) > 0 && wallet.trackConfirmations != nil {
+			wallet.trackConfirmations(wallet.ID, minedTxHashes)
+		}
+
 		if block.Header != nil {
 			txEndHeight = block.Header.Height
 			err := wallet.txDB.SaveLastIndexPoint(int32(txEndHeight))
@@ -55,12 +78,6 @@ func (wallet *Wallet) IndexTransactions() error {
 		}
 	}
 
-	beginHeight, err := wallet.txDB.ReadIndexingStartBlock()
-	if err != nil {
-		log.Errorf("[%d] Get tx indexing start point error: %v", wallet.ID, err)
-		return err
-	}
-
 	endHeight := wallet.GetBestBlock()
 
 	startBlock := w.NewBlockIdentifierFromHeight(beginHeight)
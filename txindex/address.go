@@ -0,0 +1,76 @@
+package txindex
+
+import (
+	"reflect"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	bolt "go.etcd.io/bbolt"
+)
+
+// AddressOutput is an output of an indexed transaction that pays to an
+// address. Address outputs are saved with their transaction so that the
+// transactions of an address can be found without reading every indexed
+// transaction.
+type AddressOutput struct {
+	ID      string `storm:"id"`
+	Address string `storm:"index"`
+	TxHash  string `storm:"index"`
+	Account int32
+	Amount  int64
+}
+
+// AddressIndexedTx is implemented by the transactions whose outputs are
+// indexed by address when they are saved.
+type AddressIndexedTx interface {
+	AddressOutputs() []*AddressOutput
+}
+
+// ReadAddressOutputs returns the indexed outputs that pay to the address.
+func (db *DB) ReadAddressOutputs(address string) ([]*AddressOutput, error) {
+	var outputs []*AddressOutput
+	err := db.txDB.Select(q.Eq("Address", address)).Find(&outputs)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// saveAddressOutputs replaces the address outputs of tx, if it is indexed by
+// address.
+func (db *DB) saveAddressOutputs(tx interface{}) error {
+	indexedTx, ok := tx.(AddressIndexedTx)
+	if !ok {
+		return nil
+	}
+
+	if err := db.deleteAddressOutputs(txHash(tx)); err != nil {
+		return err
+	}
+	for _, output := range indexedTx.AddressOutputs() {
+		if err := db.txDB.Save(output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) deleteAddressOutputs(hash string) error {
+	err := db.txDB.Select(q.Eq("TxHash", hash)).Delete(&AddressOutput{})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (db *DB) dropAddressOutputs() error {
+	err := db.txDB.Drop(&AddressOutput{})
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	return nil
+}
+
+func txHash(tx interface{}) string {
+	return reflect.Indirect(reflect.ValueOf(tx)).FieldByName("Hash").String()
+}
@@ -16,7 +16,7 @@ const (
 
 	// Necessary to force re-indexing if changes are made to the structure of data being stored.
 	// Increment this version number if db structure changes such that client apps need to re-index.
-	TxDbVersion uint32 = 1
+	TxDbVersion uint32 = 2
 )
 
 type DB struct {
@@ -44,6 +44,10 @@ func Initialize(dbPath string, data interface{}) (*DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error initializing tx database for wallet: %s", err.Error())
 	}
+	err = txDB.Init(&AddressOutput{})
+	if err != nil {
+		return nil, fmt.Errorf("error initializing tx database for wallet: %s", err.Error())
+	}
 
 	return &DB{
 		txDB,
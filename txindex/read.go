@@ -2,6 +2,7 @@ package txindex
 
 import (
 	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
 )
 
 const MaxReOrgBlocks = 6
@@ -63,3 +64,13 @@ func (db *DB) Count(txFilter int32, txObj interface{}) (int, error) {
 func (db *DB) FindOne(fieldName string, value interface{}, txObj interface{}) error {
 	return db.txDB.One(fieldName, value, txObj)
 }
+
+// ReadUnmined saves the transactions that are not yet included in a block,
+// identified by a block height of -1, to the received `transactions` object.
+func (db *DB) ReadUnmined(transactions interface{}) error {
+	err := db.txDB.Select(q.Eq("BlockHeight", int32(-1))).Find(transactions)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
@@ -11,11 +11,10 @@ import (
 const KeyEndBlock = "EndBlock"
 
 // SaveOrUpdate saves a transaction to the database and would overwrite
-// if a transaction with same hash exists
+// if a transaction with same hash exists. The outputs of transactions that
+// implement AddressIndexedTx are indexed by address.
 func (db *DB) SaveOrUpdate(emptyTxPointer, tx interface{}) (overwritten bool, err error) {
-	v := reflect.ValueOf(tx)
-	txHash := reflect.Indirect(v).FieldByName("Hash").String()
-	err = db.txDB.One("Hash", txHash, emptyTxPointer)
+	err = db.txDB.One("Hash", txHash(tx), emptyTxPointer)
 	if err != nil && err != storm.ErrNotFound {
 		err = errors.Errorf("error checking if tx was already indexed: %s", err.Error())
 		return
@@ -30,6 +29,11 @@ func (db *DB) SaveOrUpdate(emptyTxPointer, tx interface{}) (overwritten bool, er
 	}
 
 	err = db.txDB.Save(tx)
+	if err != nil {
+		return
+	}
+
+	err = db.saveAddressOutputs(tx)
 	return
 }
 
@@ -46,6 +50,18 @@ func (db *DB) ClearSavedTransactions(emptyTxPointer interface{}) error {
 	if err != nil {
 		return err
 	}
+	if err = db.dropAddressOutputs(); err != nil {
+		return err
+	}
 
 	return db.SaveLastIndexPoint(0)
 }
+
+// Delete removes a saved transaction and its address outputs from the
+// database.
+func (db *DB) Delete(tx interface{}) error {
+	if err := db.txDB.DeleteStruct(tx); err != nil {
+		return err
+	}
+	return db.deleteAddressOutputs(txHash(tx))
+}
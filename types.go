@@ -105,6 +105,22 @@ type HeadersRescanProgressReport struct {
 	WalletID            int   `json:"walletID"`
 }
 
+// HeadersImportProgressReport is the progress of importing headers from a
+// header snapshot. The import runs before headers are fetched from peers and
+// the total sync progress is not updated during the import.
+type HeadersImportProgressReport struct {
+	*GeneralSyncProgress
+	TotalHeadersToImport  int32 `json:"totalHeadersToImport"`
+	CurrentHeaderHeight   int32 `json:"currentHeaderHeight"`
+	HeadersImportProgress int32 `json:"headersImportProgress"`
+}
+
+// HeadersImportProgressListener is notified with the progress of importing
+// headers from a header snapshot at the start of SPV sync.
+type HeadersImportProgressListener interface {
+	OnHeadersImportProgress(headersImportProgress *HeadersImportProgressReport)
+}
+
 type DebugInfo struct {
 	TotalTimeElapsed          int64
 	TotalTimeRemaining        int64
@@ -112,6 +128,173 @@ type DebugInfo struct {
 	CurrentStageTimeRemaining int64
 }
 
+type PeerInfo struct {
+	ID              int32  `json:"id"`
+	Address         string `json:"address"`
+	UserAgent       string `json:"userAgent"`
+	ProtocolVersion int32  `json:"protocolVersion"`
+	Services        string `json:"services"`
+	StartingHeight  int32  `json:"startingHeight"`
+	BanScore        int32  `json:"banScore"`
+	PingMillis      int64  `json:"pingMillis"`
+	BytesSent       int64  `json:"bytesSent"`
+	BytesReceived   int64  `json:"bytesReceived"`
+}
+
+// StageDataUsage holds the bytes transferred with peers by direction and by
+// the sync stage that was active during the transfer. OtherBytes counts the
+// data transferred outside of the sync stages, e.g. while connecting to
+// peers or after sync has completed.
+type StageDataUsage struct {
+	BytesSent             int64 `json:"bytesSent"`
+	BytesReceived         int64 `json:"bytesReceived"`
+	HeadersFetchBytes     int64 `json:"headersFetchBytes"`
+	AddressDiscoveryBytes int64 `json:"addressDiscoveryBytes"`
+	HeadersRescanBytes    int64 `json:"headersRescanBytes"`
+	OtherBytes            int64 `json:"otherBytes"`
+}
+
+type PeerDataUsage struct {
+	Address       string `json:"address"`
+	BytesSent     int64  `json:"bytesSent"`
+	BytesReceived int64  `json:"bytesReceived"`
+}
+
+type SyncDataUsage struct {
+	StageDataUsage
+	StartedAt int64            `json:"startedAt"`
+	Peers     []*PeerDataUsage `json:"peers"`
+}
+
+// DataBudgetListener is notified when SPV sync is paused because the data
+// transferred today exceeded the budget set with SetSyncDataBudget. The
+// sync is resumed when the next UTC day starts.
+type DataBudgetListener interface {
+	OnSyncDataBudgetExceeded(usedBytes, budgetBytes int64)
+}
+
+// SyncStallListener is notified with the JSON encoded SyncStallReport when a
+// stalled SPV sync is about to be restarted.
+type SyncStallListener interface {
+	OnSyncStalled(report string)
+}
+
+// SyncStallReport describes a sync stage that made no progress for longer
+// than its stall timeout. The peers that were connected are not connected to
+// again for a while, and the sync is restarted after RetryDelaySeconds
+// unless it makes progress in the meantime. Retry counts the consecutive
+// stalls of the sync.
+type SyncStallReport struct {
+	SyncStage         int32    `json:"syncStage"`
+	StalledSeconds    int64    `json:"stalledSeconds"`
+	ConnectedPeers    int32    `json:"connectedPeers"`
+	RotatedPeers      []string `json:"rotatedPeers"`
+	Retry             int32    `json:"retry"`
+	RetryDelaySeconds int64    `json:"retryDelaySeconds"`
+	Reason            string   `json:"reason"`
+}
+
+// CFilterValidationStats counts the cfilters validated against the header
+// commitments of their blocks during SPV sync. InvalidResponses counts the
+// responses of peers with invalid cfilters, RefetchedRanges the invalid
+// responses that were requested again from another peer and BannedPeers the
+// peers banned for invalid responses in strict mode.
+type CFilterValidationStats struct {
+	StrictValidation bool  `json:"strictValidation"`
+	ValidatedFilters int64 `json:"validatedFilters"`
+	InvalidResponses int64 `json:"invalidResponses"`
+	RefetchedRanges  int64 `json:"refetchedRanges"`
+	BannedPeers      int64 `json:"bannedPeers"`
+}
+
+// SyncDiagnostics is a report of the sync activity since the multiwallet was
+// loaded. Stages are those of the current or last sync. Peers, Errors and
+// the rescan ranges of Wallets cover all syncs. HeadersFetched and
+// CFiltersFetched are totals of Peers, and PeerReconnects counts the
+// connections to peers after the first connection to each peer.
+type SyncDiagnostics struct {
+	GeneratedAt     int64  `json:"generatedAt"`
+	Network         string `json:"network"`
+	Syncing         bool   `json:"syncing"`
+	Synced          bool   `json:"synced"`
+	SyncStage       int32  `json:"syncStage"`
+	ConnectedPeers  int32  `json:"connectedPeers"`
+	SyncStartedAt   int64  `json:"syncStartedAt"`
+	SyncCompletedAt int64  `json:"syncCompletedAt"`
+	SyncEndedAt     int64  `json:"syncEndedAt"`
+	SyncRestarts    int32  `json:"syncRestarts"`
+	PeerReconnects  int32  `json:"peerReconnects"`
+	HeadersFetched  int64  `json:"headersFetched"`
+	CFiltersFetched int64  `json:"cfiltersFetched"`
+
+	Stages            []*SyncStageDiagnostics    `json:"stages"`
+	Peers             []*PeerSyncDiagnostics     `json:"peers"`
+	Wallets           []*WalletSyncDiagnostics   `json:"wallets"`
+	Errors            []*SyncErrorDiagnostics    `json:"errors"`
+	CFilterValidation *CFilterValidationStats    `json:"cfilterValidation"`
+	AddressManager    *AddressManagerDiagnostics `json:"addressManager"`
+}
+
+// SyncStageDiagnostics is a sync stage of the current or last sync. EndedAt
+// is 0 and DurationSeconds is the time spent so far if the stage did not end.
+type SyncStageDiagnostics struct {
+	Stage           int32  `json:"stage"`
+	Name            string `json:"name"`
+	StartedAt       int64  `json:"startedAt"`
+	EndedAt         int64  `json:"endedAt"`
+	DurationSeconds int64  `json:"durationSeconds"`
+}
+
+type PeerSyncDiagnostics struct {
+	Address            string `json:"address"`
+	Connections        int32  `json:"connections"`
+	Disconnects        int32  `json:"disconnects"`
+	HeadersFetched     int64  `json:"headersFetched"`
+	CFiltersFetched    int64  `json:"cfiltersFetched"`
+	LastConnectedAt    int64  `json:"lastConnectedAt"`
+	LastDisconnectedAt int64  `json:"lastDisconnectedAt"`
+	LastError          string `json:"lastError"`
+	LastErrorAt        int64  `json:"lastErrorAt"`
+}
+
+type WalletSyncDiagnostics struct {
+	WalletID     int                  `json:"walletID"`
+	Name         string               `json:"name"`
+	Opened       bool                 `json:"opened"`
+	Synced       bool                 `json:"synced"`
+	BestBlock    int32                `json:"bestBlock"`
+	RescanRanges []*RescanDiagnostics `json:"rescanRanges"`
+}
+
+// RescanDiagnostics is a rescan of a wallet, either part of a sync or
+// requested with the rescan queue.
+type RescanDiagnostics struct {
+	StartHeight    int32  `json:"startHeight"`
+	ScannedThrough int32  `json:"scannedThrough"`
+	Requested      bool   `json:"requested"`
+	StartedAt      int64  `json:"startedAt"`
+	EndedAt        int64  `json:"endedAt"`
+	Error          string `json:"error"`
+}
+
+type SyncErrorDiagnostics struct {
+	Time    int64  `json:"time"`
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+// AddressManagerDiagnostics is the state of the address manager as last
+// saved to the peers file at SavedAt. TriedAddresses are known addresses
+// that were connected to successfully.
+type AddressManagerDiagnostics struct {
+	KnownAddresses           int32    `json:"knownAddresses"`
+	TriedAddresses           int32    `json:"triedAddresses"`
+	LastSuccessfulConnection int64    `json:"lastSuccessfulConnection"`
+	SavedAt                  int64    `json:"savedAt"`
+	BannedPeers              int32    `json:"bannedPeers"`
+	PersistentPeers          []string `json:"persistentPeers"`
+}
+
 /** end sync-related types */
 
 /** begin tx-related types */
@@ -122,6 +305,27 @@ type TxAndBlockNotificationListener interface {
 	OnTransactionConfirmed(walletID int, hash string, blockHeight int32)
 }
 
+// TxConfirmationsListener is notified when a transaction reaches one of the
+// confirmation thresholds registered with AddConfirmationThreshold or
+// AddTransactionConfirmationThreshold.
+type TxConfirmationsListener interface {
+	OnTransactionConfirmations(walletID int, hash string, confirmations int32)
+}
+
+// PaymentRequestListener is notified with the JSON encoded payment request
+// whenever a payment request receives funds or changes status.
+type PaymentRequestListener interface {
+	OnPaymentRequestUpdated(paymentRequest string)
+}
+
+// MempoolListener is notified when an unmined transaction of a wallet will
+// likely never be mined, either because no peer has it in its mempool any
+// longer or because a mined transaction spends the same outputs.
+type MempoolListener interface {
+	OnTransactionDropped(walletID int, hash string)
+	OnTransactionConflicted(walletID int, hash, conflictingHash string)
+}
+
 type BlocksRescanProgressListener interface {
 	OnBlocksRescanStarted(walletID int)
 	OnBlocksRescanProgress(*HeadersRescanProgressReport)
@@ -159,6 +363,20 @@ type Transaction struct {
 	DaysToVoteOrRevoke int32  `json:"days_to_vote_revoke"`
 }
 
+// TransactionLookup is a transaction fetched from the wallets or the peers.
+// Transaction is decoded without wallet information, so all its inputs and
+// outputs are marked as external. WalletTransactions holds the transaction
+// decoded by each open wallet that owns some of its inputs or outputs, with
+// the owned inputs and outputs marked with their wallet account. BlockHash
+// is empty and BlockHeight is -1 if the transaction is not mined.
+type TransactionLookup struct {
+	Hash               string         `json:"hash"`
+	BlockHash          string         `json:"blockHash"`
+	BlockHeight        int32          `json:"blockHeight"`
+	Transaction        *Transaction   `json:"transaction"`
+	WalletTransactions []*Transaction `json:"walletTransactions"`
+}
+
 type TxInput struct {
 	PreviousTransactionHash  string `json:"previous_transaction_hash"`
 	PreviousTransactionIndex int32  `json:"previous_transaction_index"`
@@ -177,6 +395,7 @@ type TxOutput struct {
 	Internal      bool   `json:"internal"`
 	AccountName   string `json:"account_name"`
 	AccountNumber int32  `json:"account_number"`
+	ContactName   string `json:"contact_name"`
 }
 
 // TxInfoFromWallet contains tx data that relates to the querying wallet.
@@ -217,6 +436,132 @@ type TransactionDestination struct {
 
 /** end tx-related types */
 
+/** begin statistics-related types */
+
+// WalletStatistics summarizes the transaction history of one or more wallets.
+// FirstActivity and LastActivity are unix timestamps. A WalletID of -1
+// indicates a summary of all opened wallets.
+type WalletStatistics struct {
+	WalletID         int   `json:"walletID"`
+	TransactionCount int32 `json:"transactionCount"`
+
+	Regular     *TxTypeStatistics `json:"regular"`
+	Coinbase    *TxTypeStatistics `json:"coinbase"`
+	Tickets     *TxTypeStatistics `json:"tickets"`
+	Votes       *TxTypeStatistics `json:"votes"`
+	Revocations *TxTypeStatistics `json:"revocations"`
+
+	TotalFees           int64   `json:"totalFees"`
+	TotalVoteRewards    int64   `json:"totalVoteRewards"`
+	VoteCount           int32   `json:"voteCount"`
+	RevocationCount     int32   `json:"revocationCount"`
+	AverageDaysToVote   float64 `json:"averageDaysToVote"`
+	AverageDaysToRevoke float64 `json:"averageDaysToRevoke"`
+
+	FirstActivity int64 `json:"firstActivity"`
+	LastActivity  int64 `json:"lastActivity"`
+
+	totalDaysToVote   int64
+	totalDaysToRevoke int64
+}
+
+// TxTypeStatistics summarizes the transactions of a type. TotalSent and
+// TotalReceived are the amounts sent from and received by the wallet: ticket
+// purchases are counted as sent, and coinbase, vote and revocation
+// transactions as received. Regular transactions between the accounts of
+// the wallet are only counted in Count.
+type TxTypeStatistics struct {
+	Count         int32 `json:"count"`
+	TotalSent     int64 `json:"totalSent"`
+	TotalReceived int64 `json:"totalReceived"`
+}
+
+/** end statistics-related types */
+
+/** begin address-related types */
+
+// AddressUsage describes an address derived from one of the wallet's account
+// branches. Used is true if the child index of the address is at most the
+// last used index of the branch, which is how dcrwallet records used
+// addresses. ReceiveCount is the number of indexed transactions paying to
+// the address, a value above 1 indicates address reuse.
+type AddressUsage struct {
+	Address        string `json:"address"`
+	Account        int32  `json:"account"`
+	Branch         uint32 `json:"branch"`
+	Index          uint32 `json:"index"`
+	DerivationPath string `json:"derivationPath"`
+	Used           bool   `json:"used"`
+	ReceiveCount   int32  `json:"receiveCount"`
+	TotalReceived  int64  `json:"totalReceived"`
+	Balance        int64  `json:"balance"`
+}
+
+// AddressGapReport shows how close each branch of an account is to the
+// unused address gap limit.
+type AddressGapReport struct {
+	Account  int32            `json:"account"`
+	GapLimit uint32           `json:"gapLimit"`
+	External *BranchGapReport `json:"external"`
+	Internal *BranchGapReport `json:"internal"`
+}
+
+// BranchGapReport holds the gap limit usage of a single account branch.
+// LastUsedIndex and LastReturnedIndex are -1 if no address on the branch
+// has been used or returned respectively.
+type BranchGapReport struct {
+	Branch              uint32 `json:"branch"`
+	LastUsedIndex       int64  `json:"lastUsedIndex"`
+	LastReturnedIndex   int64  `json:"lastReturnedIndex"`
+	UnusedReturnedCount uint32 `json:"unusedReturnedCount"`
+	RemainingBeforeGap  uint32 `json:"remainingBeforeGap"`
+}
+
+/** end address-related types */
+
+/** begin cost basis types */
+
+// RealizedGainsReport holds the realized gains of a wallet computed with one
+// of the cost basis methods. Fiat values are in the currency of the rate
+// source used to compute the report. HoldingAmount and HoldingCostBasis
+// describe the DCR still held after the last transaction.
+type RealizedGainsReport struct {
+	WalletID         int            `json:"walletID"`
+	Method           int32          `json:"method"`
+	Disposals        []*Disposal    `json:"disposals"`
+	Years            []*YearlyGains `json:"years"`
+	TotalGain        float64        `json:"totalGain"`
+	HoldingAmount    int64          `json:"holdingAmount"`
+	HoldingCostBasis float64        `json:"holdingCostBasis"`
+}
+
+// Disposal is an amount of DCR spent by the wallet. UncoveredAmount is the
+// part of the amount for which no acquisition was found, its cost basis is
+// taken as zero.
+type Disposal struct {
+	TxHash          string  `json:"txHash"`
+	Timestamp       int64   `json:"timestamp"`
+	Type            string  `json:"type"`
+	Amount          int64   `json:"amount"`
+	Rate            float64 `json:"rate"`
+	Proceeds        float64 `json:"proceeds"`
+	CostBasis       float64 `json:"costBasis"`
+	Gain            float64 `json:"gain"`
+	UncoveredAmount int64   `json:"uncoveredAmount"`
+}
+
+type YearlyGains struct {
+	Year           int32   `json:"year"`
+	AcquiredAmount int64   `json:"acquiredAmount"`
+	AcquiredValue  float64 `json:"acquiredValue"`
+	DisposalCount  int32   `json:"disposalCount"`
+	Proceeds       float64 `json:"proceeds"`
+	CostBasis      float64 `json:"costBasis"`
+	Gain           float64 `json:"gain"`
+}
+
+/** end cost basis types */
+
 /** begin ticket-related types */
 
 type PurchaseTicketsRequest struct {
@@ -230,6 +575,65 @@ type PurchaseTicketsRequest struct {
 	PoolAddress           string
 	PoolFees              float64
 	TicketFee             int64
+
+	// VSPPubKey is the base64 encoded public key of a vspd VSP. Tickets
+	// are registered with the VSP at the vsp host using the vspd API if
+	// it is set, the legacy stakepool API is used otherwise.
+	VSPPubKey string
+
+	// VotingAccount is the account of the voting addresses of the tickets
+	// if no ticket address is set.
+	VotingAccount uint32
+}
+
+// TicketBuyerConfig configures the automatic ticket buyer of a wallet.
+// Tickets are bought with the funds of Account as long as BalanceToMaintain
+// remains spendable in it. MaxTicketPrice and MaxTicketsPerBlock are not
+// limited if 0. Tickets are registered with the VSP at VSPHost if it is set,
+// with the vspd API if VSPPubKey is set, and VotingAccount is the account of
+// the voting addresses of solo tickets.
+type TicketBuyerConfig struct {
+	Account            uint32 `json:"account"`
+	BalanceToMaintain  int64  `json:"balanceToMaintain"`
+	MaxTicketPrice     int64  `json:"maxTicketPrice"`
+	MaxTicketsPerBlock int32  `json:"maxTicketsPerBlock"`
+	VSPHost            string `json:"vspHost"`
+	VSPPubKey          string `json:"vspPubKey"`
+	VotingAccount      uint32 `json:"votingAccount"`
+}
+
+// TicketBuyerListener is notified with the JSON encoded TicketBuyerReport
+// each time the automatic ticket buyer of a wallet buys tickets or skips a
+// block.
+type TicketBuyerListener interface {
+	OnTicketsPurchased(report string)
+	OnTicketPurchaseSkipped(report string)
+}
+
+// TicketBuyerReport is the outcome of a run of the automatic ticket buyer at
+// a block. TicketHashes are the tickets purchased, or SkipReason is one of
+// the TicketBuyerSkip reasons if none were. Error is set if the reason is an
+// error.
+type TicketBuyerReport struct {
+	WalletID         int      `json:"walletID"`
+	BlockHeight      int32    `json:"blockHeight"`
+	TicketPrice      int64    `json:"ticketPrice"`
+	SpendableBalance int64    `json:"spendableBalance"`
+	TicketHashes     []string `json:"ticketHashes"`
+	SkipReason       string   `json:"skipReason"`
+	Error            string   `json:"error"`
+}
+
+// TicketBuyerStatus is the status of the automatic ticket buyer of a wallet.
+// TicketsPurchased counts the tickets purchased since it was started.
+type TicketBuyerStatus struct {
+	Running          bool               `json:"running"`
+	Config           *TicketBuyerConfig `json:"config"`
+	StartedAt        int64              `json:"startedAt"`
+	TicketsPurchased int32              `json:"ticketsPurchased"`
+	LastPurchaseAt   int64              `json:"lastPurchaseAt"`
+	LastBlockHeight  int32              `json:"lastBlockHeight"`
+	LastReport       *TicketBuyerReport `json:"lastReport"`
 }
 
 type GetTicketsRequest struct {
@@ -259,6 +663,34 @@ type VSPTicketPurchaseInfo struct {
 	TicketAddress string
 }
 
+// VSPInfo is the fee and the state of a vspd VSP. PubKey is the base64
+// encoded public key of the VSP.
+type VSPInfo struct {
+	Host          string  `json:"host"`
+	PubKey        string  `json:"pubKey"`
+	FeePercentage float64 `json:"feePercentage"`
+	Closed        bool    `json:"closed"`
+	Network       string  `json:"network"`
+	VspdVersion   string  `json:"vspdVersion"`
+	Voting        int64   `json:"voting"`
+	Voted         int64   `json:"voted"`
+	Revoked       int64   `json:"revoked"`
+}
+
+// VSPTicketStatus is the status of a ticket purchased through a vspd VSP as
+// reported by the VSP. FeeTxStatus is one of none, received, broadcast,
+// confirmed or error, the fee must be paid again if it is error.
+type VSPTicketStatus struct {
+	TicketHash      string            `json:"ticketHash"`
+	VSPHost         string            `json:"vspHost"`
+	FeeAddress      string            `json:"feeAddress"`
+	FeeAmount       int64             `json:"feeAmount"`
+	FeeTxHash       string            `json:"feeTxHash"`
+	FeeTxStatus     string            `json:"feeTxStatus"`
+	TicketConfirmed bool              `json:"ticketConfirmed"`
+	VoteChoices     map[string]string `json:"voteChoices"`
+}
+
 /** end ticket-related types */
 
 /** begin politea proposal types */
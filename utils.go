@@ -39,6 +39,7 @@ const (
 	LegacyTestnetHDPath = "m / 44’ / 11’ / "
 	MainnetHDPath       = "m / 44' / 42' / "
 	LegacyMainnetHDPath = "m / 44’ / 20’ / "
+	LegacySimnetHDPath  = "m / 44’ / 115’ / "
 
 	DefaultRequiredConfirmations = 2
 )
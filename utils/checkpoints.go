@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// checkpoints are the known good blocks of each public network that header
+// snapshots are validated against. They are hard-coded rather than read
+// from the chaincfg params so that neither a dependency update nor a caller
+// modifying the params can change the blocks that snapshots must end at.
+var checkpoints = map[wire.CurrencyNet][]chaincfg.Checkpoint{
+	wire.MainNet: {
+		{Height: 440, Hash: newHashFromStr("0000000000002203eb2c95ee96906730bb56b2985e174518f90eb4db29232d93")},
+		{Height: 24480, Hash: newHashFromStr("0000000000000c9d4239c4ef7ef3fb5aaeed940244bc69c57c8c5e1f071b28a6")},
+		{Height: 48590, Hash: newHashFromStr("0000000000000d5e0de21a96d3c965f5f2db2c82612acd7389c140c9afe92ba7")},
+		{Height: 54770, Hash: newHashFromStr("00000000000009293d067b1126b7de07fc9b2b94ee50dfe0d48c239a7adb072c")},
+		{Height: 60720, Hash: newHashFromStr("0000000000000a64475d68ffb9ad89a3d147c0f5138db26b40da9d19d0004117")},
+		{Height: 65270, Hash: newHashFromStr("0000000000000021f107601962789b201f0a0cbb98ac5f8c12b93d94e795b441")},
+		{Height: 75380, Hash: newHashFromStr("0000000000000e7d13cfc85806aa720fe3670980f5b7d33253e4f41985558372")},
+		{Height: 85410, Hash: newHashFromStr("00000000000013ec928074bea6eac9754aa614c7acb20edf300f18b0cd122692")},
+		{Height: 99880, Hash: newHashFromStr("0000000000000cb2a9a9ded647b9f78aae51ace32dd8913701d420ead272913c")},
+		{Height: 123080, Hash: newHashFromStr("000000000000009ea6e02d0f0424f445ed50686f9ae4aecdf3b268e981114477")},
+		{Height: 135960, Hash: newHashFromStr("00000000000001d2f9bbca9177972c0ba45acb40836b72945a75d73b99079498")},
+		{Height: 139740, Hash: newHashFromStr("00000000000001397179ae1aff156fb1aea228938d06b83e43b78b1c44527b5b")},
+		{Height: 155900, Hash: newHashFromStr("000000000000008557e37fb05177fc5a54e693de20689753639135f85a2dcb2e")},
+		{Height: 164300, Hash: newHashFromStr("000000000000009ed067ff51cd5e15f3c786222a5183b20a991a80ce535907a9")},
+		{Height: 181020, Hash: newHashFromStr("00000000000000b77d832cb2cbed02908d69323862a53e56345400ad81a6fb8f")},
+		{Height: 189950, Hash: newHashFromStr("000000000000007341d8ae2ea7e41f25cee00e1a70a4a3dc1cb055d14ecb2e11")},
+		{Height: 214672, Hash: newHashFromStr("0000000000000021d5cbeead55cb7fd659f07e8127358929ffc34cd362209758")},
+		{Height: 237310, Hash: newHashFromStr("000000000000000a6815e366425acd30354dfdcb55f401e38b037e87ca00f171")},
+		{Height: 259810, Hash: newHashFromStr("0000000000000000ee0fbf469a9f32477ffbb46ebd7a280a53c842ab4243f97c")},
+		{Height: 277940, Hash: newHashFromStr("000000000000000043b47c777bc96760e6fccc872cc8edb295b230dd8dd0f5d7")},
+		{Height: 295940, Hash: newHashFromStr("0000000000000000148852c8a919addf4043f9f267b13c08df051d359f1622ca")},
+		{Height: 318020, Hash: newHashFromStr("00000000000000002765c601fe4455126ab27913fcc2d2db28f78e1e6bb77266")},
+		{Height: 340120, Hash: newHashFromStr("00000000000000001a9ba30acfd8272aa1514ce7979fc508118ee3764a19df46")},
+		{Height: 362120, Hash: newHashFromStr("00000000000000000219580063387071893bcd0992cacb5f0aa55fc9e6c4a0ab")},
+		{Height: 384170, Hash: newHashFromStr("00000000000000001704bbc6bda8c4864a71cd0febcc0b44d753c69d83840f04")},
+		{Height: 404170, Hash: newHashFromStr("000000000000000010352022d88733bac2665c882030db071ce5ea29970fefd8")},
+		{Height: 424170, Hash: newHashFromStr("00000000000000000b8526a9fdd31fcb249c992fc0741d422528ce2fa975bbd0")},
+		{Height: 444170, Hash: newHashFromStr("00000000000000000673a109a997e8ca41bc4ece86445bc89c1bb2b9921633cc")},
+		{Height: 464170, Hash: newHashFromStr("0000000000000000063c4588ffa1ff25978c025eaf529f39bcbad8b962502f65")},
+		{Height: 483600, Hash: newHashFromStr("000000000000000010f98f7354b501b5747011c82d53b989dbcb368e5059ff9e")},
+	},
+	wire.TestNet3: {
+		{Height: 83520, Hash: newHashFromStr("0000000001e6244d95feae8b598e854905158c7bc781daf874afff88675ef0c8")},
+		{Height: 148320, Hash: newHashFromStr("0000000003535b80e4b759b8ec1790f81f429d9f5a810eda43c3afb64d9760b1")},
+		{Height: 213120, Hash: newHashFromStr("000000000009bb6909db742278f3ab7c9169ef396068cce7f750b587b72738af")},
+		{Height: 282340, Hash: newHashFromStr("0000001f538d6343316fe50709fa544b680a1be38141d003e755da8ad30f67a8")},
+		{Height: 347140, Hash: newHashFromStr("0000001638f00f197b882a00db04d1323e205f45bf1108e4f1bbc1f456d4250c")},
+		{Height: 411940, Hash: newHashFromStr("00000012b6d21f31f18a2f6d8b64e111327f011ac13a1b3e9d0d8b477f71f62b")},
+		{Height: 476740, Hash: newHashFromStr("00000005635a4b783ad4d85e0cb92f094e2e885a92ef4d6b8464b22a02646279")},
+		{Height: 515730, Hash: newHashFromStr("00000010ecddf8da5d91f7020f69130db8a163906d460cbbed2a91568701f0ac")},
+	},
+}
+
+// newHashFromStr converts the passed big-endian hex string into a
+// chainhash.Hash. It only differs from the one available in chainhash in
+// that it panics on an error since it will only be called with hard-coded,
+// and therefore known good, hashes.
+func newHashFromStr(hexStr string) *chainhash.Hash {
+	hash, err := chainhash.NewHashFromStr(hexStr)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// Checkpoints returns the checkpoints of the network ordered from oldest to
+// newest. The checkpoints in params are only used for networks other than
+// mainnet and testnet.
+func Checkpoints(params *chaincfg.Params) []chaincfg.Checkpoint {
+	if networkCheckpoints, ok := checkpoints[params.Net]; ok {
+		return networkCheckpoints
+	}
+	return params.Checkpoints
+}
+
+// CheckpointHash returns the hash of the checkpoint at height, or nil if
+// there is no checkpoint at that height.
+func CheckpointHash(params *chaincfg.Params, height int32) *chainhash.Hash {
+	for _, checkpoint := range Checkpoints(params) {
+		if int32(checkpoint.Height) == height {
+			return checkpoint.Hash
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"decred.org/dcrwallet/errors"
+	"decred.org/dcrwallet/validate"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/gcs/v2"
+	"github.com/decred/dcrd/gcs/v2/blockcf2"
+	"github.com/decred/dcrd/wire"
+)
+
+// HeaderSnapshotVersion is the version of the header snapshot format written
+// by WriteHeaderSnapshot.
+const HeaderSnapshotVersion = 1
+
+const (
+	// maxSnapshotProofHashes is the maximum number of hashes in the header
+	// commitment inclusion proof of each cfilter.
+	maxSnapshotProofHashes = 32
+)
+
+// headerSnapshotMagic identifies header snapshot files.
+var headerSnapshotMagic = [4]byte{'d', 'h', 'd', 'r'}
+
+// HeaderSnapshotEntry is a block header in a header snapshot together with
+// the block's version 2 cfilter and the proof that the header commits to the
+// filter. Wallets need the cfilter of every block in their main chain.
+type HeaderSnapshotEntry struct {
+	Header     *wire.BlockHeader
+	Filter     *gcs.FilterV2
+	ProofIndex uint32
+	Proof      []chainhash.Hash
+}
+
+// HeaderSnapshotInfo describes a validated header snapshot.
+type HeaderSnapshotInfo struct {
+	Version   uint32
+	TipHeight int32
+	TipHash   chainhash.Hash
+}
+
+// WriteHeaderSnapshot writes a header snapshot of entries signed with
+// privKey. The entries must start at the block after the genesis block of
+// the network and end at a checkpoint.
+//
+// The snapshot is written as the magic bytes, the format version, the
+// network and the number of entries followed by the entries and an ed25519
+// signature of the sha256 hash of all the preceding bytes. Integers are
+// little endian.
+func WriteHeaderSnapshot(w io.Writer, params *chaincfg.Params, entries []*HeaderSnapshotEntry, privKey ed25519.PrivateKey) error {
+	if len(privKey) != ed25519.PrivateKeySize {
+		return errors.New("invalid header snapshot signing key")
+	}
+
+	hasher := sha256.New()
+	hw := io.MultiWriter(w, hasher)
+
+	var prefix bytes.Buffer
+	prefix.Write(headerSnapshotMagic[:])
+	binary.Write(&prefix, binary.LittleEndian, uint32(HeaderSnapshotVersion))
+	binary.Write(&prefix, binary.LittleEndian, uint32(params.Net))
+	binary.Write(&prefix, binary.LittleEndian, uint32(len(entries)))
+	if _, err := hw.Write(prefix.Bytes()); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := writeHeaderSnapshotEntry(hw, entry); err != nil {
+			return err
+		}
+	}
+
+	signature := ed25519.Sign(privKey, hasher.Sum(nil))
+	_, err := w.Write(signature)
+	return err
+}
+
+func writeHeaderSnapshotEntry(w io.Writer, entry *HeaderSnapshotEntry) error {
+	if err := entry.Header.Serialize(w); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, entry.ProofIndex); err != nil {
+		return err
+	}
+	if err := wire.WriteVarInt(w, 0, uint64(len(entry.Proof))); err != nil {
+		return err
+	}
+	for i := range entry.Proof {
+		if _, err := w.Write(entry.Proof[i][:]); err != nil {
+			return err
+		}
+	}
+	return wire.WriteVarBytes(w, 0, entry.Filter.Bytes())
+}
+
+// VerifyHeaderSnapshot reads the header snapshot from r and returns an error
+// if it is not signed by pubKey or does not hold a valid chain of headers
+// from the genesis block of the network to one of the network's checkpoints.
+func VerifyHeaderSnapshot(r io.Reader, params *chaincfg.Params, pubKey ed25519.PublicKey) (*HeaderSnapshotInfo, error) {
+	return ReadHeaderSnapshot(r, params, pubKey, nil)
+}
+
+// ReadHeaderSnapshot validates the header snapshot read from r like
+// VerifyHeaderSnapshot and calls fn, if not nil, with each entry in order.
+// The signature can only be checked once all entries are read, so callers
+// that act on the entries before the function returns should verify the
+// snapshot with VerifyHeaderSnapshot first and read the same bytes again.
+func ReadHeaderSnapshot(r io.Reader, params *chaincfg.Params, pubKey ed25519.PublicKey,
+	fn func(entry *HeaderSnapshotEntry) error) (*HeaderSnapshotInfo, error) {
+
+	if len(pubKey) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid header snapshot public key")
+	}
+
+	hasher := sha256.New()
+	hr := io.TeeReader(r, hasher)
+
+	version, count, err := readHeaderSnapshotPrefix(hr, params)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &HeaderSnapshotInfo{Version: version}
+	prevHash := params.GenesisHash
+	for height := int32(1); height <= int32(count); height++ {
+		entry, err := readHeaderSnapshotEntry(hr)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = validateHeaderSnapshotEntry(params, entry, height, &prevHash); err != nil {
+			return nil, err
+		}
+
+		prevHash = entry.Header.BlockHash()
+		if fn != nil {
+			if err = fn(entry); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	info.TipHeight = int32(count)
+	info.TipHash = prevHash
+	if CheckpointHash(params, info.TipHeight) == nil {
+		return nil, errors.New("header snapshot does not end at a checkpoint")
+	}
+
+	if err := verifyHeaderSnapshotSignature(r, hasher, pubKey); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// ReadHeaderSnapshotTipHeight returns the height of the last header in the
+// header snapshot read from r. Only the start of the snapshot is read and
+// nothing is validated, the height is used to skip snapshots that no wallet
+// needs without verifying them.
+func ReadHeaderSnapshotTipHeight(r io.Reader, params *chaincfg.Params) (int32, error) {
+	_, count, err := readHeaderSnapshotPrefix(r, params)
+	if err != nil {
+		return 0, err
+	}
+	return int32(count), nil
+}
+
+// readHeaderSnapshotPrefix reads the magic bytes, format version, network and
+// number of entries at the start of a header snapshot.
+func readHeaderSnapshotPrefix(r io.Reader, params *chaincfg.Params) (version, count uint32, err error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return 0, 0, snapshotReadError(err)
+	}
+	if magic != headerSnapshotMagic {
+		return 0, 0, errors.New("not a header snapshot")
+	}
+
+	var net uint32
+	for _, field := range []*uint32{&version, &net, &count} {
+		if err := binary.Read(r, binary.LittleEndian, field); err != nil {
+			return 0, 0, snapshotReadError(err)
+		}
+	}
+	if version != HeaderSnapshotVersion {
+		return 0, 0, errors.Errorf("unsupported header snapshot version %d", version)
+	}
+	if wire.CurrencyNet(net) != params.Net {
+		return 0, 0, errors.New("header snapshot is for a different network")
+	}
+	if count == 0 {
+		return 0, 0, errors.New("header snapshot has no headers")
+	}
+	return version, count, nil
+}
+
+func readHeaderSnapshotEntry(r io.Reader) (*HeaderSnapshotEntry, error) {
+	entry := &HeaderSnapshotEntry{Header: new(wire.BlockHeader)}
+	if err := entry.Header.Deserialize(r); err != nil {
+		return nil, snapshotReadError(err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &entry.ProofIndex); err != nil {
+		return nil, snapshotReadError(err)
+	}
+
+	proofLen, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, snapshotReadError(err)
+	}
+	if proofLen > maxSnapshotProofHashes {
+		return nil, errors.New("header snapshot has an invalid cfilter proof")
+	}
+	entry.Proof = make([]chainhash.Hash, proofLen)
+	for i := range entry.Proof {
+		if _, err := io.ReadFull(r, entry.Proof[i][:]); err != nil {
+			return nil, snapshotReadError(err)
+		}
+	}
+
+	filterData, err := wire.ReadVarBytes(r, 0, wire.MaxCFilterDataSize, "cfilter")
+	if err != nil {
+		return nil, snapshotReadError(err)
+	}
+	entry.Filter, err = gcs.FromBytesV2(blockcf2.B, blockcf2.M, filterData)
+	if err != nil {
+		return nil, errors.Errorf("header snapshot has an invalid cfilter: %v", err)
+	}
+
+	return entry, nil
+}
+
+// validateHeaderSnapshotEntry checks that the entry is the block at height
+// that follows the block of prevHash, that the header commits to the cfilter
+// and that the block matches the checkpoint at height, if any.
+func validateHeaderSnapshotEntry(params *chaincfg.Params, entry *HeaderSnapshotEntry, height int32, prevHash *chainhash.Hash) error {
+	header := entry.Header
+	if int32(header.Height) != height || header.PrevBlock != *prevHash {
+		return errors.Errorf("header snapshot block at height %d does not connect to the previous block", height)
+	}
+
+	err := validate.CFilterV2HeaderCommitment(params.Net, header, entry.Filter, entry.ProofIndex, entry.Proof)
+	if err != nil {
+		return err
+	}
+
+	if checkpoint := CheckpointHash(params, height); checkpoint != nil {
+		if header.BlockHash() != *checkpoint {
+			return errors.Errorf("header snapshot block at height %d does not match the checkpoint", height)
+		}
+	}
+
+	return nil
+}
+
+// verifyHeaderSnapshotSignature reads the signature that must end the
+// snapshot and checks it against the hash of the bytes read before it.
+func verifyHeaderSnapshotSignature(r io.Reader, hasher hash.Hash, pubKey ed25519.PublicKey) error {
+	signature := make([]byte, ed25519.SignatureSize)
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return snapshotReadError(err)
+	}
+
+	var trailing [1]byte
+	if n, _ := r.Read(trailing[:]); n != 0 {
+		return errors.New("header snapshot has unexpected data after the signature")
+	}
+
+	if !ed25519.Verify(pubKey, hasher.Sum(nil), signature) {
+		return errors.New("invalid header snapshot signature")
+	}
+
+	return nil
+}
+
+func snapshotReadError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("header snapshot is truncated")
+	}
+	return err
+}
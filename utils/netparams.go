@@ -10,6 +10,8 @@ import (
 var (
 	mainnetParams = chaincfg.MainNetParams()
 	testnetParams = chaincfg.TestNet3Params()
+	simnetParams  = chaincfg.SimNetParams()
+	regnetParams  = chaincfg.RegNetParams()
 )
 
 func ChainParams(netType string) (*chaincfg.Params, error) {
@@ -18,7 +20,27 @@ func ChainParams(netType string) (*chaincfg.Params, error) {
 		return mainnetParams, nil
 	case strings.ToLower(testnetParams.Name):
 		return testnetParams, nil
+	case strings.ToLower(simnetParams.Name):
+		return simnetParams, nil
+	case strings.ToLower(regnetParams.Name):
+		return regnetParams, nil
 	default:
 		return nil, errors.New("invalid net type")
 	}
 }
+
+// DefaultRPCPort returns the default dcrd JSON-RPC port for the network.
+func DefaultRPCPort(params *chaincfg.Params) string {
+	switch params.Name {
+	case mainnetParams.Name:
+		return "9109"
+	case testnetParams.Name:
+		return "19109"
+	case simnetParams.Name:
+		return "19556"
+	case regnetParams.Name:
+		return "18656"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,659 @@
+package dcrlibwallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	w "decred.org/dcrwallet/wallet"
+	"decred.org/dcrwallet/wallet/txauthor"
+	"decred.org/dcrwallet/wallet/txrules"
+	"decred.org/dcrwallet/wallet/txsizes"
+	"github.com/asdine/storm"
+	"github.com/decred/dcrd/blockchain/stake/v3"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/txhelper"
+	"github.com/planetdecred/dcrlibwallet/vsp"
+)
+
+const (
+	// vspTicketsConfigKey is the wallet config key of the tickets purchased
+	// through vspd VSPs, saved as a map of ticket hashes to vspTicket.
+	vspTicketsConfigKey = "vsp_tickets"
+
+	// vspRequestTimeout is the time to wait for a VSP to respond.
+	vspRequestTimeout = 30 * time.Second
+
+	// maxVSPFee is the largest fee paid to a VSP for a ticket.
+	maxVSPFee = dcrutil.Amount(1e7)
+)
+
+// vspTicket is saved for each ticket purchased through a vspd VSP so that
+// the VSP of the ticket can be contacted after the purchase. FeeTxHash is
+// empty until the fee is paid.
+type vspTicket struct {
+	Host       string `json:"host"`
+	PubKey     []byte `json:"pub_key"`
+	Account    uint32 `json:"account"`
+	FeeAddress string `json:"fee_address"`
+	FeeAmount  int64  `json:"fee_amount"`
+	FeeTxHash  string `json:"fee_tx_hash"`
+}
+
+func (mw *MultiWallet) VSPInfo(vspHost string) (string, error) {
+	info, err := mw.VSPInfoRaw(vspHost)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedInfo, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedInfo), nil
+}
+
+// VSPInfoRaw returns the fee, the state and the public key of the vspd VSP
+// at vspHost. The response of the VSP is only verified against the key that
+// it holds, the key must be confirmed by the user, e.g. against the list of
+// VSPs of decred.org, before it is used to purchase tickets.
+func (mw *MultiWallet) VSPInfoRaw(vspHost string) (*VSPInfo, error) {
+	ctx, shutdownCancel := mw.contextWithShutdownCancel()
+	defer shutdownCancel()
+	ctx, cancel := context.WithTimeout(ctx, vspRequestTimeout)
+	defer cancel()
+
+	info, err := vsp.FetchVSPInfo(ctx, proxyHTTPClient(mw.socksProxy()), vspHost)
+	if err != nil {
+		return nil, fmt.Errorf("vsp connection error: %s", err.Error())
+	}
+
+	return &VSPInfo{
+		Host:          vspHost,
+		PubKey:        base64.StdEncoding.EncodeToString(info.PubKey),
+		FeePercentage: info.FeePercentage,
+		Closed:        info.VspClosed,
+		Network:       info.Network,
+		VspdVersion:   info.VspdVersion,
+		Voting:        info.Voting,
+		Voted:         info.Voted,
+		Revoked:       info.Revoked,
+	}, nil
+}
+
+func (wallet *Wallet) VSPTicketStatus(ticketHash string, passphrase []byte) (string, error) {
+	status, err := wallet.VSPTicketStatusRaw(ticketHash, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	jsonEncodedStatus, err := json.Marshal(status)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonEncodedStatus), nil
+}
+
+// VSPTicketStatusRaw returns the status of the fee and the vote choices of a
+// ticket purchased through a vspd VSP as reported by the VSP. The passphrase
+// is needed to sign the request with the commitment address of the ticket.
+func (wallet *Wallet) VSPTicketStatusRaw(ticketHash string, passphrase []byte) (*VSPTicketStatus, error) {
+	hash, err := chainhash.NewHashFromStr(ticketHash)
+	if err != nil {
+		return nil, errors.New(ErrInvalid)
+	}
+
+	record, err := wallet.vspTicket(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := make(chan time.Time, 1)
+	defer func() {
+		lock <- time.Time{}
+	}()
+	err = wallet.internal.Unlock(wallet.shutdownContext(), passphrase, lock)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	ctx, cancel := wallet.vspContext()
+	defer cancel()
+
+	client, err := wallet.vspClient(record.Host, record.PubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	_, commitmentAddr, _, err := wallet.ticketAddresses(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.TicketStatus(ctx, commitmentAddr, &vsp.TicketStatusRequest{
+		TicketHash: hash.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vsp connection error: %s", err.Error())
+	}
+
+	return &VSPTicketStatus{
+		TicketHash:      hash.String(),
+		VSPHost:         record.Host,
+		FeeAddress:      record.FeeAddress,
+		FeeAmount:       record.FeeAmount,
+		FeeTxHash:       resp.FeeTxHash,
+		FeeTxStatus:     resp.FeeTxStatus,
+		TicketConfirmed: resp.TicketConfirmed,
+		VoteChoices:     resp.VoteChoices,
+	}, nil
+}
+
+// PayVSPFee pays the fee of a ticket purchased through a vspd VSP whose fee
+// payment failed during the purchase or whose fee transaction could not be
+// broadcast by the VSP. The fee is paid from the account that purchased the
+// ticket.
+func (wallet *Wallet) PayVSPFee(ticketHash string, passphrase []byte) error {
+	hash, err := chainhash.NewHashFromStr(ticketHash)
+	if err != nil {
+		return errors.New(ErrInvalid)
+	}
+
+	record, err := wallet.vspTicket(hash)
+	if err != nil {
+		return err
+	}
+
+	if record.FeeTxHash != "" {
+		status, err := wallet.VSPTicketStatusRaw(ticketHash, passphrase)
+		if err != nil {
+			return err
+		}
+		if status.FeeTxStatus != vsp.FeeTxStatusError {
+			return errors.New(ErrExist)
+		}
+	}
+
+	lock := make(chan time.Time, 1)
+	defer func() {
+		lock <- time.Time{}
+	}()
+	err = wallet.internal.Unlock(wallet.shutdownContext(), passphrase, lock)
+	if err != nil {
+		return translateError(err)
+	}
+
+	ctx, cancel := wallet.vspContext()
+	defer cancel()
+
+	client, err := wallet.vspClient(record.Host, record.PubKey)
+	if err != nil {
+		return err
+	}
+
+	// The VSP could not broadcast the previous fee transaction. It is
+	// removed from the wallet so that its inputs are released instead of
+	// staying spent by a transaction that will never be mined.
+	if record.FeeTxHash != "" {
+		if err = wallet.abandonVSPFeeTx(ctx, record.FeeTxHash); err != nil {
+			return err
+		}
+	}
+
+	_, err = wallet.payVSPFee(ctx, client, hash, record.Account, nil)
+	return err
+}
+
+// SetVoteChoice sets the choice that the wallet votes with on an agenda and
+// updates the vote choices of the tickets purchased through vspd VSPs that
+// can still vote. The passphrase is needed to sign the requests to the VSPs.
+// The choice is set even if a VSP cannot be updated, in which case the error
+// of the first VSP that could not be updated is returned.
+func (wallet *Wallet) SetVoteChoice(agendaID, choiceID string, passphrase []byte) error {
+	ctx := wallet.shutdownContext()
+
+	_, err := wallet.internal.SetAgendaChoices(ctx, nil, w.AgendaChoice{
+		AgendaID: agendaID,
+		ChoiceID: choiceID,
+	})
+	if err != nil {
+		return translateError(err)
+	}
+
+	records, err := wallet.vspTickets()
+	if err != nil || len(records) == 0 {
+		return err
+	}
+
+	lock := make(chan time.Time, 1)
+	defer func() {
+		lock <- time.Time{}
+	}()
+	err = wallet.internal.Unlock(ctx, passphrase, lock)
+	if err != nil {
+		return translateError(err)
+	}
+
+	var firstErr error
+	for ticketHash, record := range records {
+		if record.FeeTxHash == "" {
+			continue
+		}
+
+		err := wallet.updateVSPVoteChoices(ticketHash, record)
+		if err != nil {
+			log.Errorf("[%d] Failed to update the vote choices of ticket %s: %v", wallet.ID, ticketHash, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("vsp connection error: %s", err.Error())
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// updateVSPVoteChoices sends the vote choices of the wallet for a ticket to
+// the VSP of the ticket if the ticket can still vote. The wallet must be
+// unlocked.
+func (wallet *Wallet) updateVSPVoteChoices(ticketHash string, record *vspTicket) error {
+	hash, err := chainhash.NewHashFromStr(ticketHash)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := wallet.vspContext()
+	defer cancel()
+
+	ticketSummary, _, err := wallet.internal.GetTicketInfo(ctx, hash)
+	if err != nil {
+		return err
+	}
+	switch ticketSummary.Status {
+	case w.TicketStatusUnmined, w.TicketStatusImmature, w.TicketStatusLive:
+	default:
+		return nil
+	}
+
+	client, err := wallet.vspClient(record.Host, record.PubKey)
+	if err != nil {
+		return err
+	}
+
+	_, commitmentAddr, _, err := wallet.ticketAddresses(ctx, hash)
+	if err != nil {
+		return err
+	}
+
+	voteChoices, err := wallet.ticketVoteChoices(ctx, hash)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.SetVoteChoices(ctx, commitmentAddr, &vsp.SetVoteChoicesRequest{
+		TicketHash:  ticketHash,
+		VoteChoices: voteChoices,
+	})
+	return err
+}
+
+// vspFeePurchaseProcess sets the functions of the ticket purchase request
+// that register the purchased tickets with the VSP of client. The fees are
+// paid from account. The VSP info is fetched from the VSP if info is nil.
+func (wallet *Wallet) vspFeePurchaseProcess(client *vsp.Client, info *vsp.VSPInfoResponse, account uint32,
+	request *w.PurchaseTicketsRequest) {
+
+	request.VSPFeeProcess = func(ctx context.Context) (float64, error) {
+		info := info
+		if info == nil {
+			var err error
+			info, err = client.VSPInfo(ctx)
+			if err != nil {
+				return 0, fmt.Errorf("vsp connection error: %s", err.Error())
+			}
+		}
+		if info.VspClosed {
+			return 0, errors.New("VSP is closed")
+		}
+		if info.Network != wallet.chainParams.Name {
+			return 0, fmt.Errorf("VSP is on %s, not %s", info.Network, wallet.chainParams.Name)
+		}
+		return info.FeePercentage, nil
+	}
+
+	request.VSPFeePaymentProcess = func(ctx context.Context, ticketHash chainhash.Hash, credits []w.Input) (*wire.MsgTx, error) {
+		ctx, cancel := context.WithTimeout(ctx, vspRequestTimeout)
+		defer cancel()
+		return wallet.payVSPFee(ctx, client, &ticketHash, account, credits)
+	}
+}
+
+// payVSPFee registers a ticket with the VSP of client and pays the fee of the
+// ticket with the credits, or with outputs of account reserved for the fee
+// if credits is nil. The VSP broadcasts the fee transaction. The wallet must
+// be unlocked.
+func (wallet *Wallet) payVSPFee(ctx context.Context, client *vsp.Client, ticketHash *chainhash.Hash, account uint32,
+	credits []w.Input) (*wire.MsgTx, error) {
+
+	ticketTx, commitmentAddr, votingAddr, err := wallet.ticketAddresses(ctx, ticketHash)
+	if err != nil {
+		return nil, err
+	}
+
+	parentHash := ticketTx.TxIn[0].PreviousOutPoint.Hash
+	parentTxs, _, err := wallet.internal.GetTransactionsByHashes(ctx, []*chainhash.Hash{&parentHash})
+	if err != nil {
+		return nil, err
+	}
+
+	ticketHex, err := serializeTxHex(ticketTx)
+	if err != nil {
+		return nil, err
+	}
+	parentHex, err := serializeTxHex(parentTxs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Save the VSP of the ticket before contacting it so that the fee can
+	// be paid again if the payment fails.
+	record := &vspTicket{
+		Host:    client.URL(),
+		PubKey:  client.PubKey(),
+		Account: account,
+	}
+	if err = wallet.saveVSPTicket(ticketHash, record); err != nil {
+		return nil, err
+	}
+
+	feeResp, err := client.FeeAddress(ctx, commitmentAddr, &vsp.FeeAddressRequest{
+		TicketHash: ticketHash.String(),
+		TicketHex:  ticketHex,
+		ParentHex:  parentHex,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vsp connection error: %s", err.Error())
+	}
+
+	feeAddr, err := dcrutil.DecodeAddress(feeResp.FeeAddress, wallet.chainParams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vsp fee address: %s", err.Error())
+	}
+	feeAmount := dcrutil.Amount(feeResp.FeeAmount)
+	if feeAmount <= 0 || feeAmount > maxVSPFee {
+		return nil, fmt.Errorf("invalid vsp fee amount: %v", feeAmount)
+	}
+
+	record.FeeAddress = feeAddr.String()
+	record.FeeAmount = int64(feeAmount)
+	if err = wallet.saveVSPTicket(ticketHash, record); err != nil {
+		return nil, err
+	}
+
+	// feePaid is set once the VSP accepts the fee transaction. Its inputs
+	// are spent on the network from then on and must stay locked.
+	var feePaid bool
+	if credits == nil {
+		credits, err = wallet.internal.ReserveOutputsForAmount(ctx, account, feeAmount, 1)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		defer func() {
+			if err != nil && !feePaid {
+				for _, credit := range credits {
+					wallet.internal.UnlockOutpoint(&credit.OutPoint.Hash, credit.OutPoint.Index)
+				}
+			}
+		}()
+	}
+
+	feeTx, err := wallet.createVSPFeeTx(ctx, feeAddr, feeAmount, account, credits)
+	if err != nil {
+		return nil, err
+	}
+	feeTxHex, err := serializeTxHex(feeTx)
+	if err != nil {
+		return nil, err
+	}
+
+	votingKey, err := wallet.internal.DumpWIFPrivateKey(ctx, votingAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	voteChoices, err := wallet.ticketVoteChoices(ctx, ticketHash)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = client.PayFee(ctx, commitmentAddr, &vsp.PayFeeRequest{
+		TicketHash:  ticketHash.String(),
+		FeeTx:       feeTxHex,
+		VotingKey:   votingKey,
+		VoteChoices: voteChoices,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vsp connection error: %s", err.Error())
+	}
+	feePaid = true
+
+	// The fee transaction was broadcast by the VSP, record it so that its
+	// inputs are not spent again before the wallet sees it on the network.
+	// The inputs stay locked if it cannot be recorded.
+	feeTxHash := feeTx.TxHash()
+	if err = wallet.internal.AddTransaction(ctx, feeTx, nil); err != nil {
+		log.Errorf("[%d] Failed to record VSP fee %s of ticket %s: %v", wallet.ID, feeTxHash, ticketHash, err)
+		return nil, err
+	}
+	if err = wallet.internal.UpdateVspTicketFeeToPaid(ctx, ticketHash, &feeTxHash); err != nil {
+		return nil, err
+	}
+
+	record.FeeTxHash = feeTxHash.String()
+	if err = wallet.saveVSPTicket(ticketHash, record); err != nil {
+		return nil, err
+	}
+
+	log.Infof("[%d] Paid VSP fee %s for ticket %s", wallet.ID, feeTxHash, ticketHash)
+	return feeTx, nil
+}
+
+// abandonVSPFeeTx removes an unmined fee transaction that the VSP could not
+// broadcast from the wallet, releasing its inputs. Fee transactions that are
+// not in the wallet are ignored.
+func (wallet *Wallet) abandonVSPFeeTx(ctx context.Context, feeTxHash string) error {
+	hash, err := chainhash.NewHashFromStr(feeTxHash)
+	if err != nil {
+		return err
+	}
+
+	err = wallet.internal.AbandonTransaction(ctx, hash)
+	if err != nil && !errors.Is(err, errors.NotExist) {
+		return translateError(err)
+	}
+	return nil
+}
+
+// createVSPFeeTx returns a signed transaction that pays feeAmount to feeAddr
+// from the credits with the change going to an internal address of account.
+func (wallet *Wallet) createVSPFeeTx(ctx context.Context, feeAddr dcrutil.Address, feeAmount dcrutil.Amount,
+	account uint32, credits []w.Input) (*wire.MsgTx, error) {
+
+	output, err := txhelper.MakeTxOutput(feeAddr.String(), int64(feeAmount), wallet.chainParams)
+	if err != nil {
+		return nil, err
+	}
+
+	changeAddr, err := wallet.internal.NewChangeAddress(ctx, account)
+	if err != nil {
+		return nil, fmt.Errorf("change address error: %v", err)
+	}
+	changeSource, err := txhelper.MakeTxChangeSource(changeAddr.String(), wallet.chainParams)
+	if err != nil {
+		return nil, fmt.Errorf("change source error: %v", err)
+	}
+
+	// The credits are reserved P2PKH outputs of the account.
+	inputSource := func(target dcrutil.Amount) (*txauthor.InputDetail, error) {
+		detail := new(txauthor.InputDetail)
+		for _, credit := range credits {
+			if detail.Amount >= target {
+				break
+			}
+
+			detail.Amount += dcrutil.Amount(credit.PrevOut.Value)
+			detail.Inputs = append(detail.Inputs, wire.NewTxIn(&credit.OutPoint, credit.PrevOut.Value, nil))
+			detail.Scripts = append(detail.Scripts, credit.PrevOut.PkScript)
+			detail.RedeemScriptSizes = append(detail.RedeemScriptSizes, txsizes.RedeemP2PKHSigScriptSize)
+		}
+		return detail, nil
+	}
+
+	unsignedTx, err := wallet.internal.NewUnsignedTransaction(ctx, []*wire.TxOut{output}, txrules.DefaultRelayFeePerKb,
+		account, 1, w.OutputSelectionAlgorithmDefault, changeSource, inputSource)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if unsignedTx.ChangeIndex >= 0 {
+		unsignedTx.RandomizeChangePosition()
+	}
+
+	_, err = wallet.internal.SignTransaction(ctx, unsignedTx.Tx, txscript.SigHashAll, nil, nil, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return unsignedTx.Tx, nil
+}
+
+// ticketAddresses returns the ticket with ticketHash, the commitment address
+// that signs the requests about the ticket to its VSP and the voting address
+// whose key is given to the VSP.
+func (wallet *Wallet) ticketAddresses(ctx context.Context, ticketHash *chainhash.Hash) (*wire.MsgTx, dcrutil.Address, dcrutil.Address, error) {
+	txs, _, err := wallet.internal.GetTransactionsByHashes(ctx, []*chainhash.Hash{ticketHash})
+	if err != nil {
+		return nil, nil, nil, translateError(err)
+	}
+
+	ticketTx := txs[0]
+	if !stake.IsSStx(ticketTx) {
+		return nil, nil, nil, errors.New(ErrInvalid)
+	}
+
+	commitmentAddr, err := stake.AddrFromSStxPkScrCommitment(ticketTx.TxOut[1].PkScript, wallet.chainParams)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	_, votingAddrs, _, err := txscript.ExtractPkScriptAddrs(ticketTx.TxOut[0].Version, ticketTx.TxOut[0].PkScript,
+		wallet.chainParams, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(votingAddrs) != 1 {
+		return nil, nil, nil, errors.New(ErrInvalid)
+	}
+
+	return ticketTx, commitmentAddr, votingAddrs[0], nil
+}
+
+// ticketVoteChoices returns the choices that the wallet votes with for a
+// ticket keyed by agenda ID.
+func (wallet *Wallet) ticketVoteChoices(ctx context.Context, ticketHash *chainhash.Hash) (map[string]string, error) {
+	agendaChoices, _, err := wallet.internal.AgendaChoices(ctx, ticketHash)
+	if err != nil {
+		return nil, err
+	}
+
+	voteChoices := make(map[string]string, len(agendaChoices))
+	for _, choice := range agendaChoices {
+		voteChoices[choice.AgendaID] = choice.ChoiceID
+	}
+	return voteChoices, nil
+}
+
+// vspClient returns a client of the VSP at vspHost with pubKey whose requests
+// are signed by the wallet and sent through the proxy if one is set.
+func (wallet *Wallet) vspClient(vspHost string, pubKey []byte) (*vsp.Client, error) {
+	client, err := vsp.NewClient(proxyHTTPClient(wallet.socksProxy()), vspHost, pubKey, wallet.internal)
+	if err != nil {
+		return nil, errors.New(ErrInvalid)
+	}
+	return client, nil
+}
+
+func (wallet *Wallet) vspContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(wallet.shutdownContext(), vspRequestTimeout)
+}
+
+// vspTicket returns the saved VSP of the ticket with ticketHash.
+func (wallet *Wallet) vspTicket(ticketHash *chainhash.Hash) (*vspTicket, error) {
+	records, err := wallet.vspTickets()
+	if err != nil {
+		return nil, err
+	}
+
+	record, ok := records[ticketHash.String()]
+	if !ok {
+		return nil, errors.New(ErrNotExist)
+	}
+	return record, nil
+}
+
+// vspTickets returns the saved VSPs of the tickets of the wallet keyed by
+// ticket hash.
+func (wallet *Wallet) vspTickets() (map[string]*vspTicket, error) {
+	wallet.vspTicketsMu.Lock()
+	defer wallet.vspTicketsMu.Unlock()
+
+	return wallet.readVSPTickets()
+}
+
+func (wallet *Wallet) saveVSPTicket(ticketHash *chainhash.Hash, record *vspTicket) error {
+	wallet.vspTicketsMu.Lock()
+	defer wallet.vspTicketsMu.Unlock()
+
+	records, err := wallet.readVSPTickets()
+	if err != nil {
+		return err
+	}
+
+	records[ticketHash.String()] = record
+	if wallet.setUserConfigValue == nil {
+		return errors.New(ErrWalletNotLoaded)
+	}
+	return wallet.setUserConfigValue(vspTicketsConfigKey, records)
+}
+
+// readVSPTickets reads the saved VSP tickets. wallet.vspTicketsMu must be
+// held.
+func (wallet *Wallet) readVSPTickets() (map[string]*vspTicket, error) {
+	records := make(map[string]*vspTicket)
+	if wallet.readUserConfigValue == nil {
+		return records, nil
+	}
+
+	err := wallet.readUserConfigValue(false, vspTicketsConfigKey, &records)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return records, nil
+}
+
+func serializeTxHex(tx *wire.MsgTx) (string, error) {
+	var txBuf bytes.Buffer
+	txBuf.Grow(tx.SerializeSize())
+	if err := tx.Serialize(&txBuf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(txBuf.Bytes()), nil
+}
@@ -0,0 +1,340 @@
+// Package vsp implements a client of the version 3 API of vspd, the server
+// run by voting service providers (VSPs) that vote the tickets of their users
+// for a fee.
+//
+// Every response of a VSP is signed with the ed25519 key of the VSP and is
+// verified against the public key of the VSP before it is used. Requests
+// about a ticket are signed with the commitment address of the ticket so that
+// the VSP can verify that they come from the owner of the ticket.
+package vsp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"decred.org/dcrwallet/errors"
+	"github.com/decred/dcrd/dcrutil/v3"
+)
+
+const (
+	apiVSPInfo        = "/api/v3/vspinfo"
+	apiFeeAddress     = "/api/v3/feeaddress"
+	apiPayFee         = "/api/v3/payfee"
+	apiTicketStatus   = "/api/v3/ticketstatus"
+	apiSetVoteChoices = "/api/v3/setvotechoices"
+
+	// ClientSignatureHeader is the header of the signature of a request by
+	// the commitment address of the ticket.
+	ClientSignatureHeader = "VSP-Client-Signature"
+
+	// ServerSignatureHeader is the header of the signature of a response by
+	// the key of the VSP.
+	ServerSignatureHeader = "VSP-Server-Signature"
+)
+
+// maxResponseSize is the size of the largest response read from a VSP.
+const maxResponseSize = 1 << 20
+
+// Signer signs messages with the private key of an address. The wallet that
+// owns the tickets is the signer of the requests about the tickets.
+type Signer interface {
+	SignMessage(ctx context.Context, message string, address dcrutil.Address) ([]byte, error)
+}
+
+// BadRequestError is the error returned by a VSP that rejects a request.
+// Code is the vspd error code.
+type BadRequestError struct {
+	HTTPStatus int    `json:"-"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *BadRequestError) Error() string { return e.Message }
+
+// Client is a client of the VSP at a URL whose responses are verified against
+// the public key of the VSP.
+type Client struct {
+	httpClient *http.Client
+	url        string
+	pubKey     ed25519.PublicKey
+	signer     Signer
+}
+
+// NewClient returns a client of the VSP at vspURL with the ed25519 public key
+// pubKey. Requests are sent with httpClient and signed by signer.
+func NewClient(httpClient *http.Client, vspURL string, pubKey []byte, signer Signer) (*Client, error) {
+	const op errors.Op = "vsp.NewClient"
+
+	u, err := parseURL(vspURL)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if len(pubKey) != ed25519.PublicKeySize {
+		return nil, errors.E(op, errors.Invalid, "invalid VSP public key")
+	}
+
+	return &Client{
+		httpClient: httpClient,
+		url:        u,
+		pubKey:     ed25519.PublicKey(pubKey),
+		signer:     signer,
+	}, nil
+}
+
+// FetchVSPInfo returns the info of the VSP at vspURL. The response is
+// verified against the public key that it holds, which only proves that the
+// VSP has the private key. The key must be confirmed by other means before it
+// is trusted, e.g. against the list of VSPs published by decred.org, and used
+// with NewClient.
+func FetchVSPInfo(ctx context.Context, httpClient *http.Client, vspURL string) (*VSPInfoResponse, error) {
+	const op errors.Op = "vsp.FetchVSPInfo"
+
+	u, err := parseURL(vspURL)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	status, body, sig, err := roundTrip(ctx, httpClient, "GET", u+apiVSPInfo, nil, "")
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if status != http.StatusOK {
+		return nil, errors.E(op, errors.Protocol, errors.Errorf("http %d %s", status, http.StatusText(status)))
+	}
+
+	info := new(VSPInfoResponse)
+	if err = json.Unmarshal(body, info); err != nil {
+		return nil, errors.E(op, errors.Encoding, err)
+	}
+	if len(info.PubKey) != ed25519.PublicKeySize {
+		return nil, errors.E(op, errors.Protocol, "invalid VSP public key")
+	}
+	if err = verifySignature(info.PubKey, body, sig); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return info, nil
+}
+
+// URL returns the URL of the VSP.
+func (c *Client) URL() string {
+	return c.url
+}
+
+// PubKey returns the public key of the VSP.
+func (c *Client) PubKey() []byte {
+	return c.pubKey
+}
+
+// VSPInfo returns the fee and the state of the VSP.
+func (c *Client) VSPInfo(ctx context.Context) (*VSPInfoResponse, error) {
+	const op errors.Op = "vsp.VSPInfo"
+
+	info := new(VSPInfoResponse)
+	if err := c.get(ctx, apiVSPInfo, info); err != nil {
+		return nil, errors.E(op, err)
+	}
+	return info, nil
+}
+
+// FeeAddress requests the address and the amount of the fee to pay for the
+// VSP to vote a ticket with the commitment address commitmentAddr. The
+// timestamp of req is set to the current time.
+func (c *Client) FeeAddress(ctx context.Context, commitmentAddr dcrutil.Address, req *FeeAddressRequest) (*FeeAddressResponse, error) {
+	const op errors.Op = "vsp.FeeAddress"
+
+	req.Timestamp = time.Now().Unix()
+	resp := new(FeeAddressResponse)
+	requestBody, err := c.post(ctx, apiFeeAddress, commitmentAddr, req, resp)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if err = checkRequest(requestBody, resp.Request); err != nil {
+		return nil, errors.E(op, err)
+	}
+	return resp, nil
+}
+
+// PayFee sends the fee transaction of a ticket with the commitment address
+// commitmentAddr to the VSP, which broadcasts it. The timestamp of req is set
+// to the current time.
+func (c *Client) PayFee(ctx context.Context, commitmentAddr dcrutil.Address, req *PayFeeRequest) (*PayFeeResponse, error) {
+	const op errors.Op = "vsp.PayFee"
+
+	req.Timestamp = time.Now().Unix()
+	resp := new(PayFeeResponse)
+	requestBody, err := c.post(ctx, apiPayFee, commitmentAddr, req, resp)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if err = checkRequest(requestBody, resp.Request); err != nil {
+		return nil, errors.E(op, err)
+	}
+	return resp, nil
+}
+
+// TicketStatus returns the status of the fee and the vote choices of a
+// ticket with the commitment address commitmentAddr.
+func (c *Client) TicketStatus(ctx context.Context, commitmentAddr dcrutil.Address, req *TicketStatusRequest) (*TicketStatusResponse, error) {
+	const op errors.Op = "vsp.TicketStatus"
+
+	resp := new(TicketStatusResponse)
+	requestBody, err := c.post(ctx, apiTicketStatus, commitmentAddr, req, resp)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if err = checkRequest(requestBody, resp.Request); err != nil {
+		return nil, errors.E(op, err)
+	}
+	return resp, nil
+}
+
+// SetVoteChoices updates the choices that the VSP votes with for a ticket
+// with the commitment address commitmentAddr. The timestamp of req is set to
+// the current time.
+func (c *Client) SetVoteChoices(ctx context.Context, commitmentAddr dcrutil.Address, req *SetVoteChoicesRequest) (*SetVoteChoicesResponse, error) {
+	const op errors.Op = "vsp.SetVoteChoices"
+
+	req.Timestamp = time.Now().Unix()
+	resp := new(SetVoteChoicesResponse)
+	requestBody, err := c.post(ctx, apiSetVoteChoices, commitmentAddr, req, resp)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if err = checkRequest(requestBody, resp.Request); err != nil {
+		return nil, errors.E(op, err)
+	}
+	return resp, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, resp interface{}) error {
+	return c.do(ctx, "GET", path, nil, "", resp)
+}
+
+// post sends req signed by addr and returns the request body so that it can
+// be compared with the request echoed by the VSP.
+func (c *Client) post(ctx context.Context, path string, addr dcrutil.Address, req, resp interface{}) ([]byte, error) {
+	requestBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.E(errors.Encoding, err)
+	}
+
+	sig, err := c.signer.SignMessage(ctx, string(requestBody), addr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.do(ctx, "POST", path, requestBody, base64.StdEncoding.EncodeToString(sig), resp)
+	return requestBody, err
+}
+
+// do sends the request and decodes the verified response into resp. Requests
+// rejected by the VSP return a *BadRequestError.
+func (c *Client) do(ctx context.Context, method, path string, requestBody []byte, clientSig string, resp interface{}) error {
+	status, body, sig, err := roundTrip(ctx, c.httpClient, method, c.url+path, requestBody, clientSig)
+	if err != nil {
+		return err
+	}
+
+	is4xx := status >= 400 && status <= 499
+	if status != http.StatusOK && !is4xx {
+		return errors.E(errors.Protocol, errors.Errorf("http %d %s", status, http.StatusText(status)))
+	}
+
+	if err = verifySignature(c.pubKey, body, sig); err != nil {
+		return err
+	}
+
+	if is4xx {
+		apiError := &BadRequestError{HTTPStatus: status}
+		if err = json.Unmarshal(body, apiError); err != nil {
+			return errors.E(errors.Encoding, err)
+		}
+		return apiError
+	}
+
+	if err = json.Unmarshal(body, resp); err != nil {
+		return errors.E(errors.Encoding, err)
+	}
+	return nil
+}
+
+// roundTrip sends a request and returns the status, body and decoded
+// signature of the response. The signature is nil if the response is not
+// signed.
+func roundTrip(ctx context.Context, httpClient *http.Client, method, reqURL string, requestBody []byte,
+	clientSig string) (int, []byte, []byte, error) {
+
+	var reqBody io.Reader
+	if requestBody != nil {
+		reqBody = bytes.NewReader(requestBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+	if err != nil {
+		return 0, nil, nil, errors.E(errors.Invalid, err)
+	}
+	if clientSig != "" {
+		req.Header.Set(ClientSignatureHeader, clientSig)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, nil, errors.E(errors.IO, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, nil, nil, errors.E(errors.IO, err)
+	}
+
+	var sig []byte
+	if sigBase64 := resp.Header.Get(ServerSignatureHeader); sigBase64 != "" {
+		sig, err = base64.StdEncoding.DecodeString(sigBase64)
+		if err != nil {
+			return 0, nil, nil, errors.E(errors.Protocol, "cannot authenticate VSP: invalid signature encoding")
+		}
+	}
+
+	return resp.StatusCode, body, sig, nil
+}
+
+// verifySignature returns an error if sig is not the signature of body by
+// the key of the VSP.
+func verifySignature(pubKey ed25519.PublicKey, body, sig []byte) error {
+	if sig == nil {
+		return errors.E(errors.Protocol, "cannot authenticate VSP: no signature")
+	}
+	if !ed25519.Verify(pubKey, body, sig) {
+		return errors.E(errors.Protocol, "cannot authenticate VSP: invalid signature")
+	}
+	return nil
+}
+
+// checkRequest returns an error if the request echoed in a response is not
+// the request that was sent, in which case the response is not for the
+// request.
+func checkRequest(sent, echoed []byte) error {
+	if !bytes.Equal(sent, echoed) {
+		return errors.E(errors.Protocol, "VSP response is for a different request")
+	}
+	return nil
+}
+
+// parseURL returns the http or https URL of a VSP without a trailing slash.
+func parseURL(vspURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(vspURL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.E(errors.Invalid, "invalid VSP URL")
+	}
+	return u.String(), nil
+}
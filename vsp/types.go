@@ -0,0 +1,102 @@
+package vsp
+
+import "encoding/json"
+
+// Fee transaction statuses reported by the VSP for a ticket.
+const (
+	// FeeTxStatusNone is the status of tickets whose fee was not paid.
+	FeeTxStatusNone = "none"
+
+	// FeeTxStatusReceived is the status of tickets whose fee transaction
+	// was received and not yet broadcast by the VSP.
+	FeeTxStatusReceived = "received"
+
+	// FeeTxStatusBroadcast is the status of tickets whose fee transaction
+	// was broadcast and not yet confirmed.
+	FeeTxStatusBroadcast = "broadcast"
+
+	// FeeTxStatusConfirmed is the status of tickets whose fee transaction
+	// is confirmed. The VSP votes these tickets.
+	FeeTxStatusConfirmed = "confirmed"
+
+	// FeeTxStatusError is the status of tickets whose fee transaction
+	// could not be broadcast. The fee must be paid again.
+	FeeTxStatusError = "error"
+)
+
+// VSPInfoResponse is the response of /api/v3/vspinfo.
+type VSPInfoResponse struct {
+	APIVersions   []int64 `json:"apiversions"`
+	Timestamp     int64   `json:"timestamp"`
+	PubKey        []byte  `json:"pubkey"`
+	FeePercentage float64 `json:"feepercentage"`
+	VspClosed     bool    `json:"vspclosed"`
+	Network       string  `json:"network"`
+	VspdVersion   string  `json:"vspdversion"`
+	Voting        int64   `json:"voting"`
+	Voted         int64   `json:"voted"`
+	Revoked       int64   `json:"revoked"`
+}
+
+// FeeAddressRequest is the request of /api/v3/feeaddress. ParentHex is the
+// transaction that funds the ticket.
+type FeeAddressRequest struct {
+	Timestamp  int64  `json:"timestamp"`
+	TicketHash string `json:"tickethash"`
+	TicketHex  string `json:"tickethex"`
+	ParentHex  string `json:"parenthex"`
+}
+
+// FeeAddressResponse is the response of /api/v3/feeaddress. The fee must
+// be paid to FeeAddress before Expiration.
+type FeeAddressResponse struct {
+	Timestamp  int64           `json:"timestamp"`
+	FeeAddress string          `json:"feeaddress"`
+	FeeAmount  int64           `json:"feeamount"`
+	Expiration int64           `json:"expiration"`
+	Request    json.RawMessage `json:"request"`
+}
+
+// PayFeeRequest is the request of /api/v3/payfee. VotingKey is the WIF
+// encoded private key of the voting address of the ticket.
+type PayFeeRequest struct {
+	Timestamp   int64             `json:"timestamp"`
+	TicketHash  string            `json:"tickethash"`
+	FeeTx       string            `json:"feetx"`
+	VotingKey   string            `json:"votingkey"`
+	VoteChoices map[string]string `json:"votechoices"`
+}
+
+// PayFeeResponse is the response of /api/v3/payfee.
+type PayFeeResponse struct {
+	Timestamp int64           `json:"timestamp"`
+	Request   json.RawMessage `json:"request"`
+}
+
+// TicketStatusRequest is the request of /api/v3/ticketstatus.
+type TicketStatusRequest struct {
+	TicketHash string `json:"tickethash"`
+}
+
+// TicketStatusResponse is the response of /api/v3/ticketstatus.
+type TicketStatusResponse struct {
+	Timestamp       int64             `json:"timestamp"`
+	TicketConfirmed bool              `json:"ticketconfirmed"`
+	FeeTxStatus     string            `json:"feetxstatus"`
+	FeeTxHash       string            `json:"feetxhash"`
+	VoteChoices     map[string]string `json:"votechoices"`
+	Request         json.RawMessage   `json:"request"`
+}
+
+// SetVoteChoicesRequest is the request of /api/v3/setvotechoices.
+type SetVoteChoicesRequest struct {
+	Timestamp   int64             `json:"timestamp"`
+	TicketHash  string            `json:"tickethash"`
+	VoteChoices map[string]string `json:"votechoices"`
+}
+
+// SetVoteChoicesResponse is the response of /api/v3/setvotechoices.
+type SetVoteChoicesResponse struct {
+	Timestamp int64           `json:"timestamp"`
+	Request   json.RawMessage `json:"request"`
+}
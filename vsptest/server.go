@@ -0,0 +1,529 @@
+// Package vsptest provides an in-process stand-in for a vspd server for
+// testing ticket purchases through a VSP without connecting to a real VSP. A
+// Server serves the version 3 vspd API over loopback HTTP, signs its responses
+// as vspd does and relays the tickets and fee transactions that it receives to
+// an spvtest.Chain.
+package vsptest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"decred.org/dcrwallet/wallet"
+	"decred.org/dcrwallet/wallet/txrules"
+	"github.com/decred/dcrd/blockchain/stake/v3"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	"github.com/planetdecred/dcrlibwallet/spvtest"
+	"github.com/planetdecred/dcrlibwallet/vsp"
+)
+
+// Misbehavior describes how a Server deviates from the behavior of an honest
+// vspd server.
+type Misbehavior int
+
+const (
+	// Honest servers serve the vspd API as vspd would.
+	Honest Misbehavior = iota
+
+	// InvalidSignatures servers sign their responses with a key other than
+	// the one they advertise.
+	InvalidSignatures
+)
+
+// FeePercentage is the fee of the servers as a percentage of the vote
+// subsidy.
+const FeePercentage = 2.0
+
+// minFee is the smallest fee charged for a ticket, the fee of the cheap
+// tickets of test networks would otherwise be dust.
+const minFee = dcrutil.Amount(1e5)
+
+// feeExpiry is the time allowed to pay the fee of a ticket.
+const feeExpiry = time.Hour
+
+// ticketConfirmations is the number of confirmations after which the
+// servers report tickets as confirmed.
+const ticketConfirmations = 6
+
+// Error codes of vspd.
+const (
+	errBadRequest = iota
+	errInternalError
+	errVspClosed
+	errFeeAlreadyReceived
+	errInvalidFeeTx
+	errFeeTooSmall
+	errUnknownTicket
+	errTicketCannotVote
+	errFeeExpired
+	errInvalidVoteChoices
+	errBadSignature
+	errInvalidPrivKey
+	errFeeNotReceived
+	errInvalidTicket
+)
+
+// Ticket is a ticket registered with a Server. FeeTx is nil until the fee
+// is paid.
+type Ticket struct {
+	Hash              chainhash.Hash
+	CommitmentAddress dcrutil.Address
+	VotingAddress     dcrutil.Address
+	FeeAddress        dcrutil.Address
+	FeeAmount         dcrutil.Amount
+	FeeTx             *wire.MsgTx
+	VotingKey         string
+	VoteChoices       map[string]string
+}
+
+// Server is a simulated vspd server for the tickets of a Chain.
+type Server struct {
+	chain      *spvtest.Chain
+	pubKey     ed25519.PublicKey
+	signKey    ed25519.PrivateKey
+	httpServer *httptest.Server
+
+	mu           sync.Mutex
+	tickets      map[chainhash.Hash]*Ticket
+	infoRequests int
+}
+
+// NewServer starts a server for the tickets of chain with the given
+// misbehavior on a random loopback port. The server must be closed with
+// Close.
+func NewServer(chain *spvtest.Chain, misbehavior Misbehavior) (*Server, error) {
+	pubKey, signKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if misbehavior == InvalidSignatures {
+		_, signKey, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Server{
+		chain:   chain,
+		pubKey:  pubKey,
+		signKey: signKey,
+		tickets: make(map[chainhash.Hash]*Ticket),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/vspinfo", s.handleVSPInfo)
+	mux.HandleFunc("/api/v3/feeaddress", s.handleFeeAddress)
+	mux.HandleFunc("/api/v3/payfee", s.handlePayFee)
+	mux.HandleFunc("/api/v3/ticketstatus", s.handleTicketStatus)
+	mux.HandleFunc("/api/v3/setvotechoices", s.handleSetVoteChoices)
+	s.httpServer = httptest.NewServer(mux)
+
+	return s, nil
+}
+
+// URL returns the URL of the server.
+func (s *Server) URL() string {
+	return s.httpServer.URL
+}
+
+// PubKey returns the public key that the server advertises.
+func (s *Server) PubKey() []byte {
+	return s.pubKey
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Ticket returns a copy of the ticket with hash or false if the ticket was
+// not registered with the server.
+func (s *Server) Ticket(hash *chainhash.Hash) (*Ticket, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticket, ok := s.tickets[*hash]
+	if !ok {
+		return nil, false
+	}
+
+	ticketCopy := *ticket
+	ticketCopy.VoteChoices = make(map[string]string, len(ticket.VoteChoices))
+	for agendaID, choiceID := range ticket.VoteChoices {
+		ticketCopy.VoteChoices[agendaID] = choiceID
+	}
+	return &ticketCopy, true
+}
+
+// InfoRequests returns the number of requests for the info of the server.
+func (s *Server) InfoRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.infoRequests
+}
+
+func (s *Server) handleVSPInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.infoRequests++
+	s.mu.Unlock()
+
+	s.respond(w, http.StatusOK, &vsp.VSPInfoResponse{
+		APIVersions:   []int64{3},
+		Timestamp:     time.Now().Unix(),
+		PubKey:        s.pubKey,
+		FeePercentage: FeePercentage,
+		Network:       s.chain.Params().Name,
+		VspdVersion:   "vsptest",
+	})
+}
+
+func (s *Server) handleFeeAddress(w http.ResponseWriter, r *http.Request) {
+	var req vsp.FeeAddressRequest
+	body, ok := s.readRequest(w, r, &req)
+	if !ok {
+		return
+	}
+
+	params := s.chain.Params()
+	ticketTx, err := decodeTx(req.TicketHex)
+	if err != nil || ticketTx.TxHash().String() != req.TicketHash || !stake.IsSStx(ticketTx) {
+		s.fail(w, errInvalidTicket, "invalid ticket")
+		return
+	}
+
+	commitmentAddr, err := stake.AddrFromSStxPkScrCommitment(ticketTx.TxOut[1].PkScript, params)
+	if err != nil {
+		s.fail(w, errInvalidTicket, "invalid ticket commitment")
+		return
+	}
+	if !s.verifySignature(r, body, commitmentAddr) {
+		s.fail(w, errBadSignature, "bad request signature")
+		return
+	}
+
+	_, votingAddrs, _, err := txscript.ExtractPkScriptAddrs(0, ticketTx.TxOut[0].PkScript, params, true)
+	if err != nil || len(votingAddrs) != 1 {
+		s.fail(w, errInvalidTicket, "invalid ticket voting address")
+		return
+	}
+
+	ticketHash := ticketTx.TxHash()
+	_, mined := s.chain.TxHeight(&ticketHash)
+	if !mined && s.chain.MempoolTx(&ticketHash) == nil {
+		s.chain.AddMempoolTx(ticketTx)
+	}
+
+	s.mu.Lock()
+	ticket, exists := s.tickets[ticketHash]
+	if exists && ticket.FeeTx != nil {
+		s.mu.Unlock()
+		s.fail(w, errFeeAlreadyReceived, "fee tx already received for ticket")
+		return
+	}
+	if !exists {
+		feeAddr, err := newAddress(params)
+		if err != nil {
+			s.mu.Unlock()
+			s.fail(w, errInternalError, err.Error())
+			return
+		}
+
+		_, height := s.chain.Tip()
+		fee := txrules.StakePoolTicketFee(dcrutil.Amount(ticketTx.TxOut[0].Value), txrules.DefaultRelayFeePerKb,
+			height, FeePercentage, params)
+		if fee < minFee {
+			fee = minFee
+		}
+
+		ticket = &Ticket{
+			Hash:              ticketHash,
+			CommitmentAddress: commitmentAddr,
+			VotingAddress:     votingAddrs[0],
+			FeeAddress:        feeAddr,
+			FeeAmount:         fee,
+			VoteChoices:       make(map[string]string),
+		}
+		s.tickets[ticketHash] = ticket
+	}
+	resp := &vsp.FeeAddressResponse{
+		Timestamp:  time.Now().Unix(),
+		FeeAddress: ticket.FeeAddress.String(),
+		FeeAmount:  int64(ticket.FeeAmount),
+		Expiration: time.Now().Add(feeExpiry).Unix(),
+		Request:    body,
+	}
+	s.mu.Unlock()
+
+	s.respond(w, http.StatusOK, resp)
+}
+
+func (s *Server) handlePayFee(w http.ResponseWriter, r *http.Request) {
+	var req vsp.PayFeeRequest
+	body, ok := s.readRequest(w, r, &req)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticket, ok := s.verifiedTicket(w, r, body, req.TicketHash)
+	if !ok {
+		return
+	}
+	if ticket.FeeTx != nil {
+		s.fail(w, errFeeAlreadyReceived, "fee tx already received for ticket")
+		return
+	}
+
+	params := s.chain.Params()
+	feeTx, err := decodeTx(req.FeeTx)
+	if err != nil {
+		s.fail(w, errInvalidFeeTx, "invalid fee tx")
+		return
+	}
+	feeScript, err := txscript.PayToAddrScript(ticket.FeeAddress)
+	if err != nil {
+		s.fail(w, errInternalError, err.Error())
+		return
+	}
+	var feePaid dcrutil.Amount
+	for _, txOut := range feeTx.TxOut {
+		if bytes.Equal(txOut.PkScript, feeScript) {
+			feePaid += dcrutil.Amount(txOut.Value)
+		}
+	}
+	if feePaid == 0 {
+		s.fail(w, errInvalidFeeTx, "fee tx does not pay the fee address")
+		return
+	}
+	if feePaid < ticket.FeeAmount {
+		s.fail(w, errFeeTooSmall, "fee too small")
+		return
+	}
+
+	votingKey, err := dcrutil.DecodeWIF(req.VotingKey, params.PrivateKeyID)
+	if err != nil || !bytes.Equal(dcrutil.Hash160(votingKey.PubKey()), ticket.VotingAddress.ScriptAddress()) {
+		s.fail(w, errInvalidPrivKey, "invalid voting key")
+		return
+	}
+
+	if !s.validVoteChoices(req.VoteChoices) {
+		s.fail(w, errInvalidVoteChoices, "invalid vote choices")
+		return
+	}
+
+	ticket.FeeTx = feeTx
+	ticket.VotingKey = req.VotingKey
+	for agendaID, choiceID := range req.VoteChoices {
+		ticket.VoteChoices[agendaID] = choiceID
+	}
+	s.chain.AddMempoolTx(feeTx)
+
+	s.respond(w, http.StatusOK, &vsp.PayFeeResponse{
+		Timestamp: time.Now().Unix(),
+		Request:   body,
+	})
+}
+
+func (s *Server) handleTicketStatus(w http.ResponseWriter, r *http.Request) {
+	var req vsp.TicketStatusRequest
+	body, ok := s.readRequest(w, r, &req)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticket, ok := s.verifiedTicket(w, r, body, req.TicketHash)
+	if !ok {
+		return
+	}
+
+	_, tipHeight := s.chain.Tip()
+	ticketHeight, mined := s.chain.TxHeight(&ticket.Hash)
+	resp := &vsp.TicketStatusResponse{
+		Timestamp:       time.Now().Unix(),
+		TicketConfirmed: mined && tipHeight-ticketHeight+1 >= ticketConfirmations,
+		FeeTxStatus:     vsp.FeeTxStatusNone,
+		VoteChoices:     ticket.VoteChoices,
+		Request:         body,
+	}
+	if ticket.FeeTx != nil {
+		feeTxHash := ticket.FeeTx.TxHash()
+		resp.FeeTxHash = feeTxHash.String()
+		resp.FeeTxStatus = vsp.FeeTxStatusBroadcast
+		if _, mined := s.chain.TxHeight(&feeTxHash); mined {
+			resp.FeeTxStatus = vsp.FeeTxStatusConfirmed
+		}
+	}
+
+	s.respond(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleSetVoteChoices(w http.ResponseWriter, r *http.Request) {
+	var req vsp.SetVoteChoicesRequest
+	body, ok := s.readRequest(w, r, &req)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticket, ok := s.verifiedTicket(w, r, body, req.TicketHash)
+	if !ok {
+		return
+	}
+	if ticket.FeeTx == nil {
+		s.fail(w, errFeeNotReceived, "fee not received for ticket")
+		return
+	}
+	if !s.validVoteChoices(req.VoteChoices) {
+		s.fail(w, errInvalidVoteChoices, "invalid vote choices")
+		return
+	}
+
+	for agendaID, choiceID := range req.VoteChoices {
+		ticket.VoteChoices[agendaID] = choiceID
+	}
+
+	s.respond(w, http.StatusOK, &vsp.SetVoteChoicesResponse{
+		Timestamp: time.Now().Unix(),
+		Request:   body,
+	})
+}
+
+// readRequest decodes the body of a POST request into req and returns the
+// body. A failure is sent if the request is invalid.
+func (s *Server) readRequest(w http.ResponseWriter, r *http.Request, req interface{}) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		s.fail(w, errBadRequest, "method not allowed")
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || json.Unmarshal(body, req) != nil {
+		s.fail(w, errBadRequest, "invalid request")
+		return nil, false
+	}
+	return body, true
+}
+
+// verifiedTicket returns the registered ticket with ticketHash if the
+// request is signed by the commitment address of the ticket. A failure is
+// sent otherwise. s.mu must be held.
+func (s *Server) verifiedTicket(w http.ResponseWriter, r *http.Request, body []byte, ticketHash string) (*Ticket, bool) {
+	hash, err := chainhash.NewHashFromStr(ticketHash)
+	if err != nil {
+		s.fail(w, errBadRequest, "invalid ticket hash")
+		return nil, false
+	}
+
+	ticket, ok := s.tickets[*hash]
+	if !ok {
+		s.fail(w, errUnknownTicket, "unknown ticket")
+		return nil, false
+	}
+	if !s.verifySignature(r, body, ticket.CommitmentAddress) {
+		s.fail(w, errBadSignature, "bad request signature")
+		return nil, false
+	}
+	return ticket, true
+}
+
+// verifySignature reports whether the request is signed by addr.
+func (s *Server) verifySignature(r *http.Request, body []byte, addr dcrutil.Address) bool {
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(vsp.ClientSignatureHeader))
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+
+	valid, err := wallet.VerifyMessage(string(body), addr, sig, s.chain.Params())
+	return err == nil && valid
+}
+
+// validVoteChoices reports whether the choices are choices of the agendas of
+// the network.
+func (s *Server) validVoteChoices(choices map[string]string) bool {
+	for agendaID, choiceID := range choices {
+		var valid bool
+		for _, deployments := range s.chain.Params().Deployments {
+			for _, deployment := range deployments {
+				if deployment.Vote.Id != agendaID {
+					continue
+				}
+				for _, choice := range deployment.Vote.Choices {
+					valid = valid || choice.Id == choiceID
+				}
+			}
+		}
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
+
+// respond sends resp signed by the key of the server.
+func (s *Server) respond(w http.ResponseWriter, status int, resp interface{}) {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sig := ed25519.Sign(s.signKey, body)
+	w.Header().Set(vsp.ServerSignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// fail sends a vspd error with code.
+func (s *Server) fail(w http.ResponseWriter, code int, message string) {
+	s.respond(w, http.StatusBadRequest, &vsp.BadRequestError{
+		Code:    code,
+		Message: message,
+	})
+}
+
+func decodeTx(txHex string) (*wire.MsgTx, error) {
+	serializedTx, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := new(wire.MsgTx)
+	if err = tx.Deserialize(bytes.NewReader(serializedTx)); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// newAddress returns a P2PKH address of a new key. The fees paid to the
+// address are never spent.
+func newAddress(params dcrutil.AddressParams) (dcrutil.Address, error) {
+	privKey, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyHash := dcrutil.Hash160(privKey.PubKey().SerializeCompressed())
+	return dcrutil.NewAddressPubKeyHash(pubKeyHash, params, dcrec.STEcdsaSecp256k1)
+}
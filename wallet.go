@@ -6,11 +6,13 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"decred.org/dcrwallet/errors"
 	w "decred.org/dcrwallet/wallet"
 	"decred.org/dcrwallet/walletseed"
+	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrd/chaincfg/v3"
 	"github.com/planetdecred/dcrlibwallet/internal/loader"
 	"github.com/planetdecred/dcrlibwallet/txindex"
@@ -26,6 +28,10 @@ type Wallet struct {
 	HasDiscoveredAccounts bool
 	PrivatePassphraseType int32
 
+	// ExcludedFromSync is set for wallets that the user does not want
+	// synced, e.g. archived or cold wallets.
+	ExcludedFromSync bool
+
 	internal    *w.Wallet
 	chainParams *chaincfg.Params
 	dataDir     string
@@ -36,6 +42,25 @@ type Wallet struct {
 	syncing bool
 	waiting bool
 
+	// mempoolMu protects the unmined transactions seen at the last check
+	// for dropped transactions, the tip height at that check and the
+	// transactions already reported as dropped.
+	mempoolMu        sync.Mutex
+	pendingTxs       map[chainhash.Hash]struct{}
+	pendingTxsHeight int32
+	droppedTxs       map[chainhash.Hash]struct{}
+	checkingMempool  bool
+
+	// conflictsMu serializes the checks for unmined transactions removed
+	// by the wallet.
+	conflictsMu sync.Mutex
+
+	// vspTicketsMu protects the VSP tickets saved in the wallet config.
+	vspTicketsMu sync.Mutex
+
+	ticketBuyerMu sync.Mutex
+	ticketBuyer   *ticketBuyer
+
 	shuttingDown chan bool
 	cancelFuncs  []context.CancelFunc
 
@@ -49,6 +74,17 @@ type Wallet struct {
 	// This function is ideally assigned when the `wallet.prepare` method is
 	// called from a MultiWallet instance.
 	readUserConfigValue configReadFn
+
+	// contactNameForAddress returns the name of the address book contact
+	// saved with the provided address, if any. This function is assigned
+	// when the wallet is added to a MultiWallet instance.
+	contactNameForAddress func(address string) string
+
+	// trackConfirmations creates the pending confirmation notifications of
+	// the provided mined transactions for the wallet-wide confirmation
+	// thresholds of the wallet. This function is assigned when the wallet
+	// is added to a MultiWallet instance.
+	trackConfirmations func(walletID int, txHashes []string)
 }
 
 // prepare gets a wallet ready for use by opening the transactions index database
@@ -192,6 +228,17 @@ func (wallet *Wallet) openWallet() error {
 	return nil
 }
 
+// IsExcludedFromSync returns true if the wallet is not synced when the
+// multiwallet syncs.
+func (wallet *Wallet) IsExcludedFromSync() bool {
+	return wallet.ExcludedFromSync
+}
+
+// syncable returns true if the wallet is opened and not excluded from sync.
+func (wallet *Wallet) syncable() bool {
+	return wallet.WalletOpened() && !wallet.ExcludedFromSync
+}
+
 func (wallet *Wallet) WalletOpened() bool {
 	return wallet.internal != nil
 }